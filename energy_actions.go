@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 電表寫入動作：依型號在 write_actions 定義 (例如清除電能、設定 CT/PT 比)，由 API 執行
+// 執行分兩步：prepare 檢查參數並發出一次性的確認權杖，execute 帶權杖才真正寫入；
+// 寫入後讀回驗證，準備與執行都記錄於稽核紀錄
+
+// 確認權杖的有效時間
+const actionConfirmTTL = 2 * time.Minute
+
+var (
+	errActionToken = errors.New("確認權杖無效或已過期，請重新準備")
+	errActionOwner = errors.New("確認權杖屬於其他使用者")
+)
+
+// 各類型佔用的暫存器數
+var writeTypeRegisters = map[string]int{"": 1, "u16": 1, "i16": 1, "u32": 2, "i32": 2, "float32": 2}
+
+// 可執行的動作 (含電表 ID)
+type MeterWriteAction struct {
+	Device string `json:"device"`
+	WriteAction
+}
+
+// 等待確認的寫入
+type ActionConfirmation struct {
+	Token       string    `json:"token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Device      string    `json:"device"`
+	Action      string    `json:"action"`
+	Description string    `json:"description,omitempty"`
+	Function    string    `json:"function"`
+	Address     uint16    `json:"address"`
+	Value       float64   `json:"value"`
+	Data        string    `json:"data"` // 將寫入的資料 (hex)
+
+	user string // 發出權杖的使用者，只有同一使用者可以執行
+	data []byte
+}
+
+// 寫入結果
+type ActionResult struct {
+	Device     string    `json:"device"`
+	Action     string    `json:"action"`
+	Value      float64   `json:"value"`
+	Data       string    `json:"data"` // 寫入的資料 (hex)
+	Verify     string    `json:"verify"`
+	Readback   string    `json:"readback,omitempty"`    // 讀回的資料 (hex，verify: readback)
+	PointValue *float64  `json:"point_value,omitempty"` // 讀回的點位數值 (verify: point)
+	Verified   bool      `json:"verified"`
+	At         time.Time `json:"at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// 套用預設值
+func (a WriteAction) normalized() WriteAction {
+	if a.Function == "" {
+		a.Function = "register"
+		if writeTypeRegisters[a.Type] == 2 {
+			a.Function = "registers"
+		}
+	}
+	if a.Type == "" && a.Function != "coil" {
+		a.Type = "u16"
+	}
+	if a.Scale == 0 {
+		a.Scale = 1
+	}
+	if a.Verify == "" {
+		a.Verify = "readback"
+	}
+	return a
+}
+
+// 數值轉為寫入的資料：coil 為 FF00/0000，暫存器依類型編碼 (32 位元為 Word-Swap)
+func (a WriteAction) encode(value float64) ([]byte, error) {
+	a = a.normalized()
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("數值無效")
+	}
+	if a.Function == "coil" {
+		switch value {
+		case 0:
+			return []byte{0x00, 0x00}, nil
+		case 1:
+			return []byte{0xFF, 0x00}, nil
+		}
+		return nil, fmt.Errorf("coil 的數值必須為 0 或 1 (目前 %v)", value)
+	}
+
+	raw := value * a.Scale
+	if a.Type == "float32" {
+		if math.Abs(raw) > math.MaxFloat32 {
+			return nil, fmt.Errorf("數值超出 float32 範圍: %v", raw)
+		}
+		return wordSwappedBytes(math.Float32bits(float32(raw))), nil
+	}
+
+	rounded := math.Round(raw)
+	if math.Abs(raw-rounded) > 1e-6 {
+		return nil, fmt.Errorf("%s 必須為整數 (數值 × scale = %v)", a.Type, raw)
+	}
+	limits := map[string][2]float64{
+		"u16": {0, math.MaxUint16}, "i16": {math.MinInt16, math.MaxInt16},
+		"u32": {0, math.MaxUint32}, "i32": {math.MinInt32, math.MaxInt32},
+	}[a.Type]
+	if rounded < limits[0] || rounded > limits[1] {
+		return nil, fmt.Errorf("數值超出 %s 範圍 (%v ~ %v): %v", a.Type, limits[0], limits[1], rounded)
+	}
+	switch a.Type {
+	case "u16", "i16":
+		data := make([]byte, 2)
+		binary.BigEndian.PutUint16(data, uint16(int32(rounded)))
+		return data, nil
+	default:
+		return wordSwappedBytes(uint32(int64(rounded))), nil
+	}
+}
+
+// 32 位元值以 Word-Swap 順序排列 (低位字組在前)
+func wordSwappedBytes(v uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:], uint16(v))
+	binary.BigEndian.PutUint16(data[2:], uint16(v>>16))
+	return data
+}
+
+// 一次性確認權杖
+type actionState struct {
+	mu      sync.Mutex
+	pending map[string]*ActionConfirmation
+}
+
+func newActionState() *actionState {
+	return &actionState{pending: make(map[string]*ActionConfirmation)}
+}
+
+func (s *actionState) add(c *ActionConfirmation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for token, pending := range s.pending {
+		if now.After(pending.ExpiresAt) {
+			delete(s.pending, token)
+		}
+	}
+	s.pending[c.Token] = c
+}
+
+// 取出權杖：只有準備的使用者能使用，使用或過期後移除；
+// 其他使用者送出時回傳 errActionOwner 與權杖內容 (供稽核)，權杖保留給原使用者
+func (s *actionState) take(token, user string) (*ActionConfirmation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.pending[token]
+	if !ok {
+		return nil, errActionToken
+	}
+	if time.Now().After(c.ExpiresAt) {
+		delete(s.pending, token)
+		return nil, errActionToken
+	}
+	if c.user != user {
+		return c, errActionOwner
+	}
+	delete(s.pending, token)
+	return c, nil
+}
+
+// 電表型號的寫入動作
+func (cfg *Config) WriteAction(meter MeterConfig, name string) (WriteAction, bool) {
+	for _, action := range cfg.WriteActions[meter.Model] {
+		if action.Name == name {
+			return action.normalized(), true
+		}
+	}
+	return WriteAction{}, false
+}
+
+// 寫入並驗證 (與輪詢共用連線)
+func (mc *MeterCollector) Write(action WriteAction, data []byte) (*ActionResult, error) {
+	release := mc.system.acquirePollSlot()
+	defer release()
+	mc.connMu.Lock()
+	defer mc.connMu.Unlock()
+
+	result := &ActionResult{Device: mc.ID(), Action: action.Name, Data: hex.EncodeToString(data), Verify: action.Verify, At: time.Now()}
+	if err := mc.connect(); err != nil {
+		return result, err
+	}
+	err := mc.write(action, data, result)
+	if err != nil {
+		if quality, _ := classifyReadError(err); quality == QualityCommError {
+			mc.dropConnection()
+		}
+	}
+	result.DurationMs = time.Since(result.At).Milliseconds()
+	if err == nil && !result.Verified && action.Verify != "none" {
+		err = fmt.Errorf("寫入後驗證失敗")
+	}
+	return result, err
+}
+
+func (mc *MeterCollector) write(action WriteAction, data []byte, result *ActionResult) error {
+	var err error
+	switch action.Function {
+	case "coil":
+		_, err = mc.client.WriteSingleCoil(action.Address, binary.BigEndian.Uint16(data))
+	case "register":
+		_, err = mc.client.WriteSingleRegister(action.Address, binary.BigEndian.Uint16(data))
+	default:
+		_, err = mc.client.WriteMultipleRegisters(action.Address, uint16(len(data)/2), data)
+	}
+	if err != nil {
+		return fmt.Errorf("寫入失敗: %w", err)
+	}
+	if action.Verify == "none" {
+		return nil
+	}
+	if action.VerifyDelay > 0 {
+		time.Sleep(action.VerifyDelay)
+	}
+
+	switch action.Verify {
+	case "point":
+		mc.mu.Lock()
+		param, ok := findParameter(mc.parameters, action.VerifyPoint)
+		mc.mu.Unlock()
+		if !ok || param.computed() {
+			return fmt.Errorf("暫存器對照表中沒有可讀取的點位 %s", action.VerifyPoint)
+		}
+		results, err := readPoint(mc.client, param)
+		if err != nil {
+			return fmt.Errorf("讀回點位 %s 失敗: %w", action.VerifyPoint, err)
+		}
+		value, _, err := decodePoint(param, results)
+		if err != nil {
+			return fmt.Errorf("讀回點位 %s 失敗: %v", action.VerifyPoint, err)
+		}
+		result.PointValue = &value
+		result.Verified = math.Abs(value-action.Expect) <= action.Tolerance
+
+	default:
+		var readback []byte
+		if action.Function == "coil" {
+			bits, err := mc.client.ReadCoils(action.Address, 1)
+			if err != nil {
+				return fmt.Errorf("讀回失敗: %w", err)
+			}
+			readback = []byte{0x00, 0x00}
+			if len(bits) > 0 && bits[0]&1 == 1 {
+				readback[0] = 0xFF
+			}
+		} else {
+			registers, err := mc.client.ReadHoldingRegisters(action.Address, uint16(len(data)/2))
+			if err != nil {
+				return fmt.Errorf("讀回失敗: %w", err)
+			}
+			readback = registers
+		}
+		result.Readback = hex.EncodeToString(readback)
+		result.Verified = bytes.Equal(readback, data)
+	}
+	return nil
+}
+
+// 列出可執行的寫入動作 (device 空白表示全部電表)
+func (es *EnergySystem) ActionsHandler(w http.ResponseWriter, r *http.Request) {
+	config := es.Config()
+	device := r.URL.Query().Get("device")
+	if device != "" {
+		if _, ok := config.Meter(device); !ok {
+			writeAPIError(w, r, http.StatusNotFound, fmt.Sprintf("找不到電表: %s", device), nil)
+			return
+		}
+	}
+
+	actions := make([]MeterWriteAction, 0)
+	for _, meter := range config.Meters {
+		if device != "" && meter.ID != device {
+			continue
+		}
+		for _, action := range config.WriteActions[meter.Model] {
+			actions = append(actions, MeterWriteAction{Device: meter.ID, WriteAction: action.normalized()})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"actions": actions})
+}
+
+// 準備寫入：檢查動作與數值，回傳確認權杖 (不寫入)
+func (es *EnergySystem) ActionPrepareHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Device string   `json:"device"`
+		Action string   `json:"action"`
+		Value  *float64 `json:"value"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "請求格式錯誤: "+err.Error(), nil)
+		return
+	}
+	setAudit(r, req.Device+"/"+req.Action, "")
+
+	config := es.Config()
+	meter, ok := config.Meter(req.Device)
+	if !ok {
+		writeAPIError(w, r, http.StatusNotFound, fmt.Sprintf("找不到電表: %s", req.Device), nil)
+		return
+	}
+	action, ok := config.WriteAction(meter, req.Action)
+	if !ok {
+		writeAPIError(w, r, http.StatusNotFound, fmt.Sprintf("電表 %s (%s) 沒有寫入動作: %s", meter.ID, meter.Model, req.Action), nil)
+		return
+	}
+
+	var value float64
+	switch {
+	case action.Value != nil:
+		value = *action.Value
+		if req.Value != nil && *req.Value != value {
+			writeAPIError(w, r, http.StatusBadRequest, fmt.Sprintf("動作 %s 的數值固定為 %v", action.Name, value), nil)
+			return
+		}
+	case req.Value == nil:
+		writeAPIError(w, r, http.StatusBadRequest, fmt.Sprintf("動作 %s 需要 value", action.Name), nil)
+		return
+	default:
+		value = *req.Value
+		if value < *action.Min || value > *action.Max {
+			writeAPIError(w, r, http.StatusBadRequest, fmt.Sprintf("value 必須介於 %v 到 %v (目前 %v)", *action.Min, *action.Max, value), nil)
+			return
+		}
+	}
+	data, err := action.encode(value)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, "產生確認權杖失敗", nil)
+		return
+	}
+	confirmation := &ActionConfirmation{
+		Token:       hex.EncodeToString(token),
+		ExpiresAt:   time.Now().Add(actionConfirmTTL),
+		Device:      meter.ID,
+		Action:      action.Name,
+		Description: action.Description,
+		Function:    action.Function,
+		Address:     action.Address,
+		Value:       value,
+		Data:        hex.EncodeToString(data),
+		user:        principalFrom(r).User,
+		data:        data,
+	}
+	es.actions.add(confirmation)
+	setAudit(r, meter.ID+"/"+action.Name, fmt.Sprintf("準備寫入 value=%v (位址 0x%04X，資料 %s)", value, action.Address, confirmation.Data))
+	writeJSON(w, http.StatusOK, confirmation)
+}
+
+// 執行寫入 (需 prepare 發出的確認權杖)
+func (es *EnergySystem) ActionExecuteHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "請求格式錯誤: "+err.Error(), nil)
+		return
+	}
+	confirmation, err := es.actions.take(req.Token, principalFrom(r).User)
+	if err == errActionToken {
+		writeAPIError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	setAudit(r, confirmation.Device+"/"+confirmation.Action, "")
+	if err != nil {
+		writeAPIError(w, r, http.StatusForbidden, err.Error(), nil)
+		return
+	}
+
+	// 設定可能在準備後重新載入，重新取得動作並確認資料相同
+	config := es.Config()
+	meter, ok := config.Meter(confirmation.Device)
+	action, found := config.WriteAction(meter, confirmation.Action)
+	if !ok || !found {
+		writeAPIError(w, r, http.StatusConflict, "設定已變更，找不到電表或動作，請重新準備", nil)
+		return
+	}
+	if data, err := action.encode(confirmation.Value); err != nil || !bytes.Equal(data, confirmation.data) || action.Address != confirmation.Address {
+		writeAPIError(w, r, http.StatusConflict, "動作設定已變更，請重新準備", nil)
+		return
+	}
+
+	es.collectorsMu.Lock()
+	collector := es.collectors[meter.ID]
+	es.collectorsMu.Unlock()
+	if collector == nil {
+		writeAPIError(w, r, http.StatusServiceUnavailable, fmt.Sprintf("電表 %s 未在收集中", meter.ID), nil)
+		return
+	}
+
+	result, err := collector.Write(action, confirmation.data)
+	result.Value = confirmation.Value
+	if err != nil {
+		log.Printf("❌ 電表 %s 執行 %s (value=%v) 失敗: %v", meter.ID, action.Name, confirmation.Value, err)
+		writeAPIError(w, r, http.StatusBadGateway, fmt.Sprintf("電表 %s 執行 %s 失敗: %v", meter.ID, action.Name, err), result)
+		return
+	}
+
+	log.Printf("✏️ 電表 %s 已執行 %s (value=%v，位址 0x%04X，驗證 %s)", meter.ID, action.Name, confirmation.Value, action.Address, action.Verify)
+	setAudit(r, meter.ID+"/"+action.Name, fmt.Sprintf("寫入 value=%v (位址 0x%04X，資料 %s)，驗證 %s", confirmation.Value, action.Address, result.Data, action.Verify))
+	writeJSON(w, http.StatusOK, result)
+}
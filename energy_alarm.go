@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 告警規則 (設定檔 alarms 區段)
+type AlarmRule struct {
+	ID        string   `yaml:"id" json:"id"`
+	Meter     string   `yaml:"meter" json:"meter"` // 空白表示套用到所有電表
+	Parameter string   `yaml:"parameter" json:"parameter"`
+	High      *float64 `yaml:"high" json:"high,omitempty"`
+	Low       *float64 `yaml:"low" json:"low,omitempty"`
+	Deadband  float64  `yaml:"deadband" json:"deadband"`
+	Severity  string   `yaml:"severity" json:"severity"`
+}
+
+// 進行中的告警
+type ActiveAlarm struct {
+	RuleID    string    `json:"rule_id"`
+	DeviceID  string    `json:"device_id"`
+	Parameter string    `json:"parameter"`
+	Severity  string    `json:"severity"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Message   string    `json:"message"`
+	Since     time.Time `json:"since"`
+}
+
+// 告警事件 (觸發或解除)
+type AlarmEvent struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	RuleID    string    `json:"rule_id"`
+	DeviceID  string    `json:"device_id"`
+	Parameter string    `json:"parameter"`
+	State     string    `json:"state"` // active, cleared
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Message   string    `json:"message"`
+}
+
+// 告警引擎
+type AlarmEngine struct {
+	store Storage
+
+	mu     sync.Mutex
+	rules  []AlarmRule
+	active map[string]*ActiveAlarm
+}
+
+// 建立告警引擎
+func NewAlarmEngine(store Storage, rules []AlarmRule) *AlarmEngine {
+	return &AlarmEngine{
+		store:  store,
+		rules:  rules,
+		active: make(map[string]*ActiveAlarm),
+	}
+}
+
+// 更新告警規則 (設定重新載入時呼叫)，已移除或變更的規則會解除其進行中的告警
+func (ae *AlarmEngine) SetRules(rules []AlarmRule) {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	current := make(map[string]AlarmRule, len(rules))
+	for _, rule := range rules {
+		current[rule.ID] = rule
+	}
+
+	now := time.Now()
+	for key, alarm := range ae.active {
+		rule, ok := current[alarm.RuleID]
+		if ok && ruleEqual(rule, ae.findRule(alarm.RuleID)) {
+			continue
+		}
+		delete(ae.active, key)
+		ae.record(AlarmEvent{
+			Timestamp: now,
+			RuleID:    alarm.RuleID,
+			DeviceID:  alarm.DeviceID,
+			Parameter: alarm.Parameter,
+			State:     "cleared",
+			Value:     alarm.Value,
+			Threshold: alarm.Threshold,
+			Message:   "告警規則已變更或移除",
+		})
+	}
+
+	ae.rules = rules
+}
+
+func (ae *AlarmEngine) findRule(id string) AlarmRule {
+	for _, rule := range ae.rules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return AlarmRule{}
+}
+
+func ruleEqual(a, b AlarmRule) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+// 依最新讀值評估告警
+func (ae *AlarmEngine) Evaluate(deviceID string, readings []MeterReading, ts time.Time) {
+	if ae == nil {
+		return
+	}
+
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	for _, rule := range ae.rules {
+		if rule.Meter != "" && rule.Meter != deviceID {
+			continue
+		}
+
+		for _, reading := range readings {
+			// 品質異常的數值不觸發也不解除告警
+			if reading.Name != rule.Parameter || (reading.Quality != QualityGood && reading.Quality != QualityStale) {
+				continue
+			}
+			ae.evaluateRule(rule, deviceID, reading, ts)
+		}
+	}
+}
+
+// 評估單一規則；解除告警時需超過死區才視為恢復
+func (ae *AlarmEngine) evaluateRule(rule AlarmRule, deviceID string, reading MeterReading, ts time.Time) {
+	key := rule.ID + "|" + deviceID
+	alarm, isActive := ae.active[key]
+
+	var threshold float64
+	var message string
+	violated := false
+
+	if rule.High != nil {
+		limit := *rule.High
+		if isActive {
+			limit -= rule.Deadband
+		}
+		if reading.Value > limit {
+			violated, threshold = true, *rule.High
+			message = fmt.Sprintf("%s 過高: %.3f %s > %.3f", reading.Name, reading.Value, reading.Unit, *rule.High)
+		}
+	}
+	if rule.Low != nil && !violated {
+		limit := *rule.Low
+		if isActive {
+			limit += rule.Deadband
+		}
+		if reading.Value < limit {
+			violated, threshold = true, *rule.Low
+			message = fmt.Sprintf("%s 過低: %.3f %s < %.3f", reading.Name, reading.Value, reading.Unit, *rule.Low)
+		}
+	}
+
+	switch {
+	case violated && !isActive:
+		ae.active[key] = &ActiveAlarm{
+			RuleID:    rule.ID,
+			DeviceID:  deviceID,
+			Parameter: rule.Parameter,
+			Severity:  rule.Severity,
+			Value:     reading.Value,
+			Threshold: threshold,
+			Message:   message,
+			Since:     ts,
+		}
+		log.Printf("🚨 告警 [%s] 電表 %s: %s", rule.ID, deviceID, message)
+		ae.record(AlarmEvent{Timestamp: ts, RuleID: rule.ID, DeviceID: deviceID, Parameter: rule.Parameter,
+			State: "active", Value: reading.Value, Threshold: threshold, Message: message})
+
+	case violated && isActive:
+		alarm.Value = reading.Value
+
+	case !violated && isActive:
+		delete(ae.active, key)
+		log.Printf("✅ 告警解除 [%s] 電表 %s: %s", rule.ID, deviceID, reading.Name)
+		ae.record(AlarmEvent{Timestamp: ts, RuleID: rule.ID, DeviceID: deviceID, Parameter: rule.Parameter,
+			State: "cleared", Value: reading.Value, Threshold: alarm.Threshold, Message: "恢復正常"})
+	}
+}
+
+// 寫入告警事件
+func (ae *AlarmEngine) record(event AlarmEvent) {
+	if ae.store == nil {
+		return
+	}
+
+	if err := ae.store.InsertAlarmEvent(event); err != nil {
+		log.Printf("❌ 寫入告警事件失敗: %v", err)
+	}
+}
+
+// 目前進行中的告警
+func (ae *AlarmEngine) Active() []ActiveAlarm {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+
+	result := make([]ActiveAlarm, 0, len(ae.active))
+	for _, alarm := range ae.active {
+		result = append(result, *alarm)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Since.Before(result[j].Since) })
+	return result
+}
+
+// 獲取告警 (進行中的告警與最近事件)
+func (es *EnergySystem) GetAlarmsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	events, err := es.store.RecentAlarmEvents(100)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("查詢失敗: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active": es.alarms.Active(),
+		"events": events,
+	})
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 版本化的 API：/api/v1 下的端點與 /api 相同，但回應統一為
+//   成功: {"data": ...}
+//   失敗: {"error": {"code": "...", "message": "...", "details": ...}}
+// 端點、參數與回應結構定義於 apiRouteTable，同時用來註冊路由與產生 OpenAPI 文件 (/api/v1/openapi.json)
+
+const apiV1Prefix = "/api/v1"
+
+// 結構化錯誤
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type apiErrorBody struct {
+	Error APIError `json:"error"`
+}
+
+type apiVersionKeyType struct{}
+
+var apiVersionKey apiVersionKeyType
+
+// 請求是否來自 /api/v1
+func isAPIv1(r *http.Request) bool {
+	v1, _ := r.Context().Value(apiVersionKey).(bool)
+	return v1
+}
+
+// HTTP 狀態碼對應的錯誤代碼
+func apiErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusUnauthorized:
+		return "unauthenticated"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusRequestEntityTooLarge:
+		return "payload_too_large"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	case http.StatusBadGateway:
+		return "device_error"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// 回應錯誤：/api/v1 為結構化 JSON (含 details)，舊版 /api 維持純文字
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, message string, details interface{}) {
+	if !isAPIv1(r) {
+		http.Error(w, message, status)
+		return
+	}
+	writeJSON(w, status, apiErrorBody{Error: APIError{Code: apiErrorCode(status), Message: message, Details: details}})
+}
+
+// 將處理器的回應包裝為 /api/v1 格式：
+// JSON 成功回應串流寫入 {"data": ...}；純文字錯誤 (http.Error) 轉為結構化錯誤；
+// 已是 JSON 的錯誤 (writeAPIError) 與非 JSON 的成功回應 (CSV、XLSX 下載) 原樣傳送
+type envelopeWriter struct {
+	http.ResponseWriter
+	status  int
+	mode    int
+	written int
+	newline bool // 延後寫出的結尾換行 (json.Encoder 會加上換行，放在包裝結尾之後)
+	errBody bytes.Buffer
+}
+
+const (
+	envelopePass = iota + 1
+	envelopeData
+	envelopeError
+)
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	if ew.status != 0 {
+		return
+	}
+	ew.status = status
+	header := ew.Header()
+	isJSON := strings.HasPrefix(header.Get("Content-Type"), "application/json")
+	switch {
+	case status >= 400 && !isJSON:
+		ew.mode = envelopeError // 錯誤訊息先暫存，結束時再寫出
+		return
+	case status >= 200 && status < 300 && status != http.StatusNoContent && isJSON:
+		ew.mode = envelopeData
+		header.Del("Content-Length")
+		ew.ResponseWriter.WriteHeader(status)
+		io.WriteString(ew.ResponseWriter, `{"data":`)
+	default:
+		ew.mode = envelopePass
+		ew.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (ew *envelopeWriter) Write(p []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	switch ew.mode {
+	case envelopeError:
+		return ew.errBody.Write(p)
+	case envelopeData:
+		if len(p) == 0 {
+			return 0, nil
+		}
+		ew.written += len(p)
+		if ew.newline {
+			ew.newline = false
+			if _, err := io.WriteString(ew.ResponseWriter, "\n"); err != nil {
+				return 0, err
+			}
+		}
+		if p[len(p)-1] == '\n' {
+			ew.newline = true
+			n, err := ew.ResponseWriter.Write(p[:len(p)-1])
+			if err == nil {
+				n++
+			}
+			return n, err
+		}
+	}
+	return ew.ResponseWriter.Write(p)
+}
+
+func (ew *envelopeWriter) Flush() {
+	if ew.mode == envelopeError {
+		return
+	}
+	if flusher, ok := ew.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// 補上包裝的結尾；處理器沒有寫出任何內容時回應 {"data": null}
+func (ew *envelopeWriter) finish() {
+	switch ew.mode {
+	case 0:
+		ew.Header().Set("Content-Type", "application/json")
+		ew.ResponseWriter.WriteHeader(http.StatusOK)
+		io.WriteString(ew.ResponseWriter, `{"data":null}`+"\n")
+	case envelopeData:
+		if ew.written == 0 {
+			io.WriteString(ew.ResponseWriter, "null")
+		}
+		io.WriteString(ew.ResponseWriter, "}\n")
+	case envelopeError:
+		header := ew.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Type", "application/json")
+		ew.ResponseWriter.WriteHeader(ew.status)
+		json.NewEncoder(ew.ResponseWriter).Encode(apiErrorBody{Error: APIError{
+			Code:    apiErrorCode(ew.status),
+			Message: strings.TrimSpace(ew.errBody.String()),
+		}})
+	}
+}
+
+// /api/v1 中介層：限制方法 (依 API 定義)、標記請求版本並包裝回應
+func apiV1(methods []string, next http.Handler) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &envelopeWriter{ResponseWriter: w}
+		defer ew.finish()
+
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if methods != nil && !containsString(methods, method) {
+			w.Header().Set("Allow", allow)
+			http.Error(ew, "不支援的方法，可用: "+allow, http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(ew, r.WithContext(context.WithValue(r.Context(), apiVersionKey, true)))
+	})
+}
+
+// API 定義
+
+type apiParam struct {
+	Name        string
+	In          string // query (預設) 或 path
+	Schema      *apiSchema
+	Required    bool
+	Description string
+}
+
+type apiOperation struct {
+	Method      string
+	Summary     string
+	Params      []apiParam
+	Body        *apiSchema // JSON 請求本文
+	BodyTypes   []string   // 其他請求本文格式 (例如匯入的 text/csv)
+	Status      int        // 成功的狀態碼 (預設 200)
+	Response    *apiSchema // data 的結構，nil 表示沒有內容
+	Produces    []string   // 非 JSON 的回應格式 (例如匯出)
+	ErrorStatus []int      // 可能的錯誤狀態碼 (401/403/500 依角色自動加入)
+}
+
+type apiRoute struct {
+	Path    string // /api 之後的路徑
+	Role    string // 需要的角色，空白表示不需登入
+	Handler func(*EnergySystem, http.ResponseWriter, *http.Request)
+	Ops     []apiOperation
+}
+
+func (route apiRoute) methods() []string {
+	methods := make([]string, 0, len(route.Ops))
+	for _, op := range route.Ops {
+		methods = append(methods, op.Method)
+	}
+	return methods
+}
+
+// 註冊 /api 與 /api/v1 端點；會變更資料的請求記錄於稽核紀錄
+func (es *EnergySystem) registerAPIRoutes(mux *http.ServeMux) {
+	for _, route := range apiRouteTable() {
+		route := route
+		handler := es.authorize(route.Role, true, func(w http.ResponseWriter, r *http.Request) {
+			route.Handler(es, w, r)
+		})
+		mux.Handle("/api"+route.Path, handler)
+		mux.Handle(apiV1Prefix+route.Path, apiV1(route.methods(), handler))
+	}
+	mux.Handle(apiV1Prefix+"/openapi.json", http.HandlerFunc(es.OpenAPIHandler))
+	mux.Handle(apiV1Prefix+"/", apiV1(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, r, http.StatusNotFound, "找不到 API 端點: "+r.URL.Path, nil)
+	})))
+}
+
+func queryParam(name, description string) apiParam {
+	return apiParam{Name: name, Schema: &apiSchema{Type: "string"}, Description: description}
+}
+
+func enumParam(name, description string, values []string) apiParam {
+	return apiParam{Name: name, Schema: &apiSchema{Type: "string", Enum: values}, Description: description}
+}
+
+func requiredParam(p apiParam) apiParam {
+	p.Required = true
+	return p
+}
+
+// 時間參數 (RFC3339、日期或毫秒時間戳記)
+var (
+	fromParam   = queryParam("from", "開始時間 (RFC3339、YYYY-MM-DD 或毫秒時間戳記)")
+	toParam     = queryParam("to", "結束時間 (格式同 from，只有日期時包含整天)")
+	deviceParam = queryParam("device", "電表 ID (預設第一個電表)")
+)
+
+// 所有 API 端點
+func apiRouteTable() []apiRoute {
+	s := apiSchemas
+	principal := s.of(Principal{})
+	gapsResponse := objectSchema(map[string]*apiSchema{
+		"from":   {Type: "string", Format: "date-time"},
+		"to":     {Type: "string", Format: "date-time"},
+		"meters": {Type: "array", Items: s.of(GapReport{})},
+	})
+	gapParams := []apiParam{queryParam("device", "電表 ID (預設全部)"), fromParam, toParam, queryParam("min_gap", "缺口門檻 (例如 1m)")}
+	exportParams := []apiParam{
+		enumParam("kind", "匯出種類", exportKinds), enumParam("format", "檔案格式", exportFormats),
+		deviceParam, queryParam("points", "點位，以逗號分隔"), fromParam, toParam,
+		enumParam("interval", "彙總間隔", exportIntervals), enumParam("lang", "欄位名稱語言", exportLanguages),
+		queryParam("tz", "時區 (例如 Asia/Taipei)"), enumParam("quality", "all 表示包含品質異常的資料", []string{"all"}),
+	}
+
+	return []apiRoute{
+		// 登入 (不需權限)
+		{"/auth/login", "", (*EnergySystem).LoginHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "登入並設定工作階段 Cookie",
+			Body:     objectSchema(map[string]*apiSchema{"username": {Type: "string"}, "password": {Type: "string"}}, "username", "password"),
+			Response: principal, ErrorStatus: []int{400, 401},
+		}}},
+		{"/auth/logout", "", (*EnergySystem).LogoutHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "登出", Status: http.StatusNoContent,
+		}}},
+		{"/auth/me", "", (*EnergySystem).MeHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "目前的身分與角色", Response: principal, ErrorStatus: []int{401},
+		}}},
+		{"/auth/password", RoleViewer, (*EnergySystem).PasswordHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "變更自己的密碼", Status: http.StatusNoContent,
+			Body:        objectSchema(map[string]*apiSchema{"old_password": {Type: "string"}, "new_password": {Type: "string"}}, "old_password", "new_password"),
+			ErrorStatus: []int{400},
+		}}},
+
+		// 查詢 (viewer)
+		{"/latest", RoleViewer, (*EnergySystem).GetLatestDataHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "各點位最新的讀值",
+			Params:   []apiParam{deviceParam},
+			Response: &apiSchema{Type: "array", Items: s.of(MeterReading{})}, ErrorStatus: []int{404},
+		}}},
+		{"/aggregated", RoleViewer, (*EnergySystem).GetAggregatedDataHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "依日曆期間彙總 (平均、最小、最大)",
+			Params: []apiParam{
+				requiredParam(enumParam("range", "時間範圍", []string{"daily", "monthly", "quarterly", "yearly"})),
+				requiredParam(queryParam("date", "日期 (2025-07-09、2025-07、2025-Q3 或 2025，依 range)")),
+				requiredParam(queryParam("parameter", "點位名稱")),
+				deviceParam, enumParam("quality", "all 表示包含品質異常的資料", []string{"all"}),
+			},
+			Response: &apiSchema{Type: "array", Items: s.of(AggregatedData{})}, ErrorStatus: []int{400},
+		}}},
+		{"/history", RoleViewer, (*EnergySystem).GetHistoryHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "歷史資料 (原始資料分頁或降採樣)",
+			Params: []apiParam{
+				deviceParam, requiredParam(queryParam("points", "點位，以逗號分隔")), fromParam, toParam,
+				enumParam("method", "降採樣方式", historyMethods), queryParam("max_points", "每個點位最多筆數"),
+				queryParam("cursor", "下一頁的游標 (next_cursor)"), enumParam("quality", "all 表示包含品質異常的資料", []string{"all"}),
+			},
+			Response: s.of(HistoryResponse{}), ErrorStatus: []int{400},
+		}}},
+		{"/export", RoleViewer, (*EnergySystem).ExportHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "匯出 CSV 或 Excel 檔案", Params: exportParams,
+			Produces: []string{"text/csv", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}, ErrorStatus: []int{400},
+		}}},
+		{"/alarms", RoleViewer, (*EnergySystem).GetAlarmsHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "進行中的告警與最近事件",
+			Response: objectSchema(map[string]*apiSchema{
+				"active": {Type: "array", Items: s.of(ActiveAlarm{}), Nullable: true},
+				"events": {Type: "array", Items: s.of(AlarmEvent{}), Nullable: true},
+			}),
+		}}},
+		{"/stats", RoleViewer, (*EnergySystem).GetStatsHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "輪詢、寫入佇列、暫存區、備份與 Modbus 閘道統計",
+			Response: objectSchema(map[string]*apiSchema{
+				"collectors":     {Type: "object", AdditionalProperties: s.of(PollStats{})},
+				"write_queue":    s.of(WriteQueueStats{}),
+				"spool":          {Type: "object", AdditionalProperties: s.of(SpoolStats{})},
+				"backup":         s.of(BackupStatus{}),
+				"modbus_gateway": s.of(ModbusServerStats{}),
+			}, "collectors", "spool", "backup"),
+		}}},
+		{"/gaps", RoleViewer, (*EnergySystem).GapsHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "資料缺口", Params: gapParams, Response: gapsResponse, ErrorStatus: []int{400},
+		}}},
+		{"/gaps/backfill", RoleOperator, (*EnergySystem).GapsHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "從電表內部紀錄補齊缺口", Params: gapParams, Response: gapsResponse, ErrorStatus: []int{400},
+		}}},
+		{"/energy/deltas", RoleViewer, (*EnergySystem).EnergyDeltasHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "累積電能計數器的用電量",
+			Params: []apiParam{deviceParam, queryParam("point", "計數器點位 (預設第一個)"), fromParam, toParam},
+			Response: objectSchema(map[string]*apiSchema{
+				"device": {Type: "string"}, "point": {Type: "string"}, "unit": {Type: "string"},
+				"from": {Type: "string", Format: "date-time"}, "to": {Type: "string", Format: "date-time"},
+				"total": {Type: "number"}, "backfilled": {Type: "number"}, "gap": {Type: "number"},
+				"deltas": {Type: "array", Items: s.of(EnergyDelta{}), Nullable: true},
+			}),
+			ErrorStatus: []int{400},
+		}}},
+
+		// 寫入資料 (operator)
+		{"/import", RoleOperator, (*EnergySystem).ImportHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "匯入歷史資料 (CSV、JSON、line protocol 或 multipart 上傳)",
+			Params: []apiParam{
+				deviceParam, enumParam("format", "檔案格式 (預設依內容判斷)", []string{"csv", "json", "lp"}),
+				queryParam("tz", "沒有時區的時間使用的時區"), queryParam("precision", "line protocol 時間精度 (ns、us、ms、s)"),
+				queryParam("mapping", "欄位對應 (JSON)"), enumParam("dry_run", "只檢查不寫入", []string{"1", "true"}),
+			},
+			BodyTypes: []string{"text/csv", "application/json", "text/plain", "multipart/form-data"},
+			Response:  &apiSchema{Type: "array", Items: s.of(ImportReport{})}, ErrorStatus: []int{400},
+		}}},
+
+		// 管理 (admin)
+		{"/actions", RoleAdmin, (*EnergySystem).ActionsHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "列出電表寫入動作 (write_actions)", Params: []apiParam{queryParam("device", "電表 ID (預設全部)")},
+			Response:    objectSchema(map[string]*apiSchema{"actions": {Type: "array", Items: s.of(MeterWriteAction{})}}),
+			ErrorStatus: []int{404},
+		}}},
+		{"/actions/prepare", RoleAdmin, (*EnergySystem).ActionPrepareHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "準備寫入：檢查數值並取得確認權杖 (不寫入)",
+			Body: objectSchema(map[string]*apiSchema{
+				"device": {Type: "string"}, "action": {Type: "string"}, "value": {Type: "number", Description: "動作未設定固定值時必填"},
+			}, "device", "action"),
+			Response: s.of(ActionConfirmation{}), ErrorStatus: []int{400, 404},
+		}}},
+		{"/actions/execute", RoleAdmin, (*EnergySystem).ActionExecuteHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "以確認權杖執行寫入並讀回驗證 (失敗時 details 為寫入結果)",
+			Body:        objectSchema(map[string]*apiSchema{"token": {Type: "string"}}),
+			Response:    s.of(ActionResult{}),
+			ErrorStatus: []int{400, 409, 502, 503},
+		}}},
+		{"/discover", RoleAdmin, (*EnergySystem).DiscoverHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "掃描網段尋找 Modbus 電表，比對型號並產生建議的 meters 設定",
+			Body: s.of(DiscoverRequest{}), Response: s.of(DiscoverResult{}), ErrorStatus: []int{400},
+		}}},
+		{"/diagnostics", RoleAdmin, (*EnergySystem).DiagnosticsHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "診斷資訊 (電表連線、資料庫、執行環境與建置資訊)", Response: s.of(Diagnostics{}),
+		}}},
+		{"/config/reload", RoleAdmin, (*EnergySystem).ReloadConfigHandler, []apiOperation{{
+			Method: http.MethodPost, Summary: "重新載入設定檔", Response: s.of(ReloadResult{}), ErrorStatus: []int{400},
+		}}},
+		{"/users", RoleAdmin, (*EnergySystem).UsersHandler, []apiOperation{
+			{Method: http.MethodGet, Summary: "列出使用者", Response: objectSchema(map[string]*apiSchema{
+				"users": {Type: "array", Items: s.of(User{}), Nullable: true},
+			})},
+			{Method: http.MethodPost, Summary: "新增 (201) 或更新 (200) 使用者", Status: http.StatusCreated,
+				Body: objectSchema(map[string]*apiSchema{
+					"name": {Type: "string"}, "password": {Type: "string"},
+					"role": {Type: "string", Enum: []string{RoleViewer, RoleOperator, RoleAdmin}}, "disabled": {Type: "boolean"},
+				}, "name"),
+				Response: s.of(User{}), ErrorStatus: []int{400}},
+			{Method: http.MethodDelete, Summary: "刪除使用者與其權杖", Status: http.StatusNoContent,
+				Params: []apiParam{requiredParam(queryParam("name", "使用者名稱"))}, ErrorStatus: []int{400, 404}},
+		}},
+		{"/tokens", RoleAdmin, (*EnergySystem).TokensHandler, []apiOperation{
+			{Method: http.MethodGet, Summary: "列出 API 權杖", Params: []apiParam{queryParam("user", "使用者 (預設全部)")},
+				Response: objectSchema(map[string]*apiSchema{"tokens": {Type: "array", Items: s.of(APIToken{}), Nullable: true}})},
+			{Method: http.MethodPost, Summary: "建立 API 權杖 (token 只在建立時回傳)", Status: http.StatusCreated,
+				Body: objectSchema(map[string]*apiSchema{
+					"user": {Type: "string"}, "name": {Type: "string"},
+					"role": {Type: "string", Enum: []string{RoleViewer, RoleOperator, RoleAdmin}}, "expires_in": {Type: "string"},
+				}, "user", "name"),
+				Response:    objectSchema(map[string]*apiSchema{"token": {Type: "string"}, "info": s.of(APIToken{})}),
+				ErrorStatus: []int{400}},
+			{Method: http.MethodDelete, Summary: "撤銷 API 權杖", Status: http.StatusNoContent,
+				Params: []apiParam{requiredParam(queryParam("id", "權杖 ID"))}, ErrorStatus: []int{404}},
+		}},
+		{"/audit", RoleAdmin, (*EnergySystem).AuditHandler, []apiOperation{{
+			Method: http.MethodGet, Summary: "稽核紀錄 (新到舊)",
+			Params:      []apiParam{fromParam, toParam, queryParam("user", "使用者"), queryParam("limit", "最多筆數 (預設 200)")},
+			Response:    objectSchema(map[string]*apiSchema{"events": {Type: "array", Items: s.of(AuditEvent{}), Nullable: true}}),
+			ErrorStatus: []int{400},
+		}}},
+	}
+}
+
+// JSON 結構 (OpenAPI 3.0 schema 的子集)
+type apiSchema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	AllOf                []*apiSchema          `json:"allOf,omitempty"`
+	Type                 string                `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Description          string                `json:"description,omitempty"`
+	Nullable             bool                  `json:"nullable,omitempty"`
+	Enum                 []string              `json:"enum,omitempty"`
+	Properties           map[string]*apiSchema `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	Items                *apiSchema            `json:"items,omitempty"`
+	AdditionalProperties *apiSchema            `json:"additionalProperties,omitempty"`
+}
+
+// 物件結構；未列在 required 的屬性可省略
+func objectSchema(properties map[string]*apiSchema, required ...string) *apiSchema {
+	if required == nil {
+		for name := range properties {
+			required = append(required, name)
+		}
+		sort.Strings(required)
+	}
+	return &apiSchema{Type: "object", Properties: properties, Required: required}
+}
+
+// 由 Go 型別產生的結構 (components/schemas)
+type schemaRegistry struct {
+	mu         sync.Mutex
+	components map[string]*apiSchema
+}
+
+var apiSchemas = &schemaRegistry{components: make(map[string]*apiSchema)}
+
+// 值的型別對應的結構
+func (reg *schemaRegistry) of(v interface{}) *apiSchema {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.schemaFor(reflect.TypeOf(v))
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// 依 encoding/json 的規則產生結構：具名 struct 放在 components，
+// 指標、slice 與 map 可能為 null，omitempty 的欄位不列為必要
+func (reg *schemaRegistry) schemaFor(t reflect.Type) *apiSchema {
+	switch t {
+	case timeType:
+		return &apiSchema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &apiSchema{Type: "integer", Format: "int64", Description: "奈秒"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		inner := reg.schemaFor(t.Elem())
+		if inner.Ref != "" {
+			return &apiSchema{AllOf: []*apiSchema{inner}, Nullable: true}
+		}
+		inner.Nullable = true
+		return inner
+	case reflect.Bool:
+		return &apiSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &apiSchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &apiSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &apiSchema{Type: "number"}
+	case reflect.String:
+		return &apiSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &apiSchema{Type: "string", Format: "byte"}
+		}
+		return &apiSchema{Type: "array", Items: reg.schemaFor(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &apiSchema{Type: "object", AdditionalProperties: reg.schemaFor(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return reg.structSchema(t)
+		}
+		ref := &apiSchema{Ref: "#/components/schemas/" + t.Name()}
+		if _, ok := reg.components[t.Name()]; !ok {
+			reg.components[t.Name()] = &apiSchema{} // 先佔位，避免遞迴型別無限展開
+			*reg.components[t.Name()] = *reg.structSchema(t)
+		}
+		return ref
+	}
+	return &apiSchema{} // interface{}：任意值
+}
+
+func (reg *schemaRegistry) structSchema(t reflect.Type) *apiSchema {
+	schema := &apiSchema{Type: "object", Properties: make(map[string]*apiSchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := reg.structSchema(field.Type)
+			for prop, s := range embedded.Properties {
+				schema.Properties[prop] = s
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = reg.schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// OpenAPI 文件 (產生一次後快取)
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+func openAPIDocument() []byte {
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.MarshalIndent(buildOpenAPI(apiRouteTable()), "", "  ")
+	})
+	return openAPIJSON
+}
+
+// api 子命令
+func runAPICommand(args []string) int {
+	if len(args) == 1 && args[0] == "openapi" {
+		os.Stdout.Write(openAPIDocument())
+		fmt.Println()
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, "用法: energy_system api openapi")
+	return 2
+}
+
+func buildOpenAPI(routes []apiRoute) map[string]interface{} {
+	errorResponse := func(status int) map[string]interface{} {
+		return map[string]interface{}{
+			"description": http.StatusText(status),
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": &apiSchema{Ref: "#/components/schemas/ErrorResponse"}},
+			},
+		}
+	}
+	authenticated := []map[string][]string{{"bearerAuth": {}}, {"basicAuth": {}}, {"sessionCookie": {}}}
+
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		item := make(map[string]interface{})
+		for _, op := range route.Ops {
+			status := op.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			success := map[string]interface{}{"description": http.StatusText(status)}
+			switch {
+			case op.Produces != nil:
+				content := make(map[string]interface{})
+				for _, mediaType := range op.Produces {
+					content[mediaType] = map[string]interface{}{"schema": &apiSchema{Type: "string", Format: "binary"}}
+				}
+				success["content"] = content
+			case op.Response != nil:
+				success["content"] = map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": objectSchema(map[string]*apiSchema{"data": op.Response})},
+				}
+			}
+			responses := map[string]interface{}{strconv.Itoa(status): success}
+			if route.Path == "/users" && op.Method == http.MethodPost {
+				responses["200"] = success // 更新既有使用者
+			}
+			errorStatus := append([]int{}, op.ErrorStatus...)
+			if route.Role != "" {
+				errorStatus = append(errorStatus, http.StatusUnauthorized, http.StatusForbidden)
+			}
+			errorStatus = append(errorStatus, http.StatusInternalServerError)
+			for _, code := range errorStatus {
+				responses[strconv.Itoa(code)] = errorResponse(code)
+			}
+
+			operation := map[string]interface{}{
+				"operationId": operationID(op.Method, route.Path),
+				"summary":     op.Summary,
+				"tags":        []string{strings.Split(strings.TrimPrefix(route.Path, "/"), "/")[0]},
+				"responses":   responses,
+			}
+			if route.Role != "" {
+				operation["security"] = authenticated
+				operation["x-required-role"] = route.Role
+			} else {
+				operation["security"] = []map[string][]string{}
+			}
+			if len(op.Params) > 0 {
+				params := make([]map[string]interface{}, 0, len(op.Params))
+				for _, p := range op.Params {
+					in := p.In
+					if in == "" {
+						in = "query"
+					}
+					params = append(params, map[string]interface{}{
+						"name": p.Name, "in": in, "required": p.Required, "schema": p.Schema, "description": p.Description,
+					})
+				}
+				operation["parameters"] = params
+			}
+			if op.Body != nil || op.BodyTypes != nil {
+				content := make(map[string]interface{})
+				if op.Body != nil {
+					content["application/json"] = map[string]interface{}{"schema": op.Body}
+				}
+				for _, mediaType := range op.BodyTypes {
+					content[mediaType] = map[string]interface{}{"schema": &apiSchema{Type: "string", Format: "binary"}}
+				}
+				operation["requestBody"] = map[string]interface{}{"required": true, "content": content}
+			}
+			item[strings.ToLower(op.Method)] = operation
+		}
+		paths[apiV1Prefix+route.Path] = item
+	}
+
+	schemas := make(map[string]*apiSchema)
+	apiSchemas.mu.Lock()
+	for name, schema := range apiSchemas.components {
+		schemas[name] = schema
+	}
+	apiSchemas.mu.Unlock()
+	schemas["ErrorResponse"] = objectSchema(map[string]*apiSchema{
+		"error": objectSchema(map[string]*apiSchema{
+			"code":    {Type: "string", Description: "錯誤代碼 (invalid_request、unauthenticated、forbidden、not_found 等)"},
+			"message": {Type: "string"},
+			"details": {Description: "錯誤明細 (例如設定驗證錯誤清單)"},
+		}, "code", "message"),
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Energy Monitoring API",
+			"version":     "1.0.0",
+			"description": "成功回應為 {\"data\": ...}，失敗回應為 {\"error\": {\"code\", \"message\", \"details\"}}",
+		},
+		"servers": []map[string]string{{"url": "/"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth":    map[string]string{"type": "http", "scheme": "bearer", "description": "API 權杖 (emt_...) 或 http.admin_token"},
+				"basicAuth":     map[string]string{"type": "http", "scheme": "basic"},
+				"sessionCookie": map[string]string{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+		},
+	}
+}
+
+// operationId，例如 GET /energy/deltas → getEnergyDeltas
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '_' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// OpenAPI 文件 (不需登入)
+func (es *EnergySystem) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAPIError(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey, true)), http.StatusMethodNotAllowed, "不支援的方法", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(openAPIDocument())
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// API 合約檢查：以暫存資料庫啟動 API，對每個端點送出請求，
+// 確認狀態碼、內容類型與回應內容符合 /api/v1/openapi.json 的定義，且每個定義的操作都有檢查到
+
+const (
+	checkAdminToken   = "api-check-admin-token"
+	checkUserName     = "api-check"
+	checkUserPassword = "api-check-password-1"
+	checkNewPassword  = "api-check-password-2"
+)
+
+// 檢查範圍 (checkBase 起一小時)
+var (
+	checkFrom = checkBase.Format(time.RFC3339)
+	checkTo   = checkBase.Add(time.Hour).Format(time.RFC3339)
+	checkSpan = "device=check&from=" + checkFrom + "&to=" + checkTo
+)
+
+type apiCheck struct {
+	name        string
+	method      string
+	target      string // 路徑與查詢參數，{名稱} 以先前檢查記錄的值取代 (body 亦同)
+	auth        string // 空白為 admin 權杖；none 不帶身分、user 以檢查帳號 (Basic)、session 以登入的 Cookie
+	contentType string
+	body        string
+	status      int
+	before      func(c *apiChecker)
+	after       func(c *apiChecker, resp *http.Response, data interface{}) error
+}
+
+var apiChecks = []apiCheck{
+	{name: "最新讀值", method: "GET", target: "/latest", status: 200},
+	{name: "彙總資料", method: "GET", target: "/aggregated?range=daily&date=2024-03-01&parameter=P1&device=check", status: 200},
+	{name: "歷史資料 (原始)", method: "GET", target: "/history?points=P1,E&" + checkSpan, status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			series, _ := data.(map[string]interface{})["series"].([]interface{})
+			return expectEqual("序列數", len(series), 2)
+		}},
+	{name: "歷史資料 (降採樣)", method: "GET", target: "/history?points=P1&method=lttb&max_points=3&" + checkSpan, status: 200},
+	{name: "匯出 CSV", method: "GET", target: "/export?kind=raw&format=csv&" + checkSpan, status: 200},
+	{name: "告警", method: "GET", target: "/alarms", status: 200},
+	{name: "運作統計", method: "GET", target: "/stats", status: 200},
+	{name: "資料缺口", method: "GET", target: "/gaps?min_gap=2m&" + checkSpan, status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			meters, _ := data.(map[string]interface{})["meters"].([]interface{})
+			if len(meters) != 1 {
+				return fmt.Errorf("電表數: 預期 1，實際 %d", len(meters))
+			}
+			// 第 5~7 分鐘與最後一筆之後 (尚未恢復) 的缺口
+			gaps, _ := meters[0].(map[string]interface{})["gaps"].([]interface{})
+			return expectEqual("缺口數", len(gaps), 2)
+		}},
+	{name: "補齊缺口", method: "POST", target: "/gaps/backfill?min_gap=2m&" + checkSpan, status: 200},
+	{name: "用電量", method: "GET", target: "/energy/deltas?" + checkSpan, status: 200},
+	{name: "匯入 (只檢查)", method: "POST", target: "/import?format=lp&precision=s&dry_run=1", contentType: "text/plain",
+		body: fmt.Sprintf("energy,device=check,point=P1,unit=V value=231 %d\n", checkBase.Add(20*time.Minute).Unix()), status: 200},
+	{name: "診斷資訊", method: "GET", target: "/diagnostics", status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			meters, _ := data.(map[string]interface{})["meters"].([]interface{})
+			if err := expectEqual("電表數", len(meters), 1); err != nil {
+				return err
+			}
+			database, _ := data.(map[string]interface{})["database"].(map[string]interface{})
+			return expectEqual("結構版本", database["schema_version"], schemaVersion)
+		}},
+	{name: "寫入動作", method: "GET", target: "/actions?device=check", status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			actions, _ := data.(map[string]interface{})["actions"].([]interface{})
+			return expectEqual("動作數", len(actions), 2)
+		}},
+	{name: "準備寫入", method: "POST", target: "/actions/prepare", contentType: "application/json",
+		body: `{"device":"check","action":"set_ratio","value":200}`, status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			token, _ := data.(map[string]interface{})["token"].(string)
+			if token == "" {
+				return fmt.Errorf("缺少確認權杖")
+			}
+			c.vars["action_token"] = token
+			return expectEqual("資料", data.(map[string]interface{})["data"], "00c8")
+		}},
+	{name: "準備寫入 (超出範圍)", method: "POST", target: "/actions/prepare", contentType: "application/json",
+		body: `{"device":"check","action":"set_ratio","value":0}`, status: 400},
+	{name: "準備寫入 (未知動作)", method: "POST", target: "/actions/prepare", contentType: "application/json",
+		body: `{"device":"check","action":"unknown"}`, status: 404},
+	{name: "執行寫入 (無效權杖)", method: "POST", target: "/actions/execute", contentType: "application/json",
+		body: `{"token":"invalid"}`, status: 400},
+	{name: "執行寫入", method: "POST", target: "/actions/execute", contentType: "application/json",
+		body: `{"token":"{action_token}"}`, status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			if err := expectEqual("驗證", data.(map[string]interface{})["verified"], true); err != nil {
+				return err
+			}
+			return expectEqual("電表暫存器", c.meter.register(0x10), 200)
+		}},
+	{name: "執行寫入 (重複使用權杖)", method: "POST", target: "/actions/execute", contentType: "application/json",
+		body: `{"token":"{action_token}"}`, status: 400},
+	{name: "掃描網段", method: "POST", target: "/discover", contentType: "application/json",
+		body: `{"cidr":"127.0.0.1/32","port":{meter_port},"units":"1-2","timeout_ms":500}`, status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			meters, _ := data.(map[string]interface{})["meters"].([]interface{})
+			if err := expectEqual("裝置數", len(meters), 2); err != nil {
+				return err
+			}
+			if err := expectEqual("已設定", meters[0].(map[string]interface{})["configured"], "check"); err != nil {
+				return err
+			}
+			proposed, _ := meters[1].(map[string]interface{})["proposed"].(map[string]interface{})
+			return expectEqual("建議型號", proposed["model"], "check")
+		}},
+	{name: "掃描網段 (範圍太大)", method: "POST", target: "/discover", contentType: "application/json",
+		body: `{"cidr":"10.0.0.0/8"}`, status: 400},
+	{name: "重新載入設定", method: "POST", target: "/config/reload", status: 200},
+	{name: "重新載入無效設定", method: "POST", target: "/config/reload", status: 400,
+		before: func(c *apiChecker) { c.invalidConfig = true },
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			details, _ := data.(map[string]interface{})["details"].([]interface{})
+			if len(details) == 0 {
+				return fmt.Errorf("錯誤缺少 details")
+			}
+			return nil
+		}},
+
+	{name: "新增使用者", method: "POST", target: "/users", contentType: "application/json",
+		body: `{"name":"` + checkUserName + `","password":"` + checkUserPassword + `","role":"viewer"}`, status: 201},
+	{name: "更新使用者", method: "POST", target: "/users", contentType: "application/json",
+		body: `{"name":"` + checkUserName + `","role":"viewer"}`, status: 200},
+	{name: "列出使用者", method: "GET", target: "/users", status: 200},
+	{name: "登入", method: "POST", target: "/auth/login", auth: "none", contentType: "application/json",
+		body: `{"username":"` + checkUserName + `","password":"` + checkUserPassword + `"}`, status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			for _, cookie := range resp.Cookies() {
+				if cookie.Name == sessionCookieName {
+					c.session = cookie
+					return nil
+				}
+			}
+			return fmt.Errorf("沒有設定 %s Cookie", sessionCookieName)
+		}},
+	{name: "目前身分", method: "GET", target: "/auth/me", auth: "session", status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			return expectEqual("角色", data.(map[string]interface{})["role"], RoleViewer)
+		}},
+	{name: "登出", method: "POST", target: "/auth/logout", auth: "session", status: 204},
+	{name: "角色不足", method: "GET", target: "/users", auth: "user", status: 403},
+	{name: "變更密碼", method: "POST", target: "/auth/password", auth: "user", contentType: "application/json",
+		body: `{"old_password":"` + checkUserPassword + `","new_password":"` + checkNewPassword + `"}`, status: 204},
+	{name: "建立權杖", method: "POST", target: "/tokens", contentType: "application/json",
+		body: `{"user":"` + checkUserName + `","name":"check","role":"viewer","expires_in":"1h"}`, status: 201,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			info, _ := data.(map[string]interface{})["info"].(map[string]interface{})
+			id, _ := info["id"].(string)
+			if id == "" {
+				return fmt.Errorf("缺少權杖 ID")
+			}
+			c.vars["token_id"] = id
+			return nil
+		}},
+	{name: "列出權杖", method: "GET", target: "/tokens?user=" + checkUserName, status: 200},
+	{name: "撤銷權杖", method: "DELETE", target: "/tokens?id={token_id}", status: 204},
+	{name: "刪除使用者", method: "DELETE", target: "/users?name=" + checkUserName, status: 204},
+	{name: "稽核紀錄", method: "GET", target: "/audit?limit=10", status: 200,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			events, _ := data.(map[string]interface{})["events"].([]interface{})
+			if len(events) == 0 {
+				return fmt.Errorf("沒有稽核紀錄")
+			}
+			return nil
+		}},
+
+	// 錯誤回應
+	{name: "未登入", method: "GET", target: "/latest", auth: "none", status: 401},
+	{name: "參數錯誤", method: "GET", target: "/aggregated?range=weekly&date=2024-03-01&parameter=P1", status: 400},
+	{name: "不支援的方法", method: "DELETE", target: "/latest", status: 405,
+		after: func(c *apiChecker, resp *http.Response, data interface{}) error {
+			return expectEqual("Allow", resp.Header.Get("Allow"), "GET")
+		}},
+	{name: "不存在的端點", method: "GET", target: "/not-found", status: 404},
+}
+
+type apiChecker struct {
+	handler http.Handler
+	doc     map[string]interface{}
+	schemas map[string]interface{}
+	session *http.Cookie
+	meter   *checkMeter // 模擬的電表 (Modbus 從站)
+	vars    map[string]string
+	covered map[string]bool // 已檢查的操作 (方法 路徑)
+
+	invalidConfig bool // 重新載入時使用無效的設定
+}
+
+// 送出請求並依 OpenAPI 文件檢查回應；回傳 data (成功) 或 error (失敗) 的內容
+func (c *apiChecker) run(check apiCheck) (*http.Response, interface{}, error) {
+	target, reqBody := check.target, check.body
+	for name, value := range c.vars {
+		target = strings.ReplaceAll(target, "{"+name+"}", value)
+		reqBody = strings.ReplaceAll(reqBody, "{"+name+"}", value)
+	}
+	req := httptest.NewRequest(check.method, apiV1Prefix+target, strings.NewReader(reqBody))
+	if check.contentType != "" {
+		req.Header.Set("Content-Type", check.contentType)
+	}
+	switch check.auth {
+	case "":
+		req.Header.Set("Authorization", "Bearer "+checkAdminToken)
+	case "user":
+		password := checkUserPassword
+		if c.covered["POST /auth/password"] {
+			password = checkNewPassword
+		}
+		req.SetBasicAuth(checkUserName, password)
+	case "session":
+		if c.session == nil {
+			return nil, nil, fmt.Errorf("尚未登入")
+		}
+		req.AddCookie(c.session)
+	}
+
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	if resp.StatusCode != check.status {
+		return resp, nil, fmt.Errorf("狀態碼: 預期 %d，實際 %d (%s)", check.status, resp.StatusCode, strings.TrimSpace(rec.Body.String()))
+	}
+
+	path := strings.SplitN(target, "?", 2)[0]
+	response, documented := c.response(path, check.method, resp.StatusCode)
+	if !documented {
+		if resp.StatusCode < 400 {
+			return resp, nil, fmt.Errorf("OpenAPI 文件沒有定義 %s %s 的 %d 回應", check.method, path, resp.StatusCode)
+		}
+		// 未定義的端點或方法：仍須為結構化錯誤
+		response = map[string]interface{}{"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/ErrorResponse"}},
+		}}
+	}
+
+	content, _ := response["content"].(map[string]interface{})
+	if content == nil {
+		if rec.Body.Len() > 0 {
+			return resp, nil, fmt.Errorf("預期沒有內容，實際 %d bytes", rec.Body.Len())
+		}
+		c.covered[check.method+" "+path] = true
+		return resp, nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		return resp, nil, fmt.Errorf("未定義的內容類型: %s", mediaType)
+	}
+	if mediaType != "application/json" {
+		c.covered[check.method+" "+path] = true
+		return resp, nil, nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		return resp, nil, fmt.Errorf("回應不是 JSON: %v", err)
+	}
+	if err := c.validate(media["schema"], body, "$"); err != nil {
+		return resp, nil, err
+	}
+	c.covered[check.method+" "+path] = true
+
+	envelope := body.(map[string]interface{})
+	if resp.StatusCode >= 400 {
+		return resp, envelope["error"], nil
+	}
+	return resp, envelope["data"], nil
+}
+
+// OpenAPI 文件中的回應定義
+func (c *apiChecker) response(path, method string, status int) (map[string]interface{}, bool) {
+	paths, _ := c.doc["paths"].(map[string]interface{})
+	item, _ := paths[apiV1Prefix+path].(map[string]interface{})
+	op, _ := item[strings.ToLower(method)].(map[string]interface{})
+	responses, _ := op["responses"].(map[string]interface{})
+	response, ok := responses[fmt.Sprint(status)].(map[string]interface{})
+	return response, ok
+}
+
+// 依 schema 檢查值；未定義的屬性視為錯誤，確保文件與實作同步
+func (c *apiChecker) validate(schemaValue interface{}, value interface{}, where string) error {
+	schema, _ := schemaValue.(map[string]interface{})
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := c.schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: 找不到結構 %s", where, ref)
+		}
+		return c.validate(resolved, value, where)
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || len(schema) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%s: 不可為 null", where)
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if err := c.validate(sub, value, where); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: 預期物件，實際 %T", where, value)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: 缺少屬性 %s", where, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, hasAdditional := schema["additionalProperties"]
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var err error
+			if prop, ok := properties[name]; ok {
+				err = c.validate(prop, object[name], where+"."+name)
+			} else if hasAdditional {
+				err = c.validate(additional, object[name], where+"."+name)
+			} else if properties != nil {
+				err = fmt.Errorf("%s: 文件未定義的屬性 %s", where, name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: 預期陣列，實際 %T", where, value)
+		}
+		for i, item := range array {
+			if err := c.validate(schema["items"], item, fmt.Sprintf("%s[%d]", where, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: 預期字串，實際 %T", where, value)
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			found := false
+			for _, v := range enum {
+				found = found || v == s
+			}
+			if !found {
+				return fmt.Errorf("%s: %q 不在允許的值 %v 中", where, s, enum)
+			}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: 時間格式錯誤: %s", where, s)
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s: 預期數字，實際 %T", where, value)
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			return fmt.Errorf("%s: 預期整數，實際 %v", where, n)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: 預期布林值，實際 %T", where, value)
+		}
+	}
+	return nil
+}
+
+// 檢查用的設定：一個電表 (兩個點位，其中一個為累積電能)，不連線
+func apiCheckConfig(port int) *Config {
+	config := DefaultConfig()
+	config.HTTP.AdminToken = checkAdminToken
+	config.Meters = []MeterConfig{{ID: "check", Host: "127.0.0.1", Port: port, SlaveID: 1, Model: "check"}}
+	config.RegisterMaps = map[string][]MeterParameter{"check": {
+		{Name: "P1", Address: 0, Unit: "V"},
+		{Name: "E", Address: 2, Unit: "kWh", Counter: true},
+	}}
+	config.LoadProfiles = nil
+	config.Discovery.Models = map[string]DiscoveryModel{"check": {Signature: []string{"P1"}}}
+	ratioMin, ratioMax, reset := 1.0, 9999.0, 1.0
+	config.WriteActions = map[string][]WriteAction{"check": {
+		{Name: "set_ratio", Function: "register", Address: 0x10, Min: &ratioMin, Max: &ratioMax},
+		{Name: "reset_energy", Function: "register", Address: 0x20, Value: &reset, Verify: "point", VerifyPoint: "E"},
+	}}
+	// 單元 1 使用自訂配置 (abcd)，單元 2 依暫存器對照表自動排列 (cdab)
+	config.Outputs.Modbus.Units = []ModbusGatewayUnit{
+		{UnitID: 1, Meter: "check", Layout: "check"},
+		{UnitID: 2, Meter: "check", WordOrder: "cdab"},
+	}
+	config.Outputs.Modbus.Layouts = map[string][]ModbusGatewayRegister{"check": {
+		{Address: 0, Point: "P1"},
+		{Address: 2, Point: "E", Value: gatewayValueDailyEnergy},
+		{Address: 4, Point: "P1", Value: gatewayValueDemand},
+		{Address: 6, Point: "P1", Value: gatewayValueQuality},
+		{Address: 7, Point: "P1", Type: "i32", Scale: 10},
+	}}
+	return config
+}
+
+// 模擬的電表：保存寫入的暫存器，讀取時回傳 (FC03/FC06/FC16)
+type checkMeter struct {
+	mu        sync.Mutex
+	registers map[uint16]uint16
+}
+
+func (m *checkMeter) register(address uint16) uint16 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.registers[address]
+}
+
+func (m *checkMeter) handle(unitID byte, pdu []byte) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	function := pdu[0]
+	switch {
+	case function == 0x03:
+		start, quantity, exception := parseReadRequest(pdu, modbusMaxReadRegisters)
+		if exception != nil {
+			return exception
+		}
+		response := []byte{function, byte(quantity * 2)}
+		for i := uint16(0); i < quantity; i++ {
+			response = binary.BigEndian.AppendUint16(response, m.registers[start+i])
+		}
+		return response
+	case function == 0x06 && len(pdu) == 5:
+		m.registers[binary.BigEndian.Uint16(pdu[1:3])] = binary.BigEndian.Uint16(pdu[3:5])
+		return pdu
+	case function == 0x10 && len(pdu) >= 6 && len(pdu) == 6+int(pdu[5]):
+		start := binary.BigEndian.Uint16(pdu[1:3])
+		for i := 0; i < int(pdu[5])/2; i++ {
+			m.registers[start+uint16(i)] = binary.BigEndian.Uint16(pdu[6+2*i:])
+		}
+		return pdu[:5]
+	}
+	return modbusException(function, modbusIllegalFunction)
+}
+
+// 檢查資料：每分鐘一筆，第 5~7 分鐘缺資料
+func seedAPICheck(store Storage) error {
+	for i := 0; i < 10; i++ {
+		if i >= 5 && i < 8 {
+			continue
+		}
+		readings := []MeterReading{
+			{Name: "P1", Value: float64(220 + i), Unit: "V", Quality: QualityGood},
+			{Name: "E", Value: float64(1000 + i), Unit: "kWh", Quality: QualityGood},
+		}
+		if err := store.WriteSamples([]PollRecord{{"check", checkBase.Add(time.Duration(i) * time.Minute), readings}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLite 使用暫存目錄中的資料庫
+func TestAPIContractSQLite(t *testing.T) {
+	database := DefaultConfig().Database
+	database.Driver = "sqlite"
+	runAPIChecks(t, database)
+}
+
+// PostgreSQL 需以 ENERGY_TEST_POSTGRES_DSN 指定連線字串，檢查使用暫存 schema，結束後刪除
+func TestAPIContractPostgres(t *testing.T) {
+	dsn := os.Getenv("ENERGY_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未設定 ENERGY_TEST_POSTGRES_DSN")
+	}
+	database := DefaultConfig().Database
+	database.Driver, database.DSN = "postgres", dsn
+	runAPIChecks(t, database)
+}
+
+// 依序執行所有檢查 (後面的檢查沿用前面建立的使用者、權杖與寫入動作)
+func runAPIChecks(t *testing.T, database DatabaseConfig) {
+	store, cleanup, err := openScratchStorage(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	if err := store.Init(); err != nil {
+		t.Fatalf("初始化失敗: %v", err)
+	}
+	if err := seedAPICheck(store); err != nil {
+		t.Fatalf("寫入檢查資料失敗: %v", err)
+	}
+
+	// 寫入動作與 Modbus 閘道使用模擬的電表，收集器不執行輪詢
+	meter := &checkMeter{registers: make(map[uint16]uint16)}
+	slave, err := listenModbus("127.0.0.1:0", 4, 0, meter.handle)
+	if err != nil {
+		t.Fatalf("無法啟動模擬電表: %v", err)
+	}
+	t.Cleanup(func() { slave.Close() })
+	port := slave.Addr().(*net.TCPAddr).Port
+
+	config := apiCheckConfig(port)
+	es := NewEnergySystem(config)
+	es.store = store
+	es.alarms = NewAlarmEngine(store, config.Alarms)
+	collector := NewMeterCollector(es, config, config.Meters[0])
+	t.Cleanup(collector.disconnect)
+	es.collectors["check"] = collector
+	mux := http.NewServeMux()
+	es.registerAPIRoutes(mux)
+	c := &apiChecker{handler: mux, meter: meter, vars: make(map[string]string), covered: make(map[string]bool)}
+	c.vars["meter_port"] = strconv.Itoa(port)
+	es.loadConfig = func() (*Config, error) {
+		config := apiCheckConfig(port)
+		if c.invalidConfig {
+			config.Meters[0].ID = ""
+		}
+		return config, nil
+	}
+
+	if err := json.Unmarshal(openAPIDocument(), &c.doc); err != nil {
+		t.Fatalf("OpenAPI 文件: %v", err)
+	}
+	components, _ := c.doc["components"].(map[string]interface{})
+	c.schemas, _ = components["schemas"].(map[string]interface{})
+
+	for _, check := range apiChecks {
+		check := check
+		t.Run(check.name, func(t *testing.T) {
+			c.invalidConfig = false
+			if check.before != nil {
+				check.before(c)
+			}
+			resp, data, err := c.run(check)
+			if err == nil && check.after != nil {
+				err = check.after(c, resp, data)
+			}
+			if err != nil {
+				t.Fatalf("%s %s: %v", check.method, check.target, err)
+			}
+		})
+	}
+
+	// 舊版 /api 維持原本的回應格式 (沒有包裝)
+	t.Run("舊版 /api 相容", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/latest", nil)
+		req.Header.Set("Authorization", "Bearer "+checkAdminToken)
+		mux.ServeHTTP(rec, req)
+		var legacy []MeterReading
+		if err := json.Unmarshal(rec.Body.Bytes(), &legacy); err != nil || len(legacy) != 2 {
+			t.Fatalf("%v (%s)", err, strings.TrimSpace(rec.Body.String()))
+		}
+	})
+
+	t.Run("Modbus 閘道", func(t *testing.T) {
+		if err := checkModbusGateway(es, collector); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// 每個定義的操作都需要有檢查
+	for _, route := range apiRouteTable() {
+		for _, op := range route.Ops {
+			if !c.covered[op.Method+" "+route.Path] {
+				t.Errorf("沒有檢查到 %s %s%s", op.Method, apiV1Prefix, route.Path)
+			}
+		}
+	}
+}
+
+// 啟動閘道，送入兩次輪詢結果後以 Modbus 主站讀取，確認數值、位元組順序與例外回應
+func checkModbusGateway(es *EnergySystem, collector *MeterCollector) error {
+	config := es.Config().Outputs.Modbus
+	config.Listen = "127.0.0.1:0"
+	gw, err := StartModbusGateway(es, config)
+	if err != nil {
+		return err
+	}
+	defer gw.Stop()
+
+	now := time.Now()
+	collector.mu.Lock()
+	collector.stats.LastSuccess = now
+	collector.mu.Unlock()
+	gw.Observe("check", []MeterReading{
+		{Name: "P1", Value: 220, Quality: QualityGood}, {Name: "E", Value: 1000, Quality: QualityGood},
+	}, now.Add(-time.Minute))
+	gw.Observe("check", []MeterReading{
+		{Name: "P1", Value: 230.5, Quality: QualityGood}, {Name: "E", Value: 1002.5, Quality: QualityGood},
+	}, now)
+
+	read := func(unitID byte, function byte, address, quantity uint16) ([]byte, error) {
+		handler := modbus.NewTCPClientHandler(gw.server.Addr().String())
+		handler.SlaveId = unitID
+		handler.Timeout = 2 * time.Second
+		defer handler.Close()
+		client := modbus.NewClient(handler)
+		if function == 0x04 {
+			return client.ReadInputRegisters(address, quantity)
+		}
+		return client.ReadHoldingRegisters(address, quantity)
+	}
+	float := func(data []byte) float64 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	}
+	expectException := func(what string, err error, code byte) error {
+		var modbusErr *modbus.ModbusError
+		if !errors.As(err, &modbusErr) || modbusErr.ExceptionCode != code {
+			return fmt.Errorf("%s: 預期例外碼 %d，實際 %v", what, code, err)
+		}
+		return nil
+	}
+
+	data, err := read(1, 0x03, 0, 9)
+	if err != nil {
+		return fmt.Errorf("讀取單元 1: %v", err)
+	}
+	for _, e := range []error{
+		expectFloat("最新讀值", float(data[0:4]), 230.5),
+		expectFloat("本日用電量", float(data[4:8]), 2.5),
+		expectFloat("需量", float(data[8:12]), 225.25),
+		expectEqual("品質代碼", binary.BigEndian.Uint16(data[12:14]), 0),
+		expectEqual("i32 × 10", int32(binary.BigEndian.Uint32(data[14:18])), 2305),
+	} {
+		if e != nil {
+			return e
+		}
+	}
+	// 單元 2 依暫存器對照表排列，cdab 順序
+	if data, err = read(2, 0x04, 0, 4); err != nil {
+		return fmt.Errorf("讀取單元 2: %v", err)
+	}
+	if err := expectFloat("cdab 最新讀值", float(append(data[2:4:4], data[0:2]...)), 230.5); err != nil {
+		return err
+	}
+
+	exceptions := []struct {
+		what     string
+		unitID   byte
+		function byte
+		address  uint16
+		quantity uint16
+		code     byte
+	}{
+		{"未對應的單元", 3, 0x03, 0, 2, modbusGatewayPath},
+		{"超出配置範圍", 1, 0x03, 8, 2, modbusIllegalAddress},
+		{"不支援的功能碼", 1, 0x01, 0, 1, modbusIllegalFunction},
+	}
+	for _, e := range exceptions {
+		var err error
+		if e.function == 0x01 {
+			handler := modbus.NewTCPClientHandler(gw.server.Addr().String())
+			handler.SlaveId = e.unitID
+			_, err = modbus.NewClient(handler).ReadCoils(e.address, e.quantity)
+			handler.Close()
+		} else {
+			_, err = read(e.unitID, e.function, e.address, e.quantity)
+		}
+		if err := expectException(e.what, err, e.code); err != nil {
+			return err
+		}
+	}
+
+	// 電表最近沒有輪詢成功時回應目標裝置沒有回應
+	collector.mu.Lock()
+	collector.stats.LastSuccess = time.Time{}
+	collector.mu.Unlock()
+	_, err = read(1, 0x03, 0, 2)
+	return expectException("電表離線", err, modbusGatewayTarget)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+	"path"
+	"strings"
+
+	"energy-monitoring/internal/webcore"
+)
+
+// 看板網頁檔案編入執行檔 (由 webcore.AssetServer 傳送)，只有這些檔案 (與 assets_dir 覆寫目錄) 可從網頁存取
+//
+//go:embed energy_dashboard.html login.html thinkpower.ico css js
+var dashboardFiles embed.FS
+
+// 不需登入即可取得的檔案類型 (登入頁面使用)
+var publicAssetExts = map[string]bool{
+	".css": true, ".js": true, ".ico": true, ".png": true, ".jpg": true, ".svg": true, ".woff": true, ".woff2": true,
+}
+
+// 看板網頁：只提供 /dashboard/ 下的網頁檔案，其餘路徑一律 404；
+// 登入頁面與 css/js/圖片不需登入，其他頁面需要 viewer 權限
+func (es *EnergySystem) staticHandler() (http.Handler, error) {
+	assets, err := webcore.NewAssetServer(dashboardFiles, es.config.HTTP.AssetsDir)
+	if err != nil {
+		return nil, err
+	}
+	protected := es.authorize(RoleViewer, false, func(w http.ResponseWriter, r *http.Request) {
+		name, ok := webcore.AssetName(strings.TrimPrefix(r.URL.Path, webcore.DashboardPrefix))
+		if !ok || !assets.Has(name) {
+			http.NotFound(w, r)
+			return
+		}
+		assets.Serve(w, r, name)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/" || r.URL.Path == webcore.DashboardPrefix || r.URL.Path == "/energy_dashboard.html":
+			http.Redirect(w, r, webcore.DashboardPrefix+"energy_dashboard.html", http.StatusFound)
+			return
+		case r.URL.Path == "/login.html":
+			// 舊網址
+			http.Redirect(w, r, webcore.DashboardPrefix+"login.html?"+r.URL.RawQuery, http.StatusFound)
+			return
+		case !strings.HasPrefix(r.URL.Path, webcore.DashboardPrefix):
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "不支援的方法", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 公開檔案直接傳送，其餘先驗證權限 (未登入時不透露檔案是否存在)
+		name, ok := webcore.AssetName(strings.TrimPrefix(r.URL.Path, webcore.DashboardPrefix))
+		if ok && (name == "login.html" || publicAssetExts[strings.ToLower(path.Ext(name))]) {
+			if !assets.Has(name) {
+				http.NotFound(w, r)
+				return
+			}
+			assets.Serve(w, r, name)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	}), nil
+}
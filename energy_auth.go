@@ -0,0 +1,1154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"energy-monitoring/internal/webcore"
+)
+
+// 角色 (權限由低到高)
+const (
+	RoleViewer   = "viewer"   // 查詢資料
+	RoleOperator = "operator" // 寫入資料 (匯入、line protocol、補齊缺口)
+	RoleAdmin    = "admin"    // 重新載入設定、管理使用者與權杖、稽核紀錄
+)
+
+var roleLevels = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+const (
+	sessionCookieName  = "energy_session"
+	apiTokenPrefix     = "emt_"
+	tokenTouchInterval = time.Minute // 權杖最後使用時間的更新間隔 (避免每個請求都寫入資料庫)
+	auditDefaultLimit  = 200
+)
+
+// 認證方式
+const (
+	authSession    = "session"
+	authToken      = "token"
+	authBasic      = "basic"
+	authAdminToken = "admin_token"
+	authAnonymous  = "anonymous"
+)
+
+var (
+	errAuthFailed  = errors.New("帳號、密碼或權杖錯誤")
+	errLoginLocked = webcore.ErrLoginLocked
+	errThrottled   = webcore.ErrThrottled
+)
+
+// 使用者帳號
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	Disabled     bool      `json:"disabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// API 權杖 (供自動化程式使用)，權限不超過所屬使用者的角色
+type APIToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	User       string    `json:"user"`
+	Role       string    `json:"role"`
+	SecretHash string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // 零值表示不過期
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// 稽核紀錄
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user"`
+	Role      string    `json:"role"`
+	Auth      string    `json:"auth"`
+	Remote    string    `json:"remote"`
+	Action    string    `json:"action"` // 方法與路徑，例如 POST /api/config/reload
+	Target    string    `json:"target,omitempty"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// 稽核紀錄查詢
+type AuditQuery struct {
+	From  time.Time
+	To    time.Time
+	User  string // 空白表示全部
+	Limit int
+}
+
+// 已驗證的身分
+type Principal struct {
+	User    string `json:"user"`
+	Role    string `json:"role"`
+	Auth    string `json:"auth"`
+	TokenID string `json:"token_id,omitempty"`
+}
+
+func validRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// role 是否具有 required 以上的權限
+func roleAllows(role, required string) bool {
+	return roleLevels[role] > 0 && roleLevels[role] >= roleLevels[required]
+}
+
+// 使用者名稱：不可含冒號 (HTTP Basic) 與空白
+func validUserName(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("使用者名稱長度必須為 1 到 64 個字元")
+	}
+	if strings.ContainsAny(name, ": \t\r\n") {
+		return fmt.Errorf("使用者名稱不可包含冒號或空白")
+	}
+	return nil
+}
+
+// 隨機字串 (base64url)
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// 建立 API 權杖，回傳的字串 (emt_<id>_<secret>) 只在建立時顯示
+func newAPIToken(user User, name, role string, ttl time.Duration) (APIToken, string, error) {
+	if role == "" {
+		role = user.Role
+	}
+	if !validRole(role) {
+		return APIToken{}, "", fmt.Errorf("無效的角色: %s", role)
+	}
+	if !roleAllows(user.Role, role) {
+		return APIToken{}, "", fmt.Errorf("權杖角色 %s 不可高於使用者 %s 的角色 %s", role, user.Name, user.Role)
+	}
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return APIToken{}, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+
+	now := time.Now()
+	token := APIToken{ID: hex.EncodeToString(idBytes), Name: name, User: user.Name, Role: role,
+		SecretHash: hashSecret(secret), CreatedAt: now}
+	if ttl > 0 {
+		token.ExpiresAt = now.Add(ttl)
+	}
+	return token, apiTokenPrefix + token.ID + "_" + secret, nil
+}
+
+// 拆解 API 權杖字串
+func splitAPIToken(value string) (string, string, bool) {
+	if !strings.HasPrefix(value, apiTokenPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, apiTokenPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// 請求中的權杖：Authorization: Bearer/Token (InfluxDB v2)、Basic 密碼或 p 參數 (InfluxDB v1)
+func requestToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, "Bearer "):
+		return strings.TrimPrefix(auth, "Bearer ")
+	case strings.HasPrefix(auth, "Token "):
+		return strings.TrimPrefix(auth, "Token ")
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return r.URL.Query().Get("p")
+}
+
+// 登入工作階段 (存於記憶體，重新啟動後需重新登入)
+type session struct {
+	user     string
+	started  time.Time
+	lastSeen time.Time
+}
+
+type authState struct {
+	mu       sync.Mutex
+	sessions map[string]*session   // 以工作階段 ID 的雜湊為鍵
+	touched  map[string]time.Time  // 權杖最後一次寫入使用時間
+	logins   *webcore.LoginLimiter // 登入失敗暫停、來源 IP 次數限制與驗證成功的快取
+}
+
+func newAuthState() *authState {
+	return &authState{
+		sessions: make(map[string]*session),
+		touched:  make(map[string]time.Time),
+		logins:   webcore.NewLoginLimiter(),
+	}
+}
+
+// 建立工作階段並回傳 ID (同時清除已逾時的工作階段)
+func (a *authState) startSession(user string, ttl time.Duration) (string, error) {
+	id, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, s := range a.sessions {
+		if now.Sub(s.lastSeen) > ttl {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[hashSecret(id)] = &session{user: user, started: now, lastSeen: now}
+	return id, nil
+}
+
+// 查詢工作階段 (閒置超過 ttl 即失效)，有效時延長期限
+func (a *authState) session(id string, ttl time.Duration) (session, bool) {
+	key := hashSecret(id)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[key]
+	if !ok {
+		return session{}, false
+	}
+	if now.Sub(s.lastSeen) > ttl {
+		delete(a.sessions, key)
+		return session{}, false
+	}
+	s.lastSeen = now
+	return *s, true
+}
+
+func (a *authState) endSession(id string) {
+	a.mu.Lock()
+	delete(a.sessions, hashSecret(id))
+	a.mu.Unlock()
+}
+
+// 是否需要更新權杖的最後使用時間
+func (a *authState) shouldTouch(id string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.touched[id]) < tokenTouchInterval {
+		return false
+	}
+	a.touched[id] = now
+	return true
+}
+
+// 以帳號密碼登入 (含暫停登入與來源 IP 次數限制，見 webcore.LoginLimiter)，remote 為來源 IP
+func (es *EnergySystem) login(name, password, remote string) (User, error) {
+	auth := es.Config().HTTP.Auth
+	user, found, err := es.store.GetUser(name)
+	if err != nil {
+		return User{}, err
+	}
+	policy := webcore.LoginPolicy{MaxFailures: auth.MaxFailures, Lockout: auth.Lockout}
+	err = es.auth.logins.Verify(policy, name, password, user.PasswordHash, remote, found && !user.Disabled)
+	if errors.Is(err, webcore.ErrLoginFailed) {
+		return User{}, errAuthFailed
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// 登入失敗的 HTTP 狀態碼 (0 表示非預期的錯誤)
+func loginErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errThrottled):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errAuthFailed), errors.Is(err, errLoginLocked):
+		return http.StatusUnauthorized
+	}
+	return 0
+}
+
+// 驗證 API 權杖
+func (es *EnergySystem) authenticateToken(value string) (*Principal, error) {
+	id, secret, ok := splitAPIToken(value)
+	if !ok {
+		return nil, errAuthFailed
+	}
+	token, found, err := es.store.GetAPIToken(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found || subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(token.SecretHash)) != 1 {
+		return nil, errAuthFailed
+	}
+	now := time.Now()
+	if !token.ExpiresAt.IsZero() && now.After(token.ExpiresAt) {
+		return nil, errAuthFailed
+	}
+	user, found, err := es.store.GetUser(token.User)
+	if err != nil {
+		return nil, err
+	}
+	if !found || user.Disabled {
+		return nil, errAuthFailed
+	}
+
+	// 使用者角色降低時，權杖權限隨之降低
+	role := token.Role
+	if !roleAllows(user.Role, role) {
+		role = user.Role
+	}
+	if es.auth.shouldTouch(token.ID, now) {
+		if err := es.store.TouchAPIToken(token.ID, now); err != nil {
+			log.Printf("⚠️ 更新權杖使用時間失敗: %v", err)
+		}
+	}
+	return &Principal{User: user.Name, Role: role, Auth: authToken, TokenID: token.ID}, nil
+}
+
+// 驗證請求的身分：工作階段 Cookie、HTTP Basic 帳號密碼、API 權杖或 http.admin_token；
+// 未提供任何憑證時為匿名 (角色為 http.auth.anonymous_role)，提供錯誤的憑證時回傳 errAuthFailed
+func (es *EnergySystem) authenticate(r *http.Request) (*Principal, error) {
+	cfg := es.Config().HTTP
+
+	// 帳號在登入後有變更 (密碼、角色、停用，包含以 user 子命令變更) 時需重新登入
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if s, ok := es.auth.session(cookie.Value, cfg.Auth.SessionTTL); ok {
+			user, found, err := es.store.GetUser(s.user)
+			if err != nil {
+				return nil, err
+			}
+			if found && !user.Disabled && !user.UpdatedAt.After(s.started) {
+				return &Principal{User: user.Name, Role: user.Role, Auth: authSession}, nil
+			}
+			es.auth.endSession(cookie.Value)
+		}
+	}
+
+	token := requestToken(r)
+	if name, password, ok := r.BasicAuth(); ok && name != "" && !strings.HasPrefix(password, apiTokenPrefix) &&
+		(cfg.AdminToken == "" || subtle.ConstantTimeCompare([]byte(password), []byte(cfg.AdminToken)) != 1) {
+		user, err := es.login(name, password, webcore.RemoteHost(r))
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{User: user.Name, Role: user.Role, Auth: authBasic}, nil
+	}
+	if token != "" {
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			return es.authenticateToken(token)
+		}
+		if cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1 {
+			return &Principal{User: authAdminToken, Role: RoleAdmin, Auth: authAdminToken}, nil
+		}
+		return nil, errAuthFailed
+	}
+
+	return &Principal{Role: cfg.Auth.AnonymousRole, Auth: authAnonymous}, nil
+}
+
+type authContextKey int
+
+const (
+	principalKey authContextKey = iota
+	auditKey
+)
+
+// 請求的身分 (經過 authorize 的請求才有)
+func principalFrom(r *http.Request) *Principal {
+	if p, ok := r.Context().Value(principalKey).(*Principal); ok {
+		return p
+	}
+	return &Principal{Auth: authAnonymous}
+}
+
+// 處理器補充的稽核內容
+type auditRecord struct {
+	user   string // 登入時為嘗試登入的帳號
+	target string
+	detail string
+}
+
+// 設定稽核紀錄的對象與說明 (不記錄稽核的請求忽略)
+func setAudit(r *http.Request, target, detail string) {
+	if rec, ok := r.Context().Value(auditKey).(*auditRecord); ok {
+		rec.target, rec.detail = target, detail
+	}
+}
+
+// 記錄回應狀態碼與錯誤訊息 (稽核紀錄使用)
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	errMsg []byte
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status >= http.StatusBadRequest && len(s.errMsg) < 200 {
+		s.errMsg = append(s.errMsg, p...)
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// 錯誤訊息 (/api/v1 的結構化錯誤只取 message)
+func (s *statusRecorder) errorMessage() string {
+	var body apiErrorBody
+	if json.Unmarshal(s.errMsg, &body) == nil && body.Error.Message != "" {
+		return body.Error.Message
+	}
+	return strings.TrimSpace(string(s.errMsg))
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// 會變更資料的請求
+func unsafeMethod(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// 依角色保護端點 (role 空白表示不需登入)；audit 為 true 時記錄會變更資料的請求 (含遭拒絕的請求)
+func (es *EnergySystem) authorize(role string, audit bool, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := es.authenticate(r)
+		if err != nil {
+			status := loginErrorStatus(err)
+			if status == 0 {
+				log.Printf("❌ 驗證身分失敗: %v", err)
+				status = http.StatusInternalServerError
+				err = fmt.Errorf("驗證身分失敗")
+			}
+			if audit && unsafeMethod(r.Method) {
+				name, _, _ := r.BasicAuth()
+				es.recordAudit(&Principal{User: name, Auth: authAnonymous}, r, status, &auditRecord{detail: err.Error()})
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		if role != "" && !roleAllows(principal.Role, role) {
+			status, message := http.StatusForbidden, fmt.Sprintf("權限不足 (需要 %s)", role)
+			if principal.Auth == authAnonymous {
+				// 瀏覽器開啟頁面時導向登入頁
+				if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+					http.Redirect(w, r, webcore.DashboardPrefix+"login.html?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+					return
+				}
+				status, message = http.StatusUnauthorized, "需要登入"
+			}
+			if audit && unsafeMethod(r.Method) {
+				es.recordAudit(principal, r, status, &auditRecord{detail: message})
+			}
+			http.Error(w, message, status)
+			return
+		}
+
+		// 以 Cookie 驗證的變更請求需來自同一來源 (防止跨站請求偽造)
+		if principal.Auth == authSession && unsafeMethod(r.Method) && !sameOrigin(r) {
+			if audit {
+				es.recordAudit(principal, r, http.StatusForbidden, &auditRecord{detail: "拒絕跨來源請求: " + r.Header.Get("Origin")})
+			}
+			http.Error(w, "拒絕跨來源請求", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		if !audit || !unsafeMethod(r.Method) {
+			next(w, r.WithContext(ctx))
+			return
+		}
+		rec := &auditRecord{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r.WithContext(context.WithValue(ctx, auditKey, rec)))
+		if recorder.status >= http.StatusBadRequest {
+			rec.detail = recorder.errorMessage()
+		}
+		es.recordAudit(principal, r, recorder.status, rec)
+	})
+}
+
+// Origin (或 Referer) 與請求的主機相同；兩者皆未提供時視為同源 (非瀏覽器的用戶端)
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	trimmed := origin
+	if i := strings.Index(trimmed, "://"); i >= 0 {
+		trimmed = trimmed[i+3:]
+	}
+	if i := strings.IndexAny(trimmed, "/?#"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	return strings.EqualFold(trimmed, r.Host)
+}
+
+// 寫入稽核紀錄
+func (es *EnergySystem) recordAudit(principal *Principal, r *http.Request, status int, rec *auditRecord) {
+	event := AuditEvent{
+		Timestamp: time.Now(),
+		User:      principal.User,
+		Role:      principal.Role,
+		Auth:      principal.Auth,
+		Remote:    webcore.RemoteHost(r),
+		Action:    r.Method + " " + r.URL.Path,
+		Target:    rec.target,
+		Status:    status,
+		Detail:    rec.detail,
+	}
+	if rec.user != "" {
+		event.User = rec.user
+	}
+	if err := es.store.InsertAuditEvent(event); err != nil {
+		log.Printf("⚠️ 寫入稽核紀錄失敗: %v", err)
+	}
+}
+
+// 讀取 JSON 或表單請求本文
+func decodeAuthRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			return fmt.Errorf("請求格式錯誤: %v", err)
+		}
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("請求格式錯誤: %v", err)
+	}
+	fields := make(map[string]string)
+	for key := range r.PostForm {
+		fields[key] = r.PostForm.Get(key)
+	}
+	content, _ := json.Marshal(fields)
+	return json.Unmarshal(content, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// 登入 (POST username、password)，成功時設定工作階段 Cookie
+func (es *EnergySystem) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "僅支援 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := decodeAuthRequest(w, r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rec, ok := r.Context().Value(auditKey).(*auditRecord); ok {
+		rec.user = req.Username
+	}
+
+	user, err := es.login(req.Username, req.Password, webcore.RemoteHost(r))
+	if err != nil {
+		status := loginErrorStatus(err)
+		if status == 0 {
+			log.Printf("❌ 登入失敗: %v", err)
+			status, err = http.StatusInternalServerError, fmt.Errorf("登入失敗")
+		}
+		setAudit(r, req.Username, "")
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	cfg := es.Config().HTTP
+	id, err := es.auth.startSession(user.Name, cfg.Auth.SessionTTL)
+	if err != nil {
+		http.Error(w, "建立工作階段失敗", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cfg.TLS.Enabled || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	setAudit(r, user.Name, "登入")
+	writeJSON(w, http.StatusOK, Principal{User: user.Name, Role: user.Role, Auth: authSession})
+}
+
+// 登出
+func (es *EnergySystem) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "僅支援 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		es.auth.endSession(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	setAudit(r, principalFrom(r).User, "登出")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 目前的身分與角色
+func (es *EnergySystem) MeHandler(w http.ResponseWriter, r *http.Request) {
+	principal := principalFrom(r)
+	if principal.Role == "" {
+		http.Error(w, "需要登入", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, principal)
+}
+
+// 變更自己的密碼 (POST old_password、new_password)，之後需重新登入
+func (es *EnergySystem) PasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "僅支援 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	principal := principalFrom(r)
+	if principal.Auth != authSession && principal.Auth != authBasic {
+		http.Error(w, "請以帳號登入後變更密碼", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := decodeAuthRequest(w, r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setAudit(r, principal.User, "變更密碼")
+
+	user, err := es.login(principal.User, req.OldPassword, webcore.RemoteHost(r))
+	if err != nil {
+		http.Error(w, "目前的密碼錯誤", http.StatusForbidden)
+		return
+	}
+	if user.PasswordHash, err = webcore.HashPassword(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user.UpdatedAt = time.Now()
+	if err := es.store.PutUser(user); err != nil {
+		http.Error(w, fmt.Sprintf("儲存失敗: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 使用者管理 (admin)：GET 列出、POST 新增或更新 (name、password、role、disabled)、DELETE ?name=
+func (es *EnergySystem) UsersHandler(w http.ResponseWriter, r *http.Request) {
+	principal := principalFrom(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		users, err := es.store.ListUsers()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("查詢失敗: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"users": users})
+
+	case http.MethodPost:
+		var req struct {
+			Name     string `json:"name"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+			Disabled bool   `json:"disabled"`
+		}
+		if err := decodeAuthRequest(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setAudit(r, req.Name, "")
+		user, created, err := es.saveUser(req.Name, req.Password, req.Role, req.Disabled, principal.User)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, action := http.StatusOK, "更新使用者"
+		if created {
+			status, action = http.StatusCreated, "新增使用者"
+		}
+		setAudit(r, user.Name, fmt.Sprintf("%s (角色 %s，停用 %v)", action, user.Role, user.Disabled))
+		writeJSON(w, status, user)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		setAudit(r, name, "刪除使用者")
+		if name == principal.User {
+			http.Error(w, "不可刪除自己的帳號", http.StatusBadRequest)
+			return
+		}
+		deleted, err := es.store.DeleteUser(name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("刪除失敗: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, fmt.Sprintf("找不到使用者: %s", name), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "僅支援 GET、POST、DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
+// 新增或更新使用者 (更新時密碼空白表示不變更)；self 為操作者，不可停用自己或降低自己的角色
+func (es *EnergySystem) saveUser(name, password, role string, disabled bool, self string) (User, bool, error) {
+	if err := validUserName(name); err != nil {
+		return User{}, false, err
+	}
+	user, found, err := es.store.GetUser(name)
+	if err != nil {
+		return User{}, false, err
+	}
+	if role == "" {
+		role = user.Role
+	}
+	if !validRole(role) {
+		return User{}, false, fmt.Errorf("角色必須為 viewer、operator 或 admin")
+	}
+	if name == self && (disabled || role != user.Role) {
+		return User{}, false, fmt.Errorf("不可停用自己的帳號或變更自己的角色")
+	}
+
+	now := time.Now()
+	if !found {
+		if password == "" {
+			return User{}, false, fmt.Errorf("新增使用者時必須設定密碼")
+		}
+		user = User{Name: name, CreatedAt: now}
+	}
+	if password != "" {
+		if user.PasswordHash, err = webcore.HashPassword(password); err != nil {
+			return User{}, false, err
+		}
+	}
+	user.Role, user.Disabled, user.UpdatedAt = role, disabled, now
+	if err := es.store.PutUser(user); err != nil {
+		return User{}, false, fmt.Errorf("儲存失敗: %v", err)
+	}
+	return user, !found, nil
+}
+
+// API 權杖管理 (admin)：GET ?user= 列出、POST 建立 (user、name、role、expires_in)、DELETE ?id= 撤銷
+func (es *EnergySystem) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := es.store.ListAPITokens(r.URL.Query().Get("user"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("查詢失敗: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tokens": tokens})
+
+	case http.MethodPost:
+		var req struct {
+			User      string `json:"user"`
+			Name      string `json:"name"`
+			Role      string `json:"role"`
+			ExpiresIn string `json:"expires_in"` // 例如 720h，空白表示不過期
+		}
+		if err := decodeAuthRequest(w, r, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setAudit(r, req.User, fmt.Sprintf("建立權杖 %s (角色 %s)", req.Name, req.Role))
+		var ttl time.Duration
+		if req.ExpiresIn != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+				http.Error(w, fmt.Sprintf("expires_in 格式錯誤: %s", req.ExpiresIn), http.StatusBadRequest)
+				return
+			}
+		}
+		token, secret, err := createAPIToken(es.store, req.User, req.Name, req.Role, ttl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"token": secret, "info": token})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		setAudit(r, id, "撤銷權杖")
+		deleted, err := es.store.DeleteAPIToken(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("撤銷失敗: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, fmt.Sprintf("找不到權杖: %s", id), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "僅支援 GET、POST、DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
+// 為使用者建立 API 權杖
+func createAPIToken(store Storage, userName, name, role string, ttl time.Duration) (APIToken, string, error) {
+	if name == "" {
+		return APIToken{}, "", fmt.Errorf("權杖名稱不可為空白")
+	}
+	user, found, err := store.GetUser(userName)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	if !found {
+		return APIToken{}, "", fmt.Errorf("找不到使用者: %s", userName)
+	}
+	token, secret, err := newAPIToken(user, name, role, ttl)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	if err := store.PutAPIToken(token); err != nil {
+		return APIToken{}, "", fmt.Errorf("儲存失敗: %v", err)
+	}
+	return token, secret, nil
+}
+
+// 稽核紀錄查詢 (admin)：from、to、user、limit
+func (es *EnergySystem) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := AuditQuery{From: time.Unix(0, 0), To: time.Now(), User: query.Get("user"), Limit: auditDefaultLimit}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if q.From, err = parseHistoryTime(v, time.Local); err != nil {
+			http.Error(w, fmt.Sprintf("from 格式錯誤: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if q.To, err = parseHistoryEnd(v, time.Local); err != nil {
+			http.Error(w, fmt.Sprintf("to 格式錯誤: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			http.Error(w, fmt.Sprintf("limit 格式錯誤: %s", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := es.store.AuditEvents(q)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("查詢失敗: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+}
+
+// 啟動時提醒尚未建立帳號
+func (es *EnergySystem) checkAuthSetup() {
+	users, err := es.store.ListUsers()
+	if err != nil {
+		log.Printf("⚠️ 讀取使用者失敗: %v", err)
+		return
+	}
+	cfg := es.Config().HTTP
+	switch {
+	case len(users) == 0 && cfg.AdminToken == "":
+		log.Printf("⚠️ 尚未建立任何使用者，請執行 user add -role admin <名稱> 建立管理員")
+	case cfg.Auth.AnonymousRole != "":
+		log.Printf("🔓 未登入的使用者具有 %s 權限 (http.auth.anonymous_role)", cfg.Auth.AnonymousRole)
+	}
+}
+
+// user 子命令
+func runUserCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, `用法: energy_system user <命令> [選項]
+  list                                   列出使用者
+  add [-role viewer] <名稱>              新增使用者 (密碼由 -password、ENERGY_USER_PASSWORD 或標準輸入讀取)
+  passwd <名稱>                          變更密碼
+  role <名稱> <viewer|operator|admin>    變更角色
+  disable|enable <名稱>                  停用或啟用帳號
+  delete <名稱>                          刪除使用者與其 API 權杖
+  token create [-role 角色] [-expires 720h] <名稱> <權杖名稱>
+  token list [名稱]
+  token revoke <權杖 ID>
+  hash                                   產生密碼雜湊 (web.users 使用)`)
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	command := args[0]
+	if command == "token" {
+		if len(args) < 2 {
+			return usage()
+		}
+		command, args = "token "+args[1], args[1:]
+	}
+
+	fs := flag.NewFlagSet("user "+command, flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	role := fs.String("role", "", "角色: viewer、operator、admin")
+	password := fs.String("password", "", "密碼 (未指定時由 ENERGY_USER_PASSWORD 或標準輸入讀取)")
+	expires := fs.Duration("expires", 0, "權杖有效期間 (0 表示不過期)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	rest := fs.Args()
+	if n, ok := userCommandArgs[command]; !ok || (n >= 0 && len(rest) != n) || (n < 0 && len(rest) > 1) {
+		return usage()
+	}
+
+	readPassword := func() (string, error) {
+		if *password != "" {
+			return *password, nil
+		}
+		if v := os.Getenv("ENERGY_USER_PASSWORD"); v != "" {
+			return v, nil
+		}
+		fmt.Fprint(os.Stderr, "密碼: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("無法讀取密碼: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	if command == "hash" {
+		pw, err := readPassword()
+		if err == nil {
+			var hash string
+			if hash, err = webcore.HashPassword(pw); err == nil {
+				fmt.Println(hash)
+				return 0
+			}
+		}
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+	store, err := OpenStorage(config.Database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 無法開啟資料庫: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+	if err := store.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	if err := runUserAction(store, command, rest, *role, *expires, readPassword); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// user 子命令的參數數量 (-1 表示 0 或 1 個)
+var userCommandArgs = map[string]int{"list": 0, "add": 1, "passwd": 1, "role": 2, "disable": 1, "enable": 1, "delete": 1,
+	"token create": 2, "token list": -1, "token revoke": 1, "hash": 0}
+
+// 執行 user 子命令
+func runUserAction(store Storage, command string, args []string, role string, expires time.Duration, readPassword func() (string, error)) error {
+	// 更新既有使用者
+	update := func(name string, change func(*User) error) error {
+		user, found, err := store.GetUser(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("找不到使用者: %s", name)
+		}
+		if err := change(&user); err != nil {
+			return err
+		}
+		user.UpdatedAt = time.Now()
+		return store.PutUser(user)
+	}
+
+	switch command {
+	case "list":
+		users, err := store.ListUsers()
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			fmt.Println("尚未建立任何使用者")
+		}
+		for _, user := range users {
+			status := ""
+			if user.Disabled {
+				status = " (已停用)"
+			}
+			fmt.Printf("%-20s %-8s 建立於 %s%s\n", user.Name, user.Role, user.CreatedAt.Local().Format("2006-01-02 15:04"), status)
+		}
+
+	case "add":
+		if err := validUserName(args[0]); err != nil {
+			return err
+		}
+		if role == "" {
+			role = RoleViewer
+		}
+		if !validRole(role) {
+			return fmt.Errorf("角色必須為 viewer、operator 或 admin")
+		}
+		if _, found, err := store.GetUser(args[0]); err != nil {
+			return err
+		} else if found {
+			return fmt.Errorf("使用者 %s 已存在", args[0])
+		}
+		pw, err := readPassword()
+		if err != nil {
+			return err
+		}
+		hash, err := webcore.HashPassword(pw)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := store.PutUser(User{Name: args[0], PasswordHash: hash, Role: role, CreatedAt: now, UpdatedAt: now}); err != nil {
+			return err
+		}
+		fmt.Printf("✅ 已新增使用者 %s (%s)\n", args[0], role)
+
+	case "passwd":
+		pw, err := readPassword()
+		if err != nil {
+			return err
+		}
+		err = update(args[0], func(user *User) (err error) {
+			user.PasswordHash, err = webcore.HashPassword(pw)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ 已變更 %s 的密碼\n", args[0])
+
+	case "role":
+		if !validRole(args[1]) {
+			return fmt.Errorf("角色必須為 viewer、operator 或 admin")
+		}
+		if err := update(args[0], func(user *User) error { user.Role = args[1]; return nil }); err != nil {
+			return err
+		}
+		fmt.Printf("✅ %s 的角色已變更為 %s\n", args[0], args[1])
+
+	case "disable", "enable":
+		if err := update(args[0], func(user *User) error { user.Disabled = command == "disable"; return nil }); err != nil {
+			return err
+		}
+		fmt.Printf("✅ 已%s帳號 %s\n", map[string]string{"disable": "停用", "enable": "啟用"}[command], args[0])
+
+	case "delete":
+		deleted, err := store.DeleteUser(args[0])
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("找不到使用者: %s", args[0])
+		}
+		fmt.Printf("✅ 已刪除使用者 %s\n", args[0])
+
+	case "token create":
+		token, secret, err := createAPIToken(store, args[0], args[1], role, expires)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ 已建立權杖 %s (%s，角色 %s)，請妥善保存，之後無法再次顯示:\n%s\n", token.ID, token.Name, token.Role, secret)
+
+	case "token list":
+		userName := ""
+		if len(args) == 1 {
+			userName = args[0]
+		}
+		tokens, err := store.ListAPITokens(userName)
+		if err != nil {
+			return err
+		}
+		if len(tokens) == 0 {
+			fmt.Println("沒有 API 權杖")
+		}
+		for _, token := range tokens {
+			expiry, used := "不過期", "未使用"
+			if !token.ExpiresAt.IsZero() {
+				expiry = "到期 " + token.ExpiresAt.Local().Format("2006-01-02 15:04")
+			}
+			if !token.LastUsedAt.IsZero() {
+				used = "最後使用 " + token.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%s  %-16s %-12s %-8s %s，%s\n", token.ID, token.User, token.Name, token.Role, expiry, used)
+		}
+
+	case "token revoke":
+		deleted, err := store.DeleteAPIToken(args[0])
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("找不到權杖: %s", args[0])
+		}
+		fmt.Printf("✅ 已撤銷權杖 %s\n", args[0])
+	}
+	return nil
+}
@@ -2,29 +2,45 @@ package main
 
 import (
 	"database/sql"
-	"encoding/binary"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
-	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/goburrow/modbus"
-	_ "github.com/mattn/go-sqlite3"
-	"github.com/rs/cors"
+	"energy-monitoring/internal/webcore"
 )
 
 // 台達電表參數定義
 type MeterParameter struct {
-	Name    string `json:"name"`
-	Address uint16 `json:"address"`
-	Unit    string `json:"unit"`
+	Name    string `json:"name" yaml:"name"`
+	Address uint16 `json:"address" yaml:"address"`
+	Unit    string `json:"unit" yaml:"unit"`
+	Group   string `json:"group,omitempty" yaml:"group"`     // 點位群組 (可設定不同輪詢間隔)
+	Counter bool   `json:"counter,omitempty" yaml:"counter"` // 累積電能計數器 (計算每次取樣間的用電量)
+
+	// 讀取與解碼 (見 energy_points.go)
+	Function string         `json:"function,omitempty" yaml:"function"` // holding (FC03，預設)、input (FC04)、coil (FC01) 或 discrete (FC02)
+	Type     string         `json:"type,omitempty" yaml:"type"`         // float32 (預設)、u16、i16、u32、i32 或 bool
+	Bit      *int           `json:"bit,omitempty" yaml:"bit"`           // 只取整數暫存器的某個位元 (0 為最低位元)
+	Scale    float64        `json:"scale,omitempty" yaml:"scale"`       // 數值 = 原始值 × scale (預設 1)
+	States   map[int]string `json:"states,omitempty" yaml:"states"`     // 數值對應的狀態名稱 (例如 0: 分閘、1: 合閘)
+
+	// 計算點位 (見 energy_computed.go)，不讀取電表
+	Compute string   `json:"compute,omitempty" yaml:"compute"` // unbalance_nema 或 unbalance_iec
+	Inputs  []string `json:"inputs,omitempty" yaml:"inputs"`   // 計算使用的點位名稱
+
+	// 合理範圍，超出時標記為 out_of_range
+	Min *float64 `json:"min,omitempty" yaml:"min"`
+	Max *float64 `json:"max,omitempty" yaml:"max"`
 }
 
 // 電表數據結構
@@ -37,10 +53,13 @@ type MeterData struct {
 
 // 電表讀取值結構
 type MeterReading struct {
-	Index int     `json:"index"`
-	Name  string  `json:"name"`
-	Value float64 `json:"value"`
-	Unit  string  `json:"unit"`
+	Index         int     `json:"index"`
+	Name          string  `json:"name"`
+	Value         float64 `json:"value"`
+	Unit          string  `json:"unit"`
+	Quality       string  `json:"quality"`
+	ExceptionCode int     `json:"exception_code,omitempty"`
+	State         string  `json:"state,omitempty"` // 暫存器對照表 states 對應的狀態名稱
 }
 
 // 聚合資料結構
@@ -50,179 +69,183 @@ type AggregatedData struct {
 	AvgValue  float64   `json:"avg_value"`
 	MinValue  float64   `json:"min_value"`
 	MaxValue  float64   `json:"max_value"`
+	Count     int       `json:"count"`     // 納入計算的筆數
+	BadCount  int       `json:"bad_count"` // 品質異常的筆數
 }
 
 // 根據提供的參數表格定義電表參數
+// 分相量測與不平衡率 (計算點位) 的位址需依電表通訊手冊，不列入內建對照表，以 register_maps 自訂 (見 README 計算點位)
 var meterParameters = []MeterParameter{
-	{"相電壓平均值", 0x0106, "V"},
-	{"三相平均電流", 0x0126, "A"},
-	{"頻率", 0x0142, "Hz"},
-	{"三相正向實功率", 0x015C, "kW"},
-	{"三相反向實功率", 0x015E, "kW"},
-	{"線實功率因數", 0x0132, "N/A"},
-	{"電流諧波失真率", 0x0188, "%"},
-	{"電流諧波失真率", 0x018A, "%"},
+	{Name: "相電壓平均值", Address: 0x0106, Unit: "V", Min: floatPtr(0), Max: floatPtr(1000)},
+	{Name: "三相平均電流", Address: 0x0126, Unit: "A", Min: floatPtr(0)},
+	{Name: "頻率", Address: 0x0142, Unit: "Hz", Min: floatPtr(40), Max: floatPtr(70)},
+	{Name: "三相正向實功率", Address: 0x015C, Unit: "kW"},
+	{Name: "三相反向實功率", Address: 0x015E, Unit: "kW"},
+	{Name: "線實功率因數", Address: 0x0132, Unit: "N/A", Min: floatPtr(-1), Max: floatPtr(1)},
+	{Name: "電流諧波失真率", Address: 0x0188, Unit: "%", Min: floatPtr(0), Max: floatPtr(100)},
+	{Name: "電流諧波失真率", Address: 0x018A, Unit: "%", Min: floatPtr(0), Max: floatPtr(100)},
 }
 
 // 能源系統結構
 type EnergySystem struct {
-	db          *sql.DB
-	modbusHost  string
-	modbusPort  int
-	slaveID     byte
-	running     bool
-	stopChannel chan bool
+	store     Storage
+	running   bool
+	startedAt time.Time
+
+	configMu   sync.RWMutex
+	config     *Config
+	loadConfig func() (*Config, error)
+	reloadMu   sync.Mutex // 一次只套用一份新設定
+
+	collectorsMu sync.Mutex
+	collectors   map[string]*MeterCollector
+	pollSlots    chan struct{}
+	alarms       *AlarmEngine
+	influx       *InfluxOutput
+	gateway      *ModbusGateway
+	writes       *WriteQueue
+
+	backupMu     sync.Mutex
+	backupStatus BackupStatus
+	backupStop   chan struct{}
+	backupDone   chan struct{}
+
+	gapMu     sync.Mutex
+	backfills map[string][]*BackfillResult // 依電表記錄的補齊結果
+	gapStop   chan struct{}
+	gapDone   chan struct{}
+
+	auth    *authState   // 登入工作階段與登入失敗紀錄
+	actions *actionState // 等待確認的寫入動作
 }
 
 // 建立新的能源系統
-func NewEnergySystem() *EnergySystem {
+func NewEnergySystem(config *Config) *EnergySystem {
 	return &EnergySystem{
-		modbusHost:  "192.168.1.9",
-		modbusPort:  502,
-		slaveID:     2,
-		running:     false,
-		stopChannel: make(chan bool),
+		config:     config,
+		running:    false,
+		startedAt:  time.Now(),
+		collectors: make(map[string]*MeterCollector),
+		backfills:  make(map[string][]*BackfillResult),
+		auth:       newAuthState(),
+		actions:    newActionState(),
+		pollSlots:  make(chan struct{}, config.Collection.MaxConcurrent),
 	}
 }
 
+// 目前生效的設定
+func (es *EnergySystem) Config() *Config {
+	es.configMu.RLock()
+	defer es.configMu.RUnlock()
+	return es.config
+}
+
 // 初始化資料庫
 func (es *EnergySystem) InitDatabase() error {
-	var err error
-	es.db, err = sql.Open("sqlite3", "./energy_data.db")
+	store, err := OpenStorage(es.config.Database)
 	if err != nil {
 		return fmt.Errorf("無法開啟資料庫: %v", err)
 	}
-
-	// 建立資料表
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS meter_data (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		device_id TEXT NOT NULL,
-		json_data TEXT NOT NULL
-	);
-	
-	CREATE INDEX IF NOT EXISTS idx_timestamp ON meter_data(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_device_id ON meter_data(device_id);
-	CREATE INDEX IF NOT EXISTS idx_timestamp_device ON meter_data(timestamp, device_id);
-	`
-
-	_, err = es.db.Exec(createTableSQL)
-	if err != nil {
-		return fmt.Errorf("建立資料表失敗: %v", err)
+	if err := store.Init(); err != nil {
+		store.Close()
+		return err
 	}
+	es.store = store
+	es.alarms = NewAlarmEngine(es.store, es.config.Alarms)
 
-	log.Println("✅ 資料庫初始化完成")
+	log.Printf("✅ 資料庫初始化完成 (%s)", store.Driver())
 	return nil
 }
 
-// 讀取電表資料
-func (es *EnergySystem) ReadMeterData() ([]MeterReading, error) {
-	// 建立 Modbus TCP 客戶端
-	handler := modbus.NewTCPClientHandler(fmt.Sprintf("%s:%d", es.modbusHost, es.modbusPort))
-	handler.Timeout = 10 * time.Second
-	handler.IdleTimeout = 60 * time.Second
-	handler.SlaveId = es.slaveID
-
-	// 連接到電表
-	err := handler.Connect()
-	if err != nil {
-		return nil, fmt.Errorf("無法連接到電表: %v", err)
-	}
-	defer handler.Close()
-
-	client := modbus.NewClient(handler)
-	readings := make([]MeterReading, 0)
-
-	// 讀取所有參數
-	for i, param := range meterParameters {
-		results, err := client.ReadHoldingRegisters(param.Address, 2)
-		if err != nil {
-			log.Printf("❌ 讀取 %s 失敗: %v", param.Name, err)
-			continue
-		}
-
-		if len(results) >= 4 {
-			// 使用 Word-Swap 解析 (根據之前的測試結果)
-			swapped := []byte{results[2], results[3], results[0], results[1]}
-			valueFloat := binary.BigEndian.Uint32(swapped)
-			value := math.Float32frombits(valueFloat)
-
-			reading := MeterReading{
-				Index: i,
-				Name:  param.Name,
-				Value: float64(value),
-				Unit:  param.Unit,
-			}
-			readings = append(readings, reading)
-		}
+// 儲存資料到資料庫 (寫入佇列啟動後由背景分批寫入)
+func (es *EnergySystem) SaveToDatabase(deviceID string, sampleTime time.Time, readings []MeterReading) error {
+	record := PollRecord{DeviceID: deviceID, Timestamp: sampleTime, Readings: readings}
+	var err error
+	if es.writes != nil {
+		err = es.writes.Enqueue(record)
+	} else {
+		err = es.store.WriteSamples([]PollRecord{record})
 	}
-
-	return readings, nil
-}
-
-// 儲存資料到資料庫
-func (es *EnergySystem) SaveToDatabase(readings []MeterReading) error {
-	jsonData, err := json.Marshal(readings)
 	if err != nil {
-		return fmt.Errorf("JSON 編碼失敗: %v", err)
+		return err
 	}
-
-	insertSQL := `INSERT INTO meter_data (device_id, json_data) VALUES (?, ?)`
-	_, err = es.db.Exec(insertSQL, "DPMC530E", string(jsonData))
-	if err != nil {
-		return fmt.Errorf("資料庫插入失敗: %v", err)
+	if es.influx != nil {
+		es.influx.Enqueue(deviceID, sampleTime, readings)
 	}
-
 	return nil
 }
 
-// 定時資料收集
+// 啟動所有電表的資料收集
 func (es *EnergySystem) StartDataCollection() {
-	es.running = true
-	log.Println("🔄 開始每 5 秒收集電表資料...")
-
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for es.running {
-		select {
-		case <-ticker.C:
-			readings, err := es.ReadMeterData()
-			if err != nil {
-				log.Printf("❌ 讀取電表資料失敗: %v", err)
-				continue
-			}
+	config := es.Config()
 
-			err = es.SaveToDatabase(readings)
-			if err != nil {
-				log.Printf("❌ 儲存資料失敗: %v", err)
-				continue
-			}
+	es.collectorsMu.Lock()
+	defer es.collectorsMu.Unlock()
 
-			log.Printf("✅ 成功收集並儲存 %d 筆資料 (%s)", len(readings), time.Now().Format("15:04:05"))
+	es.running = true
+	log.Printf("🔄 開始收集 %d 個電表資料 (預設每 %v)...", len(config.Meters), config.Collection.PollInterval)
 
-		case <-es.stopChannel:
-			return
-		}
+	for _, meter := range config.Meters {
+		es.startCollector(config, meter)
 	}
 }
 
+// 啟動單一電表收集器 (呼叫端需持有 collectorsMu)
+func (es *EnergySystem) startCollector(config *Config, meter MeterConfig) {
+	collector := NewMeterCollector(es, config, meter)
+	es.collectors[meter.ID] = collector
+	go collector.Run()
+}
+
 // 停止資料收集
 func (es *EnergySystem) StopDataCollection() {
+	es.collectorsMu.Lock()
 	es.running = false
-	close(es.stopChannel)
+	collectors := make([]*MeterCollector, 0, len(es.collectors))
+	for id, collector := range es.collectors {
+		collectors = append(collectors, collector)
+		delete(es.collectors, id)
+	}
+	es.collectorsMu.Unlock()
+
+	for _, collector := range collectors {
+		collector.Stop()
+	}
 }
 
 // HTTP API 處理器
 
 // 獲取最新資料 (原有功能相容)
+// 依點位群組輪詢時每次只讀取部分點位，因此回傳收集器合併後的各點位最新讀值；
+// 收集器尚未輪詢 (例如剛啟動) 時才使用資料庫中該電表最後一次輪詢的紀錄
 func (es *EnergySystem) GetLatestDataHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	querySQL := `SELECT json_data FROM meter_data ORDER BY timestamp DESC LIMIT 1`
-	var jsonData string
-	err := es.db.QueryRow(querySQL).Scan(&jsonData)
+	deviceID := r.URL.Query().Get("device") // 電表 ID (預設第一個電表)
+	config := es.Config()
+	if deviceID == "" && len(config.Meters) > 0 {
+		deviceID = config.Meters[0].ID
+	}
+	if _, ok := config.Meter(deviceID); !ok {
+		http.Error(w, fmt.Sprintf("未知的電表 %q", deviceID), http.StatusNotFound)
+		return
+	}
+
+	es.collectorsMu.Lock()
+	collector := es.collectors[deviceID]
+	es.collectorsMu.Unlock()
+	if collector != nil {
+		if readings := collector.Latest(); len(readings) > 0 {
+			json.NewEncoder(w).Encode(readings)
+			return
+		}
+	}
+
+	jsonData, err := es.store.LatestSnapshot(deviceID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "尚無資料", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("查詢失敗: %v", err), http.StatusInternalServerError)
 		return
@@ -231,6 +254,36 @@ func (es *EnergySystem) GetLatestDataHandler(w http.ResponseWriter, r *http.Requ
 	w.Write([]byte(jsonData))
 }
 
+// 獲取運作統計 (各電表輪詢、寫入佇列、暫存區與 Modbus 閘道)
+func (es *EnergySystem) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	es.collectorsMu.Lock()
+	collectors := make(map[string]PollStats, len(es.collectors))
+	for id, collector := range es.collectors {
+		collectors[id] = collector.Stats()
+	}
+	es.collectorsMu.Unlock()
+
+	stats := map[string]interface{}{"collectors": collectors}
+	spools := make(map[string]SpoolStats)
+	if es.writes != nil {
+		stats["write_queue"] = es.writes.Stats()
+		if es.writes.spool != nil {
+			spools["database"] = es.writes.spool.Stats()
+		}
+	}
+	if es.influx != nil && es.influx.spool != nil {
+		spools["influx"] = es.influx.spool.Stats()
+	}
+	stats["spool"] = spools
+	stats["backup"] = es.BackupStatus()
+	if es.gateway != nil {
+		stats["modbus_gateway"] = es.gateway.Stats()
+	}
+	json.NewEncoder(w).Encode(stats)
+}
+
 // 獲取聚合資料 (新功能)
 func (es *EnergySystem) GetAggregatedDataHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -239,13 +292,19 @@ func (es *EnergySystem) GetAggregatedDataHandler(w http.ResponseWriter, r *http.
 	timeRange := r.URL.Query().Get("range")     // daily, monthly, quarterly, yearly
 	dateParam := r.URL.Query().Get("date")      // 格式依範圍而定
 	parameter := r.URL.Query().Get("parameter") // 參數名稱
+	deviceID := r.URL.Query().Get("device")     // 電表 ID (預設第一個電表)
+	includeBad := r.URL.Query().Get("quality") == "all"
 
 	if timeRange == "" || dateParam == "" || parameter == "" {
 		http.Error(w, "缺少必要參數: range, date, parameter", http.StatusBadRequest)
 		return
 	}
+	if _, _, _, err := aggregationWindow(timeRange, dateParam); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	aggregatedData, err := es.getAggregatedData(timeRange, dateParam, parameter)
+	aggregatedData, err := es.getAggregatedData(timeRange, dateParam, parameter, deviceID, includeBad)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("聚合資料查詢失敗: %v", err), http.StatusInternalServerError)
 		return
@@ -260,151 +319,149 @@ func (es *EnergySystem) GetAggregatedDataHandler(w http.ResponseWriter, r *http.
 	w.Write(jsonResponse)
 }
 
-// 聚合資料查詢邏輯
-func (es *EnergySystem) getAggregatedData(timeRange, dateParam, parameter string) ([]AggregatedData, error) {
-	var querySQL string
-	var timeFormat string
-	var args []interface{}
+// 聚合資料查詢邏輯 (預設只計算正常品質的資料，並統計異常筆數)
+func (es *EnergySystem) getAggregatedData(timeRange, dateParam, parameter, deviceID string, includeBad bool) ([]AggregatedData, error) {
+	from, to, interval, err := aggregationWindow(timeRange, dateParam)
+	if err != nil {
+		return nil, err
+	}
 
+	if deviceID == "" {
+		deviceID = es.Config().Meters[0].ID
+	}
+
+	rollups, err := es.store.Rollup(deviceID, parameter, periodBoundaries(from, to, interval), usableFilter(includeBad))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]AggregatedData, 0, len(rollups))
+	for _, rollup := range rollups {
+		result = append(result, AggregatedData{
+			Timestamp: rollup.Start,
+			Parameter: parameter,
+			AvgValue:  rollup.Avg,
+			MinValue:  rollup.Min,
+			MaxValue:  rollup.Max,
+			Count:     rollup.Count,
+			BadCount:  rollup.BadCount,
+		})
+	}
+
+	return result, nil
+}
+
+// 區間內各期間的起始時間，最後一個元素為結束時間
+func periodBoundaries(from, to time.Time, interval string) []time.Time {
+	boundaries := []time.Time{from}
+	for t := from; t.Before(to); {
+		t = nextPeriod(t, interval)
+		if t.After(to) {
+			t = to
+		}
+		boundaries = append(boundaries, t)
+	}
+	return boundaries
+}
+
+// 依時間範圍計算查詢區間 (當地時間) 與分組期間
+func aggregationWindow(timeRange, dateParam string) (from, to time.Time, interval string, err error) {
 	switch timeRange {
 	case "daily":
 		// 按小時聚合，顯示一天24小時
-		querySQL = `
-		SELECT 
-			datetime(timestamp, 'localtime', 'start of hour') as hour_timestamp,
-			json_extract(json_data, '$[*]') as readings
-		FROM meter_data 
-		WHERE date(timestamp, 'localtime') = ?
-		ORDER BY hour_timestamp`
-		args = []interface{}{dateParam}
-		timeFormat = "hour"
+		from, err = time.ParseInLocation("2006-01-02", dateParam, time.Local)
+		to = from.AddDate(0, 0, 1)
+		interval = "hour"
 
 	case "monthly":
 		// 按日聚合，顯示一個月的每一天
-		querySQL = `
-		SELECT 
-			date(timestamp, 'localtime') as day_timestamp,
-			json_extract(json_data, '$[*]') as readings
-		FROM meter_data 
-		WHERE strftime('%Y-%m', timestamp, 'localtime') = ?
-		ORDER BY day_timestamp`
-		args = []interface{}{dateParam}
-		timeFormat = "day"
+		from, err = time.ParseInLocation("2006-01", dateParam, time.Local)
+		to = from.AddDate(0, 1, 0)
+		interval = "day"
 
 	case "quarterly":
-		// 按週聚合，顯示一季的資料
-		quarterMap := map[string][]string{
-			"Q1": {"01", "02", "03"},
-			"Q2": {"04", "05", "06"},
-			"Q3": {"07", "08", "09"},
-			"Q4": {"10", "11", "12"},
+		// 按月聚合，顯示一季的資料 (格式: 2025-Q1)
+		var year, quarter int
+		if _, scanErr := fmt.Sscanf(dateParam, "%d-Q%d", &year, &quarter); scanErr != nil || quarter < 1 || quarter > 4 {
+			err = fmt.Errorf("季度格式錯誤: %s (應為 YYYY-Q1 ~ YYYY-Q4)", dateParam)
+			break
 		}
-		year := dateParam[:4]
-		quarter := dateParam[5:]
-		months := quarterMap[quarter]
-
-		querySQL = `
-		SELECT 
-			strftime('%Y-%m', timestamp, 'localtime') as month_timestamp,
-			json_extract(json_data, '$[*]') as readings
-		FROM meter_data 
-		WHERE strftime('%Y', timestamp, 'localtime') = ? 
-		AND strftime('%m', timestamp, 'localtime') IN (?, ?, ?)
-		ORDER BY month_timestamp`
-		args = []interface{}{year, months[0], months[1], months[2]}
-		timeFormat = "month"
+		from = time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.Local)
+		to = from.AddDate(0, 3, 0)
+		interval = "month"
 
 	case "yearly":
 		// 按月聚合，顯示一年的12個月
-		querySQL = `
-		SELECT 
-			strftime('%Y-%m', timestamp, 'localtime') as month_timestamp,
-			json_extract(json_data, '$[*]') as readings
-		FROM meter_data 
-		WHERE strftime('%Y', timestamp, 'localtime') = ?
-		ORDER BY month_timestamp`
-		args = []interface{}{dateParam}
-		timeFormat = "month"
+		from, err = time.ParseInLocation("2006", dateParam, time.Local)
+		to = from.AddDate(1, 0, 0)
+		interval = "month"
 
 	default:
-		return nil, fmt.Errorf("不支援的時間範圍: %s", timeRange)
+		err = fmt.Errorf("不支援的時間範圍: %s", timeRange)
 	}
 
-	rows, err := es.db.Query(querySQL, args...)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	result := make([]AggregatedData, 0)
-
-	// 這裡需要實現複雜的 JSON 解析和聚合邏輯
-	// 由於 SQLite 的 JSON 函數限制，我們先簡化實現
-	for rows.Next() {
-		var timestampStr string
-		var readingsJSON string
-
-		err := rows.Scan(&timestampStr, &readingsJSON)
-		if err != nil {
-			continue
-		}
-
-		// 解析時間戳
-		var timestamp time.Time
-		switch timeFormat {
-		case "hour":
-			timestamp, _ = time.Parse("2006-01-02 15:04:05", timestampStr)
-		case "day":
-			timestamp, _ = time.Parse("2006-01-02", timestampStr)
-		case "month":
-			timestamp, _ = time.Parse("2006-01", timestampStr)
-		}
-
-		// 簡化實現：返回模擬資料
-		result = append(result, AggregatedData{
-			Timestamp: timestamp,
-			Parameter: parameter,
-			AvgValue:  220.5 + float64(len(result)), // 模擬資料
-			MinValue:  215.0,
-			MaxValue:  225.0,
-		})
+		err = fmt.Errorf("日期參數錯誤: %v", err)
 	}
-
-	return result, nil
+	return
 }
 
 // 啟動 HTTP 服務器
-func (es *EnergySystem) StartHTTPServer() {
+func (es *EnergySystem) StartHTTPServer() error {
 	mux := http.NewServeMux()
 
-	// API 端點
-	mux.HandleFunc("/api/latest", es.GetLatestDataHandler)
-	mux.HandleFunc("/api/aggregated", es.GetAggregatedDataHandler)
+	// /api 與 /api/v1 端點 (定義於 apiRouteTable)
+	es.registerAPIRoutes(mux)
 
-	// 靜態檔案服務
-	mux.Handle("/", http.FileServer(http.Dir(".")))
+	// 健康檢查 (不需登入)
+	mux.HandleFunc("/healthz", es.HealthzHandler)
+	mux.HandleFunc("/readyz", es.ReadyzHandler)
 
-	// 設定 CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders: []string{"*"},
-	})
+	// InfluxDB 相容寫入端點 (v1 與 v2)；資料記錄器頻繁寫入，不記錄稽核
+	mux.Handle("/write", es.authorize(RoleOperator, false, es.LineProtocolWriteHandler))
+	mux.Handle("/api/v2/write", es.authorize(RoleOperator, false, es.LineProtocolWriteHandler))
 
-	handler := c.Handler(mux)
+	// Grafana JSON datasource (查詢使用 POST，不記錄稽核)
+	mux.Handle("/grafana/", es.authorize(RoleViewer, false, es.GrafanaHandler))
 
-	log.Println("🌐 HTTP 服務器啟動於 http://localhost:8080")
+	// 看板網頁 (內建於執行檔)
+	static, err := es.staticHandler()
+	if err != nil {
+		return err
+	}
+	mux.Handle("/", static)
 
-	go func() {
-		if err := http.ListenAndServe(":8080", handler); err != nil {
-			log.Printf("HTTP 服務器錯誤: %v", err)
-		}
-	}()
+	// CORS 允許清單與安全標頭
+	cfg := es.config.HTTP
+	handler := securityHandler(cfg.CORSOrigins, cfg.Headers, cfg.TLS.Enabled, mux)
+
+	if err := serveHTTP("HTTP 服務器", cfg.Listen, cfg.TLS, handler); err != nil {
+		return err
+	}
+	log.Printf("🌐 HTTP 服務器啟動於 %s", es.baseURL())
+	return nil
+}
+
+// 本機存取用的網址
+func (es *EnergySystem) baseURL() string {
+	scheme := "http"
+	if es.config.HTTP.TLS.Enabled {
+		scheme = "https"
+	}
+
+	host, port, err := net.SplitHostPort(es.config.HTTP.Listen)
+	if err != nil {
+		return scheme + "://localhost:8080"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, port))
 }
 
 // 開啟瀏覽器
 func (es *EnergySystem) OpenBrowser() {
-	url := "http://localhost:8080/energy_dashboard.html"
+	url := es.baseURL() + webcore.DashboardPrefix + "energy_dashboard.html"
 	var cmd *exec.Cmd
 
 	switch runtime.GOOS {
@@ -435,24 +492,61 @@ func (es *EnergySystem) Start() error {
 	if err != nil {
 		return err
 	}
+	es.recordAppliedConfig(es.config)
+	es.checkAuthSetup()
+	var spool *Spool
+	if dir := es.config.Database.Spool.Dir; dir != "" {
+		if spool, err = OpenSpool("database", dir, es.config.Database.Spool.MaxMB); err != nil {
+			return err
+		}
+	}
+	es.writes = NewWriteQueue(es.store, es.config.Database.WriteQueue, spool)
+	es.writes.Start()
+
+	// 2. 啟動 InfluxDB 輸出
+	es.influx, err = NewInfluxOutput(es.config.Outputs.Influx)
+	if err != nil {
+		return err
+	}
+	if es.influx != nil {
+		es.influx.Start()
+	}
+
+	// 3. 啟動定期備份、缺口檢查與用電量計算
+	es.startBackupSchedule()
+	es.startGapMonitor()
+
+	// 4. 啟動 HTTP 服務器
+	if err := es.StartHTTPServer(); err != nil {
+		return err
+	}
 
-	// 2. 啟動 HTTP 服務器
-	es.StartHTTPServer()
+	// 5. 啟動 Modbus 閘道 (需在資料收集前，才能收到第一次輪詢的結果)
+	if es.gateway, err = StartModbusGateway(es, es.config.Outputs.Modbus); err != nil {
+		return err
+	}
 
-	// 3. 啟動資料收集
-	go es.StartDataCollection()
+	// 6. 啟動資料收集
+	es.StartDataCollection()
 
-	// 4. 等待系統穩定
+	// 7. 等待系統穩定
 	time.Sleep(2 * time.Second)
 
-	// 5. 開啟瀏覽器
-	es.OpenBrowser()
+	// 8. 開啟瀏覽器
+	if es.config.HTTP.OpenBrowser {
+		es.OpenBrowser()
+	}
 
 	fmt.Println("==================================================")
 	fmt.Println("✅ 系統啟動完成！")
-	fmt.Println("📊 能源儀表板: http://localhost:8080/energy_dashboard.html")
-	fmt.Println("🔄 每 5 秒自動收集電表資料")
-	fmt.Println("💾 資料儲存至 SQLite3: energy_data.db")
+	fmt.Printf("📊 能源儀表板: %s%senergy_dashboard.html\n", es.baseURL(), webcore.DashboardPrefix)
+	fmt.Printf("🔄 每 %v 自動收集 %d 個電表資料\n", es.config.Collection.PollInterval, len(es.config.Meters))
+	fmt.Println("🔁 修改設定檔後送出 SIGHUP 或呼叫 /api/config/reload 即可重新載入")
+	if es.config.Database.Driver == "postgres" {
+		fmt.Println("💾 資料儲存至 PostgreSQL")
+	} else {
+		fmt.Printf("💾 資料儲存至 SQLite3: %s\n", es.config.Database.Path)
+	}
 	fmt.Println("按 Ctrl+C 停止系統")
 	fmt.Println("==================================================")
 
@@ -462,21 +556,85 @@ func (es *EnergySystem) Start() error {
 // 停止系統
 func (es *EnergySystem) Stop() {
 	es.StopDataCollection()
-	if es.db != nil {
-		es.db.Close()
+	if es.gateway != nil {
+		es.gateway.Stop()
+	}
+	if es.writes != nil {
+		es.writes.Stop()
+	}
+	es.stopBackupSchedule()
+	es.stopGapMonitor()
+	if es.influx != nil {
+		es.influx.Stop()
+	}
+	if es.store != nil {
+		es.store.Close()
 	}
 	log.Println("🛑 系統已停止")
 }
 
 func main() {
-	system := NewEnergySystem()
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(runConfigCommand(os.Args[2:]))
+		case "export":
+			os.Exit(runExportCommand(os.Args[2:]))
+		case "import":
+			os.Exit(runImportCommand(os.Args[2:]))
+		case "storage":
+			os.Exit(runStorageCommand(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackupCommand(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestoreCommand(os.Args[2:]))
+		case "user":
+			os.Exit(runUserCommand(os.Args[2:]))
+		case "api":
+			os.Exit(runAPICommand(os.Args[2:]))
+		case "discover":
+			os.Exit(runDiscoverCommand(os.Args[2:]))
+		case "simulate":
+			os.Exit(runSimulateCommand(os.Args[2:]))
+		}
+	}
+
+	flags := registerConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	config, err := flags.load()
+	if err != nil {
+		log.Fatalf("載入設定失敗: %v", err)
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, e := range errs {
+			log.Printf("❌ 設定錯誤 %v", e)
+		}
+		log.Fatalf("設定驗證失敗，請執行 config validate 檢查設定檔")
+	}
+
+	system := NewEnergySystem(config)
+	system.loadConfig = flags.load
 
 	// 設定信號處理
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP 重新載入設定
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			log.Println("🔁 接收到 SIGHUP，重新載入設定...")
+			if _, err := system.ReloadConfig(); err != nil {
+				log.Printf("❌ 重新載入設定失敗: %v", err)
+			}
+		}
+	}()
+
 	// 啟動系統
-	err := system.Start()
+	err = system.Start()
 	if err != nil {
 		log.Fatalf("系統啟動失敗: %v", err)
 	}
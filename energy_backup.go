@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"energy-monitoring/internal/webcore"
+)
+
+// 資料庫備份：以 SQLite 線上備份 API 取得一致的快照 (收集器不需停止)，
+// 壓縮為 gzip，可選擇以密碼加密 (AES-256-GCM)，並只保留最新的 keep 份
+
+const (
+	backupTimeFormat = "20060102-150405"
+	backupExt        = ".db.gz"
+	backupEncExt     = ".enc"
+
+	// 加密格式: 標頭 [magic 8][salt 16][PBKDF2 次數 u32][nonce 前綴 4]，
+	// 之後為多個區塊 [密文長度 u32][密文]；nonce = 前綴 + 區塊序號，
+	// 最後一個區塊另外標記，檔案被截斷時解密失敗
+	backupMagic         = "EMBAK001"
+	backupChunkSize     = 64 * 1024
+	backupKDFIterations = 200000
+	// 標頭中 PBKDF2 次數的上限，避免惡意檔案以極大次數讓驗證或還原長時間佔用 CPU
+	backupKDFMaxIterations = 5000000
+
+	// 定期備份檢查間隔
+	backupCheckInterval = time.Minute
+)
+
+// 支援線上備份的儲存 (目前僅 SQLite)
+type backupStorage interface {
+	Backup(destPath string) error
+}
+
+// 備份結果
+type BackupResult struct {
+	Path      string        `json:"path"`
+	Size      int64         `json:"size"`
+	Database  int64         `json:"database_size"` // 未壓縮的資料庫大小
+	Encrypted bool          `json:"encrypted"`
+	Duration  time.Duration `json:"duration"`
+	Removed   []string      `json:"removed,omitempty"` // 輪替刪除的舊備份
+}
+
+// 定期備份狀態
+type BackupStatus struct {
+	Interval    time.Duration `json:"interval"`
+	Count       int64         `json:"count"` // 本次啟動後完成的備份數
+	Last        *BackupResult `json:"last,omitempty"`
+	LastAt      time.Time     `json:"last_at"`
+	LastError   string        `json:"last_error,omitempty"`
+	LastErrorAt time.Time     `json:"last_error_at"`
+}
+
+// 備份資料庫至 cfg.Dir
+func CreateBackup(store Storage, dbPath string, cfg BackupConfig) (*BackupResult, error) {
+	backupper, ok := store.(backupStorage)
+	if !ok {
+		return nil, fmt.Errorf("%s 不支援線上備份，PostgreSQL 請使用 pg_dump", store.Driver())
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("建立備份目錄失敗: %v", err)
+	}
+
+	start := time.Now()
+	base := backupBaseName(dbPath)
+	snapshot := filepath.Join(cfg.Dir, "."+base+"-snapshot.db")
+	os.Remove(snapshot)
+	defer os.Remove(snapshot)
+	defer os.Remove(snapshot + "-journal")
+
+	if err := backupper.Backup(snapshot); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BackupResult{Database: info.Size(), Encrypted: cfg.Passphrase != ""}
+	result.Path = filepath.Join(cfg.Dir, base+"-"+start.Format(backupTimeFormat)+backupExt)
+	if result.Encrypted {
+		result.Path += backupEncExt
+	}
+	if result.Size, err = compressBackup(snapshot, result.Path, cfg.Passphrase); err != nil {
+		return nil, err
+	}
+	result.Duration = time.Since(start)
+
+	if result.Removed, err = rotateBackups(cfg.Dir, base, cfg.Keep); err != nil {
+		log.Printf("⚠️ 刪除舊備份失敗: %v", err)
+	}
+	return result, nil
+}
+
+// 備份檔名前綴 (資料庫檔名去除副檔名)
+func backupBaseName(dbPath string) string {
+	name := filepath.Base(dbPath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// 壓縮 (及加密) 快照，先寫入暫存檔完成後再改名，回傳檔案大小
+func compressBackup(snapshot, path, passphrase string) (int64, error) {
+	in, err := os.Open(snapshot)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp := path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("建立備份檔失敗: %v", err)
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	var w io.Writer = out
+	var encrypter io.WriteCloser
+	if passphrase != "" {
+		if encrypter, err = newBackupEncrypter(out, passphrase); err != nil {
+			return 0, err
+		}
+		w = encrypter
+	}
+	gz := gzip.NewWriter(w)
+	if _, err := io.Copy(gz, in); err != nil {
+		return 0, fmt.Errorf("壓縮備份失敗: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("壓縮備份失敗: %v", err)
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return 0, fmt.Errorf("加密備份失敗: %v", err)
+		}
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(tmp, path)
+}
+
+// 目錄中同一資料庫的備份檔 (依時間由舊到新)
+func listBackups(dir, base string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base+"-") {
+			continue
+		}
+		if strings.HasSuffix(name, backupExt) || strings.HasSuffix(name, backupExt+backupEncExt) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	// 檔名含時間戳記，依名稱排序即為時間順序
+	sort.Strings(files)
+	return files, nil
+}
+
+// 只保留最新的 keep 份備份 (0 表示全部保留)
+func rotateBackups(dir, base string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	files, err := listBackups(dir, base)
+	if err != nil || len(files) <= keep {
+		return nil, err
+	}
+
+	var removed []string
+	for _, path := range files[:len(files)-keep] {
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// 檔案大小 (KB/MB/GB)
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// 由密碼與標頭建立 AES-256-GCM
+func backupCipher(passphrase string, salt []byte, iterations uint32) (cipher.AEAD, error) {
+	key := webcore.PBKDF2SHA256([]byte(passphrase), salt, int(iterations), 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 加密串流的區塊 nonce 與附加資料 (標頭 + 是否為最後一個區塊)
+type backupStream struct {
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+}
+
+func (s *backupStream) nonce() []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	copy(nonce, s.header[len(s.header)-4:])
+	binary.BigEndian.PutUint64(nonce[4:], s.counter)
+	s.counter++
+	return nonce
+}
+
+func (s *backupStream) additionalData(final bool) []byte {
+	flag := byte(0)
+	if final {
+		flag = 1
+	}
+	return append(append([]byte(nil), s.header...), flag)
+}
+
+// 加密寫入：資料滿一個區塊且還有後續資料時才寫出，Close 時寫出最後一個區塊
+type backupEncrypter struct {
+	backupStream
+	w   io.Writer
+	buf []byte
+}
+
+func newBackupEncrypter(w io.Writer, passphrase string) (*backupEncrypter, error) {
+	header := make([]byte, len(backupMagic)+16+4+4)
+	copy(header, backupMagic)
+	salt := header[len(backupMagic) : len(backupMagic)+16]
+	prefix := header[len(header)-4:]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(header[len(backupMagic)+16:], backupKDFIterations)
+
+	aead, err := backupCipher(passphrase, salt, backupKDFIterations)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &backupEncrypter{
+		backupStream: backupStream{aead: aead, header: header},
+		w:            w,
+		buf:          make([]byte, 0, backupChunkSize),
+	}, nil
+}
+
+func (e *backupEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == backupChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):backupChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *backupEncrypter) Close() error {
+	return e.seal(true)
+}
+
+func (e *backupEncrypter) seal(final bool) error {
+	sealed := e.aead.Seal(nil, e.nonce(), e.buf, e.additionalData(final))
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(sealed)))
+	if _, err := e.w.Write(length); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// 解密讀取
+type backupDecrypter struct {
+	backupStream
+	r     *bufio.Reader
+	plain []byte
+	done  bool
+}
+
+func newBackupDecrypter(r *bufio.Reader, passphrase string) (*backupDecrypter, error) {
+	header := make([]byte, len(backupMagic)+16+4+4)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return nil, fmt.Errorf("不是加密的備份檔")
+	}
+	salt := header[len(backupMagic) : len(backupMagic)+16]
+	iterations := binary.BigEndian.Uint32(header[len(backupMagic)+16:])
+	if iterations == 0 || iterations > backupKDFMaxIterations {
+		return nil, fmt.Errorf("備份檔的金鑰衍生次數不正確 (%d)", iterations)
+	}
+
+	aead, err := backupCipher(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	return &backupDecrypter{backupStream: backupStream{aead: aead, header: header}, r: r}, nil
+}
+
+func (d *backupDecrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *backupDecrypter) open() error {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(d.r, length); err != nil {
+		return fmt.Errorf("備份檔不完整")
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size > backupChunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("備份檔格式錯誤")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("備份檔不完整")
+	}
+
+	// 之後沒有資料即為最後一個區塊
+	_, err := d.r.Peek(1)
+	final := err == io.EOF
+	plain, err := d.aead.Open(nil, d.nonce(), sealed, d.additionalData(final))
+	if err != nil {
+		return fmt.Errorf("解密失敗 (密碼錯誤或檔案損毀)")
+	}
+	d.plain = plain
+	d.done = final
+	return nil
+}
+
+// 解壓縮 (及解密) 備份檔至 dest
+func extractBackup(path, dest, passphrase string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader := bufio.NewReader(in)
+	var r io.Reader = reader
+	if magic, _ := reader.Peek(len(backupMagic)); string(magic) == backupMagic {
+		if passphrase == "" {
+			return fmt.Errorf("備份檔已加密，請提供密碼 (-passphrase 或 ENERGY_BACKUP_PASSPHRASE)")
+		}
+		if r, err = newBackupDecrypter(reader, passphrase); err != nil {
+			return err
+		}
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("解壓縮失敗: %v", err)
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, gz); err != nil {
+		return fmt.Errorf("解壓縮失敗: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("解壓縮失敗: %v", err)
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// 驗證資料庫檔案：integrity_check 通過、含 samples 資料表且結構版本不比程式新，回傳結構版本
+func verifyBackupDatabase(path string) (int, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return 0, fmt.Errorf("無法讀取資料庫: %v", err)
+	}
+	var problems []string
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if message != "ok" {
+			problems = append(problems, message)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("完整性檢查失敗: %v", err)
+	}
+	if len(problems) > 0 {
+		if len(problems) > 5 {
+			problems = append(problems[:5], fmt.Sprintf("... 共 %d 項", len(problems)))
+		}
+		return 0, fmt.Errorf("完整性檢查失敗: %s", strings.Join(problems, "; "))
+	}
+
+	var version, tables int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("讀取資料庫版本失敗: %v", err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'samples'`).Scan(&tables); err != nil {
+		return 0, err
+	}
+	if version < 1 || tables == 0 {
+		return version, fmt.Errorf("不是能源監控資料庫 (結構版本 %d)", version)
+	}
+	if version > schemaVersion {
+		return version, fmt.Errorf("備份的結構版本 %d 比目前程式支援的版本 %d 新，請先更新程式", version, schemaVersion)
+	}
+	return version, nil
+}
+
+// 還原備份：驗證通過後才取代資料庫，原資料庫改名保留為 <db>.before-restore-<時間>
+func RestoreBackup(path, dbPath, passphrase string, verifyOnly bool) (int, error) {
+	// 解壓縮至資料庫所在目錄，確保可直接改名取代
+	tmp := filepath.Join(filepath.Dir(dbPath), "."+filepath.Base(dbPath)+".restore")
+	defer os.Remove(tmp)
+	if err := extractBackup(path, tmp, passphrase); err != nil {
+		return 0, err
+	}
+	version, err := verifyBackupDatabase(tmp)
+	if err != nil || verifyOnly {
+		return version, err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := releaseDatabase(dbPath); err != nil {
+			return version, err
+		}
+		previous := dbPath + ".before-restore-" + time.Now().Format(backupTimeFormat)
+		if err := os.Rename(dbPath, previous); err != nil {
+			return version, fmt.Errorf("保留原資料庫失敗: %v", err)
+		}
+		for _, suffix := range []string{"-wal", "-shm"} {
+			os.Remove(dbPath + suffix)
+		}
+		log.Printf("💾 原資料庫已保留為 %s", previous)
+	}
+
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return version, fmt.Errorf("取代資料庫失敗: %v", err)
+	}
+	return version, nil
+}
+
+// 確認資料庫未被使用並將 WAL 寫回主檔案 (系統執行中時無法取得獨佔鎖定)
+func releaseDatabase(dbPath string) error {
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_locking_mode=EXCLUSIVE&_busy_timeout=1000")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`BEGIN EXCLUSIVE; COMMIT`); err != nil {
+		return fmt.Errorf("資料庫使用中，請先停止能源監控系統: %v", err)
+	}
+	if _, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("寫回 WAL 失敗: %v", err)
+	}
+	return nil
+}
+
+// 啟動定期備份 (每分鐘檢查一次設定，重新載入設定後立即生效)
+func (es *EnergySystem) startBackupSchedule() {
+	es.backupStop = make(chan struct{})
+	es.backupDone = make(chan struct{})
+	go func() {
+		defer close(es.backupDone)
+
+		last := time.Now()
+		ticker := time.NewTicker(backupCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-es.backupStop:
+				return
+			}
+
+			config := es.Config()
+			if config.Backup.Interval <= 0 || time.Since(last) < config.Backup.Interval {
+				continue
+			}
+			last = time.Now()
+			es.runBackup(config)
+		}
+	}()
+}
+
+// 停止定期備份 (等待進行中的備份完成)
+func (es *EnergySystem) stopBackupSchedule() {
+	if es.backupStop != nil {
+		close(es.backupStop)
+		<-es.backupDone
+	}
+}
+
+func (es *EnergySystem) runBackup(config *Config) {
+	result, err := CreateBackup(es.store, config.Database.Path, config.Backup)
+
+	es.backupMu.Lock()
+	defer es.backupMu.Unlock()
+	if err != nil {
+		es.backupStatus.LastError = err.Error()
+		es.backupStatus.LastErrorAt = time.Now()
+		log.Printf("❌ 定期備份失敗: %v", err)
+		return
+	}
+	es.backupStatus.Count++
+	es.backupStatus.Last = result
+	es.backupStatus.LastAt = time.Now()
+	log.Printf("💾 已備份資料庫至 %s (%s，%v)", result.Path, formatBytes(result.Size), result.Duration.Round(time.Millisecond))
+	for _, path := range result.Removed {
+		log.Printf("🔁 已刪除舊備份 %s", path)
+	}
+}
+
+// 定期備份狀態
+func (es *EnergySystem) BackupStatus() BackupStatus {
+	es.backupMu.Lock()
+	defer es.backupMu.Unlock()
+	status := es.backupStatus
+	status.Interval = es.Config().Backup.Interval
+	return status
+}
+
+// backup 子命令
+func runBackupCommand(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	dir := fs.String("dir", "", "備份目錄 (預設為 backup.dir)")
+	keep := fs.Int("keep", -1, "保留最新的備份數量 (預設為 backup.keep，0 表示全部保留)")
+	passphrase := fs.String("passphrase", "", "加密密碼 (預設為 backup.passphrase 或 ENERGY_BACKUP_PASSPHRASE)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+	if *dir != "" {
+		config.Backup.Dir = *dir
+	}
+	if *keep >= 0 {
+		config.Backup.Keep = *keep
+	}
+	if *passphrase != "" {
+		config.Backup.Passphrase = *passphrase
+	}
+
+	// 不執行資料庫初始化，系統執行中也可備份
+	store, err := OpenStorage(config.Database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 無法開啟資料庫: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	result, err := CreateBackup(store, config.Database.Path, config.Backup)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 備份失敗: %v\n", err)
+		return 1
+	}
+	encrypted := ""
+	if result.Encrypted {
+		encrypted = "，已加密"
+	}
+	fmt.Printf("✅ 已備份至 %s (資料庫 %s，壓縮後 %s%s，%v)\n", result.Path,
+		formatBytes(result.Database), formatBytes(result.Size), encrypted, result.Duration.Round(time.Millisecond))
+	for _, path := range result.Removed {
+		fmt.Printf("🔁 已刪除舊備份 %s\n", path)
+	}
+	return 0
+}
+
+// restore 子命令
+func runRestoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	passphrase := fs.String("passphrase", "", "解密密碼 (預設為 backup.passphrase 或 ENERGY_BACKUP_PASSPHRASE)")
+	verifyOnly := fs.Bool("verify-only", false, "只驗證備份檔，不還原")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: restore [參數] <備份檔>")
+		fmt.Fprintln(fs.Output(), "還原前請先停止能源監控系統")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+	if config.Database.Driver == "postgres" {
+		fmt.Fprintln(os.Stderr, "❌ 僅支援還原 SQLite 資料庫，PostgreSQL 請使用 pg_restore")
+		return 1
+	}
+	if *passphrase != "" {
+		config.Backup.Passphrase = *passphrase
+	}
+
+	path := fs.Arg(0)
+	version, err := RestoreBackup(path, config.Database.Path, config.Backup.Passphrase, *verifyOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 還原失敗: %v\n", err)
+		return 1
+	}
+	if *verifyOnly {
+		fmt.Printf("✅ 備份檔 %s 驗證通過 (結構版本 %d)\n", path, version)
+		return 0
+	}
+	fmt.Printf("✅ 已從 %s 還原資料庫 %s (結構版本 %d)\n", path, config.Database.Path, version)
+	if version < schemaVersion {
+		fmt.Printf("🔧 下次啟動時將升級至結構版本 %d\n", schemaVersion)
+	}
+	return 0
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sealBackup(t *testing.T, plain []byte, passphrase string) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	e, err := newBackupEncrypter(&sealed, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func openBackup(sealed []byte, passphrase string) ([]byte, error) {
+	d, err := newBackupDecrypter(bufio.NewReader(bytes.NewReader(sealed)), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(d)
+}
+
+func backupPlaintext(size int) []byte {
+	plain := make([]byte, size)
+	for i := range plain {
+		plain[i] = byte(i * 7)
+	}
+	return plain
+}
+
+// 各種長度 (空白、剛好一個區塊、多個區塊) 加密後可還原
+func TestBackupEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, backupChunkSize, backupChunkSize*2 + backupChunkSize/2} {
+		plain := backupPlaintext(size)
+		got, err := openBackup(sealBackup(t, plain, "secret"), "secret")
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("%d bytes: 解密內容不一致 (%d bytes)", size, len(got))
+		}
+	}
+
+	sealed := sealBackup(t, backupPlaintext(100), "secret")
+	if _, err := openBackup(sealed, "wrong"); err == nil || !strings.Contains(err.Error(), "解密失敗") {
+		t.Fatalf("密碼錯誤應解密失敗，實際 %v", err)
+	}
+	if _, err := openBackup([]byte("EMBAK00"), "secret"); err == nil {
+		t.Fatal("標頭不完整應回傳錯誤")
+	}
+}
+
+// 檔案截斷：區塊不完整，或剛好少了最後一個區塊 (前一個區塊未標記為最後一個) 都要解密失敗
+func TestBackupEncryptTruncated(t *testing.T) {
+	plain := backupPlaintext(backupChunkSize*2 + backupChunkSize/2)
+	sealed := sealBackup(t, plain, "secret")
+	header := len(backupMagic) + 16 + 4 + 4
+	chunk := 4 + backupChunkSize + 16 // 長度 + 密文 + GCM tag
+
+	cases := []struct {
+		name string
+		size int
+		want string
+	}{
+		{"最後一個區塊不完整", len(sealed) - 10, "不完整"},
+		{"少了最後一個區塊", header + 2*chunk, "解密失敗"},
+		{"只剩第一個區塊", header + chunk, "解密失敗"},
+		{"區塊長度不完整", header + 2, "不完整"},
+	}
+	for _, c := range cases {
+		_, err := openBackup(sealed[:c.size], "secret")
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: 預期錯誤包含 %q，實際 %v", c.name, c.want, err)
+		}
+	}
+
+	// 竄改最後一個區塊的內容
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := openBackup(tampered, "secret"); err == nil {
+		t.Fatal("竄改的檔案應解密失敗")
+	}
+}
+
+// 建立含一筆輪詢資料的 SQLite 資料庫
+func newBackupTestDatabase(t *testing.T, dir string) (Storage, string) {
+	t.Helper()
+	database := DefaultConfig().Database
+	database.Driver, database.Path = "sqlite", filepath.Join(dir, "energy.db")
+	store, err := NewSQLiteStorage(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	record := PollRecord{DeviceID: "check", Timestamp: checkBase, Readings: checkReadings(220.5, 12)}
+	if err := store.WriteSamples([]PollRecord{record}); err != nil {
+		t.Fatal(err)
+	}
+	return store, database.Path
+}
+
+// 加密備份後還原至新位置及取代現有資料庫 (原資料庫改名保留)
+func TestRestoreBackup(t *testing.T) {
+	dir := t.TempDir()
+	store, dbPath := newBackupTestDatabase(t, dir)
+	result, err := CreateBackup(store, dbPath, BackupConfig{Dir: filepath.Join(dir, "backups"), Keep: 1, Passphrase: "secret"})
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Encrypted || !strings.HasSuffix(result.Path, backupExt+backupEncExt) {
+		t.Fatalf("備份結果錯誤: %+v", result)
+	}
+
+	if _, err := RestoreBackup(result.Path, dbPath, "", true); err == nil || !strings.Contains(err.Error(), "請提供密碼") {
+		t.Fatalf("未提供密碼應回傳錯誤，實際 %v", err)
+	}
+	if _, err := RestoreBackup(result.Path, dbPath, "wrong", true); err == nil {
+		t.Fatal("密碼錯誤應回傳錯誤")
+	}
+
+	restored := filepath.Join(dir, "restored", "energy.db")
+	os.MkdirAll(filepath.Dir(restored), 0755)
+	if version, err := RestoreBackup(result.Path, restored, "secret", false); err != nil || version != schemaVersion {
+		t.Fatalf("還原失敗: 版本 %d, %v", version, err)
+	}
+	database := DefaultConfig().Database
+	database.Driver, database.Path = "sqlite", restored
+	store, err = NewSQLiteStorage(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := store.LatestSnapshot("check")
+	store.Close()
+	if err != nil || !strings.Contains(snapshot, "220.5") {
+		t.Fatalf("還原後的資料錯誤: %s %v", snapshot, err)
+	}
+
+	if _, err := RestoreBackup(result.Path, dbPath, "secret", false); err != nil {
+		t.Fatal(err)
+	}
+	if previous, _ := filepath.Glob(dbPath + ".before-restore-*"); len(previous) != 1 {
+		t.Fatalf("原資料庫應保留一份: %v", previous)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".*.restore")); len(leftovers) != 0 {
+		t.Fatalf("殘留的暫存檔: %v", leftovers)
+	}
+}
+
+func TestVerifyBackupDatabase(t *testing.T) {
+	dir := t.TempDir()
+	store, dbPath := newBackupTestDatabase(t, dir)
+	store.Close()
+	if version, err := verifyBackupDatabase(dbPath); err != nil || version != schemaVersion {
+		t.Fatalf("驗證失敗: 版本 %d, %v", version, err)
+	}
+
+	other := filepath.Join(dir, "other.db")
+	db, err := sql.Open("sqlite3", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE readings (value REAL)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyBackupDatabase(other); err == nil || !strings.Contains(err.Error(), "不是能源監控資料庫") {
+		t.Fatalf("其他資料庫應驗證失敗，實際 %v", err)
+	}
+
+	// 結構版本比程式新
+	db, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`PRAGMA user_version = 999`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyBackupDatabase(dbPath); err == nil || !strings.Contains(err.Error(), "請先更新程式") {
+		t.Fatalf("較新的結構版本應驗證失敗，實際 %v", err)
+	}
+
+	garbage := filepath.Join(dir, "garbage.db")
+	ioutil.WriteFile(garbage, bytes.Repeat([]byte("not a database "), 100), 0644)
+	if _, err := verifyBackupDatabase(garbage); err == nil {
+		t.Fatal("非資料庫檔案應驗證失敗")
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// 單一電表的資料收集器
+// 每個電表各自維持一條 Modbus TCP 連線，設定重新載入時只更新有變動的部分
+type MeterCollector struct {
+	system *EnergySystem
+
+	mu         sync.Mutex // 保護設定與統計
+	meter      MeterConfig
+	parameters []MeterParameter
+	groups     []pollGroup
+	timeout    time.Duration
+	overrun    string
+	maxCatchUp int
+	staleAfter time.Duration
+	substitute bool
+	identify   bool // 讀取裝置識別 (FC43/14)
+	stats      PollStats
+	connected  bool // 目前有 Modbus 連線
+	lastGood   map[string]*lastGoodValue
+	latest     map[string]MeterReading // 各點位最新的讀值 (合併各群組的輪詢，供 /api/latest)
+
+	connMu     sync.Mutex // 保護 Modbus 連線
+	handler    *modbus.TCPClientHandler
+	client     modbus.Client
+	identified bool // 本次連線已讀取裝置識別
+
+	update chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// 建立電表收集器
+func NewMeterCollector(es *EnergySystem, cfg *Config, meter MeterConfig) *MeterCollector {
+	mc := &MeterCollector{
+		system:   es,
+		lastGood: make(map[string]*lastGoodValue),
+		latest:   make(map[string]MeterReading),
+		update:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	mc.apply(cfg, meter)
+	return mc
+}
+
+// 套用新的設定 (不影響既有連線)
+func (mc *MeterCollector) apply(cfg *Config, meter MeterConfig) {
+	parameters, _ := cfg.RegisterMap(meter.Model)
+
+	mc.mu.Lock()
+	mc.meter = meter
+	mc.parameters = parameters
+	mc.groups = buildPollGroups(cfg, meter, parameters)
+	mc.timeout = cfg.Collection.Timeout
+	mc.overrun = cfg.Collection.Overrun
+	mc.maxCatchUp = cfg.Collection.MaxCatchUp
+	mc.staleAfter = cfg.Collection.StaleAfter
+	mc.substitute = cfg.Collection.SubstituteLastGood
+	mc.identify = cfg.Collection.Identify
+	mc.mu.Unlock()
+
+	mc.connMu.Lock()
+	if mc.handler != nil {
+		mc.handler.Timeout = cfg.Collection.Timeout
+	}
+	mc.connMu.Unlock()
+}
+
+// 更新輪詢間隔與暫存器對照表，通知收集迴圈重新排程
+func (mc *MeterCollector) Update(cfg *Config, meter MeterConfig) {
+	mc.apply(cfg, meter)
+
+	select {
+	case mc.update <- struct{}{}:
+	default:
+	}
+}
+
+// 判斷新設定是否需要重新建立連線
+func (mc *MeterCollector) needsReconnect(meter MeterConfig) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.meter.Host != meter.Host || mc.meter.Port != meter.Port || mc.meter.SlaveID != meter.SlaveID
+}
+
+// 收集迴圈：依各點位群組的間隔對齊時鐘邊界排程
+func (mc *MeterCollector) Run() {
+	defer close(mc.done)
+	defer mc.disconnect()
+
+	schedule := mc.newSchedule(time.Now())
+	timer := time.NewTimer(time.Until(schedule.next()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			due := schedule.due(time.Now())
+			if len(due) > 0 {
+				mc.collect(due)
+			}
+
+			mc.mu.Lock()
+			skipped := schedule.advance(due, time.Now(), mc.overrun, mc.maxCatchUp)
+			mc.stats.Skipped += skipped
+			mc.mu.Unlock()
+			if skipped > 0 {
+				log.Printf("⚠️ 電表 %s 讀取逾時，略過 %d 次排程", mc.ID(), skipped)
+			}
+
+			timer.Reset(time.Until(schedule.next()))
+
+		case <-mc.update:
+			schedule = mc.newSchedule(time.Now())
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(schedule.next()))
+			log.Printf("🔧 電表 %s 排程已更新", mc.ID())
+
+		case <-mc.stop:
+			return
+		}
+	}
+}
+
+// 依目前設定建立排程
+func (mc *MeterCollector) newSchedule(now time.Time) *pollSchedule {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return newPollSchedule(mc.groups, now)
+}
+
+// 停止收集並等待迴圈結束
+func (mc *MeterCollector) Stop() {
+	close(mc.stop)
+	<-mc.done
+}
+
+// 電表 ID
+func (mc *MeterCollector) ID() string {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.meter.ID
+}
+
+// 輪詢統計
+func (mc *MeterCollector) Stats() PollStats {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.stats
+}
+
+// 各點位最新的讀值，依暫存器對照表排序 (尚未輪詢的點位不列出)
+func (mc *MeterCollector) Latest() []MeterReading {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	readings := make([]MeterReading, 0, len(mc.latest))
+	for _, param := range mc.parameters {
+		if reading, ok := mc.latest[param.Name]; ok {
+			readings = append(readings, reading)
+		}
+	}
+	return readings
+}
+
+// 執行一次讀取與儲存 (只讀取到期的點位群組)
+func (mc *MeterCollector) collect(due []*scheduledGroup) {
+	id := mc.ID()
+
+	var parameters []indexedParameter
+	for _, group := range due {
+		parameters = append(parameters, group.parameters...)
+	}
+	sort.Slice(parameters, func(i, j int) bool { return parameters[i].index < parameters[j].index })
+
+	release := mc.system.acquirePollSlot()
+	sampleTime := time.Now()
+	readings, err := mc.ReadMeterData(parameters)
+	release()
+	duration := time.Since(sampleTime)
+
+	mc.mu.Lock()
+	mc.stats.Polls++
+	mc.stats.LastPoll = sampleTime
+	mc.stats.LastDuration = duration
+	if err != nil {
+		mc.stats.Failures++
+		mc.stats.FailStreak++
+		mc.stats.LastError = err.Error()
+	} else {
+		mc.stats.FailStreak = 0
+		mc.stats.LastError = ""
+		mc.stats.LastSuccess = sampleTime
+	}
+	for i := range readings {
+		applyQualityHistory(&readings[i], mc.lastGood, sampleTime, mc.staleAfter, mc.substitute)
+		mc.latest[readings[i].Name] = readings[i]
+	}
+	mc.mu.Unlock()
+
+	if gateway := mc.system.gateway; gateway != nil {
+		gateway.Observe(id, readings, sampleTime)
+	}
+	if err != nil {
+		log.Printf("❌ 讀取電表 %s 資料失敗: %v", id, err)
+	}
+
+	// 讀取失敗的點位仍會以品質代碼記錄
+	saveErr := mc.system.SaveToDatabase(id, sampleTime, readings)
+	if saveErr != nil {
+		log.Printf("❌ 儲存電表 %s 資料失敗: %v", id, saveErr)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	mc.system.alarms.Evaluate(id, readings, sampleTime)
+
+	good := 0
+	for _, reading := range readings {
+		if reading.Quality == QualityGood {
+			good++
+		}
+	}
+	log.Printf("✅ 成功收集並儲存電表 %s 的 %d 筆資料 (正常 %d 筆，%s，耗時 %v)", id, len(readings), good, sampleTime.Format("15:04:05"), duration.Round(time.Millisecond))
+}
+
+// 建立連線 (已連線時沿用，呼叫端需持有 connMu)
+func (mc *MeterCollector) connect() error {
+	if mc.client != nil {
+		return nil
+	}
+
+	mc.mu.Lock()
+	meter, timeout := mc.meter, mc.timeout
+	mc.mu.Unlock()
+
+	handler := modbus.NewTCPClientHandler(fmt.Sprintf("%s:%d", meter.Host, meter.Port))
+	handler.Timeout = timeout
+	handler.IdleTimeout = 60 * time.Second
+	handler.SlaveId = byte(meter.SlaveID)
+
+	if err := handler.Connect(); err != nil {
+		return fmt.Errorf("無法連接到電表: %v", err)
+	}
+
+	mc.handler = handler
+	mc.client = modbus.NewClient(handler)
+	mc.setConnected(true)
+	return nil
+}
+
+func (mc *MeterCollector) setConnected(connected bool) {
+	mc.mu.Lock()
+	mc.connected = connected
+	mc.mu.Unlock()
+}
+
+// 是否已連線到電表
+func (mc *MeterCollector) Connected() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.connected
+}
+
+// 關閉連線
+func (mc *MeterCollector) disconnect() {
+	mc.connMu.Lock()
+	defer mc.connMu.Unlock()
+
+	mc.dropConnection()
+}
+
+// 關閉連線，下次讀寫時重新連線 (呼叫端需持有 connMu)
+func (mc *MeterCollector) dropConnection() {
+	if mc.handler != nil {
+		mc.handler.Close()
+	}
+	mc.handler = nil
+	mc.client = nil
+	mc.identified = false
+	mc.setConnected(false)
+}
+
+// 讀取電表資料，每個參數都會回傳一筆讀值並標記品質
+// 連線失敗時所有參數標記為 comm_error，同時回傳錯誤
+func (mc *MeterCollector) ReadMeterData(parameters []indexedParameter) ([]MeterReading, error) {
+	mc.connMu.Lock()
+	defer mc.connMu.Unlock()
+
+	readings := make([]MeterReading, 0, len(parameters))
+
+	if err := mc.connect(); err != nil {
+		for _, param := range parameters {
+			readings = append(readings, MeterReading{Index: param.index, Name: param.Name, Unit: param.Unit, Quality: QualityCommError})
+		}
+		return readings, err
+	}
+
+	reads, commFailures := 0, 0
+
+	// 讀取所有參數 (計算點位在讀取後計算)
+	for _, param := range parameters {
+		reading := MeterReading{
+			Index: param.index,
+			Name:  param.Name,
+			Unit:  param.Unit,
+		}
+		if param.computed() {
+			readings = append(readings, reading)
+			continue
+		}
+		reads++
+
+		results, err := readPoint(mc.client, param.MeterParameter)
+		if err != nil {
+			log.Printf("❌ 讀取 %s 失敗: %v", param.Name, err)
+			reading.Quality, reading.ExceptionCode = classifyReadError(err)
+			if reading.Quality == QualityCommError {
+				commFailures++
+			}
+			readings = append(readings, reading)
+			continue
+		}
+
+		value, sentinel, err := decodePoint(param.MeterParameter, results)
+		if err != nil {
+			log.Printf("❌ 讀取 %s 失敗: %v", param.Name, err)
+			reading.Quality = QualityCommError
+			commFailures++
+			readings = append(readings, reading)
+			continue
+		}
+		reading.Quality = classifyValue(param.MeterParameter, sentinel, value)
+		if reading.Quality != QualityInvalidSentinel {
+			reading.Value = value
+			reading.State = param.stateName(value)
+		}
+
+		readings = append(readings, reading)
+	}
+
+	computePoints(parameters, readings)
+
+	// 全部通訊失敗時視為連線中斷，下次重新連線
+	if commFailures > 0 && commFailures == reads {
+		mc.dropConnection()
+		return readings, fmt.Errorf("所有參數讀取失敗，將重新連線")
+	}
+
+	mc.mu.Lock()
+	enabled := mc.identify
+	mc.mu.Unlock()
+	if enabled {
+		mc.identifyDevice()
+	}
+	return readings, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// 計算點位 (暫存器對照表的 compute 與 inputs)：不讀取電表，由同一次輪詢的其他點位計算，
+// 之後與一般點位一樣標記品質、儲存、評估告警並輸出到閘道。目前提供三相不平衡率 (%)：
+//   unbalance_nema  與三相平均值的最大偏差 ÷ 平均值 × 100 (NEMA MG1，電壓使用線電壓)
+//   unbalance_iec   負相序 ÷ 正相序 × 100 (IEC 61000-4-30)，由三個線電壓或三線式相電流的大小計算，不需要相角
+
+const (
+	computeUnbalanceNEMA = "unbalance_nema"
+	computeUnbalanceIEC  = "unbalance_iec"
+)
+
+// 各計算方式需要的輸入點位數
+var computeInputs = map[string]int{computeUnbalanceNEMA: 3, computeUnbalanceIEC: 3}
+
+// 是否為計算點位
+func (p MeterParameter) computed() bool {
+	return p.Compute != ""
+}
+
+// NEMA 不平衡率；三相皆為 0 (例如無負載) 時為 0
+func unbalanceNEMA(a, b, c float64) float64 {
+	average := (a + b + c) / 3
+	if average == 0 {
+		return 0
+	}
+	deviation := math.Max(math.Abs(a-average), math.Max(math.Abs(b-average), math.Abs(c-average)))
+	return deviation / average * 100
+}
+
+// IEC 不平衡率：β = (a⁴+b⁴+c⁴) / (a²+b²+c²)²，不平衡率 = √((1-√(3-6β)) / (1+√(3-6β))) × 100
+// 三個大小無法構成三角形時 (3-6β < 0) 視為完全不平衡 (100%)
+func unbalanceIEC(a, b, c float64) float64 {
+	squares := a*a + b*b + c*c
+	if squares == 0 {
+		return 0
+	}
+	beta := (a*a*a*a + b*b*b*b + c*c*c*c) / (squares * squares)
+	root := math.Sqrt(math.Max(0, 3-6*beta))
+	return math.Sqrt((1-root)/(1+root)) * 100
+}
+
+func computeValue(compute string, values []float64) float64 {
+	switch compute {
+	case computeUnbalanceNEMA:
+		return unbalanceNEMA(values[0], values[1], values[2])
+	case computeUnbalanceIEC:
+		return unbalanceIEC(values[0], values[1], values[2])
+	}
+	return math.NaN()
+}
+
+// 以同一次讀取的結果計算計算點位 (readings 與 parameters 順序相同)
+// 輸入沒有數值時沿用該輸入的品質；輸入有數值但品質不是 good 時，計算結果也標記該品質
+func computePoints(parameters []indexedParameter, readings []MeterReading) {
+	byName := make(map[string]*MeterReading, len(readings))
+	for i := range readings {
+		byName[readings[i].Name] = &readings[i]
+	}
+
+	for i, param := range parameters {
+		if !param.computed() {
+			continue
+		}
+		reading := &readings[i]
+		quality := QualityGood
+		values := make([]float64, 0, len(param.Inputs))
+		for _, name := range param.Inputs {
+			input, ok := byName[name]
+			if !ok {
+				quality = QualityCommError
+				break
+			}
+			if !qualityHasValue(input.Quality) {
+				quality = input.Quality
+				break
+			}
+			if input.Quality != QualityGood && quality == QualityGood {
+				quality = input.Quality
+			}
+			values = append(values, input.Value)
+		}
+		if len(values) < len(param.Inputs) {
+			reading.Quality = quality
+			continue
+		}
+
+		value := computeValue(param.Compute, values)
+		reading.Quality = classifyValue(param.MeterParameter, false, value)
+		if reading.Quality != QualityInvalidSentinel {
+			reading.Value = value
+		}
+		if reading.Quality == QualityGood {
+			reading.Quality = quality
+		}
+	}
+}
+
+// 檢查計算點位：計算方式、輸入點位數量，輸入必須是同一群組的讀取點位 (params 為重新命名後的對照表)
+func validateComputedPoint(field string, param MeterParameter, params []MeterParameter, add addFunc) {
+	count, ok := computeInputs[param.Compute]
+	if !ok {
+		add(field+".compute", "必須為 unbalance_nema 或 unbalance_iec (目前 %q)", param.Compute)
+		return
+	}
+	if len(param.Inputs) != count {
+		add(field+".inputs", "%s 需要 %d 個點位 (目前 %d 個)", param.Compute, count, len(param.Inputs))
+	}
+	for i, name := range param.Inputs {
+		input, ok := findParameter(params, name)
+		switch {
+		case !ok:
+			add(fmt.Sprintf("%s.inputs[%d]", field, i), "暫存器對照表中沒有點位 %q", name)
+		case input.computed():
+			add(fmt.Sprintf("%s.inputs[%d]", field, i), "不可使用計算點位 %q", name)
+		case input.Group != param.Group:
+			add(fmt.Sprintf("%s.inputs[%d]", field, i), "點位 %q 的群組 %q 與計算點位的群組 %q 不同", name, input.Group, param.Group)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUnbalance(t *testing.T) {
+	cases := []struct {
+		name      string
+		a, b, c   float64
+		nema, iec float64
+	}{
+		{"三相平衡", 230, 230, 230, 0, 0},
+		{"無負載", 0, 0, 0, 0, 0},
+		// NEMA: 平均 459、最大偏差 9；IEC 以對稱分量計算負相序 ÷ 正相序
+		{"線電壓 460/467/450", 460, 467, 450, 9.0 / 459 * 100, 2.14646},
+		{"線電壓 400/410/390", 400, 410, 390, 2.5, 2.88803},
+		// 只有一相有值：平均 10/3、偏差 20/3
+		{"單相", 10, 0, 0, 200, 100},
+		// 1+1=2 剛好共線 (3-6β = 0)；1+1<3 無法構成三角形，皆視為完全不平衡
+		{"共線", 1, 1, 2, 50, 100},
+		{"無法構成三角形", 1, 1, 3, 80, 100},
+	}
+	for _, c := range cases {
+		if got := unbalanceNEMA(c.a, c.b, c.c); math.Abs(got-c.nema) > 1e-9 {
+			t.Errorf("%s: NEMA 預期 %v，實際 %v", c.name, c.nema, got)
+		}
+		if got := unbalanceIEC(c.a, c.b, c.c); math.Abs(got-c.iec) > 1e-4 {
+			t.Errorf("%s: IEC 預期 %v，實際 %v", c.name, c.iec, got)
+		}
+	}
+}
+
+// 計算點位沿用輸入的品質；NEMA 不平衡率可達 200%，超出 max 時標記 out_of_range 但保留數值
+func TestComputePoints(t *testing.T) {
+	currents := []string{"L1 電流", "L2 電流", "L3 電流"}
+	parameters := []indexedParameter{
+		{MeterParameter: MeterParameter{Name: "L1 電流", Address: 0x0120, Unit: "A"}},
+		{MeterParameter: MeterParameter{Name: "L2 電流", Address: 0x0122, Unit: "A"}},
+		{MeterParameter: MeterParameter{Name: "L3 電流", Address: 0x0124, Unit: "A"}},
+		{MeterParameter: MeterParameter{Name: "電流不平衡率 (NEMA)", Unit: "%", Min: floatPtr(0), Max: floatPtr(200), Compute: computeUnbalanceNEMA, Inputs: currents}},
+		{MeterParameter: MeterParameter{Name: "電流不平衡率 (IEC)", Unit: "%", Min: floatPtr(0), Max: floatPtr(100), Compute: computeUnbalanceIEC, Inputs: currents}},
+		{MeterParameter: MeterParameter{Name: "舊設定 (max 100)", Unit: "%", Max: floatPtr(100), Compute: computeUnbalanceNEMA, Inputs: currents}},
+		{MeterParameter: MeterParameter{Name: "缺少輸入", Unit: "%", Compute: computeUnbalanceNEMA, Inputs: []string{"L1 電流", "L2 電流", "L4 電流"}}},
+	}
+	compute := func(qualities []string, values ...float64) []MeterReading {
+		readings := make([]MeterReading, len(parameters))
+		for i, param := range parameters {
+			readings[i] = MeterReading{Name: param.Name, Unit: param.Unit}
+			if i < len(values) {
+				readings[i].Value, readings[i].Quality = values[i], qualities[i]
+			}
+		}
+		computePoints(parameters, readings)
+		return readings[len(values):]
+	}
+	expect := func(what string, reading MeterReading, value float64, quality string) {
+		t.Helper()
+		if reading.Quality != quality || (qualityHasValue(quality) && math.Abs(reading.Value-value) > 1e-9) {
+			t.Errorf("%s %s: 預期 %v (%s)，實際 %v (%s)", what, reading.Name, value, quality, reading.Value, reading.Quality)
+		}
+	}
+
+	good := []string{QualityGood, QualityGood, QualityGood}
+	got := compute(good, 10, 0, 0)
+	expect("單相", got[0], 200, QualityGood)
+	expect("單相", got[1], 100, QualityGood)
+	expect("單相", got[2], 200, QualityOutOfRange)
+	expect("單相", got[3], 0, QualityCommError)
+
+	got = compute(good, 460, 467, 450)
+	expect("不平衡", got[0], 9.0/459*100, QualityGood)
+
+	got = compute([]string{QualityGood, QualityStale, QualityGood}, 5, 5, 5)
+	expect("輸入停滯", got[0], 0, QualityStale)
+	got = compute([]string{QualityGood, QualityCommError, QualityGood}, 5, 0, 5)
+	expect("輸入通訊失敗", got[1], 0, QualityCommError)
+}
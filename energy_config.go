@@ -0,0 +1,1101 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"energy-monitoring/internal/webcore"
+	"gopkg.in/yaml.v3"
+)
+
+// 預設設定檔路徑
+const defaultConfigPath = "energy_config.yaml"
+
+// 系統設定
+type Config struct {
+	Database     DatabaseConfig               `yaml:"database"`
+	HTTP         HTTPConfig                   `yaml:"http"`
+	Collection   CollectionConfig             `yaml:"collection"`
+	Meters       []MeterConfig                `yaml:"meters"`
+	RegisterMaps map[string][]MeterParameter  `yaml:"register_maps"`
+	LoadProfiles map[string]LoadProfileConfig `yaml:"load_profiles"`
+	WriteActions map[string][]WriteAction     `yaml:"write_actions"` // 依型號定義的寫入動作 (API 執行，需 admin)
+	Discovery    DiscoveryConfig              `yaml:"discovery"`     // 網段掃描 (discover 命令與 API)
+	Alarms       []AlarmRule                  `yaml:"alarms"`
+	Outputs      OutputsConfig                `yaml:"outputs"`
+	Backup       BackupConfig                 `yaml:"backup"`
+	LabVIEW      LabVIEWConfig                `yaml:"labview"`
+	Web          WebConfig                    `yaml:"web"`
+
+	// 設定檔來源 (不由 YAML 讀取)
+	path string
+}
+
+// 資料庫設定
+type DatabaseConfig struct {
+	Driver    string `yaml:"driver"`    // sqlite (預設) 或 postgres
+	Path      string `yaml:"path"`      // SQLite 資料庫檔案
+	DSN       string `yaml:"dsn"`       // PostgreSQL 連線字串
+	Timescale bool   `yaml:"timescale"` // PostgreSQL 啟用 TimescaleDB hypertable
+
+	JournalMode string        `yaml:"journal_mode"` // SQLite 日誌模式 (預設 WAL)
+	Synchronous string        `yaml:"synchronous"`  // SQLite 同步模式 (WAL 搭配 NORMAL)
+	BusyTimeout time.Duration `yaml:"busy_timeout"` // SQLite 資料庫鎖定時的等待時間
+
+	WriteQueue WriteQueueConfig `yaml:"write_queue"`
+	Spool      SpoolConfig      `yaml:"spool"`
+}
+
+// 暫存區設定：資料庫無法寫入時先寫入磁碟，恢復後依序補寫
+type SpoolConfig struct {
+	Dir   string `yaml:"dir"`    // 暫存目錄，空白表示停用 (無法寫入的資料直接丟棄)
+	MaxMB int    `yaml:"max_mb"` // 暫存上限，超過時刪除最舊的資料 (0 表示不限制)
+}
+
+// 寫入佇列設定：收集器將輪詢結果放入佇列，由背景寫入器分批以交易寫入
+type WriteQueueConfig struct {
+	Size           int           `yaml:"size"`            // 佇列可容納的輪詢筆數
+	BatchSize      int           `yaml:"batch_size"`      // 每個交易最多寫入的輪詢筆數
+	FlushInterval  time.Duration `yaml:"flush_interval"`  // 未滿一批時的寫入間隔
+	EnqueueTimeout time.Duration `yaml:"enqueue_timeout"` // 佇列已滿時收集器等待的時間，逾時丟棄該筆
+}
+
+// 資料庫備份設定 (SQLite)
+type BackupConfig struct {
+	Dir        string        `yaml:"dir"`        // 備份目錄
+	Interval   time.Duration `yaml:"interval"`   // 定期備份間隔，0 表示停用 (仍可用 backup 子命令手動備份)
+	Keep       int           `yaml:"keep"`       // 保留最新的備份數量，0 表示全部保留
+	Passphrase string        `yaml:"passphrase"` // 加密密碼 (AES-256-GCM)，空白表示不加密
+}
+
+// HTTP 服務設定
+type HTTPConfig struct {
+	Listen      string     `yaml:"listen"`
+	OpenBrowser bool       `yaml:"open_browser"`
+	TLS         TLSConfig  `yaml:"tls"`
+	AdminToken  string     `yaml:"admin_token"` // 視為 admin 角色的 Bearer token (自動化腳本，建議改用 API 權杖)
+	Auth        AuthConfig `yaml:"auth"`
+
+	CORSOrigins []string          `yaml:"cors_origins"` // 允許跨來源存取的網站 (例如 https://grafana.example.com)，空白表示只允許同源
+	Headers     map[string]string `yaml:"headers"`      // 額外或覆寫的回應標頭，值為空白表示移除預設標頭
+	AssetsDir   string            `yaml:"assets_dir"`   // 看板網頁覆寫目錄：同名檔案取代內建版本，空白表示只使用內建網頁
+}
+
+// 登入與權限設定 (使用者與 API 權杖存於資料庫，以 user 子命令或 /api/users 管理)
+type AuthConfig struct {
+	AnonymousRole string        `yaml:"anonymous_role"` // 未登入時的角色 (viewer/operator/admin)，空白表示需要登入
+	SessionTTL    time.Duration `yaml:"session_ttl"`    // 登入工作階段閒置多久後失效
+	MaxFailures   int           `yaml:"max_failures"`   // 連續登入失敗次數上限，超過時暫停該帳號登入
+	Lockout       time.Duration `yaml:"lockout"`        // 暫停登入的時間
+}
+
+// TLS 設定 (與看板 Web 伺服器共用)
+type TLSConfig = webcore.TLSConfig
+
+// 資料收集設定
+type CollectionConfig struct {
+	PollInterval  time.Duration `yaml:"poll_interval"`
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxConcurrent int           `yaml:"max_concurrent"` // 同時讀取的電表數量上限
+	Overrun       string        `yaml:"overrun"`        // 讀取逾時處理: skip 或 catch_up
+	MaxCatchUp    int           `yaml:"max_catch_up"`   // catch_up 時最多補讀次數
+
+	StaleAfter         time.Duration `yaml:"stale_after"`          // 數值未變動超過此時間標記為 stale (0 表示停用)
+	SubstituteLastGood bool          `yaml:"substitute_last_good"` // 讀取失敗時以最後正常值替代
+	Identify           bool          `yaml:"identify"`             // 連線後讀取裝置識別 (FC43/14)，記錄廠牌、型號與韌體版本
+
+	Gaps GapConfig `yaml:"gaps"`
+}
+
+// 缺口偵測與補齊設定
+type GapConfig struct {
+	MinGap        time.Duration `yaml:"min_gap"`        // 相鄰取樣間隔超過此時間視為缺口 (0 表示電表輪詢間隔的 3 倍)
+	CheckInterval time.Duration `yaml:"check_interval"` // 自動檢查缺口的間隔 (0 表示停用)
+	Lookback      time.Duration `yaml:"lookback"`       // 自動檢查的時間範圍
+	Backfill      bool          `yaml:"backfill"`       // 自動由電表內部紀錄補齊 (需設定 load_profiles)
+}
+
+// 電表內部負載曲線紀錄 (環狀緩衝，依型號設定)，用於補齊缺口
+// 每筆紀錄的前 2 個暫存器為 Unix 秒時間戳記，數值為 IEEE754 浮點數，字組順序與即時讀值相同 (Word-Swap)
+type LoadProfileConfig struct {
+	CountRegister  uint16             `yaml:"count_register"`  // 已記錄筆數 (u16)
+	LatestRegister uint16             `yaml:"latest_register"` // 最新一筆的索引 (u16)
+	StartRegister  uint16             `yaml:"start_register"`  // 第 0 筆紀錄的位址
+	Capacity       int                `yaml:"capacity"`        // 可容納的筆數
+	RecordSize     int                `yaml:"record_size"`     // 每筆紀錄的暫存器數
+	Fields         []LoadProfileField `yaml:"fields"`
+}
+
+// 負載曲線紀錄中的欄位
+type LoadProfileField struct {
+	Point  string `yaml:"point"`  // 點位名稱 (暫存器對照表中的名稱)
+	Offset int    `yaml:"offset"` // 在紀錄中的暫存器偏移
+}
+
+// 電表寫入動作 (例如清除電能、設定 CT 比)
+// 32 位元數值的字組順序與即時讀值相同 (Word-Swap)
+type WriteAction struct {
+	Name        string        `yaml:"name" json:"name"`
+	Description string        `yaml:"description" json:"description,omitempty"`
+	Function    string        `yaml:"function" json:"function"`     // coil (FC05)、register (FC06) 或 registers (FC16)，預設依 type
+	Address     uint16        `yaml:"address" json:"address"`       // 寫入位址
+	Type        string        `yaml:"type" json:"type"`             // u16 (預設)、i16、u32、i32 或 float32；coil 為 0/1
+	Scale       float64       `yaml:"scale" json:"scale,omitempty"` // 寫入值 = 數值 × scale (預設 1)
+	Value       *float64      `yaml:"value" json:"value,omitempty"` // 固定值 (例如重置命令)；未設定時由請求提供
+	Min         *float64      `yaml:"min" json:"min,omitempty"`     // 請求提供數值時的允許範圍
+	Max         *float64      `yaml:"max" json:"max,omitempty"`
+	Verify      string        `yaml:"verify" json:"verify"`                       // readback (預設，讀回寫入位址比對)、point (讀取點位比對 expect) 或 none
+	VerifyPoint string        `yaml:"verify_point" json:"verify_point,omitempty"` // verify: point 時讀取的點位
+	Expect      float64       `yaml:"expect" json:"expect,omitempty"`             // verify: point 時的預期值
+	Tolerance   float64       `yaml:"tolerance" json:"tolerance,omitempty"`       // verify: point 時的允許誤差
+	VerifyDelay time.Duration `yaml:"verify_delay" json:"-"`                      // 寫入後等待多久再讀回 (預設 0)
+}
+
+// 單一電表設定
+type MeterConfig struct {
+	ID           string        `yaml:"id"`
+	Host         string        `yaml:"host"`
+	Port         int           `yaml:"port"`
+	SlaveID      int           `yaml:"slave_id"`
+	Model        string        `yaml:"model"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	// 點位群組各自的輪詢間隔 (群組名稱對應暫存器對照表的 group 欄位)
+	GroupIntervals map[string]time.Duration `yaml:"group_intervals"`
+}
+
+// 網段掃描設定：預設的連接埠、單元 ID 範圍與比對型號的規則
+type DiscoveryConfig struct {
+	Port        int                       `yaml:"port"`
+	Units       string                    `yaml:"units"`       // 單元 ID 範圍，例如 1-10 或 1,2,5-8
+	Concurrency int                       `yaml:"concurrency"` // 同時掃描的主機數
+	Timeout     time.Duration             `yaml:"timeout"`     // 連線與每次讀取的逾時
+	Models      map[string]DiscoveryModel `yaml:"models"`      // 依型號設定比對規則
+}
+
+// 型號比對規則：裝置識別 (FC43/14) 的廠牌與產品 (不分大小寫，包含即可)，或簽章點位
+type DiscoveryModel struct {
+	Vendor    string   `yaml:"vendor"`    // 比對 vendor_name
+	Product   string   `yaml:"product"`   // 比對 product_code、product_name 或 model_name
+	Signature []string `yaml:"signature"` // 讀取這些點位且都在 min/max 範圍內即視為符合 (預設為設定了 min 與 max 的點位)
+}
+
+// 輸出整合設定
+type OutputsConfig struct {
+	Snapshot SnapshotOutputConfig `yaml:"snapshot"`
+	Influx   InfluxOutputConfig   `yaml:"influx"`
+	Modbus   ModbusGatewayConfig  `yaml:"modbus"`
+}
+
+// final.json 快照輸出設定 (與看板 Web 伺服器共用)
+type SnapshotOutputConfig = webcore.SnapshotOutputConfig
+
+// Modbus TCP 閘道 (從站) 設定：以 Modbus 提供各電表的最新讀值與計算值
+type ModbusGatewayConfig struct {
+	Listen         string                             `yaml:"listen"`          // 監聽位址 (例如 :502)，空白表示停用
+	WordOrder      string                             `yaml:"word_order"`      // 32 位元數值的位元組順序: abcd (預設，高位字組在前)、cdab、badc 或 dcba
+	MaxConnections int                                `yaml:"max_connections"` // 同時連線數上限
+	IdleTimeout    time.Duration                      `yaml:"idle_timeout"`    // 連線閒置多久後中斷 (0 表示不中斷)
+	DemandWindow   time.Duration                      `yaml:"demand_window"`   // 需量 (demand) 的滑動平均時間
+	Units          []ModbusGatewayUnit                `yaml:"units"`
+	Layouts        map[string][]ModbusGatewayRegister `yaml:"layouts"` // 暫存器配置，單元以名稱引用
+}
+
+// 閘道單元：每個單元 ID 對應一個電表
+type ModbusGatewayUnit struct {
+	UnitID    int    `yaml:"unit_id"`
+	Meter     string `yaml:"meter"`
+	Layout    string `yaml:"layout"`     // layouts 中的名稱，空白時依暫存器對照表的順序每個點位佔 2 個暫存器 (float32)
+	WordOrder string `yaml:"word_order"` // 覆寫預設的位元組順序
+}
+
+// 閘道暫存器配置中的一個數值
+type ModbusGatewayRegister struct {
+	Address uint16  `yaml:"address"`
+	Point   string  `yaml:"point"` // 點位名稱 (age 可省略，表示電表最近一次輪詢成功)
+	Value   string  `yaml:"value"` // latest (預設)、demand (滑動平均)、daily_energy (本日用電量，需計數器點位)、quality (品質代碼) 或 age (秒)
+	Type    string  `yaml:"type"`  // float32 (quality、age 預設 u16)、u16、i16、u32 或 i32
+	Scale   float64 `yaml:"scale"` // 輸出 = 數值 × scale (預設 1)，整數類型超出範圍時取上下限
+}
+
+// InfluxDB line protocol 輸出設定
+type InfluxOutputConfig struct {
+	URL           string        `yaml:"url"`   // 寫入網址 (v1 /write?db= 或 v2 /api/v2/write?org=&bucket=)，空白表示不上傳
+	Token         string        `yaml:"token"` // InfluxDB v2 API token
+	Measurement   string        `yaml:"measurement"`
+	BatchSize     int           `yaml:"batch_size"`     // 每批上傳的行數
+	FlushInterval time.Duration `yaml:"flush_interval"` // 未滿一批時的上傳間隔
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxRetries    int           `yaml:"max_retries"`   // 上傳失敗重試次數，仍失敗時寫入暫存目錄
+	BufferDir     string        `yaml:"buffer_dir"`    // 無法上傳時暫存的目錄，恢復連線後依序補傳
+	BufferMaxMB   int           `yaml:"buffer_max_mb"` // 暫存上限，超過時刪除最舊的資料
+	FileDir       string        `yaml:"file_dir"`      // 另存 .lp 檔 (每小時一個檔案，離線轉移用)，空白表示不寫
+}
+
+// labview 與 web 區段由看板 Web 伺服器 (main.go) 使用，設定型別、預設值、驗證與環境變數覆寫與其共用
+type (
+	LabVIEWConfig = webcore.LabVIEWConfig
+	WebConfig     = webcore.WebConfig
+	WebUser       = webcore.WebUser
+)
+
+// 設定驗證錯誤
+type (
+	ConfigError  = webcore.ConfigError
+	ConfigErrors = webcore.ConfigErrors
+	addFunc      = webcore.AddFunc
+)
+
+// 內建的電表暫存器對照表 (依型號)
+var builtinRegisterMaps = map[string][]MeterParameter{
+	"DPMC530E": meterParameters,
+}
+
+// 預設設定 (與原本寫死的值相同)
+func DefaultConfig() *Config {
+	return &Config{
+		Database: DatabaseConfig{
+			Driver:      "sqlite",
+			Path:        "./energy_data.db",
+			JournalMode: "WAL",
+			Synchronous: "NORMAL",
+			BusyTimeout: 5 * time.Second,
+			WriteQueue: WriteQueueConfig{
+				Size:           1000,
+				BatchSize:      200,
+				FlushInterval:  500 * time.Millisecond,
+				EnqueueTimeout: 5 * time.Second,
+			},
+			Spool: SpoolConfig{Dir: "./spool/database", MaxMB: 500},
+		},
+		HTTP: HTTPConfig{
+			Listen:      ":8080",
+			OpenBrowser: true,
+			TLS:         TLSConfig{CertFile: "./tls/cert.pem", KeyFile: "./tls/key.pem"},
+			Auth: AuthConfig{
+				SessionTTL:  12 * time.Hour,
+				MaxFailures: 5,
+				Lockout:     5 * time.Minute,
+			},
+		},
+		Collection: CollectionConfig{
+			PollInterval:  5 * time.Second,
+			Timeout:       10 * time.Second,
+			MaxConcurrent: 4,
+			Overrun:       "skip",
+			MaxCatchUp:    3,
+			Identify:      true,
+			Gaps:          GapConfig{CheckInterval: 10 * time.Minute, Lookback: 24 * time.Hour, Backfill: true},
+		},
+		Meters: []MeterConfig{
+			{ID: "DPMC530E", Host: "192.168.1.9", Port: 502, SlaveID: 2, Model: "DPMC530E"},
+		},
+		Discovery: DiscoveryConfig{
+			Port:        502,
+			Units:       "1-10",
+			Concurrency: 32,
+			Timeout:     500 * time.Millisecond,
+			Models:      map[string]DiscoveryModel{"DPMC530E": {Vendor: "Delta", Product: "C530"}},
+		},
+		Outputs: OutputsConfig{
+			Snapshot: webcore.DefaultSnapshotOutputConfig(),
+			Influx: InfluxOutputConfig{
+				Measurement:   "energy",
+				BatchSize:     500,
+				FlushInterval: 10 * time.Second,
+				Timeout:       10 * time.Second,
+				MaxRetries:    3,
+				BufferDir:     "./spool/influx",
+				BufferMaxMB:   100,
+			},
+			Modbus: ModbusGatewayConfig{
+				WordOrder:      "abcd",
+				MaxConnections: 8,
+				IdleTimeout:    5 * time.Minute,
+				DemandWindow:   15 * time.Minute,
+			},
+		},
+		Backup:  BackupConfig{Dir: "./backups", Keep: 7},
+		LabVIEW: webcore.DefaultLabVIEWConfig(),
+		Web:     webcore.DefaultWebConfig(),
+	}
+}
+
+// 讀取設定檔；檔案不存在且未明確指定時使用預設值
+func LoadConfig(path string, required bool) (*Config, error) {
+	cfg := DefaultConfig()
+	cfg.path = path
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			cfg.path = ""
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("無法讀取設定檔 %s: %v", path, err)
+	}
+
+	if err := decodeConfig(content, cfg); err != nil {
+		return nil, fmt.Errorf("設定檔 %s 格式錯誤: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// 解析 YAML 內容，不允許未知欄位
+func decodeConfig(content []byte, cfg *Config) error {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return err
+	}
+
+	cfg.Outputs.Snapshot.Normalize()
+
+	// 未指定的電表欄位使用預設值
+	for i := range cfg.Meters {
+		if cfg.Meters[i].Port == 0 {
+			cfg.Meters[i].Port = 502
+		}
+		if cfg.Meters[i].Model == "" {
+			cfg.Meters[i].Model = "DPMC530E"
+		}
+	}
+
+	return nil
+}
+
+// 套用環境變數覆寫
+func (cfg *Config) ApplyEnv(getenv func(string) string) error {
+	if v := getenv("ENERGY_DB_DRIVER"); v != "" {
+		cfg.Database.Driver = v
+	}
+	if v := getenv("ENERGY_DB_PATH"); v != "" {
+		cfg.Database.Path = v
+	}
+	if v := getenv("ENERGY_DB_DSN"); v != "" {
+		cfg.Database.DSN = v
+	}
+	if v := getenv("ENERGY_HTTP_LISTEN"); v != "" {
+		cfg.HTTP.Listen = v
+	}
+	if v := getenv("ENERGY_TLS_CERT"); v != "" {
+		cfg.HTTP.TLS.CertFile = v
+		cfg.HTTP.TLS.Enabled = true
+	}
+	if v := getenv("ENERGY_TLS_KEY"); v != "" {
+		cfg.HTTP.TLS.KeyFile = v
+		cfg.HTTP.TLS.Enabled = true
+	}
+	if v := getenv("ENERGY_CORS_ORIGINS"); v != "" {
+		cfg.HTTP.CORSOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			cfg.HTTP.CORSOrigins = append(cfg.HTTP.CORSOrigins, strings.TrimSpace(origin))
+		}
+	}
+	if v := getenv("ENERGY_ADMIN_TOKEN"); v != "" {
+		cfg.HTTP.AdminToken = v
+	}
+	if v := getenv("ENERGY_ANONYMOUS_ROLE"); v != "" {
+		cfg.HTTP.Auth.AnonymousRole = v
+	}
+	if v := getenv("ENERGY_INFLUX_URL"); v != "" {
+		cfg.Outputs.Influx.URL = v
+	}
+	if v := getenv("ENERGY_INFLUX_TOKEN"); v != "" {
+		cfg.Outputs.Influx.Token = v
+	}
+	if v := getenv("ENERGY_MODBUS_LISTEN"); v != "" {
+		cfg.Outputs.Modbus.Listen = v
+	}
+	if v := getenv("ENERGY_BACKUP_PASSPHRASE"); v != "" {
+		cfg.Backup.Passphrase = v
+	}
+	if v := getenv("ENERGY_OPEN_BROWSER"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("ENERGY_OPEN_BROWSER: 無效的布林值 %q", v)
+		}
+		cfg.HTTP.OpenBrowser = enabled
+	}
+	if v := getenv("ENERGY_POLL_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("ENERGY_POLL_INTERVAL: 無效的時間間隔 %q", v)
+		}
+		cfg.Collection.PollInterval = interval
+	}
+
+	if err := webcore.ApplyWebEnv(&cfg.LabVIEW, &cfg.Web, getenv); err != nil {
+		return err
+	}
+
+	// 個別電表: ENERGY_METER_<ID>_HOST / _PORT / _SLAVE_ID
+	for i := range cfg.Meters {
+		meter := &cfg.Meters[i]
+		prefix := "ENERGY_METER_" + envKey(meter.ID) + "_"
+		if v := getenv(prefix + "HOST"); v != "" {
+			meter.Host = v
+		}
+		if v := getenv(prefix + "PORT"); v != "" {
+			port, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%sPORT: 無效的連接埠 %q", prefix, v)
+			}
+			meter.Port = port
+		}
+		if v := getenv(prefix + "SLAVE_ID"); v != "" {
+			slaveID, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%sSLAVE_ID: 無效的通訊位址 %q", prefix, v)
+			}
+			meter.SlaveID = slaveID
+		}
+	}
+
+	return nil
+}
+
+// 將電表 ID 轉為環境變數名稱片段
+func envKey(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, id)
+}
+
+// 命令列參數 (只有明確指定時才覆寫)
+type configFlags struct {
+	path         string
+	dbPath       string
+	listen       string
+	pollInterval time.Duration
+	noBrowser    bool
+}
+
+// 註冊共用的命令列參數
+func registerConfigFlags(fs *flag.FlagSet) *configFlags {
+	flags := &configFlags{}
+	fs.StringVar(&flags.path, "config", "", "設定檔路徑 (預設 "+defaultConfigPath+"，可用 ENERGY_CONFIG 指定)")
+	fs.StringVar(&flags.dbPath, "db", "", "資料庫檔案路徑")
+	fs.StringVar(&flags.listen, "listen", "", "HTTP 監聽位址 (例如 :8080)")
+	fs.DurationVar(&flags.pollInterval, "poll-interval", 0, "預設輪詢間隔 (例如 5s)")
+	fs.BoolVar(&flags.noBrowser, "no-browser", false, "啟動後不開啟瀏覽器")
+	return flags
+}
+
+// 依優先順序載入設定: 設定檔 < 環境變數 < 命令列參數
+func (flags *configFlags) load() (*Config, error) {
+	path := flags.path
+	required := path != ""
+	if path == "" {
+		path = os.Getenv("ENERGY_CONFIG")
+		required = path != ""
+	}
+	if path == "" {
+		path = defaultConfigPath
+	}
+
+	cfg, err := LoadConfig(path, required)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.ApplyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+
+	if flags.dbPath != "" {
+		cfg.Database.Path = flags.dbPath
+	}
+	if flags.listen != "" {
+		cfg.HTTP.Listen = flags.listen
+	}
+	if flags.pollInterval != 0 {
+		cfg.Collection.PollInterval = flags.pollInterval
+	}
+	if flags.noBrowser {
+		cfg.HTTP.OpenBrowser = false
+	}
+
+	return cfg, nil
+}
+
+// 取得電表實際使用的輪詢間隔
+func (cfg *Config) MeterPollInterval(meter MeterConfig) time.Duration {
+	if meter.PollInterval > 0 {
+		return meter.PollInterval
+	}
+	return cfg.Collection.PollInterval
+}
+
+// 依 ID 取得電表設定
+func (cfg *Config) Meter(id string) (MeterConfig, bool) {
+	for _, meter := range cfg.Meters {
+		if meter.ID == id {
+			return meter, true
+		}
+	}
+	return MeterConfig{}, false
+}
+
+// 取得電表型號對應的暫存器對照表
+// 同名參數會加上序號區分 (例如 "電流諧波失真率 (2)")，確保點位名稱唯一
+func (cfg *Config) RegisterMap(model string) ([]MeterParameter, bool) {
+	params, ok := cfg.RegisterMaps[model]
+	if !ok {
+		params, ok = builtinRegisterMaps[model]
+	}
+	if !ok {
+		return nil, false
+	}
+
+	result := make([]MeterParameter, len(params))
+	counts := make(map[string]int)
+	for i, param := range params {
+		counts[param.Name]++
+		if counts[param.Name] > 1 {
+			param.Name = fmt.Sprintf("%s (%d)", param.Name, counts[param.Name])
+		}
+		result[i] = param
+	}
+	return result, true
+}
+
+// 暫存器對照表中是否有指定名稱的點位
+func hasParameter(params []MeterParameter, name string) bool {
+	_, ok := findParameter(params, name)
+	return ok
+}
+
+func findParameter(params []MeterParameter, name string) (MeterParameter, bool) {
+	for _, param := range params {
+		if param.Name == name {
+			return param, true
+		}
+	}
+	return MeterParameter{}, false
+}
+
+// 驗證設定內容，回傳所有錯誤
+func (cfg *Config) Validate() []ConfigError {
+	var errs []ConfigError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ConfigError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch cfg.Database.Driver {
+	case "", "sqlite":
+		if strings.TrimSpace(cfg.Database.Path) == "" {
+			add("database.path", "不可為空")
+		}
+	case "postgres":
+		if strings.TrimSpace(cfg.Database.DSN) == "" {
+			add("database.dsn", "使用 postgres 時必須指定")
+		}
+	default:
+		add("database.driver", "不支援的資料庫類型 %q (可用: sqlite, postgres)", cfg.Database.Driver)
+	}
+	switch strings.ToUpper(cfg.Database.JournalMode) {
+	case "", "WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF":
+	default:
+		add("database.journal_mode", "不支援的日誌模式 %q (可用: WAL, DELETE, TRUNCATE, PERSIST, MEMORY, OFF)", cfg.Database.JournalMode)
+	}
+	switch strings.ToUpper(cfg.Database.Synchronous) {
+	case "", "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		add("database.synchronous", "不支援的同步模式 %q (可用: OFF, NORMAL, FULL, EXTRA)", cfg.Database.Synchronous)
+	}
+	if cfg.Database.BusyTimeout < 0 {
+		add("database.busy_timeout", "不可為負數")
+	}
+	queue := cfg.Database.WriteQueue
+	if queue.Size < 1 {
+		add("database.write_queue.size", "必須大於 0")
+	}
+	if queue.BatchSize < 1 || queue.BatchSize > queue.Size {
+		add("database.write_queue.batch_size", "必須介於 1 到 size (%d)", queue.Size)
+	}
+	if queue.FlushInterval <= 0 {
+		add("database.write_queue.flush_interval", "必須大於 0")
+	}
+	if queue.EnqueueTimeout < 0 {
+		add("database.write_queue.enqueue_timeout", "不可為負數")
+	}
+	if cfg.Database.Spool.MaxMB < 0 {
+		add("database.spool.max_mb", "不可為負數")
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.HTTP.Listen); err != nil {
+		add("http.listen", "無效的監聽位址 %q: %v", cfg.HTTP.Listen, err)
+	}
+	webcore.ValidateTLS("http.tls", cfg.HTTP.TLS, cfg.HTTP.Listen, add)
+	webcore.ValidateCORSOrigins("http.cors_origins", cfg.HTTP.CORSOrigins, add)
+	webcore.ValidateHeaders("http.headers", cfg.HTTP.Headers, add)
+	webcore.ValidateAssetsDir("http.assets_dir", cfg.HTTP.AssetsDir, add)
+
+	if cfg.HTTP.Auth.AnonymousRole != "" && !validRole(cfg.HTTP.Auth.AnonymousRole) {
+		add("http.auth.anonymous_role", "必須為 viewer、operator 或 admin (目前 %q)", cfg.HTTP.Auth.AnonymousRole)
+	}
+	if cfg.HTTP.Auth.SessionTTL < time.Minute {
+		add("http.auth.session_ttl", "必須至少 1m (目前 %v)", cfg.HTTP.Auth.SessionTTL)
+	}
+	if cfg.HTTP.Auth.MaxFailures < 0 {
+		add("http.auth.max_failures", "不可為負數")
+	}
+	if cfg.HTTP.Auth.MaxFailures > 0 && cfg.HTTP.Auth.Lockout <= 0 {
+		add("http.auth.lockout", "設定 max_failures 時必須大於 0")
+	}
+
+	if cfg.Collection.PollInterval < time.Second {
+		add("collection.poll_interval", "必須至少 1s (目前 %v)", cfg.Collection.PollInterval)
+	}
+	if cfg.Collection.Timeout <= 0 {
+		add("collection.timeout", "必須大於 0 (目前 %v)", cfg.Collection.Timeout)
+	}
+	if cfg.Collection.MaxConcurrent < 1 {
+		add("collection.max_concurrent", "必須至少 1 (目前 %d)", cfg.Collection.MaxConcurrent)
+	}
+	if cfg.Collection.Overrun != "skip" && cfg.Collection.Overrun != "catch_up" {
+		add("collection.overrun", "必須為 skip 或 catch_up (目前 %q)", cfg.Collection.Overrun)
+	}
+	if cfg.Collection.StaleAfter < 0 {
+		add("collection.stale_after", "不可為負數 (目前 %v)", cfg.Collection.StaleAfter)
+	}
+	if cfg.Collection.MaxCatchUp < 0 {
+		add("collection.max_catch_up", "不可為負數 (目前 %d)", cfg.Collection.MaxCatchUp)
+	}
+	gaps := cfg.Collection.Gaps
+	if gaps.MinGap < 0 {
+		add("collection.gaps.min_gap", "不可為負數 (目前 %v)", gaps.MinGap)
+	}
+	if gaps.CheckInterval != 0 && gaps.CheckInterval < time.Minute {
+		add("collection.gaps.check_interval", "必須至少 1m 或設為 0 停用 (目前 %v)", gaps.CheckInterval)
+	}
+	if gaps.CheckInterval != 0 && gaps.Lookback <= 0 {
+		add("collection.gaps.lookback", "必須大於 0 (目前 %v)", gaps.Lookback)
+	}
+
+	if len(cfg.Meters) == 0 {
+		add("meters", "至少需要設定一個電表")
+	}
+	seen := make(map[string]int)
+	for i, meter := range cfg.Meters {
+		field := fmt.Sprintf("meters[%d]", i)
+		if meter.ID == "" {
+			add(field+".id", "不可為空")
+		} else if first, ok := seen[meter.ID]; ok {
+			add(field+".id", "與 meters[%d] 重複: %q", first, meter.ID)
+		} else {
+			seen[meter.ID] = i
+		}
+		if meter.Host == "" {
+			add(field+".host", "不可為空")
+		}
+		if meter.Port < 1 || meter.Port > 65535 {
+			add(field+".port", "必須介於 1 到 65535 (目前 %d)", meter.Port)
+		}
+		if meter.SlaveID < 1 || meter.SlaveID > 247 {
+			add(field+".slave_id", "必須介於 1 到 247 (目前 %d)", meter.SlaveID)
+		}
+		if _, ok := cfg.RegisterMap(meter.Model); !ok {
+			add(field+".model", "未知的電表型號 %q (可用: %s)", meter.Model, strings.Join(cfg.modelNames(), ", "))
+		}
+		if meter.PollInterval != 0 && meter.PollInterval < time.Second {
+			add(field+".poll_interval", "必須至少 1s (目前 %v)", meter.PollInterval)
+		}
+		for group, interval := range meter.GroupIntervals {
+			if interval < time.Second {
+				add(fmt.Sprintf("%s.group_intervals.%s", field, group), "必須至少 1s (目前 %v)", interval)
+			}
+		}
+	}
+
+	for _, model := range sortedKeys(cfg.RegisterMaps) {
+		params := cfg.RegisterMaps[model]
+		if len(params) == 0 {
+			add("register_maps."+model, "至少需要一個參數")
+		}
+		resolved, _ := cfg.RegisterMap(model)
+		for i, param := range params {
+			field := fmt.Sprintf("register_maps.%s[%d]", model, i)
+			if param.Name == "" {
+				add(field+".name", "不可為空")
+			}
+			if param.computed() {
+				validateComputedPoint(field, param, resolved, add)
+			} else {
+				validatePointDecoding(field, param, add)
+			}
+		}
+	}
+
+	for _, model := range sortedKeys(cfg.LoadProfiles) {
+		profile := cfg.LoadProfiles[model]
+		field := "load_profiles." + model
+		params, ok := cfg.RegisterMap(model)
+		if !ok {
+			add(field, "未知的電表型號 %q (可用: %s)", model, strings.Join(cfg.modelNames(), ", "))
+		}
+		if profile.Capacity < 1 {
+			add(field+".capacity", "必須至少 1 (目前 %d)", profile.Capacity)
+		}
+		if profile.RecordSize < 4 || profile.RecordSize > 125 {
+			add(field+".record_size", "必須介於 4 到 125 (目前 %d)", profile.RecordSize)
+		} else if int(profile.StartRegister)+profile.Capacity*profile.RecordSize > 0x10000 {
+			add(field+".capacity", "紀錄範圍超出暫存器位址 (start_register + capacity × record_size 不可超過 65536)")
+		}
+		if len(profile.Fields) == 0 {
+			add(field+".fields", "至少需要一個欄位")
+		}
+		for i, f := range profile.Fields {
+			if f.Offset < 2 || f.Offset+2 > profile.RecordSize {
+				add(fmt.Sprintf("%s.fields[%d].offset", field, i), "必須介於 2 到 record_size-2 (目前 %d)", f.Offset)
+			}
+			if param, found := findParameter(params, f.Point); ok && !found {
+				add(fmt.Sprintf("%s.fields[%d].point", field, i), "暫存器對照表中沒有點位 %q", f.Point)
+			} else if param.computed() {
+				add(fmt.Sprintf("%s.fields[%d].point", field, i), "不可使用計算點位 %q", f.Point)
+			}
+		}
+	}
+
+	for _, model := range sortedKeys(cfg.WriteActions) {
+		params, ok := cfg.RegisterMap(model)
+		if !ok {
+			add("write_actions."+model, "未知的電表型號 %q (可用: %s)", model, strings.Join(cfg.modelNames(), ", "))
+		}
+		names := make(map[string]int)
+		for i, action := range cfg.WriteActions[model] {
+			validateWriteAction(fmt.Sprintf("write_actions.%s[%d]", model, i), action, params, ok, names, i, add)
+		}
+	}
+
+	rules := make(map[string]int)
+	for i, rule := range cfg.Alarms {
+		field := fmt.Sprintf("alarms[%d]", i)
+		if rule.ID == "" {
+			add(field+".id", "不可為空")
+		} else if first, ok := rules[rule.ID]; ok {
+			add(field+".id", "與 alarms[%d] 重複: %q", first, rule.ID)
+		} else {
+			rules[rule.ID] = i
+		}
+		if rule.Meter != "" {
+			if _, ok := seen[rule.Meter]; !ok {
+				add(field+".meter", "找不到電表 %q", rule.Meter)
+			}
+		}
+		if rule.Parameter == "" {
+			add(field+".parameter", "不可為空")
+		}
+		if rule.High == nil && rule.Low == nil {
+			add(field, "至少需要設定 high 或 low")
+		}
+		if rule.High != nil && rule.Low != nil && *rule.Low >= *rule.High {
+			add(field+".low", "必須小於 high (%v >= %v)", *rule.Low, *rule.High)
+		}
+		if rule.Deadband < 0 {
+			add(field+".deadband", "不可為負數 (目前 %v)", rule.Deadband)
+		}
+	}
+
+	cfg.Outputs.Snapshot.Validate(add)
+
+	influx := cfg.Outputs.Influx
+	if influx.URL != "" {
+		if u, err := url.Parse(influx.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("outputs.influx.url", "無效的網址 %q", influx.URL)
+		}
+		if influx.BufferDir == "" {
+			add("outputs.influx.buffer_dir", "不可為空")
+		}
+	}
+	if influx.URL != "" || influx.FileDir != "" {
+		if influx.Measurement == "" {
+			add("outputs.influx.measurement", "不可為空")
+		}
+		if influx.BatchSize < 1 {
+			add("outputs.influx.batch_size", "必須至少 1 (目前 %d)", influx.BatchSize)
+		}
+		if influx.FlushInterval < 100*time.Millisecond {
+			add("outputs.influx.flush_interval", "必須至少 100ms (目前 %v)", influx.FlushInterval)
+		}
+		if influx.Timeout <= 0 {
+			add("outputs.influx.timeout", "必須大於 0 (目前 %v)", influx.Timeout)
+		}
+		if influx.MaxRetries < 0 {
+			add("outputs.influx.max_retries", "不可為負數 (目前 %d)", influx.MaxRetries)
+		}
+		if influx.BufferMaxMB < 0 {
+			add("outputs.influx.buffer_max_mb", "不可為負數 (目前 %d)", influx.BufferMaxMB)
+		}
+	}
+
+	cfg.validateModbusGateway(add)
+	cfg.validateDiscovery(add)
+
+	backup := cfg.Backup
+	if backup.Interval != 0 {
+		if backup.Interval < time.Minute {
+			add("backup.interval", "必須至少 1m 或設為 0 停用 (目前 %v)", backup.Interval)
+		}
+		if cfg.Database.Driver == "postgres" {
+			add("backup.interval", "僅支援 SQLite，PostgreSQL 請使用 pg_dump")
+		}
+	}
+	if backup.Dir == "" {
+		add("backup.dir", "不可為空")
+	}
+	if backup.Keep < 0 {
+		add("backup.keep", "不可為負數 (目前 %d)", backup.Keep)
+	}
+
+	cfg.LabVIEW.Validate(add)
+	cfg.Web.Validate(add)
+
+	return errs
+}
+
+// 檢查單一寫入動作 (names 記錄同型號已使用的名稱)
+func validateWriteAction(field string, action WriteAction, params []MeterParameter, knownModel bool, names map[string]int, index int, add addFunc) {
+	if action.Name == "" {
+		add(field+".name", "不可為空")
+	} else if first, ok := names[action.Name]; ok {
+		add(field+".name", "與第 %d 個動作重複: %q", first, action.Name)
+	} else {
+		names[action.Name] = index
+	}
+
+	width := writeTypeRegisters[action.Type]
+	if width == 0 {
+		add(field+".type", "不支援的類型 %q (可用: u16、i16、u32、i32、float32)", action.Type)
+	}
+	switch action.Function {
+	case "", "registers":
+	case "register":
+		if width == 2 {
+			add(field+".function", "32 位元數值需使用 registers (FC16)")
+		}
+	case "coil":
+		if action.Type != "" {
+			add(field+".type", "coil 不需設定類型")
+		}
+	default:
+		add(field+".function", "必須為 coil、register 或 registers (目前 %q)", action.Function)
+	}
+	if action.Scale < 0 {
+		add(field+".scale", "不可為負數")
+	}
+
+	if action.Value == nil && (action.Min == nil || action.Max == nil) {
+		add(field, "未設定固定值 value 時必須設定 min 與 max")
+	}
+	if action.Min != nil && action.Max != nil && *action.Min > *action.Max {
+		add(field+".min", "不可大於 max")
+	}
+	if action.Value != nil {
+		if _, err := action.encode(*action.Value); err != nil {
+			add(field+".value", "%v", err)
+		}
+	}
+
+	switch action.Verify {
+	case "", "readback", "none":
+	case "point":
+		if action.VerifyPoint == "" {
+			add(field+".verify_point", "verify: point 時不可為空")
+		} else if param, ok := findParameter(params, action.VerifyPoint); knownModel && !ok {
+			add(field+".verify_point", "暫存器對照表中沒有點位 %q", action.VerifyPoint)
+		} else if param.computed() {
+			add(field+".verify_point", "計算點位 %q 無法由電表讀回", action.VerifyPoint)
+		}
+	default:
+		add(field+".verify", "必須為 readback、point 或 none (目前 %q)", action.Verify)
+	}
+	if action.VerifyDelay < 0 || action.VerifyDelay > 10*time.Second {
+		add(field+".verify_delay", "必須介於 0 到 10s (目前 %v)", action.VerifyDelay)
+	}
+}
+
+// 檢查 Modbus 閘道設定：單元對應的電表與配置、配置中的點位與位址重疊
+func (cfg *Config) validateModbusGateway(add addFunc) {
+	gw := cfg.Outputs.Modbus
+	if gw.Listen == "" {
+		return
+	}
+	if _, _, err := net.SplitHostPort(gw.Listen); err != nil {
+		add("outputs.modbus.listen", "格式錯誤 (例如 :502): %v", err)
+	}
+	if !isWordOrder(gw.WordOrder) {
+		add("outputs.modbus.word_order", "必須為 abcd、cdab、badc 或 dcba (目前 %q)", gw.WordOrder)
+	}
+	if gw.MaxConnections < 1 {
+		add("outputs.modbus.max_connections", "必須至少 1 (目前 %d)", gw.MaxConnections)
+	}
+	if gw.IdleTimeout < 0 {
+		add("outputs.modbus.idle_timeout", "不可為負數 (目前 %v)", gw.IdleTimeout)
+	}
+	if gw.DemandWindow < time.Minute {
+		add("outputs.modbus.demand_window", "必須至少 1m (目前 %v)", gw.DemandWindow)
+	}
+	if len(gw.Units) == 0 {
+		add("outputs.modbus.units", "至少需要設定一個單元")
+	}
+
+	for _, name := range sortedKeys(gw.Layouts) {
+		field := "outputs.modbus.layouts." + name
+		registers := gw.Layouts[name]
+		if len(registers) == 0 {
+			add(field, "至少需要一個暫存器")
+		}
+		for i, reg := range registers {
+			regField := fmt.Sprintf("%s[%d]", field, i)
+			reg = reg.normalized()
+			width := gatewayTypeRegisters[reg.Type]
+			if width == 0 {
+				add(regField+".type", "不支援的類型 %q (可用: float32、u16、i16、u32、i32)", reg.Type)
+			} else if int(reg.Address)+width > 0x10000 {
+				add(regField+".address", "超出暫存器位址範圍")
+			}
+			if !isGatewayValue(reg.Value) {
+				add(regField+".value", "必須為 latest、demand、daily_energy、quality 或 age (目前 %q)", reg.Value)
+			}
+			if reg.Point == "" && reg.Value != gatewayValueAge {
+				add(regField+".point", "不可為空")
+			}
+			if reg.Scale < 0 {
+				add(regField+".scale", "不可為負數")
+			}
+			for j := 0; j < i; j++ {
+				other := registers[j].normalized()
+				if width > 0 && gatewayRegistersOverlap(reg, other) {
+					add(regField+".address", "與 %s[%d] 的暫存器重疊 (位址 %d)", field, j, reg.Address)
+					break
+				}
+			}
+		}
+	}
+
+	seen := make(map[int]int)
+	for i, unit := range gw.Units {
+		field := fmt.Sprintf("outputs.modbus.units[%d]", i)
+		if unit.UnitID < 1 || unit.UnitID > 247 {
+			add(field+".unit_id", "必須介於 1 到 247 (目前 %d)", unit.UnitID)
+		} else if first, ok := seen[unit.UnitID]; ok {
+			add(field+".unit_id", "與 units[%d] 重複: %d", first, unit.UnitID)
+		} else {
+			seen[unit.UnitID] = i
+		}
+		if unit.WordOrder != "" && !isWordOrder(unit.WordOrder) {
+			add(field+".word_order", "必須為 abcd、cdab、badc 或 dcba (目前 %q)", unit.WordOrder)
+		}
+		meter, ok := cfg.Meter(unit.Meter)
+		if !ok {
+			add(field+".meter", "找不到電表 %q", unit.Meter)
+		}
+		registers, found := gw.Layouts[unit.Layout]
+		if unit.Layout != "" && !found {
+			add(field+".layout", "找不到配置 %q", unit.Layout)
+		}
+		params, known := cfg.RegisterMap(meter.Model)
+		if !ok || !found || !known {
+			continue
+		}
+		// 配置引用的點位必須存在於該電表的暫存器對照表
+		for j, reg := range registers {
+			if reg.Point == "" {
+				continue
+			}
+			param, exists := findParameter(params, reg.Point)
+			if !exists {
+				add(field+".layout", "電表 %s 的暫存器對照表中沒有點位 %q (layouts.%s[%d])", meter.ID, reg.Point, unit.Layout, j)
+			} else if reg.normalized().Value == gatewayValueDailyEnergy && !param.Counter {
+				add(field+".layout", "daily_energy 需要計數器點位 (counter: true)，%q 不是 (layouts.%s[%d])", reg.Point, unit.Layout, j)
+			}
+		}
+	}
+}
+
+// 所有可用的電表型號
+func (cfg *Config) modelNames() []string {
+	names := sortedKeys(builtinRegisterMaps)
+	for _, name := range sortedKeys(cfg.RegisterMaps) {
+		if _, ok := builtinRegisterMaps[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// config 子命令
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "用法: energy_system config validate [-config 路徑]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	errs := cfg.Validate()
+	if cfg.path != "" {
+		if content, err := ioutil.ReadFile(cfg.path); err == nil {
+			errs = webcore.AnnotateConfigErrors(content, errs)
+		}
+	}
+
+	source := cfg.path
+	if source == "" {
+		source = "(預設值)"
+	}
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", source, e)
+		}
+		fmt.Fprintf(os.Stderr, "設定檔驗證失敗，共 %d 個錯誤\n", len(errs))
+		return 1
+	}
+
+	fmt.Printf("✅ %s: 設定檔驗證通過 (%d 個電表)\n", source, len(cfg.Meters))
+	return 0
+}
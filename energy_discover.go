@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"gopkg.in/yaml.v3"
+)
+
+// 網段掃描：在 CIDR 範圍內尋找開啟 Modbus TCP 連接埠的主機，依序嘗試各單元 ID，
+// 以裝置識別 (FC43/14) 比對 discovery.models 的廠牌與產品，沒有識別或比對不到時改讀各型號的簽章點位，
+// 最後產生建議的 meters 設定 (已設定的電表只標記不重複建議)
+
+// 單次掃描的主機數上限 (/20)
+const discoverMaxHosts = 4096
+
+// 比對方式
+const (
+	matchedByIdentification = "identification"
+	matchedBySignature      = "signature"
+)
+
+// 掃描條件，未指定的欄位使用 discovery 設定
+type DiscoverRequest struct {
+	CIDR        string `json:"cidr"`                  // 例如 192.168.1.0/24，單一位址表示 /32
+	Port        int    `json:"port,omitempty"`        // Modbus TCP 連接埠
+	Units       string `json:"units,omitempty"`       // 單元 ID 範圍，例如 1-10 或 1,2,5-8
+	Concurrency int    `json:"concurrency,omitempty"` // 同時掃描的主機數
+	TimeoutMs   int64  `json:"timeout_ms,omitempty"`  // 連線與每次讀取的逾時
+}
+
+// 建議的電表設定
+type ProposedMeter struct {
+	ID      string `json:"id" yaml:"id"`
+	Host    string `json:"host" yaml:"host"`
+	Port    int    `json:"port" yaml:"port"`
+	SlaveID int    `json:"slave_id" yaml:"slave_id"`
+	Model   string `json:"model" yaml:"model"`
+}
+
+// 找到的裝置
+type DiscoveredMeter struct {
+	Host       string                `json:"host"`
+	Port       int                   `json:"port"`
+	SlaveID    int                   `json:"slave_id"`
+	Device     *DeviceIdentification `json:"device,omitempty"`     // 裝置識別 (不支援時省略)
+	Models     []string              `json:"models"`               // 符合的型號，沒有符合時為空
+	MatchedBy  string                `json:"matched_by,omitempty"` // identification 或 signature
+	Configured string                `json:"configured,omitempty"` // 已設定的電表 ID
+	Proposed   *ProposedMeter        `json:"proposed,omitempty"`   // 建議的設定 (未設定且有符合型號時)
+}
+
+type DiscoverResult struct {
+	CIDR       string            `json:"cidr"`
+	Port       int               `json:"port"`
+	Units      string            `json:"units"`
+	Hosts      int               `json:"hosts"`      // 掃描的主機數
+	OpenHosts  int               `json:"open_hosts"` // 連接埠開啟的主機數
+	Meters     []DiscoveredMeter `json:"meters"`
+	Config     string            `json:"config"` // 建議的 meters 設定 (YAML)，沒有建議時為空白
+	DurationMs int64             `json:"duration_ms"`
+}
+
+// 掃描時使用的型號規則
+type discoverModel struct {
+	name      string
+	rule      DiscoveryModel
+	signature []MeterParameter
+}
+
+type discoverOptions struct {
+	hosts       []string
+	port        int
+	units       []int
+	concurrency int
+	timeout     time.Duration
+	models      []discoverModel
+}
+
+// 解析單元 ID 範圍 (1~247)，例如 1-10 或 1,2,5-8
+func parseUnitIDs(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var units []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("無效的單元 ID %q", part)
+		}
+		to, err := strconv.Atoi(hi)
+		if err != nil {
+			return nil, fmt.Errorf("無效的單元 ID %q", part)
+		}
+		if from < 1 || to > 247 || from > to {
+			return nil, fmt.Errorf("單元 ID 必須介於 1 到 247 (目前 %q)", part)
+		}
+		for unit := from; unit <= to; unit++ {
+			if !seen[unit] {
+				seen[unit] = true
+				units = append(units, unit)
+			}
+		}
+	}
+	if len(units) == 0 {
+		return nil, fmt.Errorf("沒有指定單元 ID")
+	}
+	sort.Ints(units)
+	return units, nil
+}
+
+// 展開 CIDR 範圍內的 IPv4 主機 (/31 以上排除網路與廣播位址)
+func expandCIDR(cidr string) ([]string, error) {
+	if !strings.Contains(cidr, "/") {
+		cidr += "/32"
+	}
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("無效的 CIDR %q", cidr)
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("只支援 IPv4 (目前 %q)", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 12 {
+		return nil, fmt.Errorf("範圍太大 (/%d)，最多 %d 個主機 (/20)", ones, discoverMaxHosts)
+	}
+
+	base := binary.BigEndian.Uint32(network.IP.To4())
+	count := uint32(1) << uint(bits-ones)
+	first, last := uint32(0), count-1
+	if count > 2 {
+		first, last = 1, count-2
+	}
+	hosts := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		addr := make(net.IP, 4)
+		binary.BigEndian.PutUint32(addr, base+i)
+		hosts = append(hosts, addr.String())
+	}
+	return hosts, nil
+}
+
+// 各型號的簽章點位：discovery.models 指定的點位，或暫存器對照表中同時設定 min 與 max 的點位
+func (cfg *Config) discoverModels() []discoverModel {
+	var models []discoverModel
+	for _, name := range cfg.modelNames() {
+		params, _ := cfg.RegisterMap(name)
+		model := discoverModel{name: name, rule: cfg.Discovery.Models[name]}
+		if len(model.rule.Signature) > 0 {
+			for _, point := range model.rule.Signature {
+				if param, ok := findParameter(params, point); ok && !param.computed() {
+					model.signature = append(model.signature, param)
+				}
+			}
+		} else {
+			for _, param := range params {
+				if param.Min != nil && param.Max != nil && !param.computed() {
+					model.signature = append(model.signature, param)
+				}
+			}
+		}
+		models = append(models, model)
+	}
+	return models
+}
+
+// 依請求與設定決定掃描條件
+func (cfg *Config) discoverOptions(req DiscoverRequest) (*discoverOptions, error) {
+	opts := &discoverOptions{
+		port:        cfg.Discovery.Port,
+		concurrency: cfg.Discovery.Concurrency,
+		timeout:     cfg.Discovery.Timeout,
+		models:      cfg.discoverModels(),
+	}
+	var err error
+	if opts.hosts, err = expandCIDR(strings.TrimSpace(req.CIDR)); err != nil {
+		return nil, err
+	}
+	units := cfg.Discovery.Units
+	if req.Units != "" {
+		units = req.Units
+	}
+	if opts.units, err = parseUnitIDs(units); err != nil {
+		return nil, err
+	}
+	if req.Port != 0 {
+		opts.port = req.Port
+	}
+	if opts.port < 1 || opts.port > 65535 {
+		return nil, fmt.Errorf("連接埠必須介於 1 到 65535 (目前 %d)", opts.port)
+	}
+	if req.Concurrency != 0 {
+		opts.concurrency = req.Concurrency
+	}
+	if opts.concurrency < 1 || opts.concurrency > 256 {
+		return nil, fmt.Errorf("concurrency 必須介於 1 到 256 (目前 %d)", opts.concurrency)
+	}
+	if req.TimeoutMs != 0 {
+		opts.timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	if opts.timeout < 10*time.Millisecond || opts.timeout > 10*time.Second {
+		return nil, fmt.Errorf("逾時必須介於 10ms 到 10s (目前 %v)", opts.timeout)
+	}
+	return opts, nil
+}
+
+// 掃描網段；ctx 取消時停止並回傳已找到的裝置
+func (cfg *Config) Discover(ctx context.Context, req DiscoverRequest) (*DiscoverResult, error) {
+	opts, err := cfg.discoverOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	result := &DiscoverResult{
+		CIDR:   strings.TrimSpace(req.CIDR),
+		Port:   opts.port,
+		Units:  formatUnitIDs(opts.units),
+		Hosts:  len(opts.hosts),
+		Meters: make([]DiscoveredMeter, 0),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	hosts := make(chan string)
+	for i := 0; i < opts.concurrency && i < len(opts.hosts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range hosts {
+				meters, open := opts.probeHost(ctx, host)
+				mu.Lock()
+				if open {
+					result.OpenHosts++
+				}
+				result.Meters = append(result.Meters, meters...)
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, host := range opts.hosts {
+		select {
+		case hosts <- host:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(hosts)
+	wg.Wait()
+
+	sort.Slice(result.Meters, func(i, j int) bool {
+		a, b := result.Meters[i], result.Meters[j]
+		if a.Host != b.Host {
+			return ipLess(a.Host, b.Host)
+		}
+		return a.SlaveID < b.SlaveID
+	})
+	if result.Config, err = cfg.proposeMeters(result.Meters); err != nil {
+		return nil, err
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result, ctx.Err()
+}
+
+func ipLess(a, b string) bool {
+	return binary.BigEndian.Uint32(net.ParseIP(a).To4()) < binary.BigEndian.Uint32(net.ParseIP(b).To4())
+}
+
+// 以範圍表示單元 ID，例如 1-3,5
+func formatUnitIDs(units []int) string {
+	var parts []string
+	for i := 0; i < len(units); {
+		j := i
+		for j+1 < len(units) && units[j+1] == units[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(units[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", units[i], units[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// 掃描一台主機的所有單元 ID；open 表示連接埠開啟
+func (opts *discoverOptions) probeHost(ctx context.Context, host string) (meters []DiscoveredMeter, open bool) {
+	address := net.JoinHostPort(host, strconv.Itoa(opts.port))
+	conn, err := net.DialTimeout("tcp", address, opts.timeout)
+	if err != nil {
+		return nil, false
+	}
+	conn.Close()
+
+	handler := modbus.NewTCPClientHandler(address)
+	handler.Timeout = opts.timeout
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	for _, unit := range opts.units {
+		if ctx.Err() != nil {
+			break
+		}
+		handler.SlaveId = byte(unit)
+		if meter := opts.probeUnit(handler, client); meter != nil {
+			meter.Host, meter.Port, meter.SlaveID = host, opts.port, unit
+			meters = append(meters, *meter)
+		}
+	}
+	return meters, true
+}
+
+// 嘗試一個單元 ID：沒有回應 (或閘道回應找不到裝置) 時回傳 nil
+func (opts *discoverOptions) probeUnit(handler *modbus.TCPClientHandler, client modbus.Client) *DiscoveredMeter {
+	meter := &DiscoveredMeter{Models: make([]string, 0)}
+
+	device, err := readDeviceIdentification(handler, deviceIDRegular)
+	var modbusErr *modbus.ModbusError
+	if errors.As(err, &modbusErr) && modbusErr.ExceptionCode == modbusIllegalValue {
+		device, err = readDeviceIdentification(handler, deviceIDBasic)
+	}
+	responded := err == nil
+	switch {
+	case errors.As(err, &modbusErr):
+		if absentUnit(modbusErr) {
+			return nil
+		}
+		responded = true
+	case err != nil:
+		// 逾時後可能收到延遲的回應，重新連線避免與下一個請求錯位
+		handler.Close()
+	default:
+		meter.Device = device
+		for _, model := range opts.models {
+			if model.rule.matches(device) {
+				meter.Models = append(meter.Models, model.name)
+			}
+		}
+		if len(meter.Models) > 0 {
+			meter.MatchedBy = matchedByIdentification
+			return meter
+		}
+	}
+
+	for _, model := range opts.models {
+		if len(model.signature) == 0 {
+			continue
+		}
+		matched, reply := matchSignature(client, model.signature)
+		if reply == signatureNoReply {
+			handler.Close()
+			if !responded {
+				return nil
+			}
+			continue
+		}
+		responded = responded || reply == signatureReplied
+		if matched {
+			meter.Models = append(meter.Models, model.name)
+		}
+	}
+	if !responded {
+		return nil
+	}
+	if len(meter.Models) > 0 {
+		meter.MatchedBy = matchedBySignature
+	}
+	return meter
+}
+
+// 閘道回應目標不存在或沒有回應，視為沒有裝置
+func absentUnit(err *modbus.ModbusError) bool {
+	return err.ExceptionCode == modbusGatewayPath || err.ExceptionCode == modbusGatewayTarget
+}
+
+// 簽章讀取結果
+const (
+	signatureReplied = iota
+	signatureNoReply // 通訊失敗 (逾時等)
+	signatureAbsent  // 閘道回應沒有裝置
+)
+
+// 讀取簽章點位，全部讀取成功且品質正常時符合
+func matchSignature(client modbus.Client, signature []MeterParameter) (matched bool, reply int) {
+	for _, param := range signature {
+		data, err := readPoint(client, param)
+		var modbusErr *modbus.ModbusError
+		switch {
+		case errors.As(err, &modbusErr) && absentUnit(modbusErr):
+			return false, signatureAbsent
+		case errors.As(err, &modbusErr):
+			return false, signatureReplied
+		case err != nil:
+			return false, signatureNoReply
+		}
+		value, sentinel, err := decodePoint(param, data)
+		if err != nil || classifyValue(param, sentinel, value) != QualityGood {
+			return false, signatureReplied
+		}
+	}
+	return true, signatureReplied
+}
+
+// 裝置識別是否符合型號規則 (沒有設定 vendor 與 product 時不比對)
+func (rule DiscoveryModel) matches(device *DeviceIdentification) bool {
+	if rule.Vendor == "" && rule.Product == "" {
+		return false
+	}
+	contains := func(value, substr string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(substr))
+	}
+	if rule.Vendor != "" && !contains(device.VendorName, rule.Vendor) {
+		return false
+	}
+	if rule.Product != "" && !contains(device.ProductCode, rule.Product) &&
+		!contains(device.ProductName, rule.Product) && !contains(device.ModelName, rule.Product) {
+		return false
+	}
+	return true
+}
+
+// 標記已設定的電表，其餘符合型號的裝置產生建議設定 (使用第一個符合的型號)
+func (cfg *Config) proposeMeters(meters []DiscoveredMeter) (string, error) {
+	ids := make(map[string]bool)
+	for _, meter := range cfg.Meters {
+		ids[meter.ID] = true
+	}
+
+	var proposed []ProposedMeter
+	for i := range meters {
+		m := &meters[i]
+		for _, meter := range cfg.Meters {
+			if meter.Host == m.Host && meter.Port == m.Port && meter.SlaveID == m.SlaveID {
+				m.Configured = meter.ID
+				break
+			}
+		}
+		if m.Configured != "" || len(m.Models) == 0 {
+			continue
+		}
+
+		id := fmt.Sprintf("%s-%s-%d", m.Models[0], strings.ReplaceAll(m.Host, ".", "-"), m.SlaveID)
+		for n := 2; ids[id]; n++ {
+			id = fmt.Sprintf("%s-%s-%d-%d", m.Models[0], strings.ReplaceAll(m.Host, ".", "-"), m.SlaveID, n)
+		}
+		ids[id] = true
+		m.Proposed = &ProposedMeter{ID: id, Host: m.Host, Port: m.Port, SlaveID: m.SlaveID, Model: m.Models[0]}
+		proposed = append(proposed, *m.Proposed)
+	}
+	if len(proposed) == 0 {
+		return "", nil
+	}
+
+	var content strings.Builder
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string][]ProposedMeter{"meters": proposed}); err != nil {
+		return "", fmt.Errorf("產生建議設定失敗: %v", err)
+	}
+	return content.String(), nil
+}
+
+// 檢查掃描設定
+func (cfg *Config) validateDiscovery(add addFunc) {
+	d := cfg.Discovery
+	if d.Port < 1 || d.Port > 65535 {
+		add("discovery.port", "必須介於 1 到 65535 (目前 %d)", d.Port)
+	}
+	if _, err := parseUnitIDs(d.Units); err != nil {
+		add("discovery.units", "%v", err)
+	}
+	if d.Concurrency < 1 || d.Concurrency > 256 {
+		add("discovery.concurrency", "必須介於 1 到 256 (目前 %d)", d.Concurrency)
+	}
+	if d.Timeout < 10*time.Millisecond || d.Timeout > 10*time.Second {
+		add("discovery.timeout", "必須介於 10ms 到 10s (目前 %v)", d.Timeout)
+	}
+	for _, model := range sortedKeys(d.Models) {
+		field := "discovery.models." + model
+		params, ok := cfg.RegisterMap(model)
+		if !ok {
+			add(field, "未知的電表型號 %q (可用: %s)", model, strings.Join(cfg.modelNames(), ", "))
+			continue
+		}
+		for i, point := range d.Models[model].Signature {
+			if param, ok := findParameter(params, point); !ok {
+				add(fmt.Sprintf("%s.signature[%d]", field, i), "暫存器對照表中沒有點位 %q", point)
+			} else if param.computed() {
+				add(fmt.Sprintf("%s.signature[%d]", field, i), "計算點位 %q 無法由電表讀取", point)
+			}
+		}
+	}
+}
+
+// 掃描網段 (admin)；掃描期間請求保持連線，用戶端中斷時停止
+func (es *EnergySystem) DiscoverHandler(w http.ResponseWriter, r *http.Request) {
+	var req DiscoverRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "請求格式錯誤: "+err.Error(), nil)
+		return
+	}
+	setAudit(r, req.CIDR, "")
+
+	result, err := es.Config().Discover(r.Context(), req)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// discover 子命令
+func runDiscoverCommand(args []string) int {
+	fs := flag.NewFlagSet("discover", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	var req DiscoverRequest
+	fs.StringVar(&req.CIDR, "cidr", "", "掃描範圍，例如 192.168.1.0/24 (必填)")
+	fs.IntVar(&req.Port, "port", 0, "Modbus TCP 連接埠 (預設 discovery.port)")
+	fs.StringVar(&req.Units, "units", "", "單元 ID 範圍，例如 1-10 或 1,2,5-8 (預設 discovery.units)")
+	fs.IntVar(&req.Concurrency, "concurrency", 0, "同時掃描的主機數 (預設 discovery.concurrency)")
+	timeout := fs.Duration("timeout", 0, "連線與每次讀取的逾時 (預設 discovery.timeout)")
+	asJSON := fs.Bool("json", false, "以 JSON 輸出結果")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if req.CIDR == "" {
+		fmt.Fprintln(os.Stderr, "用法: energy_system discover -cidr 192.168.1.0/24 [-units 1-10] [-port 502] [-concurrency 32] [-timeout 500ms] [-json]")
+		return 2
+	}
+	req.TimeoutMs = timeout.Milliseconds()
+
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+
+	if !*asJSON {
+		fmt.Printf("🔍 掃描 %s...\n", req.CIDR)
+	}
+	result, err := config.Discover(context.Background(), req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+		return 0
+	}
+
+	for _, m := range result.Meters {
+		line := fmt.Sprintf("%s:%d 單元 %d", m.Host, m.Port, m.SlaveID)
+		if m.Device != nil {
+			line += fmt.Sprintf(" [%s %s 韌體 %s]", m.Device.VendorName, m.Device.ProductCode, m.Device.Revision)
+		}
+		switch {
+		case len(m.Models) == 0:
+			line += " → 沒有符合的型號"
+		default:
+			line += fmt.Sprintf(" → %s (%s)", strings.Join(m.Models, "、"), m.MatchedBy)
+		}
+		if m.Configured != "" {
+			line += fmt.Sprintf("，已設定為 %s", m.Configured)
+		}
+		fmt.Println("  " + line)
+	}
+	fmt.Printf("✅ 掃描 %d 個主機 (%d 個連接埠開啟)，找到 %d 個裝置，耗時 %v\n",
+		result.Hosts, result.OpenHosts, len(result.Meters), time.Duration(result.DurationMs)*time.Millisecond)
+	if result.Config != "" {
+		fmt.Println("\n# 建議加入設定檔的電表")
+		fmt.Print(result.Config)
+	}
+	return 0
+}
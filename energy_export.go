@@ -0,0 +1,631 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Windows 沒有時區資料庫，內嵌供 tz 參數使用
+)
+
+// 匯出種類、格式、聚合層級與語言
+var (
+	exportKinds     = []string{"raw", "aggregated", "report"}
+	exportFormats   = []string{"csv", "xlsx"}
+	exportIntervals = []string{"15m", "hour", "day", "month", "year"}
+	exportLanguages = []string{"zh-TW", "en"}
+)
+
+// 匯出檔案的欄位名稱
+var exportHeaders = map[string]map[string]string{
+	"zh-TW": {
+		"time": "時間", "period": "期間起始", "device": "電表", "point": "點位", "value": "數值", "unit": "單位",
+		"quality": "品質", "avg": "平均值", "min": "最小值", "max": "最大值", "count": "筆數", "bad_count": "異常筆數",
+		"sheet": "資料",
+	},
+	"en": {
+		"time": "Time", "period": "Period Start", "device": "Meter", "point": "Point", "value": "Value", "unit": "Unit",
+		"quality": "Quality", "avg": "Average", "min": "Minimum", "max": "Maximum", "count": "Count", "bad_count": "Bad Count",
+		"sheet": "Data",
+	},
+}
+
+// CSV 的時間格式 (含時區偏移)
+const exportTimeLayout = "2006-01-02T15:04:05.999Z07:00"
+
+// 匯出條件
+// raw 為原始取樣 (含品質)；aggregated 為各點位依聚合層級的統計；report 為每期一列、每個點位一欄的平均值報表
+type ExportRequest struct {
+	Kind       string
+	Format     string
+	DeviceID   string
+	Points     []string
+	From       time.Time // 含
+	To         time.Time // 含
+	Interval   string
+	Lang       string
+	Location   *time.Location
+	IncludeBad bool
+}
+
+// 解析匯出參數 (HTTP 查詢參數與 export 命令共用)
+func (es *EnergySystem) parseExportRequest(values url.Values) (*ExportRequest, error) {
+	req := &ExportRequest{
+		Kind:       exportOption(values, "kind", "raw"),
+		Format:     exportOption(values, "format", "csv"),
+		DeviceID:   values.Get("device"),
+		Interval:   exportOption(values, "interval", "hour"),
+		Lang:       exportOption(values, "lang", "zh-TW"),
+		Location:   time.Local,
+		IncludeBad: values.Get("quality") == "all",
+	}
+
+	for _, check := range []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"kind", req.Kind, exportKinds},
+		{"format", req.Format, exportFormats},
+		{"interval", req.Interval, exportIntervals},
+		{"lang", req.Lang, exportLanguages},
+	} {
+		if !containsString(check.allowed, check.value) {
+			return nil, fmt.Errorf("不支援的 %s: %s (可用: %s)", check.name, check.value, strings.Join(check.allowed, ", "))
+		}
+	}
+
+	if tz := values.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("無效的時區: %s", tz)
+		}
+		req.Location = loc
+	}
+
+	config := es.Config()
+	if req.DeviceID == "" {
+		req.DeviceID = config.Meters[0].ID
+	}
+
+	req.Points = append(req.Points, values["point"]...)
+	for _, point := range strings.Split(values.Get("points"), ",") {
+		if point = strings.TrimSpace(point); point != "" {
+			req.Points = append(req.Points, point)
+		}
+	}
+	// 未指定點位時匯出電表型號的所有點位
+	if len(req.Points) == 0 {
+		if meter, ok := config.Meter(req.DeviceID); ok {
+			parameters, _ := config.RegisterMap(meter.Model)
+			for _, param := range parameters {
+				req.Points = append(req.Points, param.Name)
+			}
+		}
+	}
+	if len(req.Points) == 0 {
+		return nil, fmt.Errorf("缺少必要參數: points")
+	}
+
+	req.To = time.Now()
+	if value := values.Get("to"); value != "" {
+		to, err := parseHistoryEnd(value, req.Location)
+		if err != nil {
+			return nil, fmt.Errorf("to 格式錯誤: %v", err)
+		}
+		req.To = to
+	}
+	req.From = req.To.Add(-24 * time.Hour)
+	if value := values.Get("from"); value != "" {
+		from, err := parseHistoryTime(value, req.Location)
+		if err != nil {
+			return nil, fmt.Errorf("from 格式錯誤: %v", err)
+		}
+		req.From = from
+	}
+	if req.From.After(req.To) {
+		return nil, fmt.Errorf("from 不可晚於 to")
+	}
+
+	return req, nil
+}
+
+func exportOption(values url.Values, name, fallback string) string {
+	if value := values.Get(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 匯出檔名
+func (req *ExportRequest) Filename() string {
+	return fmt.Sprintf("%s_%s_%s-%s.%s", req.DeviceID, req.Kind,
+		req.From.In(req.Location).Format("20060102"), req.To.In(req.Location).Format("20060102"), req.Format)
+}
+
+// 檔案的 MIME 類型
+func (req *ExportRequest) ContentType() string {
+	if req.Format == "xlsx" {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// 欄位名稱
+func (req *ExportRequest) header(key string) string {
+	return exportHeaders[req.Lang][key]
+}
+
+// 時間欄位名稱 (標示時區)
+func (req *ExportRequest) timeHeader(key string) string {
+	zone := req.Location.String()
+	if req.Location == time.Local {
+		zone = "UTC" + time.Now().In(req.Location).Format("-07:00")
+	}
+	return fmt.Sprintf("%s (%s)", req.header(key), zone)
+}
+
+// 表格輸出 (CSV 或 XLSX)
+type tableWriter interface {
+	WriteHeader(columns []string) error
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// 串流寫出 CSV (含 UTF-8 BOM，Excel 可正確顯示中文)
+type csvTableWriter struct {
+	w        *csv.Writer
+	location *time.Location
+}
+
+func newCSVTableWriter(w io.Writer, loc *time.Location) (*csvTableWriter, error) {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return nil, err
+	}
+	return &csvTableWriter{w: csv.NewWriter(w), location: loc}, nil
+}
+
+func (cw *csvTableWriter) WriteHeader(columns []string) error {
+	return cw.w.Write(columns)
+}
+
+func (cw *csvTableWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case *float64:
+			if v != nil {
+				record[i] = strconv.FormatFloat(*v, 'f', -1, 64)
+			}
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			record[i] = v.In(cw.location).Format(exportTimeLayout)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvTableWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// 依匯出條件寫出檔案內容
+func (es *EnergySystem) Export(w io.Writer, req *ExportRequest) error {
+	var table tableWriter
+	if req.Format == "xlsx" {
+		table = newXLSXWriter(w, req.header("sheet"), req.Location)
+	} else {
+		cw, err := newCSVTableWriter(w, req.Location)
+		if err != nil {
+			return err
+		}
+		table = cw
+	}
+
+	var err error
+	switch req.Kind {
+	case "raw":
+		err = es.exportRaw(table, req)
+	case "aggregated":
+		err = es.exportAggregated(table, req)
+	case "report":
+		err = es.exportReport(table, req)
+	}
+	if closeErr := table.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 原始取樣，依點位、時間排序
+func (es *EnergySystem) exportRaw(table tableWriter, req *ExportRequest) error {
+	err := table.WriteHeader([]string{req.timeHeader("time"), req.header("device"), req.header("point"),
+		req.header("value"), req.header("unit"), req.header("quality")})
+	if err != nil {
+		return err
+	}
+
+	for _, point := range req.Points {
+		query := SampleQuery{DeviceID: req.DeviceID, Point: point, From: req.From, To: req.To, Quality: QualityFilterAll}
+		err := forEachSample(es.store, query, func(sample Sample) error {
+			return table.WriteRow([]interface{}{sample.Timestamp, req.DeviceID, point, sample.Value, sample.Unit, sample.Quality})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 各點位依聚合層級的統計
+func (es *EnergySystem) exportAggregated(table tableWriter, req *ExportRequest) error {
+	err := table.WriteHeader([]string{req.timeHeader("period"), req.header("device"), req.header("point"),
+		req.header("avg"), req.header("min"), req.header("max"), req.header("count"), req.header("bad_count"), req.header("unit")})
+	if err != nil {
+		return err
+	}
+
+	units := es.exportUnits(req)
+	for _, point := range req.Points {
+		if err := es.exportAggregatedPoint(table, req, point, units[point]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (es *EnergySystem) exportAggregatedPoint(table tableWriter, req *ExportRequest, point, unit string) error {
+	buckets, err := es.openExportBuckets(req, point)
+	if err != nil {
+		return err
+	}
+	defer buckets.Close()
+
+	for {
+		bucket, err := buckets.Next()
+		if err != nil || bucket == nil {
+			return err
+		}
+
+		err = table.WriteRow([]interface{}{bucket.start, req.DeviceID, point,
+			bucket.avg(), bucket.minValue(), bucket.maxValue(), bucket.count, bucket.bad, unit})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 報表：每期一列，每個點位一欄 (平均值)
+func (es *EnergySystem) exportReport(table tableWriter, req *ExportRequest) error {
+	units := es.exportUnits(req)
+	columns := []string{req.timeHeader("period")}
+	for _, point := range req.Points {
+		if unit := units[point]; unit != "" {
+			columns = append(columns, fmt.Sprintf("%s (%s)", point, unit))
+		} else {
+			columns = append(columns, point)
+		}
+	}
+	if err := table.WriteHeader(columns); err != nil {
+		return err
+	}
+
+	// 每個點位各自依時間讀取，逐期合併
+	iterators := make([]*exportBucketIterator, len(req.Points))
+	heads := make([]*exportBucket, len(req.Points))
+	defer func() {
+		for _, it := range iterators {
+			if it != nil {
+				it.Close()
+			}
+		}
+	}()
+	for i, point := range req.Points {
+		it, err := es.openExportBuckets(req, point)
+		if err != nil {
+			return err
+		}
+		iterators[i] = it
+		if heads[i], err = it.Next(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		var period *time.Time
+		for _, head := range heads {
+			if head != nil && (period == nil || head.start.Before(*period)) {
+				start := head.start
+				period = &start
+			}
+		}
+		if period == nil {
+			return nil
+		}
+
+		row := make([]interface{}, 0, len(heads)+1)
+		row = append(row, *period)
+		for i, head := range heads {
+			if head == nil || !head.start.Equal(*period) {
+				row = append(row, nil)
+				continue
+			}
+			row = append(row, head.avg())
+
+			var err error
+			if heads[i], err = iterators[i].Next(); err != nil {
+				return err
+			}
+		}
+		if err := table.WriteRow(row); err != nil {
+			return err
+		}
+	}
+}
+
+// 點位單位：優先使用暫存器對照表，其次為資料庫中的紀錄
+func (es *EnergySystem) exportUnits(req *ExportRequest) map[string]string {
+	units := make(map[string]string)
+
+	config := es.Config()
+	if meter, ok := config.Meter(req.DeviceID); ok {
+		parameters, _ := config.RegisterMap(meter.Model)
+		for _, param := range parameters {
+			units[param.Name] = param.Unit
+		}
+	}
+
+	for _, point := range req.Points {
+		if _, ok := units[point]; ok {
+			continue
+		}
+		units[point], _ = es.store.LatestUnit(req.DeviceID, point)
+	}
+
+	return units
+}
+
+// 單一期間的統計
+type exportBucket struct {
+	start         time.Time
+	sum, min, max float64
+	count, bad    int
+}
+
+func (b *exportBucket) avg() *float64 {
+	if b.count == 0 {
+		return nil
+	}
+	return floatPtr(b.sum / float64(b.count))
+}
+
+func (b *exportBucket) minValue() *float64 {
+	if b.count == 0 {
+		return nil
+	}
+	return floatPtr(b.min)
+}
+
+func (b *exportBucket) maxValue() *float64 {
+	if b.count == 0 {
+		return nil
+	}
+	return floatPtr(b.max)
+}
+
+// 逐期讀取單一點位的統計 (依時間串流讀取原始資料，記憶體用量固定)
+type exportBucketIterator struct {
+	rows SampleRows
+	req  *ExportRequest
+
+	pending bool // 已讀取但屬於下一期的資料
+	sample  Sample
+}
+
+func (es *EnergySystem) openExportBuckets(req *ExportRequest, point string) (*exportBucketIterator, error) {
+	rows, err := es.store.QuerySamples(SampleQuery{DeviceID: req.DeviceID, Point: point, From: req.From, To: req.To, Quality: QualityFilterAll})
+	if err != nil {
+		return nil, err
+	}
+	return &exportBucketIterator{rows: rows, req: req}, nil
+}
+
+// 下一期的統計，沒有資料時回傳 nil
+func (it *exportBucketIterator) Next() (*exportBucket, error) {
+	var bucket *exportBucket
+
+	for {
+		if !it.pending {
+			if !it.rows.Next() {
+				break
+			}
+			it.sample = it.rows.Sample()
+			it.pending = true
+		}
+
+		start := periodStart(it.sample.Timestamp, it.req.Interval, it.req.Location)
+		if bucket == nil {
+			bucket = &exportBucket{start: start}
+		} else if !start.Equal(bucket.start) {
+			return bucket, nil
+		}
+		it.pending = false
+
+		if it.sample.Quality != QualityGood {
+			bucket.bad++
+		}
+		if it.sample.Value == nil || !qualityUsable(it.sample.Quality, it.req.IncludeBad) {
+			continue
+		}
+		value := *it.sample.Value
+		if bucket.count == 0 || value < bucket.min {
+			bucket.min = value
+		}
+		if bucket.count == 0 || value > bucket.max {
+			bucket.max = value
+		}
+		bucket.sum += value
+		bucket.count++
+	}
+
+	return bucket, it.rows.Err()
+}
+
+func (it *exportBucketIterator) Close() error {
+	return it.rows.Close()
+}
+
+// 期間起始時間 (依指定時區的日曆計算)
+func periodStart(t time.Time, interval string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch interval {
+	case "15m":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()-t.Minute()%15, 0, 0, loc)
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	}
+}
+
+// 下一期的起始時間 (t 為期間起始)
+func nextPeriod(t time.Time, interval string) time.Time {
+	switch interval {
+	case "15m":
+		return t.Add(15 * time.Minute)
+	case "day":
+		return t.AddDate(0, 0, 1)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	case "year":
+		return t.AddDate(1, 0, 0)
+	default:
+		return t.Add(time.Hour)
+	}
+}
+
+// 匯出資料 (CSV/XLSX 下載)
+func (es *EnergySystem) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := es.parseExportRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filename := req.Filename()
+	w.Header().Set("Content-Type", req.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`,
+		strings.ReplaceAll(filename, `"`, ""), url.PathEscape(filename)))
+
+	if err := es.Export(w, req); err != nil {
+		log.Printf("❌ 匯出 %s 失敗: %v", filename, err)
+	}
+}
+
+// export 命令：匯出資料到檔案
+func runExportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+
+	options := map[string]*string{}
+	for _, option := range []struct{ name, usage string }{
+		{"kind", "匯出種類: raw, aggregated, report"},
+		{"format", "檔案格式: csv, xlsx (預設依輸出檔副檔名)"},
+		{"device", "電表 ID (預設為第一個電表)"},
+		{"points", "點位名稱，以逗號分隔 (預設為所有點位)"},
+		{"from", "開始時間 (RFC3339、2006-01-02 或毫秒時間戳記)"},
+		{"to", "結束時間"},
+		{"interval", "聚合層級: 15m, hour, day, month, year"},
+		{"lang", "欄位語言: zh-TW, en"},
+		{"tz", "時區，例如 Asia/Taipei (預設為系統時區)"},
+		{"quality", "設為 all 時統計包含品質異常的數值"},
+	} {
+		options[option.name] = fs.String(option.name, "", option.usage)
+	}
+	output := fs.String("o", "", "輸出檔案 (- 為標準輸出，預設依條件產生檔名)")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	values := url.Values{}
+	for name, value := range options {
+		if *value != "" {
+			values.Set(name, *value)
+		}
+	}
+	if values.Get("format") == "" && strings.EqualFold(filepath.Ext(*output), ".xlsx") {
+		values.Set("format", "xlsx")
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+
+	system := NewEnergySystem(config)
+	if err := system.InitDatabase(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer system.store.Close()
+
+	req, err := system.parseExportRequest(values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+
+	path := *output
+	if path == "" {
+		path = req.Filename()
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 無法建立檔案: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := system.Export(w, req); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 匯出失敗: %v\n", err)
+		return 1
+	}
+	if path != "-" {
+		fmt.Fprintf(os.Stderr, "✅ 已匯出 %s (%s %s，%d 個點位)\n", path, req.DeviceID, req.Kind, len(req.Points))
+	}
+	return 0
+}
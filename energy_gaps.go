@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 缺口偵測與補齊：
+// 電腦重開機或網路中斷時資料會有缺口，定期依電表檢查缺口，
+// 型號設定了負載曲線 (load_profiles) 時由電表內部紀錄補齊 (品質標記為 backfilled)，
+// 並重新計算缺口前後累積電能計數器的用電量
+
+const (
+	// 背景工作的執行間隔 (更新用電量；缺口檢查另依 collection.gaps.check_interval)
+	gapMonitorTick = time.Minute
+	// 每次更新用電量時重新計算的範圍 (涵蓋寫入佇列與暫存區稍後補寫的資料)
+	energyDeltaWindow = time.Hour
+)
+
+// 補齊結果
+type BackfillResult struct {
+	DeviceID string    `json:"device"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Records  int       `json:"records"` // 電表內部紀錄中位於缺口內的筆數
+	Samples  int       `json:"samples"` // 新增的取樣筆數 (已存在的略過)
+	Deltas   int       `json:"deltas"`  // 重新計算的用電量筆數
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// 單一缺口 (API 回應)
+type GapInfo struct {
+	Start      time.Time       `json:"start"`
+	End        time.Time       `json:"end"`
+	DurationMs int64           `json:"duration_ms"`
+	Open       bool            `json:"open"`
+	Backfill   *BackfillResult `json:"backfill,omitempty"` // 最近一次補齊結果
+}
+
+// 單一電表的缺口 (API 回應)
+type GapReport struct {
+	DeviceID          string    `json:"device"`
+	MinGapMs          int64     `json:"min_gap_ms"`
+	BackfillSupported bool      `json:"backfill_supported"`
+	MissingMs         int64     `json:"missing_ms"` // 缺口總長度
+	Gaps              []GapInfo `json:"gaps"`
+}
+
+// 電表的缺口門檻
+func (cfg *Config) GapThreshold(meter MeterConfig) time.Duration {
+	if cfg.Collection.Gaps.MinGap > 0 {
+		return cfg.Collection.Gaps.MinGap
+	}
+	return 3 * cfg.MeterPollInterval(meter)
+}
+
+// 電表型號的累積電能計數器點位
+func counterPoints(cfg *Config, meter MeterConfig) []MeterParameter {
+	params, _ := cfg.RegisterMap(meter.Model)
+	var counters []MeterParameter
+	for _, param := range params {
+		if param.Counter {
+			counters = append(counters, param)
+		}
+	}
+	return counters
+}
+
+// Word-Swap 的 32 位元值 (與即時讀值相同的字組順序)
+func wordSwappedUint32(b []byte) uint32 {
+	return binary.BigEndian.Uint32([]byte{b[2], b[3], b[0], b[1]})
+}
+
+// 負載曲線中的一筆紀錄
+type loadProfileRecord struct {
+	Timestamp time.Time
+	Raw       []byte // 整筆紀錄的暫存器內容
+}
+
+// 讀取電表內部負載曲線中 from < 時間 < to 的紀錄：由最新一筆往回讀，遇到不晚於 from 的紀錄即停止
+func (mc *MeterCollector) ReadLoadProfile(profile LoadProfileConfig, from, to time.Time) ([]loadProfileRecord, error) {
+	release := mc.system.acquirePollSlot()
+	defer release()
+	mc.connMu.Lock()
+	defer mc.connMu.Unlock()
+
+	if err := mc.connect(); err != nil {
+		return nil, err
+	}
+	records, err := mc.readLoadProfile(profile, from, to)
+	if err != nil {
+		if quality, _ := classifyReadError(err); quality == QualityCommError {
+			mc.dropConnection()
+		}
+		return nil, fmt.Errorf("讀取負載曲線失敗: %v", err)
+	}
+	return records, nil
+}
+
+func (mc *MeterCollector) readLoadProfile(profile LoadProfileConfig, from, to time.Time) ([]loadProfileRecord, error) {
+	readUint16 := func(address uint16) (int, error) {
+		results, err := mc.client.ReadHoldingRegisters(address, 1)
+		if err != nil {
+			return 0, err
+		}
+		if len(results) < 2 {
+			return 0, fmt.Errorf("資料長度不足 (%d bytes)", len(results))
+		}
+		return int(binary.BigEndian.Uint16(results)), nil
+	}
+
+	count, err := readUint16(profile.CountRegister)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := readUint16(profile.LatestRegister)
+	if err != nil {
+		return nil, err
+	}
+	if count > profile.Capacity {
+		count = profile.Capacity
+	}
+	if count > 0 && latest >= profile.Capacity {
+		return nil, fmt.Errorf("最新紀錄索引 %d 超出容量 %d", latest, profile.Capacity)
+	}
+
+	size := profile.RecordSize
+	perRead := 125 / size
+	var records []loadProfileRecord
+	for index, remaining := latest, count; remaining > 0; {
+		// 一次讀取 index 之前連續的多筆 (不跨越環狀緩衝的起點)
+		n := perRead
+		if n > remaining {
+			n = remaining
+		}
+		if n > index+1 {
+			n = index + 1
+		}
+		first := index - n + 1
+		results, err := mc.client.ReadHoldingRegisters(profile.StartRegister+uint16(first*size), uint16(n*size))
+		if err != nil {
+			return nil, err
+		}
+		if len(results) < n*size*2 {
+			return nil, fmt.Errorf("資料長度不足 (%d bytes)", len(results))
+		}
+
+		for i := n - 1; i >= 0; i-- {
+			raw := results[i*size*2 : (i+1)*size*2]
+			timestamp := time.Unix(int64(wordSwappedUint32(raw)), 0)
+			if !timestamp.After(from) {
+				return records, nil
+			}
+			if timestamp.Before(to) {
+				records = append(records, loadProfileRecord{Timestamp: timestamp, Raw: raw})
+			}
+		}
+		remaining -= n
+		index = (first - 1 + profile.Capacity) % profile.Capacity
+	}
+	return records, nil
+}
+
+// 以電表內部紀錄補齊缺口，並重新計算缺口前後的用電量
+func (es *EnergySystem) BackfillGap(config *Config, meter MeterConfig, gap SampleGap) *BackfillResult {
+	result := &BackfillResult{DeviceID: meter.ID, Start: gap.Start, End: gap.End, At: time.Now()}
+	if err := es.backfillGap(config, meter, gap, result); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (es *EnergySystem) backfillGap(config *Config, meter MeterConfig, gap SampleGap, result *BackfillResult) error {
+	profile, ok := config.LoadProfiles[meter.Model]
+	if !ok {
+		return fmt.Errorf("型號 %s 未設定負載曲線 (load_profiles)，無法補齊", meter.Model)
+	}
+	es.collectorsMu.Lock()
+	collector := es.collectors[meter.ID]
+	es.collectorsMu.Unlock()
+	if collector == nil {
+		return fmt.Errorf("電表 %s 未在收集中", meter.ID)
+	}
+
+	records, err := collector.ReadLoadProfile(profile, gap.Start, gap.End)
+	if err != nil {
+		return err
+	}
+	result.Records = len(records)
+
+	params, _ := config.RegisterMap(meter.Model)
+	byName := make(map[string]MeterParameter, len(params))
+	for _, param := range params {
+		byName[param.Name] = param
+	}
+
+	var samples []Sample
+	for _, record := range records {
+		for _, field := range profile.Fields {
+			param := byName[field.Point]
+			bits := wordSwappedUint32(record.Raw[field.Offset*2:])
+			value := float64(math.Float32frombits(bits))
+			quality := classifyValue(param, bits == 0xFFFFFFFF, value)
+			if quality == QualityInvalidSentinel {
+				continue
+			}
+			if quality == QualityGood {
+				quality = QualityBackfilled
+			}
+			samples = append(samples, Sample{DeviceID: meter.ID, Point: field.Point, Timestamp: record.Timestamp,
+				Value: floatPtr(value), Unit: param.Unit, Quality: quality})
+		}
+	}
+	// 已存在的量測值 (例如缺口邊緣) 不覆寫
+	if result.Samples, err = es.store.BackfillSamples(samples); err != nil {
+		return err
+	}
+
+	result.Deltas, err = es.recomputeEnergyDeltas(config, meter, gap.Start, gap.End)
+	return err
+}
+
+// 重新計算電表所有累積電能計數器在區間內的用電量
+func (es *EnergySystem) recomputeEnergyDeltas(config *Config, meter MeterConfig, from, to time.Time) (int, error) {
+	total := 0
+	for _, param := range counterPoints(config, meter) {
+		n, err := es.store.RecomputeEnergyDeltas(meter.ID, param.Name, from, to, config.GapThreshold(meter))
+		if err != nil {
+			return total, fmt.Errorf("計算 %s 用電量失敗: %v", param.Name, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// 偵測電表在區間內的缺口；backfill 為 true 時補齊尚未補齊 (或上次失敗) 的缺口
+func (es *EnergySystem) checkMeterGaps(config *Config, meter MeterConfig, from, to time.Time, minGap time.Duration, backfill bool) (*GapReport, error) {
+	gaps, err := es.store.SampleGaps(meter.ID, from, to, minGap)
+	if err != nil {
+		return nil, fmt.Errorf("查詢電表 %s 缺口失敗: %v", meter.ID, err)
+	}
+
+	_, supported := config.LoadProfiles[meter.Model]
+	report := &GapReport{DeviceID: meter.ID, MinGapMs: minGap.Milliseconds(), BackfillSupported: supported, Gaps: make([]GapInfo, 0, len(gaps))}
+	for _, gap := range gaps {
+		info := GapInfo{Start: gap.Start, End: gap.End, DurationMs: gap.End.Sub(gap.Start).Milliseconds(), Open: gap.Open}
+		report.MissingMs += info.DurationMs
+
+		info.Backfill = es.findBackfill(gap)
+
+		// 尚未恢復的缺口無法讀取電表，恢復後再補齊；已補齊過的範圍不重複讀取 (負載曲線間隔較長時仍會有較小的缺口)
+		if backfill && supported && !gap.Open && (info.Backfill == nil || info.Backfill.Error != "") {
+			info.Backfill = es.BackfillGap(config, meter, gap)
+			es.recordBackfill(info.Backfill)
+
+			if info.Backfill.Error != "" {
+				log.Printf("❌ 電表 %s 缺口 %s ~ %s 補齊失敗: %s", meter.ID,
+					gap.Start.Format("01-02 15:04:05"), gap.End.Format("01-02 15:04:05"), info.Backfill.Error)
+			} else {
+				log.Printf("📥 電表 %s 缺口 %s ~ %s 已由內部紀錄補齊 %d 筆取樣 (%d 筆紀錄)", meter.ID,
+					gap.Start.Format("01-02 15:04:05"), gap.End.Format("01-02 15:04:05"), info.Backfill.Samples, info.Backfill.Records)
+			}
+		}
+		report.Gaps = append(report.Gaps, info)
+	}
+	return report, nil
+}
+
+// 涵蓋缺口的最近一次補齊結果
+func (es *EnergySystem) findBackfill(gap SampleGap) *BackfillResult {
+	es.gapMu.Lock()
+	defer es.gapMu.Unlock()
+
+	results := es.backfills[gap.DeviceID]
+	for i := len(results) - 1; i >= 0; i-- {
+		if !results[i].Start.After(gap.Start) && !results[i].End.Before(gap.End) {
+			return results[i]
+		}
+	}
+	return nil
+}
+
+// 記錄補齊結果 (只保留 24 小時內執行的)
+func (es *EnergySystem) recordBackfill(result *BackfillResult) {
+	es.gapMu.Lock()
+	defer es.gapMu.Unlock()
+
+	kept := []*BackfillResult{}
+	for _, previous := range es.backfills[result.DeviceID] {
+		if time.Since(previous.At) < 24*time.Hour {
+			kept = append(kept, previous)
+		}
+	}
+	es.backfills[result.DeviceID] = append(kept, result)
+}
+
+// 啟動背景工作：每分鐘更新用電量，並依 collection.gaps.check_interval 檢查缺口
+func (es *EnergySystem) startGapMonitor() {
+	es.gapStop = make(chan struct{})
+	es.gapDone = make(chan struct{})
+	go func() {
+		defer close(es.gapDone)
+
+		var lastCheck time.Time
+		ticker := time.NewTicker(gapMonitorTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-es.gapStop:
+				return
+			}
+
+			config := es.Config()
+			now := time.Now()
+			gaps := config.Collection.Gaps
+			check := gaps.CheckInterval > 0 && now.Sub(lastCheck) >= gaps.CheckInterval
+			if check {
+				lastCheck = now
+			}
+
+			for _, meter := range config.Meters {
+				window := energyDeltaWindow
+				if check {
+					if report, err := es.checkMeterGaps(config, meter, now.Add(-gaps.Lookback), now, config.GapThreshold(meter), gaps.Backfill); err != nil {
+						log.Printf("⚠️ %v", err)
+					} else if len(report.Gaps) > 0 {
+						log.Printf("🔎 電表 %s 最近 %v 內有 %d 個缺口 (共 %v)", meter.ID, gaps.Lookback, len(report.Gaps),
+							(time.Duration(report.MissingMs) * time.Millisecond).Round(time.Second))
+					}
+					window = gaps.Lookback
+				}
+				if _, err := es.recomputeEnergyDeltas(config, meter, now.Add(-window), now); err != nil {
+					log.Printf("⚠️ 電表 %s %v", meter.ID, err)
+				}
+			}
+		}
+	}()
+}
+
+// 停止背景工作 (等待進行中的檢查完成)
+func (es *EnergySystem) stopGapMonitor() {
+	if es.gapStop != nil {
+		close(es.gapStop)
+		<-es.gapDone
+	}
+}
+
+// 解析 device/from/to/min_gap 參數；device 空白時為所有電表
+func (es *EnergySystem) parseGapQuery(values url.Values) ([]MeterConfig, time.Time, time.Time, time.Duration, error) {
+	config := es.Config()
+	var from, to time.Time
+	var minGap time.Duration
+
+	meters := config.Meters
+	if id := values.Get("device"); id != "" {
+		meter, ok := config.Meter(id)
+		if !ok {
+			return nil, from, to, minGap, fmt.Errorf("找不到電表: %s", id)
+		}
+		meters = []MeterConfig{meter}
+	}
+
+	to = time.Now()
+	if value := values.Get("to"); value != "" {
+		t, err := parseHistoryEnd(value, time.Local)
+		if err != nil {
+			return nil, from, to, minGap, fmt.Errorf("to 格式錯誤: %v", err)
+		}
+		to = t
+	}
+	lookback := config.Collection.Gaps.Lookback
+	if lookback <= 0 {
+		lookback = 24 * time.Hour
+	}
+	from = to.Add(-lookback)
+	if value := values.Get("from"); value != "" {
+		t, err := parseHistoryTime(value, time.Local)
+		if err != nil {
+			return nil, from, to, minGap, fmt.Errorf("from 格式錯誤: %v", err)
+		}
+		from = t
+	}
+	if from.After(to) {
+		return nil, from, to, minGap, fmt.Errorf("from 不可晚於 to")
+	}
+
+	if value := values.Get("min_gap"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, from, to, minGap, fmt.Errorf("min_gap 格式錯誤: %s", value)
+		}
+		minGap = d
+	}
+	return meters, from, to, minGap, nil
+}
+
+// 查詢缺口 (GET) 或立即補齊 (POST，operator 以上)
+func (es *EnergySystem) GapsHandler(w http.ResponseWriter, r *http.Request) {
+	backfill := strings.HasSuffix(r.URL.Path, "/gaps/backfill")
+	if backfill {
+		if r.Method != http.MethodPost {
+			http.Error(w, "僅支援 POST", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	meters, from, to, minGap, err := es.parseGapQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	config := es.Config()
+	reports := make([]*GapReport, 0, len(meters))
+	for _, meter := range meters {
+		threshold := minGap
+		if threshold == 0 {
+			threshold = config.GapThreshold(meter)
+		}
+		report, err := es.checkMeterGaps(config, meter, from, to, threshold, backfill)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reports = append(reports, report)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"from": from, "to": to, "meters": reports})
+}
+
+// 累積電能計數器的用電量
+func (es *EnergySystem) EnergyDeltasHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	meters, from, to, _, err := es.parseGapQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if values.Get("device") == "" {
+		meters = meters[:1]
+	}
+	meter := meters[0]
+
+	// point 空白時為第一個累積電能點位
+	var counter *MeterParameter
+	counters := counterPoints(es.Config(), meter)
+	for i := range counters {
+		if values.Get("point") == "" || counters[i].Name == values.Get("point") {
+			counter = &counters[i]
+			break
+		}
+	}
+	if counter == nil {
+		http.Error(w, fmt.Sprintf("電表 %s 沒有累積電能點位 %s (暫存器對照表需設定 counter: true)", meter.ID, values.Get("point")), http.StatusBadRequest)
+		return
+	}
+	point := counter.Name
+
+	deltas, err := es.store.EnergyDeltas(meter.ID, point, from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("用電量查詢失敗: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var total, backfilled, gap float64
+	for _, delta := range deltas {
+		total += delta.Delta
+		if delta.Backfilled {
+			backfilled += delta.Delta
+		}
+		if delta.Gap {
+			gap += delta.Delta
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device":     meter.ID,
+		"point":      point,
+		"unit":       counter.Unit,
+		"from":       from,
+		"to":         to,
+		"total":      total,
+		"backfilled": backfilled, // 來自補齊取樣的用電量
+		"gap":        gap,        // 跨越缺口、無法分配到各時段的用電量
+		"deltas":     deltas,
+	})
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Modbus TCP 閘道：以從站提供各電表的最新讀值與計算值 (需量、本日用電量)，給只能讀取 Modbus 的 PLC 與 SCADA
+// 每個單元 ID 對應一個電表，暫存器配置由 outputs.modbus.layouts 定義，FC03 與 FC04 讀取相同的配置；
+// 數值來自收集器每次輪詢的結果 (不另外讀取電表)，單元與配置可重新載入，監聽位址變更需重新啟動
+
+// 閘道暫存器的數值種類
+const (
+	gatewayValueLatest      = "latest"       // 最新讀值
+	gatewayValueDemand      = "demand"       // demand_window 內的滑動平均
+	gatewayValueDailyEnergy = "daily_energy" // 本日 (本地時間 0 點起) 的用電量
+	gatewayValueQuality     = "quality"      // 最新讀值的品質代碼 (qualityCodes 的順序，0 為 good)
+	gatewayValueAge         = "age"          // 距離最近一次有效讀值的秒數
+)
+
+// FC03/FC04 單次最多讀取的暫存器數
+const modbusMaxReadRegisters = 125
+
+// 各類型佔用的暫存器數
+var gatewayTypeRegisters = map[string]int{"float32": 2, "u16": 1, "i16": 1, "u32": 2, "i32": 2}
+
+func isGatewayValue(value string) bool {
+	switch value {
+	case gatewayValueLatest, gatewayValueDemand, gatewayValueDailyEnergy, gatewayValueQuality, gatewayValueAge:
+		return true
+	}
+	return false
+}
+
+func isWordOrder(order string) bool {
+	return order == "abcd" || order == "cdab" || order == "badc" || order == "dcba"
+}
+
+// 套用預設值
+func (r ModbusGatewayRegister) normalized() ModbusGatewayRegister {
+	if r.Value == "" {
+		r.Value = gatewayValueLatest
+	}
+	if r.Type == "" {
+		r.Type = "float32"
+		if r.Value == gatewayValueQuality || r.Value == gatewayValueAge {
+			r.Type = "u16"
+		}
+	}
+	if r.Scale == 0 {
+		r.Scale = 1
+	}
+	return r
+}
+
+func gatewayRegistersOverlap(a, b ModbusGatewayRegister) bool {
+	aEnd := int(a.Address) + gatewayTypeRegisters[a.Type]
+	bEnd := int(b.Address) + gatewayTypeRegisters[b.Type]
+	return int(a.Address) < bEnd && int(b.Address) < aEnd
+}
+
+type ModbusGateway struct {
+	system *EnergySystem
+	server *modbusServer
+
+	mu     sync.Mutex
+	meters map[string]*gatewayMeter // 依電表 ID 的最新讀值與計算狀態
+	layout *gatewayLayout           // 依目前設定編譯的單元
+}
+
+// 單一電表的最新讀值與計算狀態
+type gatewayMeter struct {
+	points map[string]*gatewayPoint
+	demand map[string][]timedValue // 需量計算的取樣 (demand_window 內)
+	daily  map[string]*dailyEnergy
+}
+
+type gatewayPoint struct {
+	value    float64 // 最近一次有數值的讀值
+	hasValue bool
+	at       time.Time // value 的取樣時間
+	quality  string    // 最新一次讀取的品質 (可能沒有數值)
+}
+
+type timedValue struct {
+	at    time.Time
+	value float64
+}
+
+// 本日用電量：累加計數器的增量，跨日歸零
+type dailyEnergy struct {
+	day   time.Time
+	total float64
+	last  float64 // 上一筆計數器讀值
+}
+
+// 依設定編譯的單元配置
+type gatewayLayout struct {
+	config *Config
+	units  map[byte]*gatewayUnit
+	demand map[string]map[string]bool // 依電表需要計算需量的點位
+	daily  map[string]map[string]bool // 依電表需要計算本日用電量的點位
+}
+
+type gatewayUnit struct {
+	meter     MeterConfig
+	order     string
+	registers []ModbusGatewayRegister
+	size      int // 配置涵蓋的暫存器數 (0 到最後一個暫存器)
+}
+
+// 啟動 Modbus 閘道；未設定監聽位址時回傳 nil
+func StartModbusGateway(es *EnergySystem, cfg ModbusGatewayConfig) (*ModbusGateway, error) {
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+	gw := &ModbusGateway{system: es, meters: make(map[string]*gatewayMeter)}
+	server, err := listenModbus(cfg.Listen, cfg.MaxConnections, cfg.IdleTimeout, gw.handle)
+	if err != nil {
+		return nil, fmt.Errorf("Modbus 閘道無法監聽 %s: %v", cfg.Listen, err)
+	}
+	gw.server = server
+	log.Printf("🔌 Modbus 閘道啟動於 %s (%d 個單元)", server.Addr(), len(cfg.Units))
+	return gw, nil
+}
+
+// 停止閘道並中斷所有連線
+func (gw *ModbusGateway) Stop() {
+	gw.server.Close()
+}
+
+func (gw *ModbusGateway) Stats() ModbusServerStats {
+	return gw.server.Stats()
+}
+
+// 目前設定的單元配置 (設定重新載入後重新編譯)
+func (gw *ModbusGateway) currentLayout(config *Config) *gatewayLayout {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if gw.layout == nil || gw.layout.config != config {
+		gw.layout = compileGatewayLayout(config)
+	}
+	return gw.layout
+}
+
+func compileGatewayLayout(config *Config) *gatewayLayout {
+	gw := config.Outputs.Modbus
+	layout := &gatewayLayout{
+		config: config,
+		units:  make(map[byte]*gatewayUnit),
+		demand: make(map[string]map[string]bool),
+		daily:  make(map[string]map[string]bool),
+	}
+	for _, u := range gw.Units {
+		meter, ok := config.Meter(u.Meter)
+		if !ok {
+			continue
+		}
+		unit := &gatewayUnit{meter: meter, order: u.WordOrder}
+		if unit.order == "" {
+			unit.order = gw.WordOrder
+		}
+
+		registers := gw.Layouts[u.Layout]
+		if u.Layout == "" {
+			// 未指定配置：依暫存器對照表的順序，每個點位的最新讀值佔 2 個暫存器
+			params, _ := config.RegisterMap(meter.Model)
+			for i, param := range params {
+				registers = append(registers, ModbusGatewayRegister{Address: uint16(2 * i), Point: param.Name})
+			}
+		}
+		for _, reg := range registers {
+			reg = reg.normalized()
+			unit.registers = append(unit.registers, reg)
+			if end := int(reg.Address) + gatewayTypeRegisters[reg.Type]; end > unit.size {
+				unit.size = end
+			}
+			switch reg.Value {
+			case gatewayValueDemand:
+				addGatewayPoint(layout.demand, meter.ID, reg.Point)
+			case gatewayValueDailyEnergy:
+				addGatewayPoint(layout.daily, meter.ID, reg.Point)
+			}
+		}
+		layout.units[byte(u.UnitID)] = unit
+	}
+	return layout
+}
+
+func addGatewayPoint(points map[string]map[string]bool, meterID, point string) {
+	if points[meterID] == nil {
+		points[meterID] = make(map[string]bool)
+	}
+	points[meterID][point] = true
+}
+
+// 本地時間當日 0 點
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.In(time.Local).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// 收集器每次輪詢後更新最新讀值、需量取樣與本日用電量
+func (gw *ModbusGateway) Observe(meterID string, readings []MeterReading, sampleTime time.Time) {
+	config := gw.system.Config()
+	layout := gw.currentLayout(config)
+	day := startOfDay(sampleTime)
+
+	// 重新啟動後 (或配置新增點位) 的第一筆：本日用電量由資料庫已計算的用電量接續
+	gw.mu.Lock()
+	m := gw.meter(meterID)
+	var seedPoints []string
+	for point := range layout.daily[meterID] {
+		if m.daily[point] == nil {
+			seedPoints = append(seedPoints, point)
+		}
+	}
+	gw.mu.Unlock()
+
+	seeds := make(map[string]float64, len(seedPoints))
+	for _, point := range seedPoints {
+		deltas, err := gw.system.store.EnergyDeltas(meterID, point, day, sampleTime)
+		if err != nil {
+			log.Printf("⚠️ Modbus 閘道查詢電表 %s %s 本日用電量失敗: %v", meterID, point, err)
+		}
+		for _, delta := range deltas {
+			seeds[point] += delta.Delta
+		}
+	}
+
+	window := config.Outputs.Modbus.DemandWindow
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	for _, reading := range readings {
+		point := m.points[reading.Name]
+		if point == nil {
+			point = &gatewayPoint{}
+			m.points[reading.Name] = point
+		}
+		point.quality = reading.Quality
+		if qualityHasValue(reading.Quality) {
+			point.value, point.hasValue, point.at = reading.Value, true, sampleTime
+		}
+		if !qualityUsable(reading.Quality, false) {
+			continue
+		}
+
+		if layout.demand[meterID][reading.Name] {
+			samples := append(m.demand[reading.Name], timedValue{sampleTime, reading.Value})
+			for len(samples) > 0 && sampleTime.Sub(samples[0].at) > window {
+				samples = samples[1:]
+			}
+			m.demand[reading.Name] = samples
+		}
+
+		if layout.daily[meterID][reading.Name] {
+			daily := m.daily[reading.Name]
+			if daily == nil {
+				m.daily[reading.Name] = &dailyEnergy{day: day, total: seeds[reading.Name], last: reading.Value}
+				continue
+			}
+			if !daily.day.Equal(day) {
+				daily.day, daily.total = day, 0
+			}
+			delta := reading.Value - daily.last
+			if delta < 0 {
+				// 計數器數值變小視為歸零
+				delta = reading.Value
+			}
+			daily.total += delta
+			daily.last = reading.Value
+		}
+	}
+}
+
+// 電表的狀態 (呼叫端需持有 mu)
+func (gw *ModbusGateway) meter(id string) *gatewayMeter {
+	m := gw.meters[id]
+	if m == nil {
+		m = &gatewayMeter{
+			points: make(map[string]*gatewayPoint),
+			demand: make(map[string][]timedValue),
+			daily:  make(map[string]*dailyEnergy),
+		}
+		gw.meters[id] = m
+	}
+	return m
+}
+
+// 處理 Modbus 請求：只支援讀取 (FC03/FC04)
+func (gw *ModbusGateway) handle(unitID byte, pdu []byte) []byte {
+	function := pdu[0]
+	config := gw.system.Config()
+	unit := gw.currentLayout(config).units[unitID]
+	if unit == nil {
+		return modbusException(function, modbusGatewayPath)
+	}
+	if function != 0x03 && function != 0x04 {
+		return modbusException(function, modbusIllegalFunction)
+	}
+	start, quantity, exception := parseReadRequest(pdu, modbusMaxReadRegisters)
+	if exception != nil {
+		return exception
+	}
+	if int(start)+int(quantity) > unit.size {
+		return modbusException(function, modbusIllegalAddress)
+	}
+
+	// 電表最近沒有輪詢成功時回應「目標裝置沒有回應」，讓 SCADA 判斷通訊中斷
+	now := time.Now()
+	gw.system.collectorsMu.Lock()
+	collector := gw.system.collectors[unit.meter.ID]
+	gw.system.collectorsMu.Unlock()
+	if collector == nil {
+		return modbusException(function, modbusGatewayTarget)
+	}
+	stats := collector.Stats()
+	if !meterHealthy(config, unit.meter, stats, now) {
+		return modbusException(function, modbusGatewayTarget)
+	}
+
+	response := []byte{function, byte(quantity * 2)}
+	return append(response, gw.registers(unit, start, quantity, stats.LastSuccess, config.Outputs.Modbus.DemandWindow, now)...)
+}
+
+// 產生請求範圍內的暫存器資料；配置範圍內未定義的暫存器為 0
+func (gw *ModbusGateway) registers(unit *gatewayUnit, start, quantity uint16, lastSuccess time.Time, window time.Duration, now time.Time) []byte {
+	gw.mu.Lock()
+	m := gw.meter(unit.meter.ID)
+	data := make([]byte, int(quantity)*2)
+	end := int(start) + int(quantity)
+	for _, reg := range unit.registers {
+		width := gatewayTypeRegisters[reg.Type]
+		if int(reg.Address)+width <= int(start) || int(reg.Address) >= end {
+			continue
+		}
+		encoded := encodeGatewayValue(reg, m.value(reg, lastSuccess, window, now), unit.order)
+		for i := 0; i < width; i++ {
+			address := int(reg.Address) + i
+			if address >= int(start) && address < end {
+				copy(data[(address-int(start))*2:], encoded[i*2:i*2+2])
+			}
+		}
+	}
+	gw.mu.Unlock()
+	return data
+}
+
+// 暫存器的數值；沒有資料時為 NaN
+func (m *gatewayMeter) value(reg ModbusGatewayRegister, lastSuccess time.Time, window time.Duration, now time.Time) float64 {
+	point := m.points[reg.Point]
+	switch reg.Value {
+	case gatewayValueDemand:
+		sum, count := 0.0, 0
+		for _, sample := range m.demand[reg.Point] {
+			if now.Sub(sample.at) <= window {
+				sum += sample.value
+				count++
+			}
+		}
+		if count > 0 {
+			return sum / float64(count)
+		}
+	case gatewayValueDailyEnergy:
+		if daily := m.daily[reg.Point]; daily != nil && daily.day.Equal(startOfDay(now)) {
+			return daily.total
+		} else if daily != nil {
+			return 0
+		}
+	case gatewayValueQuality:
+		if point != nil {
+			for i, code := range qualityCodes {
+				if code == point.quality {
+					return float64(i)
+				}
+			}
+		}
+	case gatewayValueAge:
+		at := lastSuccess
+		if reg.Point != "" {
+			at = time.Time{}
+			if point != nil && point.hasValue {
+				at = point.at
+			}
+		}
+		if !at.IsZero() {
+			return math.Floor(now.Sub(at).Seconds())
+		}
+	default:
+		if point != nil && point.hasValue {
+			return point.value
+		}
+	}
+	return math.NaN()
+}
+
+// 數值依類型與位元組順序編碼；整數類型超出範圍時取上下限，NaN 輸出最大值
+func encodeGatewayValue(reg ModbusGatewayRegister, value float64, order string) []byte {
+	scaled := value * reg.Scale
+	data := make([]byte, 2*gatewayTypeRegisters[reg.Type])
+	switch reg.Type {
+	case "float32":
+		binary.BigEndian.PutUint32(data, math.Float32bits(float32(scaled)))
+	case "u16":
+		binary.BigEndian.PutUint16(data, uint16(clampInteger(scaled, 0, math.MaxUint16)))
+	case "i16":
+		binary.BigEndian.PutUint16(data, uint16(int16(clampInteger(scaled, math.MinInt16, math.MaxInt16))))
+	case "u32":
+		binary.BigEndian.PutUint32(data, uint32(clampInteger(scaled, 0, math.MaxUint32)))
+	case "i32":
+		binary.BigEndian.PutUint32(data, uint32(int32(clampInteger(scaled, math.MinInt32, math.MaxInt32))))
+	}
+	return orderBytes(data, order)
+}
+
+func clampInteger(value, lo, hi float64) int64 {
+	switch {
+	case math.IsNaN(value) || value > hi:
+		return int64(hi)
+	case value < lo:
+		return int64(lo)
+	}
+	return int64(math.Round(value))
+}
+
+// 依位元組順序重新排列 (資料為 abcd，即高位在前)：cdab 字組交換、badc 字組內位元組交換、dcba 兩者皆交換
+func orderBytes(data []byte, order string) []byte {
+	if len(data) == 4 && (order == "cdab" || order == "dcba") {
+		data[0], data[1], data[2], data[3] = data[2], data[3], data[0], data[1]
+	}
+	if order == "badc" || order == "dcba" {
+		for i := 0; i+1 < len(data); i += 2 {
+			data[i], data[i+1] = data[i+1], data[i]
+		}
+	}
+	return data
+}
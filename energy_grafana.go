@@ -0,0 +1,621 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Grafana 資料來源 API
+// 實作 JSON datasource (simpod-json-datasource) 的 search/metrics、query、annotations、tag-keys、tag-values，
+// 另提供 /grafana/series 給 Infinity datasource 直接讀取平面 JSON 陣列
+// 目標名稱為 "電表ID/點位"，只寫點位時套用到所有具有該點位的電表 (可再以 ad hoc filter 篩選)
+
+// rollup 查詢最多的期間數 (避免一次展開過多的 VALUES)
+const maxGrafanaRollupPeriods = 5000
+
+// Grafana 查詢的時間範圍
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// ad hoc filter (=、!=、=~、!~)
+type grafanaAdhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// 查詢目標
+type grafanaTarget struct {
+	Target  string          `json:"target"`
+	RefID   string          `json:"refId"`
+	Type    string          `json:"type"` // timeserie (預設) 或 table
+	Hide    bool            `json:"hide"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// 查詢目標的附加選項 (payload)
+type grafanaPayload struct {
+	Method  string `json:"method"`  // 降採樣方式: avg (預設)、minmax、lttb、raw
+	Rollup  string `json:"rollup"`  // 日曆期間統計: hour、day、month (設定時取代降採樣)
+	Stat    string `json:"stat"`    // rollup 的統計值: avg (預設)、min、max、count
+	Quality string `json:"quality"` // all 表示包含異常品質的數值
+}
+
+// /query 請求
+type grafanaQueryRequest struct {
+	Range         grafanaRange         `json:"range"`
+	IntervalMs    int64                `json:"intervalMs"`
+	MaxDataPoints int                  `json:"maxDataPoints"`
+	Targets       []grafanaTarget      `json:"targets"`
+	AdhocFilters  []grafanaAdhocFilter `json:"adhocFilters"`
+}
+
+// 時間序列回應
+type grafanaTimeSeries struct {
+	Target     string          `json:"target"`
+	RefID      string          `json:"refId,omitempty"`
+	Datapoints [][]interface{} `json:"datapoints"` // [數值, 毫秒時間戳記]
+}
+
+// 表格回應
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	RefID   string          `json:"refId,omitempty"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// /annotations 請求
+type grafanaAnnotationRequest struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name   string `json:"name"`
+		Query  string `json:"query"`
+		Enable bool   `json:"enable"`
+	} `json:"annotation"`
+}
+
+// 註解 (告警觸發到解除為一個區間)
+type grafanaAnnotation struct {
+	Time     int64    `json:"time"`
+	TimeEnd  int64    `json:"timeEnd,omitempty"`
+	IsRegion bool     `json:"isRegion"`
+	Title    string   `json:"title"`
+	Text     string   `json:"text"`
+	Tags     []string `json:"tags"`
+}
+
+// 查詢的電表與點位
+type grafanaSeriesKey struct {
+	DeviceID string
+	Point    string
+}
+
+func (k grafanaSeriesKey) String() string {
+	return k.DeviceID + "/" + k.Point
+}
+
+// Grafana 資料來源端點 (/grafana/...)
+func (es *EnergySystem) GrafanaHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.Trim(strings.TrimPrefix(r.URL.Path, "/grafana"), "/")
+
+	// 測試連線
+	if endpoint == "" {
+		w.Write([]byte("OK"))
+		return
+	}
+	if endpoint == "series" {
+		es.grafanaSeries(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "僅支援 POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result interface{}
+	var err error
+	switch endpoint {
+	case "search", "metrics":
+		result, err = es.grafanaSearch(r, endpoint == "metrics")
+	case "query":
+		result, err = es.grafanaQuery(r)
+	case "annotations":
+		result, err = es.grafanaAnnotations(r)
+	case "tag-keys":
+		result = []map[string]string{{"type": "string", "text": "device"}, {"type": "string", "text": "point"}}
+	case "tag-values":
+		result, err = es.grafanaTagValues(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// 解析 JSON 請求內容 (空白內容視為空物件)
+func decodeGrafanaRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("請求格式錯誤: %v", err)
+	}
+	return nil
+}
+
+// 所有電表的點位 (依設定檔順序)
+func (es *EnergySystem) grafanaSeriesKeys() []grafanaSeriesKey {
+	config := es.Config()
+
+	var keys []grafanaSeriesKey
+	for _, meter := range config.Meters {
+		params, _ := config.RegisterMap(meter.Model)
+		for _, param := range params {
+			keys = append(keys, grafanaSeriesKey{meter.ID, param.Name})
+		}
+	}
+	return keys
+}
+
+// 列出可查詢的目標 (search 回傳字串陣列，metrics 回傳 label/value)
+func (es *EnergySystem) grafanaSearch(r *http.Request, metrics bool) (interface{}, error) {
+	var req struct {
+		Target string `json:"target"`
+		Metric string `json:"metric"`
+	}
+	if err := decodeGrafanaRequest(r, &req); err != nil {
+		return nil, err
+	}
+	filter := strings.ToLower(req.Target + req.Metric)
+
+	targets := make([]string, 0)
+	for _, key := range es.grafanaSeriesKeys() {
+		if filter == "" || strings.Contains(strings.ToLower(key.String()), filter) {
+			targets = append(targets, key.String())
+		}
+	}
+
+	if !metrics {
+		return targets, nil
+	}
+	options := make([]map[string]string, 0, len(targets))
+	for _, target := range targets {
+		options = append(options, map[string]string{"label": target, "value": target})
+	}
+	return options, nil
+}
+
+// ad hoc filter 可用的數值
+func (es *EnergySystem) grafanaTagValues(r *http.Request) (interface{}, error) {
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := decodeGrafanaRequest(r, &req); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	values := make([]map[string]string, 0)
+	for _, key := range es.grafanaSeriesKeys() {
+		var value string
+		switch req.Key {
+		case "device":
+			value = key.DeviceID
+		case "point":
+			value = key.Point
+		default:
+			return nil, fmt.Errorf("不支援的標籤: %s", req.Key)
+		}
+		if !seen[value] {
+			seen[value] = true
+			values = append(values, map[string]string{"text": value})
+		}
+	}
+	return values, nil
+}
+
+// 依目標名稱與 ad hoc filter 找出要查詢的電表與點位
+func (es *EnergySystem) resolveGrafanaTarget(target string, filters []grafanaAdhocFilter) ([]grafanaSeriesKey, error) {
+	var keys []grafanaSeriesKey
+	if i := strings.Index(target, "/"); i >= 0 {
+		keys = append(keys, grafanaSeriesKey{target[:i], target[i+1:]})
+	} else {
+		for _, key := range es.grafanaSeriesKeys() {
+			if key.Point == target {
+				keys = append(keys, key)
+			}
+		}
+		// 不在對照表中的點位 (例如匯入的資料) 套用到所有電表
+		if len(keys) == 0 {
+			for _, meter := range es.Config().Meters {
+				keys = append(keys, grafanaSeriesKey{meter.ID, target})
+			}
+		}
+	}
+
+	result := keys[:0]
+	for _, key := range keys {
+		matched := true
+		for _, filter := range filters {
+			ok, err := matchGrafanaFilter(filter, key)
+			if err != nil {
+				return nil, err
+			}
+			matched = matched && ok
+		}
+		if matched {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+func matchGrafanaFilter(filter grafanaAdhocFilter, key grafanaSeriesKey) (bool, error) {
+	var value string
+	switch filter.Key {
+	case "device":
+		value = key.DeviceID
+	case "point":
+		value = key.Point
+	default:
+		return true, nil
+	}
+
+	switch filter.Operator {
+	case "=", "":
+		return value == filter.Value, nil
+	case "!=":
+		return value != filter.Value, nil
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + filter.Value + ")$")
+		if err != nil {
+			return false, fmt.Errorf("無效的正規表示式: %s", filter.Value)
+		}
+		return re.MatchString(value) == (filter.Operator == "=~"), nil
+	}
+	return false, fmt.Errorf("不支援的運算子: %s", filter.Operator)
+}
+
+// 解析 payload (新版為物件，舊版可能是 JSON 字串)
+func parseGrafanaPayload(raw json.RawMessage) (grafanaPayload, error) {
+	var payload grafanaPayload
+	if len(raw) == 0 || string(raw) == "null" {
+		return payload, nil
+	}
+
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		if strings.TrimSpace(text) == "" {
+			return payload, nil
+		}
+		raw = json.RawMessage(text)
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return payload, fmt.Errorf("payload 格式錯誤: %v", err)
+	}
+	return payload, nil
+}
+
+// 查詢時間序列或表格
+func (es *EnergySystem) grafanaQuery(r *http.Request) (interface{}, error) {
+	var req grafanaQueryRequest
+	if err := decodeGrafanaRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Range.From.IsZero() || req.Range.To.IsZero() || req.Range.From.After(req.Range.To) {
+		return nil, fmt.Errorf("缺少或無效的查詢範圍")
+	}
+
+	maxPoints := req.MaxDataPoints
+	if maxPoints <= 0 {
+		maxPoints = defaultHistoryPoints
+	}
+	if maxPoints > maxHistoryPoints {
+		maxPoints = maxHistoryPoints
+	}
+	if maxPoints < 3 {
+		maxPoints = 3
+	}
+
+	results := make([]interface{}, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+		payload, err := parseGrafanaPayload(target.Payload)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := es.resolveGrafanaTarget(target.Target, req.AdhocFilters)
+		if err != nil {
+			return nil, err
+		}
+
+		var series []grafanaTimeSeries
+		for _, key := range keys {
+			var s []grafanaTimeSeries
+			if payload.Rollup != "" {
+				s, err = es.grafanaRollup(key, req.Range, payload)
+			} else {
+				s, err = es.grafanaHistory(key, req.Range, maxPoints, payload)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s 查詢失敗: %v", key, err)
+			}
+			series = append(series, s...)
+		}
+
+		if target.Type == "table" {
+			results = append(results, grafanaSeriesTable(target.RefID, series))
+			continue
+		}
+		for _, s := range series {
+			s.RefID = target.RefID
+			results = append(results, s)
+		}
+	}
+	return results, nil
+}
+
+// 以歷史資料的降採樣方式查詢 (與 /api/history 相同)
+func (es *EnergySystem) grafanaHistory(key grafanaSeriesKey, rng grafanaRange, maxPoints int, payload grafanaPayload) ([]grafanaTimeSeries, error) {
+	method := payload.Method
+	if method == "" {
+		method = "avg"
+	}
+	validMethod := false
+	for _, m := range historyMethods {
+		validMethod = validMethod || m == method
+	}
+	if !validMethod {
+		return nil, fmt.Errorf("不支援的降採樣方式: %s (可用: %s)", method, strings.Join(historyMethods, ", "))
+	}
+
+	response, err := es.queryHistory(&HistoryQuery{
+		DeviceID:   key.DeviceID,
+		Points:     []string{key.Point},
+		From:       rng.From,
+		To:         rng.To,
+		MaxPoints:  maxPoints,
+		Method:     method,
+		IncludeBad: payload.Quality == "all",
+		After:      rng.From.UnixMilli() - 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	history := response.Series[0]
+	value := grafanaTimeSeries{Target: key.String(), Datapoints: make([][]interface{}, 0, len(history.Points))}
+	min := grafanaTimeSeries{Target: key.String() + " (min)", Datapoints: make([][]interface{}, 0, len(history.Points))}
+	max := grafanaTimeSeries{Target: key.String() + " (max)", Datapoints: make([][]interface{}, 0, len(history.Points))}
+	for _, point := range history.Points {
+		ms := point.Timestamp.UnixMilli()
+		value.Datapoints = append(value.Datapoints, []interface{}{point.Value, ms})
+		if point.Min != nil {
+			min.Datapoints = append(min.Datapoints, []interface{}{point.Min, ms})
+			max.Datapoints = append(max.Datapoints, []interface{}{point.Max, ms})
+		}
+	}
+
+	// minmax 另回傳包絡線 (資料量未超過上限時為原始資料，沒有包絡線)
+	if history.Method == "minmax" {
+		return []grafanaTimeSeries{value, min, max}, nil
+	}
+	return []grafanaTimeSeries{value}, nil
+}
+
+// 以日曆期間統計查詢 (與 /api/aggregated 相同，期間依當地時區)
+func (es *EnergySystem) grafanaRollup(key grafanaSeriesKey, rng grafanaRange, payload grafanaPayload) ([]grafanaTimeSeries, error) {
+	switch payload.Rollup {
+	case "hour", "day", "month":
+	default:
+		return nil, fmt.Errorf("不支援的 rollup: %s (可用: hour, day, month)", payload.Rollup)
+	}
+
+	periods := periodBoundaries(periodStart(rng.From, payload.Rollup, time.Local), rng.To, payload.Rollup)
+	if len(periods) > maxGrafanaRollupPeriods {
+		return nil, fmt.Errorf("查詢範圍過大 (%d 個期間，上限 %d)", len(periods), maxGrafanaRollupPeriods)
+	}
+
+	rollups, err := es.store.Rollup(key.DeviceID, key.Point, periods, usableFilter(payload.Quality == "all"))
+	if err != nil {
+		return nil, err
+	}
+
+	series := grafanaTimeSeries{Target: key.String(), Datapoints: make([][]interface{}, 0, len(rollups))}
+	for _, rollup := range rollups {
+		var value interface{}
+		switch payload.Stat {
+		case "", "avg":
+			value = rollup.Avg
+		case "min":
+			value = rollup.Min
+		case "max":
+			value = rollup.Max
+		case "count":
+			value = rollup.Count
+		default:
+			return nil, fmt.Errorf("不支援的統計值: %s (可用: avg, min, max, count)", payload.Stat)
+		}
+		// 期間內沒有可用的數值
+		if rollup.Count == 0 && payload.Stat != "count" {
+			value = nil
+		}
+		series.Datapoints = append(series.Datapoints, []interface{}{value, rollup.Start.UnixMilli()})
+	}
+	if payload.Stat != "" && payload.Stat != "avg" {
+		series.Target += " (" + payload.Stat + ")"
+	}
+	return []grafanaTimeSeries{series}, nil
+}
+
+// 將時間序列轉為表格 (每個數值一列，依時間排序)
+func grafanaSeriesTable(refID string, series []grafanaTimeSeries) grafanaTable {
+	table := grafanaTable{
+		Type:  "table",
+		RefID: refID,
+		Columns: []grafanaColumn{
+			{Text: "Time", Type: "time"},
+			{Text: "Series", Type: "string"},
+			{Text: "Value", Type: "number"},
+		},
+		Rows: make([][]interface{}, 0),
+	}
+	for _, s := range series {
+		for _, point := range s.Datapoints {
+			table.Rows = append(table.Rows, []interface{}{point[1], s.Target, point[0]})
+		}
+	}
+	sort.SliceStable(table.Rows, func(i, j int) bool { return table.Rows[i][0].(int64) < table.Rows[j][0].(int64) })
+	return table
+}
+
+// 告警事件註解
+// query 可用空白分隔的 key=value 篩選 (device、rule、parameter、severity)，不帶 key 的字詞視為電表 ID
+func (es *EnergySystem) grafanaAnnotations(r *http.Request) (interface{}, error) {
+	var req grafanaAnnotationRequest
+	if err := decodeGrafanaRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Range.From.IsZero() || req.Range.To.IsZero() {
+		return nil, fmt.Errorf("缺少查詢範圍")
+	}
+
+	filters := make(map[string]string)
+	for _, field := range strings.Fields(req.Annotation.Query) {
+		if i := strings.Index(field, "="); i >= 0 {
+			filters[field[:i]] = field[i+1:]
+		} else {
+			filters["device"] = field
+		}
+	}
+
+	events, err := es.store.AlarmEvents(req.Range.From, req.Range.To)
+	if err != nil {
+		return nil, fmt.Errorf("查詢告警事件失敗: %v", err)
+	}
+
+	severities := make(map[string]string)
+	for _, rule := range es.Config().Alarms {
+		severities[rule.ID] = rule.Severity
+	}
+
+	annotations := make([]*grafanaAnnotation, 0)
+	open := make(map[string]*grafanaAnnotation) // 尚未解除的告警 (規則|電表)
+	for _, event := range events {
+		severity := severities[event.RuleID]
+		if !matchAnnotationFilters(filters, event, severity) {
+			continue
+		}
+
+		key := event.RuleID + "|" + event.DeviceID
+		ms := event.Timestamp.UnixMilli()
+		if event.State == "cleared" {
+			if annotation, ok := open[key]; ok {
+				annotation.TimeEnd, annotation.IsRegion = ms, true
+				annotation.Text += "<br>解除: " + event.Message
+				delete(open, key)
+				continue
+			}
+		}
+
+		title := "告警"
+		if event.State == "cleared" {
+			title = "告警解除"
+		}
+		annotation := &grafanaAnnotation{
+			Time:  ms,
+			Title: fmt.Sprintf("%s [%s] %s", title, event.RuleID, event.DeviceID),
+			Text:  event.Message,
+			Tags:  []string{event.DeviceID, event.RuleID, event.Parameter},
+		}
+		if severity != "" {
+			annotation.Tags = append(annotation.Tags, severity)
+		}
+		annotations = append(annotations, annotation)
+		if event.State == "active" {
+			open[key] = annotation
+		}
+	}
+
+	return annotations, nil
+}
+
+func matchAnnotationFilters(filters map[string]string, event AlarmEvent, severity string) bool {
+	for key, value := range filters {
+		var actual string
+		switch key {
+		case "device":
+			actual = event.DeviceID
+		case "rule":
+			actual = event.RuleID
+		case "parameter":
+			actual = event.Parameter
+		case "severity":
+			actual = severity
+		default:
+			continue
+		}
+		if actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Infinity datasource 使用的平面資料 (參數同 /api/history，每筆一個物件)
+func (es *EnergySystem) grafanaSeries(w http.ResponseWriter, r *http.Request) {
+	query, err := es.parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := es.queryHistory(query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("歷史資料查詢失敗: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	type row struct {
+		Time   int64    `json:"time"`
+		Device string   `json:"device"`
+		Point  string   `json:"point"`
+		Value  *float64 `json:"value"`
+		Min    *float64 `json:"min,omitempty"`
+		Max    *float64 `json:"max,omitempty"`
+		Unit   string   `json:"unit"`
+	}
+	rows := make([]row, 0)
+	for _, series := range response.Series {
+		for _, point := range series.Points {
+			rows = append(rows, row{point.Timestamp.UnixMilli(), response.Device, series.Point, point.Value, point.Min, point.Max, series.Unit})
+		}
+	}
+	if response.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", response.NextCursor)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// 健康檢查 (給程序監控與負載平衡器使用，不需登入)：
+//   /healthz  程序存活
+//   /readyz   資料庫可查詢，且至少一個電表最近輪詢成功
+// 診斷資訊 (/api/v1/diagnostics，admin) 另外列出各電表連線狀態、資料庫與執行環境
+
+// 最近一次成功輪詢在電表輪詢間隔的幾倍內視為正常
+const readyPollFactor = 3
+
+// 資料庫檢查逾時
+const readyDBTimeout = 2 * time.Second
+
+// 就緒狀態，checks 為各檢查項目的結果 (ok 或失敗原因)
+type ReadyStatus struct {
+	Status string            `json:"status"` // ready 或 not_ready
+	Checks map[string]string `json:"checks"`
+}
+
+type MeterDiagnostics struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"` // 主機:連接埠
+	SlaveID   int       `json:"slave_id"`
+	Model     string    `json:"model"`
+	State     string    `json:"state"` // connected、disconnected 或 stopped (沒有收集器)
+	PollEvery int64     `json:"poll_interval_ms"`
+	Healthy   bool      `json:"healthy"` // 最近輪詢成功
+	Stats     PollStats `json:"stats"`   // 含最近的錯誤 last_error
+
+	Device *DeviceIdentification `json:"device,omitempty"` // 最近一次讀取的裝置識別 (FC43/14)
+}
+
+type DatabaseDiagnostics struct {
+	Driver        string `json:"driver"`
+	Reachable     bool   `json:"reachable"`
+	PingMs        int64  `json:"ping_ms"`
+	SchemaVersion int    `json:"schema_version"`
+	SizeBytes     int64  `json:"size_bytes"`
+	Error         string `json:"error,omitempty"`
+}
+
+type RuntimeDiagnostics struct {
+	Goroutines int    `json:"goroutines"`
+	NumCPU     int    `json:"num_cpu"`
+	HeapBytes  uint64 `json:"heap_bytes"`
+	SysBytes   uint64 `json:"sys_bytes"`
+	NumGC      uint32 `json:"num_gc"`
+}
+
+type BuildDiagnostics struct {
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Module    string `json:"module,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Revision  string `json:"revision,omitempty"`    // git commit
+	CommitAt  string `json:"commit_time,omitempty"` // commit 時間
+	Modified  bool   `json:"modified"`              // 建置時有未提交的變更
+}
+
+type Diagnostics struct {
+	Time      time.Time           `json:"time"`
+	StartedAt time.Time           `json:"started_at"`
+	UptimeMs  int64               `json:"uptime_ms"`
+	Ready     ReadyStatus         `json:"ready"`
+	Meters    []MeterDiagnostics  `json:"meters"`
+	Database  DatabaseDiagnostics `json:"database"`
+	Runtime   RuntimeDiagnostics  `json:"runtime"`
+	Build     BuildDiagnostics    `json:"build"`
+}
+
+// 電表最近是否輪詢成功
+func meterHealthy(cfg *Config, meter MeterConfig, stats PollStats, now time.Time) bool {
+	window := readyPollFactor*cfg.MeterPollInterval(meter) + cfg.Collection.Timeout
+	return !stats.LastSuccess.IsZero() && now.Sub(stats.LastSuccess) <= window
+}
+
+// 檢查資料庫與電表輪詢；訊息不含內部錯誤細節 (readyz 不需登入)
+func (es *EnergySystem) readiness() ReadyStatus {
+	status := ReadyStatus{Status: "ready", Checks: make(map[string]string)}
+	fail := func(name, message string) {
+		status.Status = "not_ready"
+		status.Checks[name] = message
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), readyDBTimeout)
+	defer cancel()
+	if err := es.store.Ping(ctx); err != nil {
+		fail("database", "無法查詢資料庫")
+	} else {
+		status.Checks["database"] = "ok"
+	}
+
+	config := es.Config()
+	now := time.Now()
+	es.collectorsMu.Lock()
+	running := es.running
+	healthy := 0
+	for _, meter := range config.Meters {
+		if collector, ok := es.collectors[meter.ID]; ok && meterHealthy(config, meter, collector.Stats(), now) {
+			healthy++
+		}
+	}
+	es.collectorsMu.Unlock()
+
+	switch {
+	case !running:
+		fail("meters", "資料收集未啟動")
+	case len(config.Meters) > 0 && healthy == 0:
+		fail("meters", "沒有電表最近輪詢成功")
+	default:
+		status.Checks["meters"] = fmt.Sprintf("ok (%d/%d)", healthy, len(config.Meters))
+	}
+	return status
+}
+
+// 程序存活
+func (es *EnergySystem) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "ok",
+		"uptime_ms": time.Since(es.startedAt).Milliseconds(),
+	})
+}
+
+// 可提供服務 (未就緒時回應 503)
+func (es *EnergySystem) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := es.readiness()
+	code := http.StatusOK
+	if status.Status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, status)
+}
+
+// 診斷資訊
+func (es *EnergySystem) DiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	config := es.Config()
+	diag := Diagnostics{
+		Time:      now,
+		StartedAt: es.startedAt,
+		UptimeMs:  now.Sub(es.startedAt).Milliseconds(),
+		Ready:     es.readiness(),
+		Meters:    make([]MeterDiagnostics, 0, len(config.Meters)),
+		Database:  es.databaseDiagnostics(),
+		Runtime:   runtimeDiagnostics(),
+		Build:     buildDiagnostics(),
+	}
+
+	es.collectorsMu.Lock()
+	for _, meter := range config.Meters {
+		m := MeterDiagnostics{
+			ID:        meter.ID,
+			Address:   fmt.Sprintf("%s:%d", meter.Host, meter.Port),
+			SlaveID:   meter.SlaveID,
+			Model:     meter.Model,
+			State:     "stopped",
+			PollEvery: config.MeterPollInterval(meter).Milliseconds(),
+		}
+		if collector, ok := es.collectors[meter.ID]; ok {
+			m.Stats = collector.Stats()
+			m.State = "disconnected"
+			if collector.Connected() {
+				m.State = "connected"
+			}
+			m.Healthy = meterHealthy(config, meter, m.Stats, now)
+		}
+		diag.Meters = append(diag.Meters, m)
+	}
+	es.collectorsMu.Unlock()
+
+	for i := range diag.Meters {
+		device, err := es.DeviceIdentification(diag.Meters[i].ID)
+		if err != nil {
+			log.Printf("⚠️ 讀取電表 %s 裝置識別失敗: %v", diag.Meters[i].ID, err)
+		}
+		diag.Meters[i].Device = device
+	}
+
+	writeJSON(w, http.StatusOK, diag)
+}
+
+func (es *EnergySystem) databaseDiagnostics() DatabaseDiagnostics {
+	diag := DatabaseDiagnostics{Driver: es.store.Driver()}
+	ctx, cancel := context.WithTimeout(context.Background(), readyDBTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := es.store.Ping(ctx); err != nil {
+		diag.Error = err.Error()
+		return diag
+	}
+	diag.Reachable = true
+	diag.PingMs = time.Since(start).Milliseconds()
+
+	var err error
+	if diag.SchemaVersion, err = es.store.SchemaVersion(); err != nil {
+		diag.Error = fmt.Sprintf("查詢結構版本失敗: %v", err)
+	}
+	if diag.SizeBytes, err = es.store.Size(); err != nil {
+		diag.Error = fmt.Sprintf("查詢資料庫大小失敗: %v", err)
+	}
+	return diag
+}
+
+func runtimeDiagnostics() RuntimeDiagnostics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return RuntimeDiagnostics{
+		Goroutines: runtime.NumGoroutine(),
+		NumCPU:     runtime.NumCPU(),
+		HeapBytes:  mem.HeapAlloc,
+		SysBytes:   mem.Sys,
+		NumGC:      mem.NumGC,
+	}
+}
+
+// 建置資訊 (go build 自動記錄模組版本與 git commit)
+func buildDiagnostics() BuildDiagnostics {
+	build := BuildDiagnostics{GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	build.Module = info.Main.Path
+	build.Version = info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.CommitAt = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 歷史資料查詢的點數上限
+const (
+	defaultHistoryPoints = 1000
+	maxHistoryPoints     = 10000
+)
+
+// 歷史資料降採樣方式
+var historyMethods = []string{"raw", "avg", "minmax", "lttb"}
+
+// 歷史資料查詢條件
+type HistoryQuery struct {
+	DeviceID   string
+	Points     []string
+	From       time.Time // 含
+	To         time.Time // 含
+	MaxPoints  int       // 每個點位最多回傳的筆數
+	Method     string
+	IncludeBad bool
+	After      int64 // 分頁游標 (上一頁最後一筆的時間，毫秒)
+}
+
+// 歷史資料的一筆數值
+// raw 為原始取樣；avg 為時間區間平均；minmax 另帶區間最小/最大值 (包絡線)；lttb 為挑選出的原始取樣
+type HistoryPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     *float64  `json:"value"`
+	Min       *float64  `json:"min,omitempty"`
+	Max       *float64  `json:"max,omitempty"`
+	Count     int       `json:"count,omitempty"`
+	Quality   string    `json:"quality,omitempty"`
+}
+
+// 單一點位的歷史資料
+type HistorySeries struct {
+	Point  string         `json:"point"`
+	Unit   string         `json:"unit"`
+	Total  int            `json:"total"`  // 查詢區間內的原始筆數
+	Method string         `json:"method"` // 實際使用的方式 (資料量未超過上限時為 raw)
+	Points []HistoryPoint `json:"points"`
+}
+
+// 歷史資料回應
+type HistoryResponse struct {
+	Device     string          `json:"device"`
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Method     string          `json:"method"`
+	MaxPoints  int             `json:"max_points"`
+	BucketMs   int64           `json:"bucket_ms,omitempty"`
+	Series     []HistorySeries `json:"series"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// 獲取歷史資料 (原始資料分頁或降採樣)
+func (es *EnergySystem) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, err := es.parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := es.queryHistory(query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("歷史資料查詢失敗: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// 解析查詢參數
+// points 可用逗號分隔，或重複 point 參數；from/to 接受 RFC3339、日期或毫秒時間戳記
+func (es *EnergySystem) parseHistoryQuery(values url.Values) (*HistoryQuery, error) {
+	query := &HistoryQuery{
+		DeviceID:   values.Get("device"),
+		MaxPoints:  defaultHistoryPoints,
+		Method:     "avg",
+		IncludeBad: values.Get("quality") == "all",
+	}
+
+	if query.DeviceID == "" {
+		query.DeviceID = es.Config().Meters[0].ID
+	}
+
+	query.Points = append(query.Points, values["point"]...)
+	for _, point := range strings.Split(values.Get("points"), ",") {
+		if point = strings.TrimSpace(point); point != "" {
+			query.Points = append(query.Points, point)
+		}
+	}
+	if len(query.Points) == 0 {
+		return nil, fmt.Errorf("缺少必要參數: points")
+	}
+
+	query.To = time.Now()
+	if value := values.Get("to"); value != "" {
+		to, err := parseHistoryEnd(value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("to 格式錯誤: %v", err)
+		}
+		query.To = to
+	}
+	query.From = query.To.Add(-24 * time.Hour)
+	if value := values.Get("from"); value != "" {
+		from, err := parseHistoryTime(value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("from 格式錯誤: %v", err)
+		}
+		query.From = from
+	}
+	if query.From.After(query.To) {
+		return nil, fmt.Errorf("from 不可晚於 to")
+	}
+
+	if value := values.Get("method"); value != "" {
+		query.Method = value
+	}
+	validMethod := false
+	for _, method := range historyMethods {
+		if method == query.Method {
+			validMethod = true
+		}
+	}
+	if !validMethod {
+		return nil, fmt.Errorf("不支援的降採樣方式: %s (可用: %s)", query.Method, strings.Join(historyMethods, ", "))
+	}
+
+	if value := values.Get("max_points"); value != "" {
+		maxPoints, err := strconv.Atoi(value)
+		if err != nil || maxPoints < 1 || maxPoints > maxHistoryPoints {
+			return nil, fmt.Errorf("max_points 必須介於 1 到 %d", maxHistoryPoints)
+		}
+		query.MaxPoints = maxPoints
+	}
+	if query.Method == "lttb" && query.MaxPoints < 3 {
+		return nil, fmt.Errorf("lttb 的 max_points 至少為 3")
+	}
+
+	query.After = query.From.UnixMilli() - 1
+	if value := values.Get("cursor"); value != "" {
+		after, err := decodeHistoryCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		if after > query.After {
+			query.After = after
+		}
+	}
+
+	return query, nil
+}
+
+// 解析時間參數 (未帶時區的時間以 loc 解讀)
+func parseHistoryTime(value string, loc *time.Location) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return ts, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02", "2006/01/02 15:04:05", "2006/1/2 15:04:05", "2006/01/02 15:04", "2006/01/02"} {
+		if ts, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("無法解析時間: %s", value)
+}
+
+// 解析結束時間；只有日期時包含整天 (到當天最後一毫秒)
+func parseHistoryEnd(value string, loc *time.Location) (time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return day.AddDate(0, 0, 1).Add(-time.Millisecond), nil
+	}
+	return parseHistoryTime(value, loc)
+}
+
+// 分頁游標 (不透明字串，內容為上一頁最後一筆的毫秒時間戳記)
+func encodeHistoryCursor(after int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("v1:" + strconv.FormatInt(after, 10)))
+}
+
+func decodeHistoryCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), "v1:") {
+		return 0, fmt.Errorf("無效的 cursor")
+	}
+	after, err := strconv.ParseInt(strings.TrimPrefix(string(raw), "v1:"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("無效的 cursor")
+	}
+	return after, nil
+}
+
+// 查詢歷史資料
+func (es *EnergySystem) queryHistory(query *HistoryQuery) (*HistoryResponse, error) {
+	response := &HistoryResponse{
+		Device:    query.DeviceID,
+		From:      query.From,
+		To:        query.To,
+		Method:    query.Method,
+		MaxPoints: query.MaxPoints,
+		Series:    make([]HistorySeries, 0, len(query.Points)),
+	}
+
+	for _, point := range query.Points {
+		total, unit, err := es.countHistory(query, point)
+		if err != nil {
+			return nil, err
+		}
+		response.Series = append(response.Series, HistorySeries{Point: point, Unit: unit, Total: total, Method: "raw"})
+	}
+
+	if query.Method == "raw" {
+		if err := es.pageRawHistory(query, response); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+
+	// 降採樣：區間平均分成 buckets 段 (lttb 保留首尾兩筆)
+	buckets := int64(query.MaxPoints)
+	if query.Method == "lttb" {
+		buckets -= 2
+	}
+	span := query.To.UnixMilli() - query.From.UnixMilli() + 1
+	bucketMs := (span + buckets - 1) / buckets
+	if bucketMs < 1 {
+		bucketMs = 1
+	}
+	response.BucketMs = bucketMs
+
+	for i := range response.Series {
+		series := &response.Series[i]
+
+		var err error
+		switch {
+		case series.Total <= query.MaxPoints:
+			// 資料量未超過上限，直接回傳原始資料
+			series.Points, err = es.rawHistory(query, series.Point, query.After, query.MaxPoints)
+		case query.Method == "lttb":
+			series.Method = query.Method
+			series.Points, err = es.lttbHistory(query, series.Point, bucketMs)
+		default:
+			series.Method = query.Method
+			series.Points, err = es.bucketHistory(query, series.Point, bucketMs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// 查詢條件中的品質篩選；原始資料且包含異常時連同沒有數值的讀值一併回傳
+func historyQualityFilter(query *HistoryQuery) QualityFilter {
+	if query.Method == "raw" && query.IncludeBad {
+		return QualityFilterAll
+	}
+	return usableFilter(query.IncludeBad)
+}
+
+// 點位在時間 after 之後 (不含) 的查詢條件
+func historySampleQuery(query *HistoryQuery, point string, after int64) SampleQuery {
+	return SampleQuery{
+		DeviceID: query.DeviceID,
+		Point:    point,
+		From:     time.UnixMilli(after + 1),
+		To:       query.To,
+		Quality:  historyQualityFilter(query),
+	}
+}
+
+// 區間內的筆數與單位
+func (es *EnergySystem) countHistory(query *HistoryQuery, point string) (int, string, error) {
+	return es.store.CountSamples(historySampleQuery(query, point, query.From.UnixMilli()-1))
+}
+
+// 原始資料 (時間大於 after，最多 limit 筆)
+func (es *EnergySystem) rawHistory(query *HistoryQuery, point string, after int64, limit int) ([]HistoryPoint, error) {
+	sampleQuery := historySampleQuery(query, point, after)
+	sampleQuery.Limit = limit
+
+	points := make([]HistoryPoint, 0)
+	err := forEachSample(es.store, sampleQuery, func(sample Sample) error {
+		points = append(points, HistoryPoint{Timestamp: sample.Timestamp, Value: sample.Value, Quality: sample.Quality})
+		return nil
+	})
+	return points, err
+}
+
+// 原始資料分頁：每個點位最多 max_points 筆
+// 有點位被截斷時，所有點位都截到同一個時間點，下一頁從該時間之後繼續，避免重複或遺漏
+func (es *EnergySystem) pageRawHistory(query *HistoryQuery, response *HistoryResponse) error {
+	pageEnd := int64(math.MaxInt64)
+	truncated := false
+
+	for i := range response.Series {
+		series := &response.Series[i]
+
+		points, err := es.rawHistory(query, series.Point, query.After, query.MaxPoints+1)
+		if err != nil {
+			return err
+		}
+		if len(points) > query.MaxPoints {
+			points = points[:query.MaxPoints]
+			if last := points[len(points)-1].Timestamp.UnixMilli(); last < pageEnd {
+				pageEnd = last
+			}
+			truncated = true
+		}
+		series.Points = points
+	}
+
+	if !truncated {
+		return nil
+	}
+
+	for i := range response.Series {
+		series := &response.Series[i]
+		n := len(series.Points)
+		for n > 0 && series.Points[n-1].Timestamp.UnixMilli() > pageEnd {
+			n--
+		}
+		series.Points = series.Points[:n]
+	}
+	response.NextCursor = encodeHistoryCursor(pageEnd)
+	return nil
+}
+
+// 固定時間區間的平均值 (avg) 或最小/最大包絡線 (minmax)
+func (es *EnergySystem) bucketHistory(query *HistoryQuery, point string, bucketMs int64) ([]HistoryPoint, error) {
+	from := query.From.UnixMilli()
+
+	points := make([]HistoryPoint, 0)
+	err := es.store.BucketSamples(historySampleQuery(query, point, query.After), query.From, time.Duration(bucketMs)*time.Millisecond,
+		func(bucket SampleBucket) error {
+			point := HistoryPoint{Timestamp: time.UnixMilli(from + bucket.Index*bucketMs), Value: floatPtr(bucket.Avg), Count: bucket.Count}
+			if query.Method == "minmax" {
+				point.Min, point.Max = floatPtr(bucket.Min), floatPtr(bucket.Max)
+			}
+			points = append(points, point)
+			return nil
+		})
+	return points, err
+}
+
+// Largest-Triangle-Three-Buckets 降採樣
+// 先計算各區間的平均點，再依時間順序逐筆讀取原始資料，
+// 每個區間挑出與前一個選取點及下一區間平均點構成最大三角形面積的點，記憶體用量只與 max_points 有關
+func (es *EnergySystem) lttbHistory(query *HistoryQuery, point string, bucketMs int64) ([]HistoryPoint, error) {
+	from := query.From.UnixMilli()
+	sampleQuery := historySampleQuery(query, point, query.After)
+
+	var averages []SampleBucket
+	err := es.store.BucketSamples(sampleQuery, query.From, time.Duration(bucketMs)*time.Millisecond, func(bucket SampleBucket) error {
+		averages = append(averages, bucket)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]HistoryPoint, 0, query.MaxPoints)
+	appendPoint := func(ts int64, value float64) {
+		points = append(points, HistoryPoint{Timestamp: time.UnixMilli(ts), Value: floatPtr(value)})
+	}
+
+	first := true
+	current := -1                         // 目前區間在 averages 中的位置
+	var selectedTs, selectedValue float64 // 前一個選取點 (相對 from 的毫秒)
+	var nextTs, nextValue float64         // 下一區間的平均點
+	var bestTs, lastTs int64
+	var bestValue, lastValue float64
+	bestArea := -1.0
+
+	// 輸出目前區間面積最大的點，並作為下一區間的前一個選取點
+	flushBest := func() {
+		if bestArea >= 0 {
+			appendPoint(bestTs, bestValue)
+			selectedTs, selectedValue = float64(bestTs-from), bestValue
+		}
+		bestArea = -1
+	}
+
+	err = forEachSample(es.store, sampleQuery, func(sample Sample) error {
+		ts, value := sample.Timestamp.UnixMilli(), *sample.Value
+		lastTs, lastValue = ts, value
+
+		// 第一筆一定保留
+		if first {
+			first = false
+			appendPoint(ts, value)
+			selectedTs, selectedValue = float64(ts-from), value
+			return nil
+		}
+
+		bucket := (ts - from) / bucketMs
+		if current < 0 || averages[current].Index != bucket {
+			flushBest()
+			for current+1 < len(averages) && averages[current+1].Index <= bucket {
+				current++
+			}
+			// 下一區間的平均點；最後一個區間以自身平均代替
+			next := averages[current]
+			if current+1 < len(averages) {
+				next = averages[current+1]
+			}
+			nextTs, nextValue = next.MeanMs, next.Avg
+		}
+
+		x := float64(ts - from)
+		area := math.Abs((selectedTs-nextTs)*(value-selectedValue)-(selectedTs-x)*(nextValue-selectedValue)) / 2
+		if area > bestArea {
+			bestArea, bestTs, bestValue = area, ts, value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	flushBest()
+	// 最後一筆一定保留
+	if !first && len(points) > 0 && points[len(points)-1].Timestamp.UnixMilli() != lastTs {
+		appendPoint(lastTs, lastValue)
+	}
+
+	return points, nil
+}
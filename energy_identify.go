@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// 讀取裝置識別 (FC43 / MEI 14)：每條連線建立後，第一次輪詢成功時讀取一次，
+// 結果存於設定 device_id.<電表 ID>，廠牌、型號或韌體版本與上次不同時記錄變更
+// (例如更換電表或韌體更新)。電表不支援時只記錄一次，直到重新連線
+
+const (
+	modbusEncapsulatedTransport = 0x2B
+	meiReadDeviceID             = 0x0E
+
+	deviceIDBasic   = 0x01 // 基本物件 (0x00~0x02)
+	deviceIDRegular = 0x02 // 一般物件 (0x00~0x06)
+
+	// 防止電表持續回應 more follows 造成無窮迴圈
+	deviceIDMaxRequests = 16
+)
+
+// 裝置識別物件
+type DeviceIdentification struct {
+	VendorName  string            `json:"vendor_name,omitempty"`  // 0x00
+	ProductCode string            `json:"product_code,omitempty"` // 0x01
+	Revision    string            `json:"revision,omitempty"`     // 0x02 (韌體版本)
+	VendorURL   string            `json:"vendor_url,omitempty"`   // 0x03
+	ProductName string            `json:"product_name,omitempty"` // 0x04
+	ModelName   string            `json:"model_name,omitempty"`   // 0x05
+	Objects     map[string]string `json:"objects,omitempty"`      // 其他物件 (0x06 之後，鍵為十六進位物件 ID)
+	Conformity  byte              `json:"conformity_level"`
+	ReadAt      time.Time         `json:"read_at"`
+	ChangedAt   time.Time         `json:"changed_at"` // 識別內容最後一次變更的時間
+}
+
+// 識別內容是否相同 (不比較讀取時間)
+func (d *DeviceIdentification) sameAs(other *DeviceIdentification) bool {
+	if d.VendorName != other.VendorName || d.ProductCode != other.ProductCode || d.Revision != other.Revision ||
+		d.VendorURL != other.VendorURL || d.ProductName != other.ProductName || d.ModelName != other.ModelName ||
+		len(d.Objects) != len(other.Objects) {
+		return false
+	}
+	for id, value := range d.Objects {
+		if other.Objects[id] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *DeviceIdentification) set(id byte, value string) {
+	switch id {
+	case 0x00:
+		d.VendorName = value
+	case 0x01:
+		d.ProductCode = value
+	case 0x02:
+		d.Revision = value
+	case 0x03:
+		d.VendorURL = value
+	case 0x04:
+		d.ProductName = value
+	case 0x05:
+		d.ModelName = value
+	default:
+		if d.Objects == nil {
+			d.Objects = make(map[string]string)
+		}
+		d.Objects[fmt.Sprintf("0x%02X", id)] = value
+	}
+}
+
+func deviceIDSettingKey(meterID string) string {
+	return "device_id." + meterID
+}
+
+// 讀取已記錄的裝置識別
+func (es *EnergySystem) DeviceIdentification(meterID string) (*DeviceIdentification, error) {
+	value, ok, err := es.store.GetSetting(deviceIDSettingKey(meterID))
+	if err != nil || !ok {
+		return nil, err
+	}
+	var device DeviceIdentification
+	if err := json.Unmarshal([]byte(value), &device); err != nil {
+		return nil, fmt.Errorf("解析裝置識別失敗: %v", err)
+	}
+	return &device, nil
+}
+
+// 本次連線尚未讀取時讀取裝置識別並記錄 (呼叫端需持有 connMu 且已連線)
+func (mc *MeterCollector) identifyDevice() {
+	if mc.identified || mc.handler == nil {
+		return
+	}
+	mc.identified = true
+	id := mc.ID()
+
+	device, err := readDeviceIdentification(mc.handler, deviceIDRegular)
+	var modbusErr *modbus.ModbusError
+	if errors.As(err, &modbusErr) && modbusErr.ExceptionCode == modbusIllegalValue {
+		// 只支援基本物件
+		device, err = readDeviceIdentification(mc.handler, deviceIDBasic)
+	}
+	if err != nil {
+		if errors.As(err, &modbusErr) {
+			log.Printf("ℹ️ 電表 %s 不支援裝置識別 (FC43/14): %v", id, err)
+		} else {
+			log.Printf("⚠️ 讀取電表 %s 裝置識別失敗: %v", id, err)
+		}
+		return
+	}
+
+	if err := mc.system.recordDeviceIdentification(id, device); err != nil {
+		log.Printf("⚠️ 記錄電表 %s 裝置識別失敗: %v", id, err)
+	}
+}
+
+// 儲存裝置識別，與上次記錄不同時寫入日誌
+func (es *EnergySystem) recordDeviceIdentification(meterID string, device *DeviceIdentification) error {
+	previous, err := es.DeviceIdentification(meterID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case previous == nil:
+		device.ChangedAt = device.ReadAt
+		log.Printf("🏷️ 電表 %s 裝置識別: %s %s %s (韌體 %s)", meterID, device.VendorName, device.ProductCode, device.ModelName, device.Revision)
+	case !previous.sameAs(device):
+		device.ChangedAt = device.ReadAt
+		log.Printf("🏷️ 電表 %s 裝置識別變更: %s %s (韌體 %s) → %s %s (韌體 %s)", meterID,
+			previous.VendorName, previous.ProductCode, previous.Revision, device.VendorName, device.ProductCode, device.Revision)
+	default:
+		device.ChangedAt = previous.ChangedAt
+	}
+
+	value, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	return es.store.PutSetting(deviceIDSettingKey(meterID), string(value))
+}
+
+// 以 FC43/14 循序讀取 (stream access) 全部物件；goburrow/modbus 沒有提供此功能碼，直接組封包
+func readDeviceIdentification(handler *modbus.TCPClientHandler, readCode byte) (*DeviceIdentification, error) {
+	now := time.Now()
+	device := &DeviceIdentification{ReadAt: now, ChangedAt: now}
+	objectID := byte(0)
+	for i := 0; i < deviceIDMaxRequests; i++ {
+		data, err := sendDeviceIDRequest(handler, readCode, objectID)
+		if err != nil {
+			return nil, err
+		}
+
+		// MEI 類型、讀取代碼、符合等級、more follows、下一個物件 ID、物件數量
+		if len(data) < 6 || data[0] != meiReadDeviceID {
+			return nil, fmt.Errorf("裝置識別回應格式錯誤 (%d bytes)", len(data))
+		}
+		device.Conformity = data[2]
+		moreFollows, nextID, count := data[3], data[4], int(data[5])
+
+		offset := 6
+		for j := 0; j < count; j++ {
+			if offset+2 > len(data) || offset+2+int(data[offset+1]) > len(data) {
+				return nil, fmt.Errorf("裝置識別回應長度不足 (物件 %d)", j)
+			}
+			length := int(data[offset+1])
+			device.set(data[offset], string(data[offset+2:offset+2+length]))
+			offset += 2 + length
+		}
+
+		if moreFollows != 0xFF {
+			return device, nil
+		}
+		objectID = nextID
+	}
+	return nil, fmt.Errorf("裝置識別回應超過 %d 次仍未結束", deviceIDMaxRequests)
+}
+
+// 傳送一次 FC43/14 請求，回傳 PDU 資料 (不含功能碼)
+func sendDeviceIDRequest(handler *modbus.TCPClientHandler, readCode, objectID byte) ([]byte, error) {
+	request := &modbus.ProtocolDataUnit{
+		FunctionCode: modbusEncapsulatedTransport,
+		Data:         []byte{meiReadDeviceID, readCode, objectID},
+	}
+	adu, err := handler.Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	aduResponse, err := handler.Send(adu)
+	if err != nil {
+		return nil, err
+	}
+	if err := handler.Verify(adu, aduResponse); err != nil {
+		return nil, err
+	}
+	response, err := handler.Decode(aduResponse)
+	if err != nil {
+		return nil, err
+	}
+	if response.FunctionCode == modbusEncapsulatedTransport|0x80 {
+		modbusErr := &modbus.ModbusError{FunctionCode: response.FunctionCode}
+		if len(response.Data) > 0 {
+			modbusErr.ExceptionCode = response.Data[0]
+		}
+		return nil, modbusErr
+	}
+	if response.FunctionCode != modbusEncapsulatedTransport {
+		return nil, fmt.Errorf("裝置識別回應功能碼錯誤 (0x%02X)", response.FunctionCode)
+	}
+	return response.Data, nil
+}
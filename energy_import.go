@@ -0,0 +1,907 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 匯入報告最多保留的拒絕明細
+const maxImportErrors = 100
+
+// CSV 欄位對應
+// 長格式：每列一筆數值 (時間、點位、數值，可選電表、單位、品質)
+// 寬格式：每列一個時間，其餘每欄為一個點位 (欄位名稱可為 "點位 (單位)")
+// 欄位名稱未指定時依常見名稱自動判斷 (包含 export 匯出的中英文欄位)
+type ImportMapping struct {
+	Delimiter  string            `yaml:"delimiter" json:"delimiter"`     // 預設為逗號，可設為 tab 或 ;
+	Time       string            `yaml:"time" json:"time"`               // 時間欄位
+	Date       string            `yaml:"date" json:"date"`               // 日期與時間分成兩欄時的日期欄位
+	TimeFormat string            `yaml:"time_format" json:"time_format"` // Go 時間格式，空白時自動判斷
+	Device     string            `yaml:"device" json:"device"`
+	Point      string            `yaml:"point" json:"point"`
+	Value      string            `yaml:"value" json:"value"`
+	Unit       string            `yaml:"unit" json:"unit"`
+	Quality    string            `yaml:"quality" json:"quality"`
+	Columns    map[string]string `yaml:"columns" json:"columns"` // 寬格式：欄位名稱 → 點位名稱 (設定後只匯入列出的欄位)
+	Units      map[string]string `yaml:"units" json:"units"`     // 點位 → 單位 (檔案未提供單位時使用)
+	Points     map[string]string `yaml:"points" json:"points"`   // 點位名稱轉換，例如 LabVIEW 的 Frequency → 頻率
+}
+
+// 自動判斷欄位時使用的名稱 (不分大小寫，忽略括號內的單位或時區)
+var importColumnNames = map[string][]string{
+	"time":    {"時間", "期間起始", "time", "timestamp", "datetime", "period start"},
+	"date":    {"日期", "date"},
+	"device":  {"電表", "meter", "device", "device_id"},
+	"point":   {"點位", "參數", "point", "parameter", "name"},
+	"value":   {"數值", "value"},
+	"unit":    {"單位", "unit"},
+	"quality": {"品質", "quality"},
+}
+
+// 匯入選項
+type ImportOptions struct {
+	Format   string // csv、json 或 lp，空白時依副檔名或內容判斷
+	DeviceID string // 檔案沒有電表欄位時使用 (預設為第一個電表)
+	Mapping  ImportMapping
+	Location *time.Location // 未帶時區的時間以此時區解讀
+	DryRun   bool           // 只檢查不寫入
+
+	Precision time.Duration // line protocol 時間戳記精度，0 表示依位數判斷
+}
+
+// 匯入結果摘要
+type ImportReport struct {
+	Source     string         `json:"source"`
+	Format     string         `json:"format"`
+	DryRun     bool           `json:"dry_run"`
+	Rows       int            `json:"rows"`       // 讀取的數值筆數
+	Accepted   int            `json:"accepted"`   // 寫入 (或 dry run 時可寫入) 的筆數
+	Duplicates int            `json:"duplicates"` // 電表+點位+時間已存在而略過的筆數
+	Rejected   int            `json:"rejected"`
+	Reasons    map[string]int `json:"reasons,omitempty"` // 拒絕原因統計
+	Errors     []ImportError  `json:"errors,omitempty"`  // 拒絕明細 (最多 100 筆)
+	From       *time.Time     `json:"from,omitempty"`    // 匯入資料的時間範圍
+	To         *time.Time     `json:"to,omitempty"`
+	Error      string         `json:"error,omitempty"` // 無法處理整個檔案時的錯誤
+}
+
+// 單筆拒絕明細
+type ImportError struct {
+	Line   int    `json:"line,omitempty"`
+	Point  string `json:"point,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// 匯入單一檔案
+func (es *EnergySystem) Import(r io.Reader, source string, options ImportOptions) (*ImportReport, error) {
+	if options.Location == nil {
+		options.Location = time.Local
+	}
+	if options.DeviceID == "" {
+		options.DeviceID = es.Config().Meters[0].ID
+	}
+
+	// 判斷格式
+	br := bufio.NewReader(r)
+	format := options.Format
+	if format == "" {
+		format = detectImportFormat(source, br)
+	}
+	if format != "csv" && format != "json" && format != "lp" {
+		return nil, fmt.Errorf("不支援的匯入格式: %s", format)
+	}
+
+	report := &ImportReport{Source: source, Format: format, DryRun: options.DryRun, Reasons: make(map[string]int)}
+	im, err := es.newImporter(options, report)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "csv":
+		err = im.importCSV(br)
+	case "lp":
+		err = im.importLineProtocol(br)
+	default:
+		err = im.importJSON(br)
+	}
+	if closeErr := im.close(err == nil); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report, err
+}
+
+// 依副檔名或內容判斷格式
+func detectImportFormat(source string, br *bufio.Reader) string {
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".json":
+		return "json"
+	case ".lp":
+		return "lp"
+	case ".csv", ".txt", ".tsv":
+		return "csv"
+	}
+
+	head, _ := br.Peek(512)
+	head = bytes.TrimPrefix(head, []byte("\ufeff"))
+	head = bytes.TrimSpace(head)
+	if len(head) > 0 && (head[0] == '[' || head[0] == '{') {
+		return "json"
+	}
+	return "csv"
+}
+
+// 匯入處理 (分批交易寫入)
+type importer struct {
+	es      *EnergySystem
+	options ImportOptions
+	report  *ImportReport
+
+	writer SampleWriter
+	units  map[string]string // 電表|點位 → 預期單位
+}
+
+func (es *EnergySystem) newImporter(options ImportOptions, report *ImportReport) (*importer, error) {
+	// dry run 寫入暫存資料表，用來偵測檔案內重複，不修改資料
+	writer, err := es.store.OpenSampleWriter(options.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	return &importer{es: es, options: options, report: report, writer: writer, units: make(map[string]string)}, nil
+}
+
+// 結束匯入 (失敗時放棄尚未提交的批次)
+func (im *importer) close(ok bool) error {
+	return im.writer.Close(ok)
+}
+
+// 記錄拒絕的數值
+func (im *importer) reject(line int, point, reason, detail string, count int) {
+	im.report.Rows += count
+	im.report.Rejected += count
+	im.report.Reasons[reason] += count
+	if len(im.report.Errors) < maxImportErrors {
+		message := reason
+		if detail != "" {
+			message += ": " + detail
+		}
+		im.report.Errors = append(im.report.Errors, ImportError{Line: line, Point: point, Reason: message})
+	}
+}
+
+// 點位的預期單位：暫存器對照表，其次為資料庫中既有的紀錄，再其次為本次匯入第一筆的單位
+func (im *importer) expectedUnit(device, point string) (string, error) {
+	key := device + "|" + point
+	if unit, ok := im.units[key]; ok {
+		return unit, nil
+	}
+
+	unit := ""
+	config := im.es.Config()
+	if meter, ok := config.Meter(device); ok {
+		parameters, _ := config.RegisterMap(meter.Model)
+		for _, param := range parameters {
+			if param.Name == point {
+				unit = param.Unit
+			}
+		}
+	}
+	if unit == "" {
+		var err error
+		if unit, err = im.es.store.LatestUnit(device, point); err != nil {
+			return "", err
+		}
+	}
+
+	if unit != "" {
+		im.units[key] = unit
+	}
+	return unit, nil
+}
+
+// 驗證並寫入一筆數值
+func (im *importer) add(line int, device, point string, ts time.Time, valueText, unit, quality string) error {
+	if mapped, ok := im.options.Mapping.Points[point]; ok {
+		point = mapped
+	}
+	if device == "" {
+		device = im.options.DeviceID
+	}
+	if point == "" {
+		im.reject(line, point, "缺少點位", "", 1)
+		return nil
+	}
+
+	quality = strings.TrimSpace(quality)
+	if quality == "" {
+		quality = QualityGood
+	}
+	if !isQualityCode(quality) {
+		im.reject(line, point, "品質代碼無效", quality, 1)
+		return nil
+	}
+
+	var value *float64
+	valueText = strings.TrimSpace(valueText)
+	switch {
+	case valueText == "" && qualityHasValue(quality):
+		im.reject(line, point, "缺少數值", "", 1)
+		return nil
+	case valueText != "":
+		v, err := strconv.ParseFloat(valueText, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			im.reject(line, point, "數值格式錯誤", valueText, 1)
+			return nil
+		}
+		value = &v
+	}
+
+	unit = strings.TrimSpace(unit)
+	if unit == "" {
+		unit = im.options.Mapping.Units[point]
+	}
+	expected, err := im.expectedUnit(device, point)
+	if err != nil {
+		return err
+	}
+	switch {
+	case expected != "" && unit != "" && unit != expected:
+		im.reject(line, point, "單位不符", fmt.Sprintf("預期 %s，實際 %s", expected, unit), 1)
+		return nil
+	case unit == "":
+		unit = expected
+	case expected == "":
+		im.units[device+"|"+point] = unit
+	}
+
+	inserted, err := im.writer.Insert(Sample{DeviceID: device, Point: point, Timestamp: ts, Value: value, Unit: unit, Quality: quality})
+	if err != nil {
+		return err
+	}
+
+	im.report.Rows++
+	if !inserted {
+		im.report.Duplicates++
+	} else {
+		im.report.Accepted++
+		if im.report.From == nil || ts.Before(*im.report.From) {
+			from := ts
+			im.report.From = &from
+		}
+		if im.report.To == nil || ts.After(*im.report.To) {
+			to := ts
+			im.report.To = &to
+		}
+	}
+	return nil
+}
+
+// 解析時間欄位
+func (im *importer) parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if layout := im.options.Mapping.TimeFormat; layout != "" {
+		return time.ParseInLocation(layout, value, im.options.Location)
+	}
+	return parseHistoryTime(value, im.options.Location)
+}
+
+// CSV 欄位
+type importColumn struct {
+	index int
+	point string
+	unit  string
+}
+
+// 匯入 CSV (逐列串流讀取)
+func (im *importer) importCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	switch delimiter := im.options.Mapping.Delimiter; delimiter {
+	case "", ",":
+	case "tab", `\t`:
+		reader.Comma = '\t'
+	default:
+		reader.Comma, _ = utf8.DecodeRuneInString(delimiter)
+	}
+
+	record, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("讀取 CSV 標題列失敗: %v", err)
+	}
+	header := make([]string, len(record))
+	for i, name := range record {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+	}
+
+	mapping := im.options.Mapping
+	find := func(configured, key string) (int, error) {
+		if configured != "" {
+			for i, name := range header {
+				if name == configured {
+					return i, nil
+				}
+			}
+			return -1, fmt.Errorf("找不到欄位: %s", configured)
+		}
+		for i, name := range header {
+			base, _ := splitHeaderUnit(name)
+			for _, candidate := range importColumnNames[key] {
+				if strings.EqualFold(name, candidate) || strings.EqualFold(base, candidate) {
+					return i, nil
+				}
+			}
+		}
+		return -1, nil
+	}
+
+	columns := make(map[string]int)
+	for _, field := range []struct{ key, configured string }{
+		{"time", mapping.Time}, {"date", mapping.Date}, {"device", mapping.Device}, {"point", mapping.Point},
+		{"value", mapping.Value}, {"unit", mapping.Unit}, {"quality", mapping.Quality},
+	} {
+		index, err := find(field.configured, field.key)
+		if err != nil {
+			return err
+		}
+		columns[field.key] = index
+	}
+	if columns["time"] < 0 {
+		return fmt.Errorf("找不到時間欄位，請以 time 指定欄位名稱")
+	}
+
+	long := columns["point"] >= 0 && columns["value"] >= 0
+
+	// 寬格式：其餘欄位都是點位
+	var points []importColumn
+	if !long {
+		used := make(map[int]bool)
+		for _, index := range columns {
+			if index >= 0 {
+				used[index] = true
+			}
+		}
+		for i, name := range header {
+			if used[i] || name == "" {
+				continue
+			}
+			if len(mapping.Columns) > 0 {
+				if point, ok := mapping.Columns[name]; ok {
+					points = append(points, importColumn{index: i, point: point})
+				}
+				continue
+			}
+			point, unit := im.headerPoint(name)
+			points = append(points, importColumn{index: i, point: point, unit: unit})
+		}
+		if len(points) == 0 {
+			return fmt.Errorf("找不到點位欄位 (長格式需要點位與數值欄位，寬格式需要點位欄)")
+		}
+	}
+
+	field := func(record []string, key string) string {
+		if index := columns[key]; index >= 0 && index < len(record) {
+			return record[index]
+		}
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line, _ := reader.FieldPos(0)
+		values := 1
+		if !long {
+			values = len(points)
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			im.reject(parseErr.Line, "", "CSV 格式錯誤", parseErr.Err.Error(), values)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		timeText := field(record, "time")
+		if date := field(record, "date"); date != "" {
+			timeText = date + " " + timeText
+		}
+		ts, err := im.parseTime(timeText)
+		if err != nil {
+			im.reject(line, "", "時間格式錯誤", timeText, values)
+			continue
+		}
+		device := strings.TrimSpace(field(record, "device"))
+
+		if long {
+			err = im.add(line, device, strings.TrimSpace(field(record, "point")), ts,
+				field(record, "value"), field(record, "unit"), field(record, "quality"))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, column := range points {
+			value := ""
+			if column.index < len(record) {
+				value = record[column.index]
+			}
+			// 寬格式的空白欄位表示該時間沒有資料
+			if strings.TrimSpace(value) == "" {
+				continue
+			}
+			if err := im.add(line, device, column.point, ts, value, column.unit, field(record, "quality")); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 寬格式的欄位名稱 → 點位與單位
+// "相電壓平均值 (V)" 拆成點位與單位；整個名稱本身是已知點位時 (例如 "電流諧波失真率 (2)") 不拆
+func (im *importer) headerPoint(name string) (string, string) {
+	if _, ok := im.options.Mapping.Points[name]; ok {
+		return name, ""
+	}
+	config := im.es.Config()
+	if meter, ok := config.Meter(im.options.DeviceID); ok {
+		parameters, _ := config.RegisterMap(meter.Model)
+		for _, param := range parameters {
+			if param.Name == name {
+				return name, ""
+			}
+		}
+	}
+	return splitHeaderUnit(name)
+}
+
+// 拆解 "名稱 (單位)"
+func splitHeaderUnit(name string) (string, string) {
+	if strings.HasSuffix(name, ")") {
+		if open := strings.LastIndex(name, " ("); open > 0 {
+			return strings.TrimSpace(name[:open]), strings.TrimSpace(name[open+2 : len(name)-1])
+		}
+	}
+	return name, ""
+}
+
+// MeterData 格式的一筆 (數值可為字串或數字)
+type importEntry struct {
+	Index   int             `json:"index"`
+	Name    string          `json:"name"`
+	Value   json.RawMessage `json:"value"`
+	Unit    string          `json:"unit"`
+	Quality string          `json:"quality"`
+}
+
+// 匯入 MeterData JSON：LabVIEW 時期的 final.json 陣列 (以 Date/Time 項目表示時間)
+// 或快照文件 {generation, timestamp, data}
+func (im *importer) importJSON(r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	content = bytes.TrimPrefix(content, []byte("\ufeff"))
+
+	var document struct {
+		Timestamp time.Time     `json:"timestamp"`
+		Data      []importEntry `json:"data"`
+	}
+	var entries []importEntry
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(content, &document); err != nil {
+			return fmt.Errorf("JSON 解析失敗: %v", err)
+		}
+		entries = document.Data
+	} else if err := json.Unmarshal(content, &entries); err != nil {
+		return fmt.Errorf("JSON 解析失敗: %v", err)
+	}
+
+	// Date/Time 項目是時間，不是點位
+	var date, clock string
+	readings := make([]importEntry, 0, len(entries))
+	for _, entry := range entries {
+		switch strings.ToLower(entry.Name) {
+		case "date", "日期":
+			date = jsonText(entry.Value)
+		case "time", "時間":
+			clock = jsonText(entry.Value)
+		default:
+			readings = append(readings, entry)
+		}
+	}
+
+	ts := document.Timestamp
+	if ts.IsZero() {
+		if date == "" || clock == "" {
+			im.reject(0, "", "缺少時間", "沒有 timestamp 或 Date/Time 項目", len(readings))
+			return nil
+		}
+		if ts, err = im.parseTime(date + " " + clock); err != nil {
+			im.reject(0, "", "時間格式錯誤", date+" "+clock, len(readings))
+			return nil
+		}
+	}
+
+	for _, entry := range readings {
+		if err := im.add(0, "", entry.Name, ts, jsonText(entry.Value), entry.Unit, entry.Quality); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JSON 數值轉為文字 (字串去除引號，數字保留原樣)
+func jsonText(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// 匯入 InfluxDB line protocol
+// 電表: device 標籤 (沒有時使用指定的電表)；點位: point 標籤搭配 value 欄位，
+// 沒有 point 標籤時每個數值欄位為一個點位 (欄位名稱為 value 時以 measurement 為點位名稱)；
+// 單位與品質取自 unit、quality 標籤
+func (im *importer) importLineProtocol(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	now := time.Now()
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		p, err := parseLineProtocol(text)
+		if err != nil {
+			im.reject(line, "", "行格式錯誤", err.Error(), 1)
+			continue
+		}
+
+		ts := now
+		if p.Timestamp != "" {
+			precision := im.options.Precision
+			if precision == 0 {
+				precision = lpAutoPrecision(p.Timestamp)
+			}
+			ts = lpTime(p.Timestamp, precision)
+		}
+
+		device, quality := p.Tags["device"], p.Tags["quality"]
+		for _, field := range p.Fields {
+			if field.Key == "quality" && field.String {
+				quality = field.Value
+			}
+		}
+
+		if point, ok := p.Tags["point"]; ok {
+			value := ""
+			for _, field := range p.Fields {
+				if field.Key == "value" && !field.String {
+					value = field.Value
+				}
+			}
+			if err := im.add(line, device, point, ts, value, p.Tags["unit"], quality); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, field := range p.Fields {
+			if field.String {
+				continue
+			}
+			point := field.Key
+			if point == "value" {
+				point = p.Measurement
+			}
+			if err := im.add(line, device, point, ts, field.Value, p.Tags["unit"], quality); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// 依時間戳記位數判斷精度 (秒、毫秒、微秒、奈秒)
+func lpAutoPrecision(value string) time.Duration {
+	switch n := len(strings.TrimPrefix(value, "-")); {
+	case n <= 10:
+		return time.Second
+	case n <= 13:
+		return time.Millisecond
+	case n <= 16:
+		return time.Microsecond
+	default:
+		return time.Nanosecond
+	}
+}
+
+// 解析欄位對應設定 (YAML 或 JSON)
+func parseImportMapping(content []byte) (ImportMapping, error) {
+	var mapping ImportMapping
+	if len(bytes.TrimSpace(content)) == 0 {
+		return mapping, nil
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&mapping); err != nil {
+		return mapping, fmt.Errorf("欄位對應設定錯誤: %v", err)
+	}
+	return mapping, nil
+}
+
+// 匯入資料 (operator 以上)
+// 請求本文為檔案內容，或 multipart/form-data 的 file 欄位 (可多個)；
+// 欄位對應以 mapping 參數 (JSON/YAML) 或 multipart 中位於檔案之前的 mapping 欄位提供
+func (es *EnergySystem) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "僅支援 POST", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	options := ImportOptions{
+		Format:   query.Get("format"),
+		DeviceID: query.Get("device"),
+		Location: time.Local,
+		DryRun:   query.Get("dry_run") == "1" || query.Get("dry_run") == "true",
+	}
+	if tz := query.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("無效的時區: %s", tz), http.StatusBadRequest)
+			return
+		}
+		options.Location = loc
+	}
+	if value := query.Get("precision"); value != "" {
+		var ok bool
+		if options.Precision, ok = lpPrecisions[value]; !ok {
+			http.Error(w, fmt.Sprintf("不支援的時間精度: %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("mapping"); value != "" {
+		mapping, err := parseImportMapping([]byte(value))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		options.Mapping = mapping
+	}
+
+	reports := make([]*ImportReport, 0)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		if options.Format == "" && strings.Contains(mediaType, "json") {
+			options.Format = "json"
+		}
+		report, err := es.Import(r.Body, "request", options)
+		if report == nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reports = append(reports, report)
+	} else {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("讀取上傳檔案失敗: %v", err), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("讀取上傳檔案失敗: %v", err), http.StatusBadRequest)
+				return
+			}
+
+			switch part.FormName() {
+			case "mapping":
+				content, _ := ioutil.ReadAll(part)
+				mapping, err := parseImportMapping(content)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				options.Mapping = mapping
+			case "file":
+				report, err := es.Import(part, part.FileName(), options)
+				if report == nil {
+					report = &ImportReport{Source: part.FileName(), Error: err.Error()}
+				}
+				reports = append(reports, report)
+			}
+			part.Close()
+		}
+	}
+
+	for _, report := range reports {
+		es.logImportReport(report)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// 匯入結果寫入日誌
+func (es *EnergySystem) logImportReport(report *ImportReport) {
+	prefix := "📥"
+	if report.DryRun {
+		prefix = "🔎 (dry run)"
+	}
+	log.Printf("%s 匯入 %s (%s): 讀取 %d 筆，匯入 %d 筆，重複 %d 筆，拒絕 %d 筆",
+		prefix, report.Source, report.Format, report.Rows, report.Accepted, report.Duplicates, report.Rejected)
+}
+
+// import 命令：匯入 CSV、MeterData JSON 或 line protocol 檔案
+func runImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	format := fs.String("format", "", "檔案格式: csv, json, lp (預設依副檔名或內容判斷)")
+	precision := fs.String("precision", "", "line protocol 時間精度: s, ms, us, ns (預設依位數判斷)")
+	device := fs.String("device", "", "檔案沒有電表欄位時使用的電表 ID (預設為第一個電表)")
+	mappingPath := fs.String("mapping", "", "CSV 欄位對應設定檔 (YAML/JSON)")
+	tz := fs.String("tz", "", "未帶時區的時間所用的時區，例如 Asia/Taipei (預設為系統時區)")
+	dryRun := fs.Bool("dry-run", false, "只檢查不寫入")
+	jsonOutput := fs.Bool("json", false, "以 JSON 輸出匯入報告")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "用法: energy_system import [-config 路徑] [-mapping 對應.yaml] [-device ID] [-dry-run] 檔案...")
+		return 2
+	}
+
+	options := ImportOptions{Format: *format, DeviceID: *device, Location: time.Local, DryRun: *dryRun}
+	if *tz != "" {
+		loc, err := time.LoadLocation(*tz)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 無效的時區: %s\n", *tz)
+			return 2
+		}
+		options.Location = loc
+	}
+	if *precision != "" {
+		var ok bool
+		if options.Precision, ok = lpPrecisions[*precision]; !ok {
+			fmt.Fprintf(os.Stderr, "❌ 不支援的時間精度: %s\n", *precision)
+			return 2
+		}
+	}
+	if *mappingPath != "" {
+		content, err := ioutil.ReadFile(*mappingPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 無法讀取欄位對應設定: %v\n", err)
+			return 2
+		}
+		if options.Mapping, err = parseImportMapping(content); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return 2
+		}
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+	system := NewEnergySystem(config)
+	if err := system.InitDatabase(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer system.store.Close()
+
+	exitCode := 0
+	reports := make([]*ImportReport, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		report, err := importFile(system, path, options)
+		if report == nil {
+			report = &ImportReport{Source: path, Error: err.Error()}
+		}
+		if err != nil {
+			exitCode = 1
+		}
+		reports = append(reports, report)
+		if !*jsonOutput {
+			printImportReport(report)
+		}
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(reports)
+	}
+	return exitCode
+}
+
+func importFile(system *EnergySystem, path string, options ImportOptions) (*ImportReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return system.Import(file, path, options)
+}
+
+// 輸出匯入報告
+func printImportReport(report *ImportReport) {
+	if report.Format == "" {
+		fmt.Printf("❌ %s: %s\n", report.Source, report.Error)
+		return
+	}
+
+	mode := ""
+	if report.DryRun {
+		mode = " (dry run，未寫入)"
+	}
+	fmt.Printf("📥 %s (%s)%s: 讀取 %d 筆，匯入 %d 筆，重複 %d 筆，拒絕 %d 筆\n",
+		report.Source, report.Format, mode, report.Rows, report.Accepted, report.Duplicates, report.Rejected)
+	if report.From != nil {
+		fmt.Printf("   資料時間: %s ~ %s\n", report.From.Format("2006-01-02 15:04:05"), report.To.Format("2006-01-02 15:04:05"))
+	}
+
+	reasons := make([]string, 0, len(report.Reasons))
+	for reason := range report.Reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("   ⚠️ %s: %d 筆\n", reason, report.Reasons[reason])
+	}
+	for _, e := range report.Errors {
+		location := ""
+		if e.Line > 0 {
+			location = fmt.Sprintf("第 %d 行 ", e.Line)
+		}
+		if e.Point != "" {
+			location += "[" + e.Point + "] "
+		}
+		fmt.Printf("   %s%s\n", location, e.Reason)
+	}
+	if report.Error != "" {
+		fmt.Printf("   ❌ %s\n", report.Error)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 寫入端點單次請求的大小上限
+const maxLineProtocolBody = 32 << 20
+
+// InfluxDB line protocol 輸出
+// 讀值先累積成批次，滿一批或到達 flush_interval 時上傳；重試仍失敗的批次寫入暫存區 (buffer_dir)，
+// 之後連線恢復時由舊到新補傳。另可同時寫出 .lp 檔供離線轉移
+type InfluxOutput struct {
+	config   InfluxOutputConfig
+	writeURL string
+	client   *http.Client
+
+	queue chan []string
+	spool *Spool // 無法上傳的批次
+	stop  chan struct{}
+	done  chan struct{}
+
+	fileMu sync.Mutex // 保護 .lp 檔寫入
+}
+
+// 上傳被拒絕 (格式或權限錯誤)，重試也不會成功
+type influxRejectedError struct {
+	status int
+	body   string
+}
+
+func (e *influxRejectedError) Error() string {
+	return fmt.Sprintf("InfluxDB 拒絕寫入 (HTTP %d): %s", e.status, e.body)
+}
+
+// 建立 InfluxDB 輸出；未設定網址與 .lp 目錄時回傳 nil
+func NewInfluxOutput(cfg InfluxOutputConfig) (*InfluxOutput, error) {
+	if cfg.URL == "" && cfg.FileDir == "" {
+		return nil, nil
+	}
+
+	out := &InfluxOutput{
+		config: cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		queue:  make(chan []string, 1000),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if cfg.URL != "" {
+		// 時間戳記以毫秒寫出
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("InfluxDB 網址錯誤: %v", err)
+		}
+		query := u.Query()
+		query.Set("precision", "ms")
+		u.RawQuery = query.Encode()
+		out.writeURL = u.String()
+
+		spool, err := OpenSpool("influx", cfg.BufferDir, cfg.BufferMaxMB)
+		if err != nil {
+			return nil, err
+		}
+		out.spool = spool
+	}
+	if cfg.FileDir != "" {
+		if err := os.MkdirAll(cfg.FileDir, 0755); err != nil {
+			return nil, fmt.Errorf("建立 .lp 目錄失敗: %v", err)
+		}
+	}
+	return out, nil
+}
+
+// 啟動背景上傳
+func (out *InfluxOutput) Start() {
+	if out.writeURL == "" {
+		close(out.done)
+		return
+	}
+	go out.run()
+	log.Printf("📤 InfluxDB 輸出啟動: %s", redactURL(out.config.URL))
+}
+
+// 停止並上傳剩餘的資料 (失敗時寫入暫存區)
+func (out *InfluxOutput) Stop() {
+	close(out.stop)
+	<-out.done
+	if out.spool != nil {
+		out.spool.Close()
+	}
+}
+
+// 加入一次輪詢的讀值
+func (out *InfluxOutput) Enqueue(deviceID string, ts time.Time, readings []MeterReading) {
+	lines := encodeLineProtocol(out.config.Measurement, deviceID, ts, readings)
+	if len(lines) == 0 {
+		return
+	}
+
+	if out.config.FileDir != "" {
+		if err := out.appendFile(ts, lines); err != nil {
+			log.Printf("❌ 寫入 .lp 檔失敗: %v", err)
+		}
+	}
+	if out.writeURL == "" {
+		return
+	}
+
+	select {
+	case out.queue <- lines:
+	default:
+		// 上傳來不及 (例如 InfluxDB 長時間無回應)，直接寫入暫存區
+		out.spill(lines)
+	}
+}
+
+func (out *InfluxOutput) run() {
+	defer close(out.done)
+
+	ticker := time.NewTicker(out.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []string
+	for {
+		select {
+		case lines := <-out.queue:
+			batch = append(batch, lines...)
+			if len(batch) >= out.config.BatchSize {
+				out.flush(batch)
+				batch = nil
+			}
+
+		case <-ticker.C:
+			ok := true
+			if len(batch) > 0 {
+				ok = out.flush(batch)
+				batch = nil
+			}
+			if ok {
+				out.replay()
+			}
+
+		case <-out.stop:
+		drain:
+			for {
+				select {
+				case lines := <-out.queue:
+					batch = append(batch, lines...)
+				default:
+					break drain
+				}
+			}
+			if len(batch) > 0 {
+				out.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// 上傳一批，重試仍失敗時寫入暫存區；回傳 InfluxDB 是否可連線
+func (out *InfluxOutput) flush(batch []string) bool {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := out.post(batch)
+		if err == nil {
+			return true
+		}
+		if _, rejected := err.(*influxRejectedError); rejected {
+			log.Printf("❌ %v (捨棄 %d 行)", err, len(batch))
+			return true
+		}
+		if attempt >= out.config.MaxRetries {
+			log.Printf("⚠️ InfluxDB 上傳失敗: %v，%d 行寫入暫存區", err, len(batch))
+			out.spill(batch)
+			return false
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-out.stop:
+			out.spill(batch)
+			return false
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// 送出一批 line protocol
+func (out *InfluxOutput) post(lines []string) error {
+	body := strings.Join(lines, "\n") + "\n"
+	req, err := http.NewRequest(http.MethodPost, out.writeURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if out.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+out.config.Token)
+	}
+
+	resp, err := out.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout:
+		return &influxRejectedError{status: resp.StatusCode, body: strings.TrimSpace(string(message))}
+	default:
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+}
+
+// 寫入暫存區 (每批一筆紀錄)
+func (out *InfluxOutput) spill(lines []string) {
+	if err := out.spool.Append([]byte(strings.Join(lines, "\n"))); err != nil {
+		log.Printf("❌ 寫入 InfluxDB 暫存區失敗，%d 行遺失: %v", len(lines), err)
+	}
+}
+
+// 依序補傳暫存區的批次，遇到連線失敗即停止 (下次再試)；被拒絕的批次捨棄
+func (out *InfluxOutput) replay() {
+	if out.spool.Stats().Records == 0 {
+		return
+	}
+
+	sent, err := out.spool.Replay(func(payload []byte) error {
+		select {
+		case <-out.stop:
+			return fmt.Errorf("輸出已停止")
+		default:
+		}
+
+		err := out.post(strings.Split(string(payload), "\n"))
+		if _, rejected := err.(*influxRejectedError); rejected {
+			log.Printf("❌ %v (捨棄暫存批次)", err)
+			return nil
+		}
+		return err
+	})
+	if sent > 0 {
+		log.Printf("📤 已補傳 %d 個 InfluxDB 暫存批次", sent)
+	}
+	if err != nil {
+		log.Printf("⚠️ InfluxDB 補傳中斷: %v", err)
+	}
+}
+
+// 附加到當小時的 .lp 檔 (檔名依當地時間)
+func (out *InfluxOutput) appendFile(ts time.Time, lines []string) error {
+	out.fileMu.Lock()
+	defer out.fileMu.Unlock()
+
+	name := filepath.Join(out.config.FileDir, fmt.Sprintf("%s_%s.lp", out.config.Measurement, ts.Format("20060102_15")))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// 隱藏網址中的帳號密碼 (記錄用)
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := u.Query()
+	if query.Get("p") != "" {
+		query.Set("p", "xxxxx")
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// InfluxDB 相容寫入端點 (/write、/api/v2/write)
+// 接受其他資料記錄器以 line protocol 寫入，成為資料匯集點；需 operator 以上的 API 權杖
+// (Authorization: Token/Bearer、Basic 密碼或 v1 的 p 參數皆可)
+func (es *EnergySystem) LineProtocolWriteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		influxError(w, http.StatusMethodNotAllowed, "method not allowed", "僅支援 POST")
+		return
+	}
+	query := r.URL.Query()
+	precision, ok := lpPrecisions[query.Get("precision")]
+	if !ok {
+		influxError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("不支援的時間精度: %s", query.Get("precision")))
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxLineProtocolBody)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			influxError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("gzip 格式錯誤: %v", err))
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	report, err := es.Import(body, "write", ImportOptions{
+		Format:    "lp",
+		DeviceID:  query.Get("device"),
+		Precision: precision,
+	})
+	if err != nil {
+		influxError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if report.Rejected > 0 {
+		log.Printf("⚠️ line protocol 寫入: 匯入 %d 筆，拒絕 %d 筆", report.Accepted, report.Rejected)
+		message := fmt.Sprintf("拒絕 %d 筆", report.Rejected)
+		if len(report.Errors) > 0 {
+			first := report.Errors[0]
+			message = fmt.Sprintf("%s (第 %d 行: %s)", message, first.Line, first.Reason)
+		}
+		influxError(w, http.StatusBadRequest, "invalid", message)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InfluxDB 格式的錯誤回應
+func influxError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(map[string]string{"code": code, "message": message})
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxDB line protocol
+// measurement[,tag=value...] field=value[,field=value...] [timestamp]
+
+var (
+	lpMeasurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `)
+	lpKeyEscaper         = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// 將一次輪詢的讀值轉為 line protocol (每個點位一行，時間精度毫秒)
+// 沒有數值的讀值 (通訊失敗等) 以 exception_code 欄位記錄，保留品質代碼
+func encodeLineProtocol(measurement, deviceID string, ts time.Time, readings []MeterReading) []string {
+	lines := make([]string, 0, len(readings))
+	for _, reading := range readings {
+		var b strings.Builder
+		b.WriteString(lpMeasurementEscaper.Replace(measurement))
+		b.WriteString(",device=" + lpKeyEscaper.Replace(deviceID))
+		b.WriteString(",point=" + lpKeyEscaper.Replace(reading.Name))
+		if reading.Quality != "" {
+			b.WriteString(",quality=" + lpKeyEscaper.Replace(reading.Quality))
+		}
+		if reading.Unit != "" {
+			b.WriteString(",unit=" + lpKeyEscaper.Replace(reading.Unit))
+		}
+
+		if qualityHasValue(reading.Quality) && !math.IsNaN(reading.Value) && !math.IsInf(reading.Value, 0) {
+			b.WriteString(" value=" + strconv.FormatFloat(reading.Value, 'f', -1, 64))
+		} else {
+			b.WriteString(" exception_code=" + strconv.Itoa(reading.ExceptionCode) + "i")
+		}
+		b.WriteString(" " + strconv.FormatInt(ts.UnixMilli(), 10))
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// 解析後的一行資料
+type lpLine struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []lpField
+	Timestamp   string // 空白表示未提供
+}
+
+// 欄位值；字串以外保留原始文字 (整數已去除 i/u 字尾，布林轉為 1/0)
+type lpField struct {
+	Key    string
+	Value  string
+	String bool
+}
+
+// 解析一行 line protocol
+func parseLineProtocol(line string) (*lpLine, error) {
+	p := &lpLine{Tags: make(map[string]string)}
+
+	pos := 0
+	var err error
+	p.Measurement, pos = lpToken(line, pos, ", ")
+	if p.Measurement == "" {
+		return nil, fmt.Errorf("缺少 measurement")
+	}
+
+	// 標籤
+	for pos < len(line) && line[pos] == ',' {
+		var key, value string
+		key, pos = lpToken(line, pos+1, "=, ")
+		if pos >= len(line) || line[pos] != '=' || key == "" {
+			return nil, fmt.Errorf("標籤格式錯誤")
+		}
+		value, pos = lpToken(line, pos+1, ", ")
+		if value == "" {
+			return nil, fmt.Errorf("標籤 %s 缺少數值", key)
+		}
+		p.Tags[key] = value
+	}
+
+	if pos >= len(line) || line[pos] != ' ' {
+		return nil, fmt.Errorf("缺少欄位")
+	}
+	for pos < len(line) && line[pos] == ' ' {
+		pos++
+	}
+
+	// 欄位
+	for {
+		var field lpField
+		field.Key, pos = lpToken(line, pos, "=, ")
+		if pos >= len(line) || line[pos] != '=' || field.Key == "" {
+			return nil, fmt.Errorf("欄位格式錯誤")
+		}
+		pos++
+
+		if pos < len(line) && line[pos] == '"' {
+			field.String = true
+			if field.Value, pos, err = lpQuoted(line, pos); err != nil {
+				return nil, err
+			}
+		} else {
+			raw := ""
+			raw, pos = lpToken(line, pos, ", ")
+			if field.Value, err = lpFieldValue(raw); err != nil {
+				return nil, fmt.Errorf("欄位 %s: %v", field.Key, err)
+			}
+		}
+		p.Fields = append(p.Fields, field)
+
+		if pos >= len(line) || line[pos] != ',' {
+			break
+		}
+		pos++
+	}
+
+	// 時間戳記
+	p.Timestamp = strings.TrimSpace(line[pos:])
+	if p.Timestamp != "" {
+		if _, err := strconv.ParseInt(p.Timestamp, 10, 64); err != nil {
+			return nil, fmt.Errorf("時間戳記格式錯誤: %s", p.Timestamp)
+		}
+	}
+	return p, nil
+}
+
+// 讀取到未跳脫的結束字元為止，並還原跳脫字元
+func lpToken(line string, pos int, stops string) (string, int) {
+	var b strings.Builder
+	for pos < len(line) {
+		c := line[pos]
+		if c == '\\' && pos+1 < len(line) && (strings.IndexByte(stops, line[pos+1]) >= 0 || line[pos+1] == '\\' || line[pos+1] == '=') {
+			b.WriteByte(line[pos+1])
+			pos += 2
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		b.WriteByte(c)
+		pos++
+	}
+	return b.String(), pos
+}
+
+// 讀取雙引號字串欄位
+func lpQuoted(line string, pos int) (string, int, error) {
+	var b strings.Builder
+	for pos++; pos < len(line); pos++ {
+		c := line[pos]
+		if c == '\\' && pos+1 < len(line) && (line[pos+1] == '"' || line[pos+1] == '\\') {
+			pos++
+			b.WriteByte(line[pos])
+			continue
+		}
+		if c == '"' {
+			return b.String(), pos + 1, nil
+		}
+		b.WriteByte(c)
+	}
+	return "", pos, fmt.Errorf("字串欄位缺少結尾引號")
+}
+
+// 數值欄位 (浮點數、i/u 整數、布林)
+func lpFieldValue(raw string) (string, error) {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return "1", nil
+	case "f", "F", "false", "False", "FALSE":
+		return "0", nil
+	}
+	if strings.HasSuffix(raw, "i") || strings.HasSuffix(raw, "u") {
+		if _, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64); err != nil {
+			if _, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64); err != nil {
+				return "", fmt.Errorf("整數格式錯誤: %s", raw)
+			}
+		}
+		return raw[:len(raw)-1], nil
+	}
+	if _, err := strconv.ParseFloat(raw, 64); err != nil {
+		return "", fmt.Errorf("數值格式錯誤: %s", raw)
+	}
+	return raw, nil
+}
+
+// 時間精度 (InfluxDB v1: n/u/ms/s/m/h，v2: ns/us/ms/s)
+var lpPrecisions = map[string]time.Duration{
+	"": time.Nanosecond, "n": time.Nanosecond, "ns": time.Nanosecond,
+	"u": time.Microsecond, "us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// 依精度換算時間戳記
+func lpTime(value string, precision time.Duration) time.Time {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	if precision == time.Nanosecond {
+		return time.Unix(0, n)
+	}
+	return time.Unix(0, 0).Add(time.Duration(n) * precision)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Modbus TCP 從站 (goburrow/modbus 只提供主站，這裡自行實作)
+// 每條連線依序處理請求：讀取 MBAP 標頭與 PDU，交給處理函式，以相同的交易 ID 與單元 ID 回應
+
+// Modbus 例外碼
+const (
+	modbusIllegalFunction = 0x01
+	modbusIllegalAddress  = 0x02
+	modbusIllegalValue    = 0x03
+	modbusGatewayPath     = 0x0A // 閘道沒有對應的目標裝置
+	modbusGatewayTarget   = 0x0B // 目標裝置沒有回應
+)
+
+// MBAP 標頭長度 (交易 ID、協定 ID、長度、單元 ID)
+const mbapHeaderSize = 7
+
+// 處理一個請求：pdu 為功能碼加資料，回傳回應的 PDU
+type modbusHandler func(unitID byte, pdu []byte) []byte
+
+// 從站統計
+type ModbusServerStats struct {
+	Listen      string    `json:"listen"`
+	Connections int       `json:"connections"` // 目前連線數
+	Accepted    int64     `json:"accepted"`    // 累計連線數
+	Rejected    int64     `json:"rejected"`    // 超過 max_connections 而拒絕的連線
+	Requests    int64     `json:"requests"`
+	Exceptions  int64     `json:"exceptions"` // 回應例外的請求
+	LastRequest time.Time `json:"last_request"`
+}
+
+type modbusServer struct {
+	handler     modbusHandler
+	maxConns    int
+	idleTimeout time.Duration
+	listener    net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool // Close 之後接受的連線直接關閉，不再登記
+	stats  ModbusServerStats
+	wg     sync.WaitGroup
+}
+
+// 開始監聽並在背景接受連線
+func listenModbus(addr string, maxConns int, idleTimeout time.Duration, handler modbusHandler) (*modbusServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &modbusServer{
+		handler:     handler,
+		maxConns:    maxConns,
+		idleTimeout: idleTimeout,
+		listener:    listener,
+		conns:       make(map[net.Conn]struct{}),
+	}
+	s.stats.Listen = listener.Addr().String()
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// 實際監聽的位址 (監聽 :0 時取得分配的連接埠)
+func (s *modbusServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *modbusServer) Stats() ModbusServerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Connections = len(s.conns)
+	return stats
+}
+
+func (s *modbusServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("⚠️ Modbus 從站接受連線失敗: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		if len(s.conns) >= s.maxConns {
+			s.stats.Rejected++
+			s.mu.Unlock()
+			log.Printf("⚠️ Modbus 從站連線數已達上限 %d，拒絕 %s", s.maxConns, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.stats.Accepted++
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *modbusServer) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	header := make([]byte, mbapHeaderSize)
+	for {
+		if s.idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		}
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		// 長度包含單元 ID，PDU 最長 253 bytes；協定 ID 不為 0 或長度錯誤時無法再對齊封包，直接斷線
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > 254 {
+			log.Printf("⚠️ Modbus 從站收到無效的封包 (%s)，中斷連線", conn.RemoteAddr())
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		response := s.handler(header[6], pdu)
+		s.mu.Lock()
+		s.stats.Requests++
+		s.stats.LastRequest = time.Now()
+		if len(response) > 0 && response[0]&0x80 != 0 {
+			s.stats.Exceptions++
+		}
+		s.mu.Unlock()
+
+		frame := make([]byte, mbapHeaderSize, mbapHeaderSize+len(response))
+		copy(frame, header)
+		binary.BigEndian.PutUint16(frame[4:6], uint16(len(response)+1))
+		if _, err := conn.Write(append(frame, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// 停止監聽並中斷所有連線
+func (s *modbusServer) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// 例外回應
+func modbusException(function, code byte) []byte {
+	return []byte{function | 0x80, code}
+}
+
+// 解析讀取請求 (FC01~04) 的起始位址與數量；格式或數量錯誤時回傳例外回應
+func parseReadRequest(pdu []byte, maxQuantity uint16) (start, quantity uint16, exception []byte) {
+	if len(pdu) != 5 {
+		return 0, 0, modbusException(pdu[0], modbusIllegalValue)
+	}
+	start = binary.BigEndian.Uint16(pdu[1:3])
+	quantity = binary.BigEndian.Uint16(pdu[3:5])
+	if quantity < 1 || quantity > maxQuantity {
+		return 0, 0, modbusException(pdu[0], modbusIllegalValue)
+	}
+	return start, quantity, nil
+}
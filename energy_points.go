@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/goburrow/modbus"
+)
+
+// 點位的讀取與解碼 (暫存器對照表的 function、type、bit、scale、states)：
+// function 決定功能碼 — holding (FC03，預設)、input (FC04)、coil (FC01)、discrete (FC02)；
+// type 決定佔用的暫存器數與解碼方式，32 位元數值的字組順序與既有電表相同 (Word-Swap)；
+// bool、指定 bit 的位元欄位與整數可用 states 對應為狀態名稱 (例如 0: 分閘、1: 合閘)
+
+// 點位的功能碼
+const (
+	pointHolding  = "holding"
+	pointInput    = "input"
+	pointCoil     = "coil"
+	pointDiscrete = "discrete"
+)
+
+// 各類型佔用的暫存器數
+var pointTypeRegisters = map[string]int{"float32": 2, "u16": 1, "i16": 1, "u32": 2, "i32": 2, "bool": 1}
+
+// 套用預設值：holding、float32 (coil 與 discrete 為 bool)、scale 1
+func (p MeterParameter) normalized() MeterParameter {
+	if p.Function == "" {
+		p.Function = pointHolding
+	}
+	if p.Type == "" {
+		p.Type = "float32"
+		if p.bitAccess() {
+			p.Type = "bool"
+		}
+	}
+	if p.Scale == 0 {
+		p.Scale = 1
+	}
+	return p
+}
+
+// 以位元讀取 (FC01/FC02)
+func (p MeterParameter) bitAccess() bool {
+	return p.Function == pointCoil || p.Function == pointDiscrete
+}
+
+// 讀取點位的原始資料
+func readPoint(client modbus.Client, param MeterParameter) ([]byte, error) {
+	param = param.normalized()
+	quantity := uint16(pointTypeRegisters[param.Type])
+	switch param.Function {
+	case pointCoil:
+		return client.ReadCoils(param.Address, 1)
+	case pointDiscrete:
+		return client.ReadDiscreteInputs(param.Address, 1)
+	case pointInput:
+		return client.ReadInputRegisters(param.Address, quantity)
+	default:
+		return client.ReadHoldingRegisters(param.Address, quantity)
+	}
+}
+
+// 解碼點位的原始資料；sentinel 表示電表回傳無效值標記 (float32/u32 的 0xFFFFFFFF)
+func decodePoint(param MeterParameter, data []byte) (value float64, sentinel bool, err error) {
+	param = param.normalized()
+	if param.bitAccess() {
+		if len(data) < 1 {
+			return 0, false, fmt.Errorf("資料長度不足 (%d bytes)", len(data))
+		}
+		return float64(data[0] & 1), false, nil
+	}
+
+	width := pointTypeRegisters[param.Type]
+	if len(data) < width*2 {
+		return 0, false, fmt.Errorf("資料長度不足 (%d bytes)", len(data))
+	}
+	var raw uint32
+	if width == 2 {
+		raw = wordSwappedUint32(data)
+	} else {
+		raw = uint32(binary.BigEndian.Uint16(data))
+	}
+
+	if param.Bit != nil {
+		return float64(raw >> uint(*param.Bit) & 1), false, nil
+	}
+	switch param.Type {
+	case "float32":
+		return float64(math.Float32frombits(raw)) * param.Scale, raw == 0xFFFFFFFF, nil
+	case "bool":
+		if raw != 0 {
+			return 1, false, nil
+		}
+		return 0, false, nil
+	case "i16":
+		return float64(int16(raw)) * param.Scale, false, nil
+	case "i32":
+		return float64(int32(raw)) * param.Scale, false, nil
+	case "u32":
+		return float64(raw) * param.Scale, raw == 0xFFFFFFFF, nil
+	default:
+		return float64(raw) * param.Scale, false, nil
+	}
+}
+
+// 數值對應的狀態名稱，沒有設定 states 或沒有對應時為空白
+func (p MeterParameter) stateName(value float64) string {
+	if len(p.States) == 0 || value != math.Trunc(value) {
+		return ""
+	}
+	return p.States[int(value)]
+}
+
+// 檢查暫存器對照表中的點位設定 (function、type、bit、states)
+func validatePointDecoding(field string, param MeterParameter, add addFunc) {
+	switch param.Function {
+	case "", pointHolding, pointInput, pointCoil, pointDiscrete:
+	default:
+		add(field+".function", "必須為 holding、input、coil 或 discrete (目前 %q)", param.Function)
+	}
+	normalized := param.normalized()
+	width, ok := pointTypeRegisters[normalized.Type]
+	if !ok {
+		add(field+".type", "不支援的類型 %q (可用: float32、u16、i16、u32、i32、bool)", param.Type)
+	} else if normalized.bitAccess() && normalized.Type != "bool" {
+		add(field+".type", "coil 與 discrete 只能為 bool (目前 %q)", param.Type)
+	}
+	if param.Bit != nil {
+		switch {
+		case normalized.bitAccess() || normalized.Type == "float32" || normalized.Type == "bool":
+			add(field+".bit", "只適用於整數暫存器 (u16、i16、u32、i32)")
+		case ok && (*param.Bit < 0 || *param.Bit >= width*16):
+			add(field+".bit", "必須介於 0 到 %d (目前 %d)", width*16-1, *param.Bit)
+		}
+	}
+	if param.Scale < 0 {
+		add(field+".scale", "不可為負數")
+	}
+	if len(param.States) > 0 && normalized.Type == "float32" && param.Bit == nil {
+		add(field+".states", "float32 點位不可設定狀態名稱")
+	}
+	for _, key := range sortedIntKeys(param.States) {
+		if param.States[key] == "" {
+			add(field+".states."+strconv.Itoa(key), "不可為空")
+		}
+	}
+}
+
+func sortedIntKeys(m map[int]string) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// 數值編碼為點位的原始資料 (decodePoint 的反向，供模擬電表使用)；
+// 指定 bit 時只設定該位元，32 位元數值為 Word-Swap，超出範圍時取上下限
+func encodePoint(param MeterParameter, value float64) []byte {
+	param = param.normalized()
+	if param.bitAccess() {
+		if value != 0 {
+			return []byte{1}
+		}
+		return []byte{0}
+	}
+
+	var raw uint32
+	switch {
+	case param.Bit != nil:
+		if value != 0 {
+			raw = 1 << uint(*param.Bit)
+		}
+	case param.Type == "float32":
+		raw = math.Float32bits(float32(value / param.Scale))
+	case param.Type == "bool":
+		if value != 0 {
+			raw = 1
+		}
+	case param.Type == "i16":
+		raw = uint32(uint16(int16(clampInteger(value/param.Scale, math.MinInt16, math.MaxInt16))))
+	case param.Type == "i32":
+		raw = uint32(int32(clampInteger(value/param.Scale, math.MinInt32, math.MaxInt32)))
+	case param.Type == "u32":
+		raw = uint32(clampInteger(value/param.Scale, 0, math.MaxUint32))
+	default:
+		raw = uint32(clampInteger(value/param.Scale, 0, math.MaxUint16))
+	}
+
+	if pointTypeRegisters[param.Type] == 2 {
+		return wordSwappedBytes(raw)
+	}
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(raw))
+	return data
+}
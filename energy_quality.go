@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// 資料品質代碼
+const (
+	QualityGood            = "good"             // 正常
+	QualityCommError       = "comm_error"       // 通訊失敗 (逾時、連線中斷)
+	QualityDeviceException = "device_exception" // 電表回傳 Modbus 例外碼
+	QualityInvalidSentinel = "invalid_sentinel" // 電表回傳無效值 (0xFFFFFFFF、NaN)
+	QualityOutOfRange      = "out_of_range"     // 超出參數合理範圍
+	QualityStale           = "stale"            // 數值長時間未變動
+	QualitySubstituted     = "substituted"      // 讀取失敗，以最後一筆正常值替代
+	QualityBackfilled      = "backfilled"       // 事後由電表內部紀錄補齊
+)
+
+// 所有品質代碼
+var qualityCodes = []string{
+	QualityGood, QualityCommError, QualityDeviceException, QualityInvalidSentinel,
+	QualityOutOfRange, QualityStale, QualitySubstituted, QualityBackfilled,
+}
+
+// 判斷品質代碼是否有效
+func isQualityCode(code string) bool {
+	for _, q := range qualityCodes {
+		if q == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 品質代碼是否帶有實際量測值 (通訊失敗與無效值沒有數值)
+func qualityHasValue(code string) bool {
+	return code != QualityCommError && code != QualityDeviceException && code != QualityInvalidSentinel
+}
+
+// 讀值寫入資料庫時的數值欄位，沒有實際量測值時為 NULL
+func readingValue(reading MeterReading) interface{} {
+	if !qualityHasValue(reading.Quality) {
+		return nil
+	}
+	return reading.Value
+}
+
+// 查詢時可用於計算的品質條件 (SQL)；通訊失敗與無效值沒有實際數值，永遠排除
+func usableQualitySQL(includeBad bool) string {
+	if includeBad {
+		return `quality NOT IN ('comm_error', 'device_exception', 'invalid_sentinel')`
+	}
+	return `quality IN ('good', 'stale', 'backfilled')`
+}
+
+// 實際量測到的取樣 (SQL)；缺口偵測使用，替代值不算
+func measuredQualitySQL() string {
+	return `value IS NOT NULL AND quality NOT IN ('comm_error', 'device_exception', 'invalid_sentinel', 'substituted')`
+}
+
+// 與 usableQualitySQL 相同的判斷 (逐筆處理時使用)
+func qualityUsable(code string, includeBad bool) bool {
+	if includeBad {
+		return qualityHasValue(code)
+	}
+	return code == QualityGood || code == QualityStale || code == QualityBackfilled
+}
+
+// 依讀取錯誤分類品質，電表例外時一併回傳例外碼
+func classifyReadError(err error) (string, int) {
+	var modbusErr *modbus.ModbusError
+	if errors.As(err, &modbusErr) {
+		return QualityDeviceException, int(modbusErr.ExceptionCode)
+	}
+	return QualityCommError, 0
+}
+
+// 檢查解碼後的數值：無效值標記 (sentinel) 與範圍檢查
+func classifyValue(param MeterParameter, sentinel bool, value float64) string {
+	if sentinel || math.IsNaN(value) || math.IsInf(value, 0) {
+		return QualityInvalidSentinel
+	}
+	if (param.Min != nil && value < *param.Min) || (param.Max != nil && value > *param.Max) {
+		return QualityOutOfRange
+	}
+	return QualityGood
+}
+
+// 每個點位最後一筆正常值 (供替代值與停滯判斷使用)
+type lastGoodValue struct {
+	value     float64
+	timestamp time.Time
+	changedAt time.Time
+}
+
+// 處理停滯與替代：
+// 正常值若超過 staleAfter 未變動則標記 stale；
+// 讀取失敗且啟用替代時，以最後一筆正常值替代並標記 substituted
+func applyQualityHistory(reading *MeterReading, last map[string]*lastGoodValue, sampleTime time.Time, staleAfter time.Duration, substitute bool) {
+	previous := last[reading.Name]
+
+	switch reading.Quality {
+	case QualityGood:
+		if previous == nil || previous.value != reading.Value {
+			last[reading.Name] = &lastGoodValue{value: reading.Value, timestamp: sampleTime, changedAt: sampleTime}
+			return
+		}
+		previous.timestamp = sampleTime
+		if staleAfter > 0 && sampleTime.Sub(previous.changedAt) >= staleAfter {
+			reading.Quality = QualityStale
+		}
+
+	case QualityCommError, QualityDeviceException:
+		if substitute && previous != nil {
+			reading.Value = previous.value
+			reading.Quality = QualitySubstituted
+		}
+	}
+}
+
+// 取得浮點數指標的便利函式 (內建暫存器對照表的上下限、歷史資料數值)
+func floatPtr(v float64) *float64 {
+	return &v
+}
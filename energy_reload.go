@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 設定重新載入結果
+type ReloadResult struct {
+	Added      []string `json:"added"`
+	Removed    []string `json:"removed"`
+	Restarted  []string `json:"restarted"`
+	Updated    []string `json:"updated"`
+	Unchanged  []string `json:"unchanged"`
+	AlarmRules int      `json:"alarm_rules"`
+	Warnings   []string `json:"warnings"`
+}
+
+// 重新讀取設定檔並套用
+func (es *EnergySystem) ReloadConfig() (*ReloadResult, error) {
+	if es.loadConfig == nil {
+		return nil, fmt.Errorf("未設定設定檔來源")
+	}
+
+	config, err := es.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return es.ApplyConfig(config)
+}
+
+// 比對新舊設定並套用差異：
+// 新增的電表啟動收集器、移除的停止，連線參數變更的重新連線，
+// 其餘 (輪詢間隔、暫存器對照表) 直接更新，未變動的電表維持原連線
+// SIGHUP 與 API 同時觸發時依序套用，避免兩次重新載入交錯
+func (es *EnergySystem) ApplyConfig(config *Config) (*ReloadResult, error) {
+	es.reloadMu.Lock()
+	defer es.reloadMu.Unlock()
+
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("設定驗證失敗: %w", ConfigErrors(errs))
+	}
+
+	old := es.Config()
+	result := &ReloadResult{}
+
+	// 資料庫、HTTP、InfluxDB 輸出與 Modbus 閘道的監聽設定需要重新啟動才會生效 (admin_token 與 auth 除外)
+	adminToken, auth := config.HTTP.AdminToken, config.HTTP.Auth
+	config.HTTP.AdminToken, config.HTTP.Auth = old.HTTP.AdminToken, old.HTTP.Auth
+	if config.Database != old.Database {
+		result.Warnings = append(result.Warnings, "database 設定變更需要重新啟動")
+	}
+	if !reflect.DeepEqual(config.HTTP, old.HTTP) {
+		result.Warnings = append(result.Warnings, "http 設定變更需要重新啟動")
+	}
+	if config.Outputs.Influx != old.Outputs.Influx {
+		result.Warnings = append(result.Warnings, "outputs.influx 設定變更需要重新啟動")
+	}
+	// Modbus 閘道的單元、配置、位元組順序與需量時間直接生效
+	gateway, oldGateway := &config.Outputs.Modbus, old.Outputs.Modbus
+	if gateway.Listen != oldGateway.Listen || gateway.MaxConnections != oldGateway.MaxConnections || gateway.IdleTimeout != oldGateway.IdleTimeout {
+		result.Warnings = append(result.Warnings, "outputs.modbus 監聽設定變更需要重新啟動")
+	}
+	config.Database = old.Database
+	config.HTTP = old.HTTP
+	config.Outputs.Influx = old.Outputs.Influx
+	gateway.Listen, gateway.MaxConnections, gateway.IdleTimeout = oldGateway.Listen, oldGateway.MaxConnections, oldGateway.IdleTimeout
+	config.HTTP.AdminToken, config.HTTP.Auth = adminToken, auth
+
+	es.configMu.Lock()
+	es.config = config
+	if config.Collection.MaxConcurrent != old.Collection.MaxConcurrent {
+		es.pollSlots = make(chan struct{}, config.Collection.MaxConcurrent)
+	}
+	es.configMu.Unlock()
+
+	oldMeters := make(map[string]MeterConfig, len(old.Meters))
+	for _, meter := range old.Meters {
+		oldMeters[meter.ID] = meter
+	}
+	newMeters := make(map[string]bool, len(config.Meters))
+
+	// 停止收集器會等待進行中的 Modbus 讀取，在釋放 collectorsMu 之後才停止，
+	// 避免讀取逾時期間阻塞 /api/stats 與寫入動作
+	var stopping []*MeterCollector
+	var restarting []MeterConfig
+
+	es.collectorsMu.Lock()
+	for _, meter := range config.Meters {
+		newMeters[meter.ID] = true
+		collector, exists := es.collectors[meter.ID]
+
+		switch {
+		case !exists:
+			if es.running {
+				es.startCollector(config, meter)
+			}
+			result.Added = append(result.Added, meter.ID)
+
+		case collector.needsReconnect(meter):
+			delete(es.collectors, meter.ID)
+			stopping = append(stopping, collector)
+			restarting = append(restarting, meter)
+			result.Restarted = append(result.Restarted, meter.ID)
+
+		case !reflect.DeepEqual(oldMeters[meter.ID], meter) || !sameRegisterMap(old, config, meter.Model) ||
+			old.MeterPollInterval(meter) != config.MeterPollInterval(meter) ||
+			old.Collection != config.Collection:
+			collector.Update(config, meter)
+			result.Updated = append(result.Updated, meter.ID)
+
+		default:
+			result.Unchanged = append(result.Unchanged, meter.ID)
+		}
+	}
+
+	for id, collector := range es.collectors {
+		if !newMeters[id] {
+			stopping = append(stopping, collector)
+			delete(es.collectors, id)
+			result.Removed = append(result.Removed, id)
+		}
+	}
+	es.collectorsMu.Unlock()
+
+	for _, collector := range stopping {
+		collector.Stop()
+	}
+	if len(restarting) > 0 {
+		es.collectorsMu.Lock()
+		for _, meter := range restarting {
+			if es.running {
+				es.startCollector(config, meter)
+			}
+		}
+		es.collectorsMu.Unlock()
+	}
+
+	es.alarms.SetRules(config.Alarms)
+	result.AlarmRules = len(config.Alarms)
+
+	log.Printf("🔁 設定已重新載入: 新增 %d、移除 %d、重新連線 %d、更新 %d、未變動 %d 個電表，%d 條告警規則",
+		len(result.Added), len(result.Removed), len(result.Restarted), len(result.Updated), len(result.Unchanged), result.AlarmRules)
+	for _, warning := range result.Warnings {
+		log.Printf("⚠️ %s", warning)
+	}
+	es.recordAppliedConfig(config)
+
+	return result, nil
+}
+
+// 記錄目前生效的電表、告警與收集設定 (不含資料庫與 HTTP 設定，避免寫入密碼或權杖)
+func (es *EnergySystem) recordAppliedConfig(config *Config) {
+	if es.store == nil {
+		return
+	}
+
+	applied, err := yaml.Marshal(struct {
+		Collection   CollectionConfig             `yaml:"collection"`
+		Meters       []MeterConfig                `yaml:"meters"`
+		RegisterMaps map[string][]MeterParameter  `yaml:"register_maps,omitempty"`
+		LoadProfiles map[string]LoadProfileConfig `yaml:"load_profiles,omitempty"`
+		Alarms       []AlarmRule                  `yaml:"alarms,omitempty"`
+	}{config.Collection, config.Meters, config.RegisterMaps, config.LoadProfiles, config.Alarms})
+	if err == nil {
+		err = es.store.PutSetting("config.applied", string(applied))
+	}
+	if err == nil {
+		err = es.store.PutSetting("config.applied_at", time.Now().Format(time.RFC3339))
+	}
+	if err != nil {
+		log.Printf("⚠️ 記錄生效設定失敗: %v", err)
+	}
+}
+
+// 判斷兩份設定中同一型號的暫存器對照表是否相同
+func sameRegisterMap(a, b *Config, model string) bool {
+	paramsA, _ := a.RegisterMap(model)
+	paramsB, _ := b.RegisterMap(model)
+	return reflect.DeepEqual(paramsA, paramsB)
+}
+
+// 重新載入設定 (admin)
+func (es *EnergySystem) ReloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "僅支援 POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := es.ReloadConfig()
+	if err != nil {
+		var details ConfigErrors
+		errors.As(err, &details)
+		writeAPIError(w, r, http.StatusBadRequest, fmt.Sprintf("重新載入設定失敗: %v", err), details)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
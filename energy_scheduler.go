@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// 輪詢統計
+type PollStats struct {
+	Polls        int64         `json:"polls"`
+	Failures     int64         `json:"failures"`
+	Skipped      int64         `json:"skipped"`
+	LastPoll     time.Time     `json:"last_poll"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+	LastSuccess  time.Time     `json:"last_success"`         // 最近一次成功讀取
+	FailStreak   int64         `json:"consecutive_failures"` // 連續失敗次數
+}
+
+// 帶有原始索引的參數 (索引對應暫存器對照表中的位置)
+type indexedParameter struct {
+	MeterParameter
+	index int
+}
+
+// 點位群組：同一群組的參數以相同間隔讀取
+type pollGroup struct {
+	name       string
+	interval   time.Duration
+	parameters []indexedParameter
+}
+
+// 依參數的 group 欄位與電表的 group_intervals 分組
+func buildPollGroups(cfg *Config, meter MeterConfig, parameters []MeterParameter) []pollGroup {
+	byName := make(map[string]*pollGroup)
+	var names []string
+
+	for i, param := range parameters {
+		group, ok := byName[param.Group]
+		if !ok {
+			interval := cfg.MeterPollInterval(meter)
+			if groupInterval, ok := meter.GroupIntervals[param.Group]; ok && groupInterval > 0 {
+				interval = groupInterval
+			}
+			group = &pollGroup{name: param.Group, interval: interval}
+			byName[param.Group] = group
+			names = append(names, param.Group)
+		}
+		group.parameters = append(group.parameters, indexedParameter{MeterParameter: param, index: i})
+	}
+
+	sort.Strings(names)
+	groups := make([]pollGroup, 0, len(names))
+	for _, name := range names {
+		groups = append(groups, *byName[name])
+	}
+	return groups
+}
+
+// 排程中的點位群組
+type scheduledGroup struct {
+	pollGroup
+	nextRun time.Time
+}
+
+// 單一電表的輪詢排程
+type pollSchedule struct {
+	groups []*scheduledGroup
+}
+
+// 建立排程，第一次執行時間對齊到下一個時鐘邊界
+func newPollSchedule(groups []pollGroup, now time.Time) *pollSchedule {
+	schedule := &pollSchedule{}
+	for _, group := range groups {
+		schedule.groups = append(schedule.groups, &scheduledGroup{
+			pollGroup: group,
+			nextRun:   alignedNext(now, group.interval),
+		})
+	}
+	return schedule
+}
+
+// 最近一次要執行的時間
+func (s *pollSchedule) next() time.Time {
+	if len(s.groups) == 0 {
+		return time.Now().Add(time.Hour)
+	}
+
+	next := s.groups[0].nextRun
+	for _, group := range s.groups[1:] {
+		if group.nextRun.Before(next) {
+			next = group.nextRun
+		}
+	}
+	return next
+}
+
+// 已到期的群組
+func (s *pollSchedule) due(now time.Time) []*scheduledGroup {
+	var due []*scheduledGroup
+	for _, group := range s.groups {
+		if !group.nextRun.After(now) {
+			due = append(due, group)
+		}
+	}
+	return due
+}
+
+// 排定下一次執行時間並處理逾時 (overrun)：
+// skip 直接跳到下一個時鐘邊界；catch_up 依序補讀錯過的排程，最多 maxCatchUp 次
+// 回傳被略過的排程次數
+func (s *pollSchedule) advance(due []*scheduledGroup, now time.Time, overrun string, maxCatchUp int) int64 {
+	var skipped int64
+
+	for _, group := range due {
+		next := group.nextRun.Add(group.interval)
+		if next.After(now) {
+			group.nextRun = next
+			continue
+		}
+
+		// 讀取時間超過間隔，已錯過 missed 個排程
+		missed := int64(now.Sub(next)/group.interval) + 1
+		if overrun == "catch_up" {
+			if excess := missed - int64(maxCatchUp); excess > 0 {
+				next = next.Add(time.Duration(excess) * group.interval)
+				skipped += excess
+			}
+			group.nextRun = next
+			continue
+		}
+
+		group.nextRun = alignedNext(now, group.interval)
+		skipped += missed
+	}
+
+	return skipped
+}
+
+// 下一個對齊的時鐘邊界 (以 Unix 紀元 UTC 為基準，例如 5s 間隔對齊 :00/:05)
+// 不以當地午夜為基準：無法整除 24h 的間隔 (7m、45m) 不會在午夜產生較短的輪詢，日光節約時間切換也不影響對齊；
+// 整點倍數的間隔對齊 UTC 整點，在整數時區 (例如 UTC+8) 即為當地整點
+func alignedNext(now time.Time, interval time.Duration) time.Time {
+	if interval <= 0 {
+		return now
+	}
+
+	elapsed := time.Duration(now.UnixNano()) % interval
+	return now.Add(interval - elapsed)
+}
+
+// 取得輪詢名額 (限制同時讀取的電表數量)，回傳釋放函式
+func (es *EnergySystem) acquirePollSlot() func() {
+	es.configMu.RLock()
+	slots := es.pollSlots
+	es.configMu.RUnlock()
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 資料庫結構版本 (SQLite 為 PRAGMA user_version，PostgreSQL 為 schema_version 資料表)
+const schemaVersion = 4
+
+// 依版本逐步升級 SQLite 資料庫結構
+func migrateDatabase(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("讀取資料庫版本失敗: %v", err)
+	}
+
+	migrations := []func(*sql.Tx) error{
+		migrateSamplesTable,
+		migrateSettingsTable,
+		migrateEnergyDeltasTable,
+		migrateAuthTables,
+	}
+
+	for version < len(migrations) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("升級資料庫至版本 %d 失敗: %v", version+1, err)
+		}
+		// PRAGMA 不支援參數綁定
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		version++
+		log.Printf("✅ 資料庫已升級至版本 %d", version)
+	}
+
+	return nil
+}
+
+// 版本 1: 每個點位一筆的 samples 資料表 (含品質代碼)，並轉換既有的 meter_data
+func migrateSamplesTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS samples (
+		device_id TEXT NOT NULL,
+		point TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		value REAL,
+		unit TEXT NOT NULL DEFAULT '',
+		quality TEXT NOT NULL DEFAULT 'good',
+		exception_code INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (device_id, point, ts)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_samples_ts ON samples(ts);
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT timestamp, device_id, json_data FROM meter_data`)
+	if err != nil {
+		return err
+	}
+
+	type legacyRow struct {
+		timestamp time.Time
+		deviceID  string
+		readings  []MeterReading
+	}
+	var legacy []legacyRow
+	for rows.Next() {
+		var timestamp, deviceID, jsonData string
+		if err := rows.Scan(&timestamp, &deviceID, &jsonData); err != nil {
+			continue
+		}
+		ts, err := parseSQLiteTime(timestamp)
+		if err != nil {
+			continue
+		}
+		var readings []MeterReading
+		if err := json.Unmarshal([]byte(jsonData), &readings); err != nil {
+			continue
+		}
+		legacy = append(legacy, legacyRow{ts, deviceID, readings})
+	}
+	rows.Close()
+
+	for _, row := range legacy {
+		for _, reading := range row.readings {
+			_, err := tx.Exec(`INSERT OR IGNORE INTO samples (device_id, point, ts, value, unit, quality) VALUES (?, ?, ?, ?, ?, ?)`,
+				row.deviceID, reading.Name, row.timestamp.UnixMilli(), reading.Value, reading.Unit, QualityGood)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(legacy) > 0 {
+		log.Printf("🔄 已將 %d 筆舊資料轉換至 samples 資料表", len(legacy))
+	}
+
+	return nil
+}
+
+// 版本 2: 設定值資料表 (key/value)
+func migrateSettingsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// 版本 3: 累積電能計數器每次取樣間的用電量
+func migrateEnergyDeltasTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS energy_deltas (
+		device_id TEXT NOT NULL,
+		point TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		start_ts INTEGER NOT NULL,
+		delta REAL NOT NULL,
+		backfilled INTEGER NOT NULL DEFAULT 0,
+		gap INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (device_id, point, ts)
+	)`)
+	return err
+}
+
+// 版本 4: 使用者、API 權杖與稽核紀錄
+func migrateAuthTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS users (
+		name TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		disabled INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		user_name TEXT NOT NULL,
+		role TEXT NOT NULL,
+		secret_hash TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0,
+		last_used_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ts INTEGER NOT NULL,
+		user_name TEXT NOT NULL,
+		role TEXT NOT NULL,
+		auth TEXT NOT NULL,
+		remote TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		status INTEGER NOT NULL,
+		detail TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_ts ON audit_log(ts);
+	`)
+	return err
+}
+
+// 解析 meter_data 的時間戳記 (UTC 文字格式)
+func parseSQLiteTime(value string) (time.Time, error) {
+	for _, layout := range []string{sqliteTimeFormat, "2006-01-02 15:04:05", time.RFC3339Nano} {
+		if ts, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("無法解析時間: %s", value)
+}
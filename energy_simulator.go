@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// 模擬電表 (simulate 命令)：依型號的暫存器對照表提供隨時間變化的讀值 (FC01~04) 與裝置識別 (FC43/14)，
+// 可作為 discover、資料收集與 Modbus 閘道的測試對象；未模擬的單元 ID 回應閘道例外 0x0B (目標沒有回應)
+
+// 讀取線圈與離散輸入的數量上限
+const modbusMaxReadBits = 2000
+
+// 各單位的典型數值，沒有對應時取 min/max 的中間值
+var simulatorNominal = map[string]float64{"V": 220, "A": 10, "Hz": 60, "kW": 5, "kvar": 1, "kVA": 5, "%": 3}
+
+type meterSimulator struct {
+	params []MeterParameter
+	units  map[byte]bool
+	device *DeviceIdentification // nil 表示不支援裝置識別
+	start  time.Time
+}
+
+func newMeterSimulator(params []MeterParameter, units []int, device *DeviceIdentification) *meterSimulator {
+	sim := &meterSimulator{params: params, units: make(map[byte]bool), device: device, start: time.Now()}
+	for _, unit := range units {
+		sim.units[byte(unit)] = true
+	}
+	return sim
+}
+
+// 點位目前的模擬值：計數器每小時增加 10，狀態點位每分鐘切換，其他數值在典型值附近小幅變動
+func (sim *meterSimulator) value(index int, param MeterParameter, now time.Time) float64 {
+	elapsed := now.Sub(sim.start).Seconds()
+	param = param.normalized()
+	switch {
+	case param.Counter:
+		return 1000 + elapsed/3600*10
+	case param.bitAccess() || param.Type == "bool" || param.Bit != nil:
+		return float64((int(elapsed)/60 + index) % 2)
+	}
+
+	nominal, ok := simulatorNominal[param.Unit]
+	if !ok {
+		nominal = 100
+		if param.Min != nil && param.Max != nil {
+			nominal = (*param.Min + *param.Max) / 2
+		}
+	}
+	value := nominal * (1 + 0.02*math.Sin(elapsed/30+float64(index)))
+	if param.Min != nil && value < *param.Min {
+		value = *param.Min
+	}
+	if param.Max != nil && value > *param.Max {
+		value = *param.Max
+	}
+	return value
+}
+
+// 目前的暫存器內容 (FC03/FC04)；bit 點位以 OR 合併到同一個暫存器
+func (sim *meterSimulator) registers(function string, now time.Time) map[uint16]uint16 {
+	registers := make(map[uint16]uint16)
+	for i, param := range sim.params {
+		param = param.normalized()
+		if param.Function != function || param.computed() {
+			continue
+		}
+		data := encodePoint(param, sim.value(i, param, now))
+		for j := 0; j+1 < len(data); j += 2 {
+			address := param.Address + uint16(j/2)
+			word := binary.BigEndian.Uint16(data[j:])
+			if param.Bit != nil {
+				word |= registers[address]
+			}
+			registers[address] = word
+		}
+	}
+	return registers
+}
+
+// 目前的線圈或離散輸入 (FC01/FC02)
+func (sim *meterSimulator) bits(function string, now time.Time) map[uint16]bool {
+	bits := make(map[uint16]bool)
+	for i, param := range sim.params {
+		param = param.normalized()
+		if param.Function == function && !param.computed() {
+			bits[param.Address] = sim.value(i, param, now) != 0
+		}
+	}
+	return bits
+}
+
+func (sim *meterSimulator) handle(unitID byte, pdu []byte) []byte {
+	function := pdu[0]
+	if !sim.units[unitID] {
+		return modbusException(function, modbusGatewayTarget)
+	}
+	now := time.Now()
+
+	switch function {
+	case 0x01, 0x02:
+		start, quantity, exception := parseReadRequest(pdu, modbusMaxReadBits)
+		if exception != nil {
+			return exception
+		}
+		bits := sim.bits(map[byte]string{0x01: pointCoil, 0x02: pointDiscrete}[function], now)
+		data := make([]byte, (quantity+7)/8)
+		for i := uint16(0); i < quantity; i++ {
+			if bits[start+i] {
+				data[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{function, byte(len(data))}, data...)
+
+	case 0x03, 0x04:
+		start, quantity, exception := parseReadRequest(pdu, modbusMaxReadRegisters)
+		if exception != nil {
+			return exception
+		}
+		registers := sim.registers(map[byte]string{0x03: pointHolding, 0x04: pointInput}[function], now)
+		response := []byte{function, byte(quantity * 2)}
+		for i := uint16(0); i < quantity; i++ {
+			response = binary.BigEndian.AppendUint16(response, registers[start+i])
+		}
+		return response
+
+	case modbusEncapsulatedTransport:
+		if sim.device == nil {
+			return modbusException(function, modbusIllegalFunction)
+		}
+		return sim.deviceIdentification(pdu)
+	}
+	return modbusException(function, modbusIllegalFunction)
+}
+
+// 回應 FC43/14：基本 (01)、一般 (02) 與擴充 (03) 一次回傳所有物件，個別 (04) 只回傳指定物件
+func (sim *meterSimulator) deviceIdentification(pdu []byte) []byte {
+	if len(pdu) != 4 || pdu[1] != meiReadDeviceID {
+		return modbusException(pdu[0], modbusIllegalValue)
+	}
+	readCode, objectID := pdu[2], pdu[3]
+	objects := []string{sim.device.VendorName, sim.device.ProductCode, sim.device.Revision,
+		sim.device.VendorURL, sim.device.ProductName, sim.device.ModelName}
+
+	var ids []byte
+	switch readCode {
+	case deviceIDBasic:
+		ids = []byte{0, 1, 2}
+	case deviceIDRegular, 0x03:
+		ids = []byte{0, 1, 2, 3, 4, 5}
+	case 0x04:
+		if int(objectID) >= len(objects) {
+			return modbusException(pdu[0], modbusIllegalAddress)
+		}
+		ids = []byte{objectID}
+	default:
+		return modbusException(pdu[0], modbusIllegalValue)
+	}
+
+	response := []byte{pdu[0], meiReadDeviceID, readCode, 0x82, 0x00, 0x00, 0}
+	for _, id := range ids {
+		if readCode != 0x04 && id < objectID {
+			continue
+		}
+		response = append(response, id, byte(len(objects[id])))
+		response = append(response, objects[id]...)
+		response[6]++
+	}
+	return response
+}
+
+// simulate 子命令
+func runSimulateCommand(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	addr := fs.String("addr", ":1502", "Modbus TCP 監聽位址")
+	model := fs.String("model", "DPMC530E", "電表型號 (暫存器對照表)")
+	unitSpec := fs.String("units", "1", "模擬的單元 ID，例如 1-3 或 1,5")
+	vendor := fs.String("vendor", "", "裝置識別的廠牌 (預設 discovery.models 的 vendor)")
+	product := fs.String("product", "", "裝置識別的產品代碼 (預設 discovery.models 的 product)")
+	revision := fs.String("revision", "1.00", "裝置識別的韌體版本")
+	noIdentify := fs.Bool("no-identify", false, "不支援裝置識別 (FC43/14)，只能以簽章點位比對")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+	params, ok := config.RegisterMap(*model)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "❌ 未知的電表型號 %q (可用: %v)\n", *model, config.modelNames())
+		return 1
+	}
+	units, err := parseUnitIDs(*unitSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	var device *DeviceIdentification
+	if !*noIdentify {
+		rule := config.Discovery.Models[*model]
+		device = &DeviceIdentification{VendorName: rule.Vendor, ProductCode: rule.Product, Revision: *revision,
+			ProductName: "模擬電表", ModelName: *model}
+		if *vendor != "" {
+			device.VendorName = *vendor
+		}
+		if *product != "" {
+			device.ProductCode = *product
+		}
+		if device.VendorName == "" {
+			device.VendorName = "Simulator"
+		}
+		if device.ProductCode == "" {
+			device.ProductCode = *model
+		}
+	}
+
+	sim := newMeterSimulator(params, units, device)
+	server, err := listenModbus(*addr, 16, 0, sim.handle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 無法監聽 %s: %v\n", *addr, err)
+		return 1
+	}
+	log.Printf("🧪 模擬電表 %s 啟動於 %s (單元 %s，%d 個點位)", *model, server.Addr(), formatUnitIDs(units), len(params))
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	server.Close()
+	stats := server.Stats()
+	log.Printf("🛑 模擬電表已停止 (處理 %d 個請求)", stats.Requests)
+	return 0
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 暫存區 (store-and-forward)
+// 輸出目的地 (資料庫、InfluxDB) 無法寫入時，資料依序附加到暫存目錄的分段檔，恢復後由舊到新補寫。
+// 每筆紀錄格式: [長度 4 bytes][CRC-32C 4 bytes][內容]，檔名 seg-<序號>.spool；
+// 補寫進度記錄在 cursor 檔，程式中斷時最多重複補寫一筆 (寫入端需可重複寫入)
+
+const (
+	spoolSegmentBytes = 4 << 20 // 分段檔大小上限
+	spoolHeaderBytes  = 8
+	spoolMaxRecord    = 64 << 20 // 單筆紀錄上限 (超過視為檔案損壞)
+)
+
+var spoolCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// 暫存區統計
+type SpoolStats struct {
+	Records    int       `json:"records"`  // 尚未補寫的筆數
+	Bytes      int64     `json:"bytes"`    // 暫存檔大小
+	Segments   int       `json:"segments"` // 分段檔數量
+	MaxBytes   int64     `json:"max_bytes"`
+	Appended   int64     `json:"appended"` // 啟動後寫入暫存的筆數
+	Replayed   int64     `json:"replayed"` // 啟動後補寫成功的筆數
+	Evicted    int64     `json:"evicted"`  // 超過上限而刪除的筆數
+	Corrupt    int64     `json:"corrupt"`  // 檢查碼錯誤而略過的筆數
+	LastAppend time.Time `json:"last_append"`
+}
+
+// 分段檔
+type spoolSegment struct {
+	seq     uint64
+	size    int64 // 有效資料的長度
+	records int   // 有效紀錄數
+}
+
+// 暫存區
+type Spool struct {
+	name     string
+	dir      string
+	maxBytes int64
+	segBytes int64
+
+	mu       sync.Mutex
+	segments []*spoolSegment // 由舊到新，最後一個為寫入中的分段
+	writer   *os.File
+	offset   int64 // 最舊分段的補寫位置
+	consumed int   // 最舊分段已補寫的筆數
+	lastSeq  uint64
+	stats    SpoolStats
+}
+
+// 開啟暫存區 (目錄不存在時建立)，並檢查既有分段檔的完整性
+func OpenSpool(name, dir string, maxMB int) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("建立暫存目錄失敗: %v", err)
+	}
+
+	s := &Spool{name: name, dir: dir, maxBytes: int64(maxMB) << 20, segBytes: spoolSegmentBytes}
+	if s.maxBytes > 0 && s.maxBytes/4 < s.segBytes {
+		s.segBytes = s.maxBytes / 4
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		seq, ok := parseSpoolName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		segment := &spoolSegment{seq: seq}
+		if err := s.scan(segment); err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, segment)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	if n := len(s.segments); n > 0 {
+		s.lastSeq = s.segments[n-1].seq
+	}
+
+	s.loadCursor()
+	s.stats.MaxBytes = s.maxBytes
+	if records := s.pending(); records > 0 {
+		log.Printf("📥 暫存區 %s 有 %d 筆待補寫資料", name, records)
+	}
+	return s, nil
+}
+
+func spoolName(seq uint64) string {
+	return fmt.Sprintf("seg-%016d.spool", seq)
+}
+
+func parseSpoolName(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, "seg-") || !strings.HasSuffix(name, ".spool") {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "seg-"), ".spool"), 10, 64)
+	return seq, err == nil
+}
+
+func (s *Spool) path(segment *spoolSegment) string {
+	return filepath.Join(s.dir, spoolName(segment.seq))
+}
+
+// 讀取一筆紀錄；回傳內容與下一筆的位置
+func readSpoolRecord(r io.ReaderAt, offset int64) ([]byte, int64, error) {
+	var header [spoolHeaderBytes]byte
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > spoolMaxRecord {
+		return nil, 0, fmt.Errorf("紀錄長度錯誤: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+spoolHeaderBytes); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, spoolCRC) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("檢查碼錯誤")
+	}
+	return payload, offset + spoolHeaderBytes + int64(length), nil
+}
+
+// 計算分段檔的有效紀錄；結尾不完整或損壞的部分 (例如寫到一半斷電) 會被截掉
+func (s *Spool) scan(segment *spoolSegment) error {
+	f, err := os.OpenFile(s.path(segment), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	for segment.size < info.Size() {
+		_, next, err := readSpoolRecord(f, segment.size)
+		if err != nil {
+			log.Printf("⚠️ 暫存檔 %s 在位置 %d 損壞 (%v)，截掉之後的 %d bytes", spoolName(segment.seq), segment.size, err, info.Size()-segment.size)
+			s.stats.Corrupt++
+			return f.Truncate(segment.size)
+		}
+		segment.size = next
+		segment.records++
+	}
+	return nil
+}
+
+// cursor 檔: "<序號> <位置> <已補寫筆數>"
+func (s *Spool) loadCursor() {
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "cursor"))
+	if err != nil || len(s.segments) == 0 {
+		return
+	}
+	var seq uint64
+	var offset int64
+	var consumed int
+	if _, err := fmt.Sscanf(string(content), "%d %d %d", &seq, &offset, &consumed); err != nil {
+		return
+	}
+	if first := s.segments[0]; first.seq == seq && offset <= first.size && consumed <= first.records {
+		s.offset, s.consumed = offset, consumed
+	}
+}
+
+func (s *Spool) saveCursor() {
+	name := filepath.Join(s.dir, "cursor")
+	content := "0 0 0"
+	if len(s.segments) > 0 {
+		content = fmt.Sprintf("%d %d %d", s.segments[0].seq, s.offset, s.consumed)
+	}
+	if err := ioutil.WriteFile(name+".tmp", []byte(content), 0644); err == nil {
+		os.Rename(name+".tmp", name)
+	}
+}
+
+// 待補寫筆數 (呼叫端需持有 mu)
+func (s *Spool) pending() int {
+	total := -s.consumed
+	for _, segment := range s.segments {
+		total += segment.records
+	}
+	return total
+}
+
+// 附加一筆紀錄 (立即寫入磁碟)
+func (s *Spool) Append(payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rotate(int64(len(payload)) + spoolHeaderBytes); err != nil {
+		return err
+	}
+
+	record := make([]byte, spoolHeaderBytes+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, spoolCRC))
+	copy(record[spoolHeaderBytes:], payload)
+
+	segment := s.segments[len(s.segments)-1]
+	if _, err := s.writer.WriteAt(record, segment.size); err != nil {
+		return fmt.Errorf("寫入暫存檔失敗: %v", err)
+	}
+	if err := s.writer.Sync(); err != nil {
+		return fmt.Errorf("寫入暫存檔失敗: %v", err)
+	}
+	segment.size += int64(len(record))
+	segment.records++
+	s.stats.Appended++
+	s.stats.LastAppend = time.Now()
+
+	s.evict()
+	return nil
+}
+
+// 需要時開啟新的分段檔 (呼叫端需持有 mu)
+func (s *Spool) rotate(size int64) error {
+	if s.writer != nil {
+		current := s.segments[len(s.segments)-1]
+		if current.size == 0 || current.size+size <= s.segBytes {
+			return nil
+		}
+		s.writer.Close()
+		s.writer = nil
+	}
+
+	var segment *spoolSegment
+	if n := len(s.segments); n > 0 && s.segments[n-1].size+size <= s.segBytes {
+		// 重新啟動後沿用最新的分段檔
+		segment = s.segments[n-1]
+	} else {
+		s.lastSeq++
+		segment = &spoolSegment{seq: s.lastSeq}
+		s.segments = append(s.segments, segment)
+	}
+
+	f, err := os.OpenFile(s.path(segment), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("建立暫存檔失敗: %v", err)
+	}
+	s.writer = f
+	return nil
+}
+
+// 超過上限時刪除最舊的分段檔 (呼叫端需持有 mu)
+func (s *Spool) evict() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	for len(s.segments) > 1 && s.size() > s.maxBytes {
+		oldest := s.segments[0]
+		lost := oldest.records - s.consumed
+		if err := os.Remove(s.path(oldest)); err != nil {
+			log.Printf("❌ 刪除暫存檔失敗: %v", err)
+			return
+		}
+		s.segments = s.segments[1:]
+		s.offset, s.consumed = 0, 0
+		s.stats.Evicted += int64(lost)
+		log.Printf("⚠️ 暫存區 %s 超過 %d MB，刪除最舊的 %d 筆資料", s.name, s.maxBytes>>20, lost)
+		s.saveCursor()
+	}
+}
+
+func (s *Spool) size() int64 {
+	var total int64
+	for _, segment := range s.segments {
+		total += segment.size
+	}
+	return total
+}
+
+// 由舊到新補寫，fn 回傳錯誤時停止 (該筆保留，下次再試)；回傳補寫成功的筆數
+// 檢查碼錯誤的紀錄會略過並記錄
+func (s *Spool) Replay(fn func(payload []byte) error) (int, error) {
+	replayed := 0
+	for {
+		s.mu.Lock()
+		payload, segment, next, err := s.peek()
+		s.mu.Unlock()
+		if err != nil || payload == nil {
+			return replayed, err
+		}
+
+		if err := fn(payload); err != nil {
+			return replayed, err
+		}
+
+		s.mu.Lock()
+		// 補寫期間最舊的分段可能已被刪除
+		if len(s.segments) > 0 && s.segments[0] == segment {
+			s.advance(next)
+			s.stats.Replayed++
+		}
+		s.mu.Unlock()
+		replayed++
+	}
+}
+
+// 讀取下一筆待補寫的紀錄 (呼叫端需持有 mu)；沒有資料時 payload 為 nil
+func (s *Spool) peek() ([]byte, *spoolSegment, int64, error) {
+	for len(s.segments) > 0 {
+		segment := s.segments[0]
+		if s.offset >= segment.size {
+			if segment.size == 0 {
+				return nil, nil, 0, nil
+			}
+			s.drop()
+			continue
+		}
+
+		f, err := os.Open(s.path(segment))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		payload, next, err := readSpoolRecord(f, s.offset)
+		f.Close()
+		if err != nil {
+			// 損壞的紀錄無法判斷長度，略過此分段剩餘的資料
+			log.Printf("⚠️ 暫存檔 %s 在位置 %d 損壞 (%v)，略過 %d 筆", spoolName(segment.seq), s.offset, err, segment.records-s.consumed)
+			s.stats.Corrupt += int64(segment.records - s.consumed)
+			s.drop()
+			continue
+		}
+		return payload, segment, next, nil
+	}
+	return nil, nil, 0, nil
+}
+
+// 補寫完成一筆 (呼叫端需持有 mu)
+func (s *Spool) advance(next int64) {
+	s.offset = next
+	s.consumed++
+	if s.offset >= s.segments[0].size {
+		s.drop()
+		return
+	}
+	s.saveCursor()
+}
+
+// 刪除最舊的分段檔 (已全部補寫或損壞)；寫入中的分段會在下次寫入時重新建立
+func (s *Spool) drop() {
+	oldest := s.segments[0]
+	if len(s.segments) == 1 && s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	os.Remove(s.path(oldest))
+	s.segments = s.segments[1:]
+	s.offset, s.consumed = 0, 0
+	s.saveCursor()
+}
+
+// 目前統計
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Records = s.pending()
+	stats.Bytes = s.size()
+	stats.Segments = len(s.segments)
+	return stats
+}
+
+// 關閉寫入中的分段檔
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer != nil {
+		err := s.writer.Close()
+		s.writer = nil
+		return err
+	}
+	return nil
+}
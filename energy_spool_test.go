@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func spoolPayload(i int) []byte {
+	return []byte(fmt.Sprintf("rec-%06d", i))
+}
+
+func appendSpool(t *testing.T, s *Spool, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := s.Append(spoolPayload(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 補寫 limit 筆 (limit < 0 表示全部)，回傳補寫的內容
+func replaySpool(t *testing.T, s *Spool, limit int) []string {
+	t.Helper()
+	var got []string
+	errStop := errors.New("停止")
+	_, err := s.Replay(func(payload []byte) error {
+		if limit >= 0 && len(got) == limit {
+			return errStop
+		}
+		got = append(got, string(payload))
+		return nil
+	})
+	if err != nil && err != errStop {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func expectSpoolRecords(t *testing.T, got []string, from, to int) {
+	t.Helper()
+	if len(got) != to-from {
+		t.Fatalf("預期 %d 筆，實際 %d 筆: %v", to-from, len(got), got)
+	}
+	for i, payload := range got {
+		if want := string(spoolPayload(from + i)); payload != want {
+			t.Fatalf("第 %d 筆: 預期 %s，實際 %s", i, want, payload)
+		}
+	}
+}
+
+// 寫到一半斷電：最後一筆不完整時，重新開啟會截掉該筆，之前的紀錄與之後的寫入都正常
+func TestSpoolTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool("test", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendSpool(t, s, 0, 3)
+	s.Close()
+
+	segment := filepath.Join(dir, spoolName(1))
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第 4 筆只寫了標頭與部分內容
+	f, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{10, 0, 0, 0, 0xde, 0xad, 0xbe, 0xef, 'r', 'e'})
+	f.Close()
+
+	s, err = OpenSpool("test", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	stats := s.Stats()
+	if stats.Records != 3 || stats.Corrupt != 1 || stats.Bytes != info.Size() {
+		t.Fatalf("統計錯誤: %+v (原大小 %d)", stats, info.Size())
+	}
+	if truncated, _ := os.Stat(segment); truncated.Size() != info.Size() {
+		t.Fatalf("分段檔應截回 %d bytes，實際 %d", info.Size(), truncated.Size())
+	}
+
+	appendSpool(t, s, 3, 4)
+	expectSpoolRecords(t, replaySpool(t, s, -1), 0, 4)
+}
+
+// 補寫進度記錄在 cursor 檔，重新開啟後從未補寫的紀錄繼續；全部補寫後刪除分段檔
+func TestSpoolReplayAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool("test", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendSpool(t, s, 0, 5)
+	expectSpoolRecords(t, replaySpool(t, s, 2), 0, 2)
+	s.Close()
+
+	s, err = OpenSpool("test", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if records := s.Stats().Records; records != 3 {
+		t.Fatalf("重新開啟後應有 3 筆待補寫，實際 %d", records)
+	}
+	appendSpool(t, s, 5, 6)
+	expectSpoolRecords(t, replaySpool(t, s, -1), 2, 6)
+
+	stats := s.Stats()
+	if stats.Records != 0 || stats.Segments != 0 || stats.Replayed != 4 {
+		t.Fatalf("補寫後統計錯誤: %+v", stats)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "seg-*.spool")); len(matches) != 0 {
+		t.Fatalf("補寫後應刪除分段檔: %v", matches)
+	}
+}
+
+// 超過上限時由最舊的分段刪除，已補寫的紀錄不計入刪除筆數
+func TestSpoolEviction(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool("test", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每筆 18 bytes：每個分段 3 筆，最多保留 120 bytes
+	record := int64(spoolHeaderBytes + len(spoolPayload(0)))
+	s.segBytes, s.maxBytes = 3*record, 120
+
+	appendSpool(t, s, 0, 4)
+	expectSpoolRecords(t, replaySpool(t, s, 2), 0, 2)
+	// 第 7 筆時刪除第 1 段 (剩 1 筆未補寫)，第 10 筆時刪除第 2 段 (3 筆)
+	appendSpool(t, s, 4, 10)
+
+	stats := s.Stats()
+	if stats.Evicted != 4 || stats.Records != 4 || stats.Bytes > s.maxBytes {
+		t.Fatalf("統計錯誤: %+v", stats)
+	}
+	if stats.Appended != stats.Replayed+stats.Evicted+int64(stats.Records) {
+		t.Fatalf("筆數不一致: %+v", stats)
+	}
+	s.Close()
+
+	s, err = OpenSpool("test", dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectSpoolRecords(t, replaySpool(t, s, -1), 6, 10)
+}
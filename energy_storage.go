@@ -0,0 +1,1010 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 資料儲存介面
+// SQLite (預設) 與 PostgreSQL/TimescaleDB 各自實作；所有 SQL 方言差異都封裝在實作內
+type Storage interface {
+	Driver() string
+	Init() error // 建立資料表並升級結構 (可重複呼叫)
+	Close() error
+	SchemaVersion() (int, error)
+	Ping(ctx context.Context) error // 確認資料庫可查詢
+	Size() (int64, error)           // 資料庫大小 (bytes)
+
+	// 取樣資料
+	WriteSamples(records []PollRecord) error        // 多次輪詢在同一個交易寫入
+	LatestSnapshot(deviceID string) (string, error) // 電表最新一次輪詢的 JSON (MeterReading 陣列)
+	CountSamples(q SampleQuery) (int, string, error)
+	QuerySamples(q SampleQuery) (SampleRows, error) // 依時間排序
+	LatestUnit(deviceID, point string) (string, error)
+	BucketSamples(q SampleQuery, origin time.Time, width time.Duration, fn func(SampleBucket) error) error
+	Rollup(deviceID, point string, periods []time.Time, filter QualityFilter) ([]SampleRollup, error)
+	OpenSampleWriter(dryRun bool) (SampleWriter, error)
+
+	// 缺口與用電量
+	SampleGaps(deviceID string, from, to time.Time, minGap time.Duration) ([]SampleGap, error)
+	BackfillSamples(samples []Sample) (int, error)                                                       // 寫入補齊的取樣，只覆寫沒有量測值的取樣 (例如通訊失敗)
+	RecomputeEnergyDeltas(deviceID, point string, from, to time.Time, minGap time.Duration) (int, error) // 重新計算區間內的用電量
+	EnergyDeltas(deviceID, point string, from, to time.Time) ([]EnergyDelta, error)
+
+	// 告警事件
+	InsertAlarmEvent(event AlarmEvent) error
+	RecentAlarmEvents(limit int) ([]AlarmEvent, error)
+	AlarmEvents(from, to time.Time) ([]AlarmEvent, error) // 區間內的事件 (含頭尾)，依時間排序
+
+	// 設定值 (key/value)
+	PutSetting(key, value string) error
+	GetSetting(key string) (string, bool, error)
+
+	// 使用者與 API 權杖
+	PutUser(user User) error // 新增或更新
+	GetUser(name string) (User, bool, error)
+	ListUsers() ([]User, error)
+	DeleteUser(name string) (bool, error) // 同時刪除該使用者的 API 權杖
+	PutAPIToken(token APIToken) error
+	GetAPIToken(id string) (APIToken, bool, error)
+	ListAPITokens(userName string) ([]APIToken, error) // userName 空白表示全部
+	DeleteAPIToken(id string) (bool, error)
+	TouchAPIToken(id string, at time.Time) error
+
+	// 稽核紀錄
+	InsertAuditEvent(event AuditEvent) error
+	AuditEvents(q AuditQuery) ([]AuditEvent, error) // 依時間由新到舊
+}
+
+// 一次輪詢的結果
+type PollRecord struct {
+	DeviceID  string         `json:"device_id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Readings  []MeterReading `json:"readings"`
+}
+
+// 依序讀取取樣 (用法同 sql.Rows)
+type SampleRows interface {
+	Next() bool
+	Sample() Sample
+	Err() error
+	Close() error
+}
+
+// 單筆取樣
+type Sample struct {
+	DeviceID      string
+	Point         string
+	Timestamp     time.Time
+	Value         *float64 // 通訊失敗等沒有數值時為 nil
+	Unit          string
+	Quality       string
+	ExceptionCode int
+}
+
+// 查詢時的品質篩選
+type QualityFilter int
+
+const (
+	QualityFilterAll    QualityFilter = iota // 所有紀錄 (含沒有數值的)
+	QualityFilterUsable                      // good、stale 與 backfilled
+	QualityFilterValued                      // 所有帶有數值的品質 (含 out_of_range、substituted)
+)
+
+// 依是否包含異常數值選擇篩選條件
+func usableFilter(includeBad bool) QualityFilter {
+	if includeBad {
+		return QualityFilterValued
+	}
+	return QualityFilterUsable
+}
+
+// 取樣查詢條件 (From、To 皆包含，毫秒精度)
+type SampleQuery struct {
+	DeviceID string
+	Point    string
+	From     time.Time
+	To       time.Time
+	Quality  QualityFilter
+	Limit    int // 0 表示不限制
+}
+
+// 固定寬度時間區間的統計
+type SampleBucket struct {
+	Index  int64 // 自 origin 起算的區間序號
+	Avg    float64
+	Min    float64
+	Max    float64
+	Count  int
+	MeanMs float64 // 區間內取樣時間的平均 (相對 origin 的毫秒)
+}
+
+// 日曆期間的統計
+type SampleRollup struct {
+	Start    time.Time
+	Avg      float64
+	Min      float64
+	Max      float64
+	Count    int // 納入計算的筆數
+	BadCount int // 品質異常的筆數
+}
+
+// 取樣缺口：兩筆實際量測之間超過門檻的區間
+type SampleGap struct {
+	DeviceID string    `json:"device"`
+	Start    time.Time `json:"start"` // 缺口前最後一筆取樣
+	End      time.Time `json:"end"`   // 缺口後第一筆取樣 (尚未恢復時為查詢結束時間)
+	Open     bool      `json:"open"`  // 尚未恢復
+}
+
+// 累積電能計數器兩筆取樣間的用電量
+type EnergyDelta struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Delta      float64   `json:"delta"`
+	Backfilled bool      `json:"backfilled"` // 任一端為補齊的取樣
+	Gap        bool      `json:"gap"`        // 跨越缺口 (用電量無法分配到缺口內的各時段)
+}
+
+// 大量寫入 (匯入使用)：分批交易，電表+點位+時間重複時略過
+type SampleWriter interface {
+	Insert(s Sample) (bool, error) // 回傳 false 表示已存在
+	Close(commit bool) error
+}
+
+// 依設定開啟資料儲存
+func OpenStorage(cfg DatabaseConfig) (Storage, error) {
+	switch cfg.Driver {
+	case "", "sqlite":
+		return NewSQLiteStorage(cfg)
+	case "postgres":
+		return NewPostgresStorage(cfg.DSN, cfg.Timescale)
+	default:
+		return nil, fmt.Errorf("不支援的資料庫類型: %s", cfg.Driver)
+	}
+}
+
+// SQL 方言
+type sqlDialect struct {
+	numbered     bool   // 參數使用 $1, $2 (PostgreSQL)
+	upsertSample string // 寫入取樣 (重複時覆寫)
+	insertSample string // 寫入取樣 (重複時略過)
+	insertCheck  string // dry run：寫入暫存資料表 (重複或已存在時略過)
+	tempTable    string // dry run 暫存資料表
+	timeValue    func(time.Time) interface{}
+}
+
+// SQLite 與 PostgreSQL 共用的實作 (只使用兩者皆支援的 SQL)
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	var one int
+	return s.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+}
+
+// 將 ? 參數轉為方言的格式
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// 品質篩選條件 (SQL)
+func qualityFilterSQL(filter QualityFilter) string {
+	switch filter {
+	case QualityFilterUsable:
+		return usableQualitySQL(false)
+	case QualityFilterValued:
+		return usableQualitySQL(true)
+	default:
+		return `1 = 1`
+	}
+}
+
+// 查詢條件的 WHERE 子句與參數
+func (q SampleQuery) where() (string, []interface{}) {
+	return fmt.Sprintf(`device_id = ? AND point = ? AND ts >= ? AND ts <= ? AND %s`, qualityFilterSQL(q.Quality)),
+		[]interface{}{q.DeviceID, q.Point, q.From.UnixMilli(), q.To.UnixMilli()}
+}
+
+func (s *sqlStore) WriteSamples(records []PollRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("資料庫交易失敗: %v", err)
+	}
+	defer tx.Rollback()
+
+	snapshotStmt, err := tx.Prepare(s.rebind(`INSERT INTO meter_data (timestamp, device_id, json_data) VALUES (?, ?, ?)`))
+	if err != nil {
+		return fmt.Errorf("資料庫插入失敗: %v", err)
+	}
+	defer snapshotStmt.Close()
+
+	// 每個點位各存一筆，連同品質代碼
+	sampleStmt, err := tx.Prepare(s.rebind(s.dialect.upsertSample))
+	if err != nil {
+		return fmt.Errorf("資料庫插入失敗: %v", err)
+	}
+	defer sampleStmt.Close()
+
+	for _, record := range records {
+		jsonData, err := json.Marshal(record.Readings)
+		if err != nil {
+			return fmt.Errorf("JSON 編碼失敗: %v", err)
+		}
+
+		// 時間戳記使用實際取樣時間
+		if _, err := snapshotStmt.Exec(s.dialect.timeValue(record.Timestamp), record.DeviceID, string(jsonData)); err != nil {
+			return fmt.Errorf("資料庫插入失敗: %v", err)
+		}
+		for _, reading := range record.Readings {
+			_, err = sampleStmt.Exec(record.DeviceID, reading.Name, record.Timestamp.UnixMilli(), readingValue(reading), reading.Unit, reading.Quality, reading.ExceptionCode)
+			if err != nil {
+				return fmt.Errorf("資料庫插入失敗: %v", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) LatestSnapshot(deviceID string) (string, error) {
+	var jsonData string
+	err := s.db.QueryRow(s.rebind(`SELECT json_data FROM meter_data WHERE device_id = ? ORDER BY timestamp DESC LIMIT 1`), deviceID).Scan(&jsonData)
+	return jsonData, err
+}
+
+func (s *sqlStore) CountSamples(q SampleQuery) (int, string, error) {
+	where, args := q.where()
+	var total int
+	var unit string
+	err := s.db.QueryRow(s.rebind(`SELECT COUNT(*), COALESCE(MAX(unit), '') FROM samples WHERE `+where), args...).Scan(&total, &unit)
+	return total, unit, err
+}
+
+func (s *sqlStore) QuerySamples(q SampleQuery) (SampleRows, error) {
+	where, args := q.where()
+	query := `SELECT ts, value, unit, quality, exception_code FROM samples WHERE ` + where + ` ORDER BY ts`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlSampleRows{rows: rows, query: q}, nil
+}
+
+type sqlSampleRows struct {
+	rows   *sql.Rows
+	query  SampleQuery
+	sample Sample
+	err    error
+}
+
+func (r *sqlSampleRows) Next() bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+
+	var ts int64
+	var value sql.NullFloat64
+	r.sample = Sample{DeviceID: r.query.DeviceID, Point: r.query.Point}
+	if r.err = r.rows.Scan(&ts, &value, &r.sample.Unit, &r.sample.Quality, &r.sample.ExceptionCode); r.err != nil {
+		return false
+	}
+	r.sample.Timestamp = time.UnixMilli(ts)
+	if value.Valid {
+		r.sample.Value = floatPtr(value.Float64)
+	}
+	return true
+}
+
+func (r *sqlSampleRows) Sample() Sample { return r.sample }
+
+func (r *sqlSampleRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+func (r *sqlSampleRows) Close() error { return r.rows.Close() }
+
+// 逐筆處理查詢結果
+func forEachSample(store Storage, q SampleQuery, fn func(Sample) error) error {
+	rows, err := store.QuerySamples(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows.Sample()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// 點位最近一筆的單位
+func (s *sqlStore) LatestUnit(deviceID, point string) (string, error) {
+	var unit string
+	err := s.db.QueryRow(s.rebind(`SELECT unit FROM samples WHERE device_id = ? AND point = ? AND unit != '' ORDER BY ts DESC LIMIT 1`),
+		deviceID, point).Scan(&unit)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return unit, err
+}
+
+func (s *sqlStore) BucketSamples(q SampleQuery, origin time.Time, width time.Duration, fn func(SampleBucket) error) error {
+	where, args := q.where()
+	originMs, widthMs := origin.UnixMilli(), width.Milliseconds()
+	if widthMs < 1 {
+		widthMs = 1
+	}
+
+	query := `SELECT (ts - ?) / ? AS bucket, AVG(value), MIN(value), MAX(value), COUNT(*), AVG(ts - ?)
+		FROM samples WHERE ` + where + ` GROUP BY bucket ORDER BY bucket`
+	rows, err := s.db.Query(s.rebind(query), append([]interface{}{originMs, widthMs, originMs}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket SampleBucket
+		var avg, min, max sql.NullFloat64
+		if err := rows.Scan(&bucket.Index, &avg, &min, &max, &bucket.Count, &bucket.MeanMs); err != nil {
+			return err
+		}
+		bucket.Avg, bucket.Min, bucket.Max = avg.Float64, min.Float64, max.Float64
+		if err := fn(bucket); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// 日曆期間統計：期間邊界由呼叫端依時區計算 (periods[i] ~ periods[i+1])，只回傳有資料的期間
+func (s *sqlStore) Rollup(deviceID, point string, periods []time.Time, filter QualityFilter) ([]SampleRollup, error) {
+	result := make([]SampleRollup, 0)
+	if len(periods) < 2 {
+		return result, nil
+	}
+
+	values := make([]string, 0, len(periods)-1)
+	args := make([]interface{}, 0, 2*len(periods)+2)
+	starts := make(map[int64]time.Time, len(periods)-1)
+	for i := 0; i+1 < len(periods); i++ {
+		values = append(values, `(CAST(? AS BIGINT), CAST(? AS BIGINT))`)
+		args = append(args, periods[i].UnixMilli(), periods[i+1].UnixMilli())
+		starts[periods[i].UnixMilli()] = periods[i]
+	}
+	args = append(args, deviceID, point)
+
+	usable := qualityFilterSQL(filter)
+	query := fmt.Sprintf(`
+	WITH periods (start_ms, end_ms) AS (VALUES %s)
+	SELECT
+		p.start_ms,
+		AVG(CASE WHEN %[2]s THEN s.value END),
+		MIN(CASE WHEN %[2]s THEN s.value END),
+		MAX(CASE WHEN %[2]s THEN s.value END),
+		SUM(CASE WHEN %[2]s THEN 1 ELSE 0 END),
+		SUM(CASE WHEN s.quality NOT IN ('good', 'backfilled') THEN 1 ELSE 0 END)
+	FROM periods p
+	JOIN samples s ON s.ts >= p.start_ms AND s.ts < p.end_ms
+	WHERE s.device_id = ? AND s.point = ?
+	GROUP BY p.start_ms
+	ORDER BY p.start_ms`, strings.Join(values, ", "), usable)
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var start int64
+		var avg, min, max sql.NullFloat64
+		var rollup SampleRollup
+		if err := rows.Scan(&start, &avg, &min, &max, &rollup.Count, &rollup.BadCount); err != nil {
+			return nil, err
+		}
+		rollup.Start = starts[start]
+		rollup.Avg, rollup.Min, rollup.Max = avg.Float64, min.Float64, max.Float64
+		result = append(result, rollup)
+	}
+	return result, rows.Err()
+}
+
+// 缺口偵測：以實際量測的取樣時間 (任一點位) 比較相鄰間隔；
+// 區間前後各延伸到最近一筆取樣，跨越查詢邊界的缺口也能完整回傳
+func (s *sqlStore) SampleGaps(deviceID string, from, to time.Time, minGap time.Duration) ([]SampleGap, error) {
+	gaps := make([]SampleGap, 0)
+	measured := measuredQualitySQL()
+
+	var before, after, last sql.NullInt64
+	if err := s.db.QueryRow(s.rebind(`SELECT MAX(ts) FROM samples WHERE device_id = ? AND ts < ? AND `+measured),
+		deviceID, from.UnixMilli()).Scan(&before); err != nil {
+		return nil, err
+	}
+	if err := s.db.QueryRow(s.rebind(`SELECT MIN(ts) FROM samples WHERE device_id = ? AND ts > ? AND `+measured),
+		deviceID, to.UnixMilli()).Scan(&after); err != nil {
+		return nil, err
+	}
+	lower, upper := from.UnixMilli(), to.UnixMilli()
+	if before.Valid {
+		lower = before.Int64
+	}
+	if after.Valid {
+		upper = after.Int64
+	}
+
+	rows, err := s.db.Query(s.rebind(`
+	SELECT prev_ts, ts FROM (
+		SELECT ts, LAG(ts) OVER (ORDER BY ts) AS prev_ts
+		FROM (SELECT DISTINCT ts FROM samples WHERE device_id = ? AND ts >= ? AND ts <= ? AND `+measured+`) t
+	) g WHERE ts - prev_ts > ? ORDER BY ts`), deviceID, lower, upper, minGap.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var start, end int64
+		if err := rows.Scan(&start, &end); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, SampleGap{DeviceID: deviceID, Start: time.UnixMilli(start), End: time.UnixMilli(end)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 區間之後沒有取樣：最後一筆至查詢結束時間仍未恢復
+	if !after.Valid {
+		if err := s.db.QueryRow(s.rebind(`SELECT MAX(ts) FROM samples WHERE device_id = ? AND ts >= ? AND ts <= ? AND `+measured),
+			deviceID, lower, upper).Scan(&last); err != nil {
+			return nil, err
+		}
+		if last.Valid && upper-last.Int64 > minGap.Milliseconds() {
+			gaps = append(gaps, SampleGap{DeviceID: deviceID, Start: time.UnixMilli(last.Int64), End: to, Open: true})
+		}
+	}
+	return gaps, nil
+}
+
+// 寫入補齊的取樣：已存在的量測值不覆寫，通訊失敗等沒有量測值的取樣以補齊值取代。回傳寫入的筆數
+func (s *sqlStore) BackfillSamples(samples []Sample) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("資料庫交易失敗: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(s.rebind(`INSERT INTO samples (device_id, point, ts, value, unit, quality, exception_code) VALUES (?, ?, ?, ?, ?, ?, 0)
+		ON CONFLICT (device_id, point, ts) DO UPDATE SET value = excluded.value, unit = excluded.unit,
+		quality = excluded.quality, exception_code = 0
+		WHERE samples.value IS NULL OR samples.quality IN ('comm_error', 'device_exception', 'invalid_sentinel', 'substituted')`))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	written := 0
+	for _, sample := range samples {
+		var value interface{}
+		if sample.Value != nil {
+			value = *sample.Value
+		}
+		result, err := stmt.Exec(sample.DeviceID, sample.Point, sample.Timestamp.UnixMilli(), value, sample.Unit, sample.Quality)
+		if err != nil {
+			return 0, fmt.Errorf("資料庫插入失敗: %v", err)
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			written++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// 重新計算累積電能計數器在區間內 (依取樣結束時間) 的用電量，以區間前最後一筆為起點；
+// 計數器數值變小時視為歸零，以新數值為用電量。回傳寫入的筆數
+func (s *sqlStore) RecomputeEnergyDeltas(deviceID, point string, from, to time.Time, minGap time.Duration) (int, error) {
+	usable := usableQualitySQL(false)
+
+	type counterSample struct {
+		ts      int64
+		value   float64
+		quality string
+	}
+	var previous *counterSample
+	var first counterSample
+	err := s.db.QueryRow(s.rebind(`SELECT ts, value, quality FROM samples
+		WHERE device_id = ? AND point = ? AND ts < ? AND value IS NOT NULL AND `+usable+` ORDER BY ts DESC LIMIT 1`),
+		deviceID, point, from.UnixMilli()).Scan(&first.ts, &first.value, &first.quality)
+	switch {
+	case err == nil:
+		previous = &first
+	case err != sql.ErrNoRows:
+		return 0, err
+	}
+
+	rows, err := s.db.Query(s.rebind(`SELECT ts, value, quality FROM samples
+		WHERE device_id = ? AND point = ? AND ts >= ? AND ts <= ? AND value IS NOT NULL AND `+usable+` ORDER BY ts`),
+		deviceID, point, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	var deltas []EnergyDelta
+	for rows.Next() {
+		var current counterSample
+		if err := rows.Scan(&current.ts, &current.value, &current.quality); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if previous != nil {
+			delta := current.value - previous.value
+			if delta < 0 {
+				delta = current.value
+			}
+			// 補齊的取樣間隔為電表內部紀錄的間隔，不視為缺口
+			backfilled := previous.quality == QualityBackfilled || current.quality == QualityBackfilled
+			deltas = append(deltas, EnergyDelta{
+				Start:      time.UnixMilli(previous.ts),
+				End:        time.UnixMilli(current.ts),
+				Delta:      delta,
+				Backfilled: backfilled,
+				Gap:        !backfilled && current.ts-previous.ts > minGap.Milliseconds(),
+			})
+		}
+		previous = &current
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("資料庫交易失敗: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind(`DELETE FROM energy_deltas WHERE device_id = ? AND point = ? AND ts >= ? AND ts <= ?`),
+		deviceID, point, from.UnixMilli(), to.UnixMilli()); err != nil {
+		return 0, err
+	}
+	stmt, err := tx.Prepare(s.rebind(`INSERT INTO energy_deltas (device_id, point, ts, start_ts, delta, backfilled, gap) VALUES (?, ?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, delta := range deltas {
+		if _, err := stmt.Exec(deviceID, point, delta.End.UnixMilli(), delta.Start.UnixMilli(), delta.Delta, delta.Backfilled, delta.Gap); err != nil {
+			return 0, fmt.Errorf("資料庫插入失敗: %v", err)
+		}
+	}
+	return len(deltas), tx.Commit()
+}
+
+// 區間內 (依結束時間) 的用電量，依時間排序
+func (s *sqlStore) EnergyDeltas(deviceID, point string, from, to time.Time) ([]EnergyDelta, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT start_ts, ts, delta, backfilled, gap FROM energy_deltas
+		WHERE device_id = ? AND point = ? AND ts >= ? AND ts <= ? ORDER BY ts`),
+		deviceID, point, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deltas := make([]EnergyDelta, 0)
+	for rows.Next() {
+		var start, end int64
+		var delta EnergyDelta
+		if err := rows.Scan(&start, &end, &delta.Delta, &delta.Backfilled, &delta.Gap); err != nil {
+			return nil, err
+		}
+		delta.Start, delta.End = time.UnixMilli(start), time.UnixMilli(end)
+		deltas = append(deltas, delta)
+	}
+	return deltas, rows.Err()
+}
+
+func (s *sqlStore) InsertAlarmEvent(event AlarmEvent) error {
+	_, err := s.db.Exec(s.rebind(`INSERT INTO alarm_events (timestamp, rule_id, device_id, parameter, state, value, threshold, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		s.dialect.timeValue(event.Timestamp), event.RuleID, event.DeviceID, event.Parameter, event.State, event.Value, event.Threshold, event.Message)
+	return err
+}
+
+func (s *sqlStore) RecentAlarmEvents(limit int) ([]AlarmEvent, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT id, timestamp, rule_id, device_id, parameter, state, value, threshold, message
+		FROM alarm_events ORDER BY id DESC LIMIT ?`), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlarmEvents(rows, time.Time{}, time.Time{})
+}
+
+// 舊版 SQLite 資料庫的告警時間以當地時區文字寫入，無法直接以文字比較，
+// 因此先以前後各放寬一天的範圍查詢，再依實際時間篩選
+func (s *sqlStore) AlarmEvents(from, to time.Time) ([]AlarmEvent, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT id, timestamp, rule_id, device_id, parameter, state, value, threshold, message
+		FROM alarm_events WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp, id`),
+		s.dialect.timeValue(from.Add(-24*time.Hour)), s.dialect.timeValue(to.Add(24*time.Hour)))
+	if err != nil {
+		return nil, err
+	}
+	events, err := scanAlarmEvents(rows, from, to)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+// 讀取告警事件；from/to 不為零值時只保留區間內的事件
+func scanAlarmEvents(rows *sql.Rows, from, to time.Time) ([]AlarmEvent, error) {
+	defer rows.Close()
+
+	events := make([]AlarmEvent, 0)
+	for rows.Next() {
+		var event AlarmEvent
+		var timestamp interface{}
+		if err := rows.Scan(&event.ID, &timestamp, &event.RuleID, &event.DeviceID, &event.Parameter,
+			&event.State, &event.Value, &event.Threshold, &event.Message); err != nil {
+			return nil, err
+		}
+		event.Timestamp = scanTime(timestamp)
+		if !from.IsZero() && (event.Timestamp.Before(from) || event.Timestamp.After(to)) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// 時間欄位：PostgreSQL 為 time.Time，SQLite 依寫入方式可能為 time.Time 或文字
+func scanTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		ts, _ := parseSQLiteTime(v)
+		return ts
+	case []byte:
+		ts, _ := parseSQLiteTime(string(v))
+		return ts
+	}
+	return time.Time{}
+}
+
+func (s *sqlStore) GetSetting(key string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRow(s.rebind(`SELECT value FROM settings WHERE key = ?`), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s *sqlStore) PutSetting(key, value string) error {
+	_, err := s.db.Exec(s.rebind(`INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`),
+		key, value, s.dialect.timeValue(time.Now()))
+	return err
+}
+
+// 時間欄位以毫秒儲存，零值為 0
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func timeFromMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func (s *sqlStore) PutUser(user User) error {
+	_, err := s.db.Exec(s.rebind(`INSERT INTO users (name, password_hash, role, disabled, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET password_hash = excluded.password_hash, role = excluded.role,
+		disabled = excluded.disabled, updated_at = excluded.updated_at`),
+		user.Name, user.PasswordHash, user.Role, user.Disabled, unixMilliOrZero(user.CreatedAt), unixMilliOrZero(user.UpdatedAt))
+	return err
+}
+
+func (s *sqlStore) GetUser(name string) (User, bool, error) {
+	users, err := s.queryUsers(`SELECT name, password_hash, role, disabled, created_at, updated_at FROM users WHERE name = ?`, name)
+	if err != nil || len(users) == 0 {
+		return User{}, false, err
+	}
+	return users[0], true, nil
+}
+
+func (s *sqlStore) ListUsers() ([]User, error) {
+	return s.queryUsers(`SELECT name, password_hash, role, disabled, created_at, updated_at FROM users ORDER BY name`)
+}
+
+func (s *sqlStore) queryUsers(query string, args ...interface{}) ([]User, error) {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var user User
+		var created, updated int64
+		if err := rows.Scan(&user.Name, &user.PasswordHash, &user.Role, &user.Disabled, &created, &updated); err != nil {
+			return nil, err
+		}
+		user.CreatedAt, user.UpdatedAt = timeFromMilli(created), timeFromMilli(updated)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlStore) DeleteUser(name string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("資料庫交易失敗: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.rebind(`DELETE FROM api_tokens WHERE user_name = ?`), name); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(s.rebind(`DELETE FROM users WHERE name = ?`), name)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, tx.Commit()
+}
+
+func (s *sqlStore) PutAPIToken(token APIToken) error {
+	_, err := s.db.Exec(s.rebind(`INSERT INTO api_tokens (id, name, user_name, role, secret_hash, created_at, expires_at, last_used_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		token.ID, token.Name, token.User, token.Role, token.SecretHash,
+		unixMilliOrZero(token.CreatedAt), unixMilliOrZero(token.ExpiresAt), unixMilliOrZero(token.LastUsedAt))
+	return err
+}
+
+func (s *sqlStore) GetAPIToken(id string) (APIToken, bool, error) {
+	tokens, err := s.queryAPITokens(`SELECT id, name, user_name, role, secret_hash, created_at, expires_at, last_used_at
+		FROM api_tokens WHERE id = ?`, id)
+	if err != nil || len(tokens) == 0 {
+		return APIToken{}, false, err
+	}
+	return tokens[0], true, nil
+}
+
+func (s *sqlStore) ListAPITokens(userName string) ([]APIToken, error) {
+	if userName == "" {
+		return s.queryAPITokens(`SELECT id, name, user_name, role, secret_hash, created_at, expires_at, last_used_at
+			FROM api_tokens ORDER BY user_name, created_at`)
+	}
+	return s.queryAPITokens(`SELECT id, name, user_name, role, secret_hash, created_at, expires_at, last_used_at
+		FROM api_tokens WHERE user_name = ? ORDER BY created_at`, userName)
+}
+
+func (s *sqlStore) queryAPITokens(query string, args ...interface{}) ([]APIToken, error) {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		var token APIToken
+		var created, expires, used int64
+		if err := rows.Scan(&token.ID, &token.Name, &token.User, &token.Role, &token.SecretHash, &created, &expires, &used); err != nil {
+			return nil, err
+		}
+		token.CreatedAt, token.ExpiresAt, token.LastUsedAt = timeFromMilli(created), timeFromMilli(expires), timeFromMilli(used)
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlStore) DeleteAPIToken(id string) (bool, error) {
+	result, err := s.db.Exec(s.rebind(`DELETE FROM api_tokens WHERE id = ?`), id)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+func (s *sqlStore) TouchAPIToken(id string, at time.Time) error {
+	_, err := s.db.Exec(s.rebind(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`), at.UnixMilli(), id)
+	return err
+}
+
+func (s *sqlStore) InsertAuditEvent(event AuditEvent) error {
+	_, err := s.db.Exec(s.rebind(`INSERT INTO audit_log (ts, user_name, role, auth, remote, action, target, status, detail)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		event.Timestamp.UnixMilli(), event.User, event.Role, event.Auth, event.Remote, event.Action, event.Target, event.Status, event.Detail)
+	return err
+}
+
+func (s *sqlStore) AuditEvents(q AuditQuery) ([]AuditEvent, error) {
+	query := `SELECT id, ts, user_name, role, auth, remote, action, target, status, detail FROM audit_log WHERE ts >= ? AND ts <= ?`
+	args := []interface{}{q.From.UnixMilli(), q.To.UnixMilli()}
+	if q.User != "" {
+		query += ` AND user_name = ?`
+		args = append(args, q.User)
+	}
+	query += ` ORDER BY ts DESC, id DESC LIMIT ?`
+	args = append(args, q.Limit)
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]AuditEvent, 0)
+	for rows.Next() {
+		var event AuditEvent
+		var ts int64
+		if err := rows.Scan(&event.ID, &ts, &event.User, &event.Role, &event.Auth, &event.Remote,
+			&event.Action, &event.Target, &event.Status, &event.Detail); err != nil {
+			return nil, err
+		}
+		event.Timestamp = time.UnixMilli(ts)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// 每批交易寫入的筆數 (避免長時間鎖住資料庫影響收集)
+const sampleWriterBatchSize = 5000
+
+// 大量寫入
+type sqlSampleWriter struct {
+	store   *sqlStore
+	dryRun  bool
+	ctx     context.Context
+	conn    *sql.Conn
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	pending int
+}
+
+// 開啟大量寫入；dry run 時寫入暫存資料表 (偵測檔案內重複)，不修改資料
+func (s *sqlStore) OpenSampleWriter(dryRun bool) (SampleWriter, error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		if _, err := conn.ExecContext(ctx, s.dialect.tempTable); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &sqlSampleWriter{store: s, dryRun: dryRun, ctx: ctx, conn: conn}, nil
+}
+
+func (w *sqlSampleWriter) begin() error {
+	tx, err := w.conn.BeginTx(w.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("資料庫交易失敗: %v", err)
+	}
+
+	query := w.store.dialect.insertSample
+	if w.dryRun {
+		query = w.store.dialect.insertCheck
+	}
+	stmt, err := tx.Prepare(w.store.rebind(query))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	w.tx, w.stmt, w.pending = tx, stmt, 0
+	return nil
+}
+
+func (w *sqlSampleWriter) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	w.stmt.Close()
+	err := w.tx.Commit()
+	w.tx, w.stmt = nil, nil
+	return err
+}
+
+func (w *sqlSampleWriter) Insert(sample Sample) (bool, error) {
+	if w.tx == nil {
+		if err := w.begin(); err != nil {
+			return false, err
+		}
+	}
+
+	var value interface{}
+	if sample.Value != nil {
+		value = *sample.Value
+	}
+	ts := sample.Timestamp.UnixMilli()
+
+	var result sql.Result
+	var err error
+	if w.dryRun {
+		result, err = w.stmt.Exec(sample.DeviceID, sample.Point, ts, sample.DeviceID, sample.Point, ts)
+	} else {
+		result, err = w.stmt.Exec(sample.DeviceID, sample.Point, ts, value, sample.Unit, sample.Quality, sample.ExceptionCode)
+	}
+	if err != nil {
+		return false, fmt.Errorf("資料庫插入失敗: %v", err)
+	}
+	affected, _ := result.RowsAffected()
+
+	w.pending++
+	if w.pending >= sampleWriterBatchSize {
+		if err := w.commit(); err != nil {
+			return false, err
+		}
+	}
+	return affected > 0, nil
+}
+
+// 結束寫入 (commit 為 false 時放棄尚未提交的批次)
+func (w *sqlSampleWriter) Close(commit bool) error {
+	var err error
+	if commit {
+		err = w.commit()
+	} else if w.tx != nil {
+		w.stmt.Close()
+		w.tx.Rollback()
+	}
+	if w.dryRun {
+		w.conn.ExecContext(w.ctx, `DROP TABLE IF EXISTS import_check`)
+	}
+	w.conn.Close()
+	return err
+}
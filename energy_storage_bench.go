@@ -0,0 +1,367 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 寫入效能測試：模擬多個電表同時輪詢寫入，並以查詢模擬儀表板的讀取負載
+// 使用暫存資料庫 (SQLite 暫存檔、PostgreSQL 暫存 schema)，不影響正式資料
+
+// 延遲統計
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencyRecorder) add(d time.Duration) {
+	l.mu.Lock()
+	l.samples = append(l.samples, d)
+	l.mu.Unlock()
+}
+
+// 回傳 p50、p99、最大值
+func (l *latencyRecorder) summary() (time.Duration, time.Duration, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.samples) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(l.samples, func(i, j int) bool { return l.samples[i] < l.samples[j] })
+	at := func(p float64) time.Duration { return l.samples[int(p*float64(len(l.samples)-1))] }
+	return at(0.5), at(0.99), l.samples[len(l.samples)-1]
+}
+
+func (l *latencyRecorder) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.samples)
+}
+
+// storage bench 子命令
+func runStorageBenchCommand(args []string) int {
+	fs := flag.NewFlagSet("storage bench", flag.ContinueOnError)
+	flags := registerStorageFlags(fs)
+	meters := fs.Int("meters", 100, "模擬的電表數量")
+	points := fs.Int("points", 40, "每個電表的點位數")
+	interval := fs.Duration("interval", time.Second, "每個電表的輪詢間隔")
+	duration := fs.Duration("duration", 30*time.Second, "測試時間")
+	readers := fs.Int("readers", 4, "同時查詢的讀取端數量 (模擬儀表板)")
+	direct := fs.Bool("direct", false, "不使用寫入佇列，每次輪詢直接寫入 (比較用)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *meters < 1 || *points < 1 || *interval <= 0 || *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "❌ meters、points、interval、duration 必須大於 0")
+		return 2
+	}
+
+	database, err := flags.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 載入設定失敗: %v\n", err)
+		return 1
+	}
+
+	store, cleanup, err := openScratchStorage(database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer cleanup()
+	if err := store.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	mode := fmt.Sprintf("寫入佇列 (容量 %d，每批 %d 筆)", database.WriteQueue.Size, database.WriteQueue.BatchSize)
+	if *direct {
+		mode = "直接寫入"
+	}
+	fmt.Printf("🔎 寫入效能測試: %s，%s\n", store.Driver(), mode)
+	fmt.Printf("   %d 個電表 × %d 點位，每 %v 輪詢，測試 %v，%d 個讀取端\n", *meters, *points, *interval, *duration, *readers)
+
+	var queue *WriteQueue
+	if !*direct {
+		queue = NewWriteQueue(store, database.WriteQueue, nil)
+		queue.Start()
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var polls, dropped, failed, overruns int64
+	var countMu sync.Mutex
+	enqueueLatency := &latencyRecorder{}
+	queryLatency := &latencyRecorder{}
+	start := time.Now()
+
+	// 模擬電表：各自以固定間隔產生一次輪詢的讀值 (起始時間錯開)
+	for m := 0; m < *meters; m++ {
+		wg.Add(1)
+		go func(m int) {
+			defer wg.Done()
+			deviceID := fmt.Sprintf("bench-%03d", m)
+			offset := time.Duration(m) * *interval / time.Duration(*meters)
+			select {
+			case <-time.After(offset):
+			case <-stop:
+				return
+			}
+
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for {
+				readings := make([]MeterReading, *points)
+				for p := range readings {
+					readings[p] = MeterReading{Name: fmt.Sprintf("P%02d", p), Value: 220 + rand.Float64()*10, Unit: "V", Quality: QualityGood}
+				}
+				record := PollRecord{DeviceID: deviceID, Timestamp: time.Now(), Readings: readings}
+
+				begin := time.Now()
+				var err error
+				if queue != nil {
+					err = queue.Enqueue(record)
+				} else {
+					err = store.WriteSamples([]PollRecord{record})
+				}
+				elapsed := time.Since(begin)
+				enqueueLatency.add(elapsed)
+
+				countMu.Lock()
+				polls++
+				if err != nil {
+					if queue != nil {
+						dropped++
+					} else {
+						failed++
+					}
+				}
+				if elapsed > *interval {
+					overruns++
+				}
+				countMu.Unlock()
+
+				select {
+				case <-ticker.C:
+				case <-stop:
+					return
+				}
+			}
+		}(m)
+	}
+
+	// 模擬儀表板：查詢最近一分鐘的降採樣資料
+	for r := 0; r < *readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				now := time.Now()
+				q := SampleQuery{
+					DeviceID: fmt.Sprintf("bench-%03d", rand.Intn(*meters)),
+					Point:    fmt.Sprintf("P%02d", rand.Intn(*points)),
+					From:     now.Add(-time.Minute),
+					To:       now,
+					Quality:  QualityFilterUsable,
+				}
+				begin := time.Now()
+				store.BucketSamples(q, q.From, 5*time.Second, func(SampleBucket) error { return nil })
+				queryLatency.add(time.Since(begin))
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+	}
+
+	// 每 5 秒顯示進度
+	progress := time.NewTicker(5 * time.Second)
+	deadline := time.After(*duration)
+loop:
+	for {
+		select {
+		case <-progress.C:
+			if queue != nil {
+				stats := queue.Stats()
+				fmt.Printf("   %3.0fs 佇列 %d/%d，已寫入 %d 次輪詢，等待 %d 次，丟棄 %d\n",
+					time.Since(start).Seconds(), stats.Depth, stats.Capacity, stats.Written, stats.Blocked, stats.Dropped)
+			} else {
+				fmt.Printf("   %3.0fs 已寫入 %d 次輪詢\n", time.Since(start).Seconds(), enqueueLatency.count())
+			}
+		case <-deadline:
+			break loop
+		}
+	}
+	progress.Stop()
+	close(stop)
+	wg.Wait()
+
+	// 寫入佇列中剩餘的資料
+	drainStart := time.Now()
+	var stats WriteQueueStats
+	if queue != nil {
+		queue.Stop()
+		stats = queue.Stats()
+		failed = stats.Failed
+	}
+	drain := time.Since(drainStart)
+	elapsed := time.Since(start)
+
+	written := polls - dropped - failed
+	required := float64(*meters**points) / interval.Seconds()
+	throughput := float64(written*int64(*points)) / elapsed.Seconds()
+	p50, p99, max := enqueueLatency.summary()
+	q50, q99, qmax := queryLatency.summary()
+
+	fmt.Println()
+	fmt.Printf("輪詢: %d 次，寫入 %d，丟棄 %d，失敗 %d\n", polls, written, dropped, failed)
+	fmt.Printf("吞吐量: %.0f 點位/秒 (需求 %.0f 點位/秒)\n", throughput, required)
+	fmt.Printf("收集器寫入延遲: p50 %v，p99 %v，最大 %v，超過輪詢間隔 %d 次\n",
+		p50.Round(time.Microsecond), p99.Round(time.Microsecond), max.Round(time.Microsecond), overruns)
+	if queue != nil && stats.Batches > 0 {
+		fmt.Printf("寫入佇列: 最大深度 %d/%d，%d 個交易 (平均每批 %.1f 次輪詢)，背壓等待 %d 次 (%v)，結束時寫入剩餘資料 %v\n",
+			stats.MaxDepth, stats.Capacity, stats.Batches, float64(stats.Written)/float64(stats.Batches),
+			stats.Blocked, stats.BlockedTime.Round(time.Millisecond), drain.Round(time.Millisecond))
+	}
+	fmt.Printf("查詢: %d 次，p50 %v，p99 %v，最大 %v\n",
+		queryLatency.count(), q50.Round(time.Microsecond), q99.Round(time.Microsecond), qmax.Round(time.Microsecond))
+
+	if dropped > 0 || failed > 0 || overruns > 0 {
+		fmt.Println("❌ 無法維持輪詢速率 (有丟棄、失敗或逾時)")
+		return 1
+	}
+	fmt.Println("✅ 可維持輪詢速率")
+	return 0
+}
+
+// PostgreSQL 暫存資料庫使用暫存 schema (以 search_path 指定)，結束後刪除
+func postgresSchemaDSN(dsn, schema string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		values := u.Query()
+		values.Set("search_path", schema)
+		u.RawQuery = values.Encode()
+		return u.String(), nil
+	}
+	return dsn + " search_path=" + schema, nil
+}
+
+// storage 子命令
+func runStorageCommand(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "bench":
+			return runStorageBenchCommand(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "用法: energy_system storage bench [-driver sqlite|postgres] [-dsn 連線字串] [-timescale]")
+	return 2
+}
+
+// 共用的資料庫選項 (覆寫設定檔)
+type storageFlags struct {
+	config    *configFlags
+	driver    *string
+	dsn       *string
+	timescale *bool
+}
+
+func registerStorageFlags(fs *flag.FlagSet) *storageFlags {
+	return &storageFlags{
+		config:    registerConfigFlags(fs),
+		driver:    fs.String("driver", "", "資料庫類型 (預設依設定檔)"),
+		dsn:       fs.String("dsn", "", "PostgreSQL 連線字串 (預設依設定檔)"),
+		timescale: fs.Bool("timescale", false, "PostgreSQL 啟用 TimescaleDB"),
+	}
+}
+
+func (flags *storageFlags) load() (DatabaseConfig, error) {
+	config, err := flags.config.load()
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+	database := config.Database
+	if *flags.driver != "" {
+		database.Driver = *flags.driver
+	}
+	if *flags.dsn != "" {
+		database.DSN = *flags.dsn
+	}
+	if *flags.timescale {
+		database.Timescale = true
+	}
+	return database, nil
+}
+
+// 開啟暫存用的資料儲存 (SQLite 暫存檔、PostgreSQL 暫存 schema)，cleanup 關閉並刪除
+func openScratchStorage(database DatabaseConfig) (Storage, func(), error) {
+	switch database.Driver {
+	case "", "sqlite":
+		dir, err := ioutil.TempDir("", "energy-storage-scratch")
+		if err != nil {
+			return nil, nil, err
+		}
+		database.Path = filepath.Join(dir, "scratch.db")
+		store, err := NewSQLiteStorage(database)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, err
+		}
+		return store, func() {
+			store.Close()
+			os.RemoveAll(dir)
+		}, nil
+
+	case "postgres":
+		if database.DSN == "" {
+			return nil, nil, fmt.Errorf("請以 -dsn 或 database.dsn 指定 PostgreSQL 連線字串")
+		}
+		admin, err := NewPostgresStorage(database.DSN, false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		schema := fmt.Sprintf("energy_scratch_%d", time.Now().UnixNano())
+		db := admin.(*postgresStorage).db
+		if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+			admin.Close()
+			return nil, nil, fmt.Errorf("建立暫存 schema 失敗: %v", err)
+		}
+		dropSchema := func() {
+			if _, err := db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠️ 刪除暫存 schema %s 失敗: %v\n", schema, err)
+			}
+			admin.Close()
+		}
+
+		scratchDSN, err := postgresSchemaDSN(database.DSN, schema)
+		var store Storage
+		if err == nil {
+			store, err = NewPostgresStorage(scratchDSN, database.Timescale)
+		}
+		if err != nil {
+			dropSchema()
+			return nil, nil, err
+		}
+		return store, func() {
+			store.Close()
+			dropSchema()
+		}, nil
+	}
+	return nil, nil, fmt.Errorf("不支援的資料庫類型: %s", database.Driver)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgreSQL 資料儲存 (可選用 TimescaleDB)
+type postgresStorage struct {
+	sqlStore
+	timescale bool
+}
+
+// 開啟 PostgreSQL 資料庫
+func NewPostgresStorage(dsn string, timescale bool) (Storage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresStorage{sqlStore: sqlStore{db: db, dialect: sqlDialect{
+		numbered: true,
+		upsertSample: `INSERT INTO samples (device_id, point, ts, value, unit, quality, exception_code) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (device_id, point, ts) DO UPDATE SET value = excluded.value, unit = excluded.unit,
+			quality = excluded.quality, exception_code = excluded.exception_code`,
+		insertSample: `INSERT INTO samples (device_id, point, ts, value, unit, quality, exception_code) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+		insertCheck: `INSERT INTO import_check (device_id, point, ts)
+			SELECT CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS BIGINT)
+			WHERE NOT EXISTS (SELECT 1 FROM samples WHERE device_id = ? AND point = ? AND ts = ?)
+			ON CONFLICT DO NOTHING`,
+		tempTable: `CREATE TEMP TABLE IF NOT EXISTS import_check (
+			device_id TEXT NOT NULL, point TEXT NOT NULL, ts BIGINT NOT NULL,
+			PRIMARY KEY (device_id, point, ts))`,
+		timeValue: func(t time.Time) interface{} { return t },
+	}}, timescale: timescale}, nil
+}
+
+func (s *postgresStorage) Driver() string {
+	if s.timescale {
+		return "postgres+timescaledb"
+	}
+	return "postgres"
+}
+
+// PostgreSQL 資料表結構 (依版本逐步升級，與 SQLite 的版本號一致)
+var postgresMigrations = []string{
+	// 版本 1: 原始 JSON、每個點位一筆的取樣、告警事件
+	`
+	CREATE TABLE IF NOT EXISTS meter_data (
+		id BIGSERIAL PRIMARY KEY,
+		timestamp TIMESTAMPTZ NOT NULL DEFAULT now(),
+		device_id TEXT NOT NULL,
+		json_data JSONB NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_timestamp_device ON meter_data(timestamp, device_id);
+
+	CREATE TABLE IF NOT EXISTS samples (
+		device_id TEXT NOT NULL,
+		point TEXT NOT NULL,
+		ts BIGINT NOT NULL,
+		value DOUBLE PRECISION,
+		unit TEXT NOT NULL DEFAULT '',
+		quality TEXT NOT NULL DEFAULT 'good',
+		exception_code INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (device_id, point, ts)
+	);
+	CREATE INDEX IF NOT EXISTS idx_samples_ts ON samples(ts);
+
+	CREATE TABLE IF NOT EXISTS alarm_events (
+		id BIGSERIAL PRIMARY KEY,
+		timestamp TIMESTAMPTZ NOT NULL,
+		rule_id TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		parameter TEXT NOT NULL,
+		state TEXT NOT NULL,
+		value DOUBLE PRECISION,
+		threshold DOUBLE PRECISION,
+		message TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_alarm_events_timestamp ON alarm_events(timestamp);
+	`,
+	// 版本 2: 設定值資料表
+	`
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+	`,
+	// 版本 3: 累積電能計數器每次取樣間的用電量
+	`
+	CREATE TABLE IF NOT EXISTS energy_deltas (
+		device_id TEXT NOT NULL,
+		point TEXT NOT NULL,
+		ts BIGINT NOT NULL,
+		start_ts BIGINT NOT NULL,
+		delta DOUBLE PRECISION NOT NULL,
+		backfilled BOOLEAN NOT NULL DEFAULT FALSE,
+		gap BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (device_id, point, ts)
+	);
+	`,
+	// 版本 4: 使用者、API 權杖與稽核紀錄
+	`
+	CREATE TABLE IF NOT EXISTS users (
+		name TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		disabled BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		user_name TEXT NOT NULL,
+		role TEXT NOT NULL,
+		secret_hash TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL DEFAULT 0,
+		last_used_at BIGINT NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		ts BIGINT NOT NULL,
+		user_name TEXT NOT NULL,
+		role TEXT NOT NULL,
+		auth TEXT NOT NULL,
+		remote TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		status INTEGER NOT NULL,
+		detail TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_ts ON audit_log(ts);
+	`,
+}
+
+func (s *postgresStorage) Init() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("建立資料表失敗: %v", err)
+	}
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("讀取資料庫版本失敗: %v", err)
+	}
+
+	for version < len(postgresMigrations) {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(postgresMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("升級資料庫至版本 %d 失敗: %v", version+1, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM schema_version`); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES ($1)`, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		version++
+		log.Printf("✅ 資料庫已升級至版本 %d", version)
+	}
+
+	if s.timescale {
+		return s.initTimescale()
+	}
+	return nil
+}
+
+// 將 samples 轉為 TimescaleDB hypertable (以 ts 毫秒分區，每個 chunk 一天)
+func (s *postgresStorage) initTimescale() error {
+	if _, err := s.db.Exec(`CREATE EXTENSION IF NOT EXISTS timescaledb`); err != nil {
+		return fmt.Errorf("啟用 TimescaleDB 失敗: %v", err)
+	}
+	_, err := s.db.Exec(`SELECT create_hypertable('samples', 'ts', chunk_time_interval => 86400000::bigint,
+		if_not_exists => TRUE, migrate_data => TRUE)`)
+	if err != nil {
+		return fmt.Errorf("建立 hypertable 失敗: %v", err)
+	}
+	return nil
+}
+
+func (s *postgresStorage) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// 整個資料庫的大小 (含其他 schema)
+func (s *postgresStorage) Size() (int64, error) {
+	var size int64
+	err := s.db.QueryRow(`SELECT pg_database_size(current_database())`).Scan(&size)
+	return size, err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// SQLite 時間戳記格式 (UTC)
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+// SQLite 資料儲存
+type sqliteStorage struct {
+	sqlStore
+}
+
+// 開啟 SQLite 資料庫
+// WAL 模式讓 HTTP 查詢與寫入互不阻擋；busy_timeout 讓鎖定衝突時等待而不是立即失敗
+func NewSQLiteStorage(cfg DatabaseConfig) (Storage, error) {
+	db, err := sql.Open("sqlite3", sqliteDSN(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteStorage{sqlStore{db: db, dialect: sqlDialect{
+		upsertSample: `INSERT OR REPLACE INTO samples (device_id, point, ts, value, unit, quality, exception_code) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		insertSample: `INSERT OR IGNORE INTO samples (device_id, point, ts, value, unit, quality, exception_code) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		insertCheck: `INSERT OR IGNORE INTO temp.import_check (device_id, point, ts)
+			SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM main.samples WHERE device_id = ? AND point = ? AND ts = ?)`,
+		tempTable: `CREATE TEMP TABLE IF NOT EXISTS import_check (
+			device_id TEXT NOT NULL, point TEXT NOT NULL, ts INTEGER NOT NULL,
+			PRIMARY KEY (device_id, point, ts))`,
+		// 與 CURRENT_TIMESTAMP 格式相同
+		timeValue: func(t time.Time) interface{} { return t.UTC().Format(sqliteTimeFormat) },
+	}}}, nil
+}
+
+// 連線參數 (go-sqlite3 在每個連線開啟時套用)
+func sqliteDSN(cfg DatabaseConfig) string {
+	params := url.Values{}
+	if cfg.JournalMode != "" {
+		params.Set("_journal_mode", cfg.JournalMode)
+	}
+	if cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	}
+	if cfg.Synchronous != "" {
+		params.Set("_synchronous", cfg.Synchronous)
+	}
+	if len(params) == 0 {
+		return cfg.Path
+	}
+
+	separator := "?"
+	if strings.Contains(cfg.Path, "?") {
+		separator = "&"
+	}
+	return cfg.Path + separator + params.Encode()
+}
+
+func (s *sqliteStorage) Driver() string { return "sqlite" }
+
+func (s *sqliteStorage) Init() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS meter_data (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		device_id TEXT NOT NULL,
+		json_data TEXT NOT NULL
+	);
+	
+	CREATE INDEX IF NOT EXISTS idx_timestamp ON meter_data(timestamp);
+	CREATE INDEX IF NOT EXISTS idx_device_id ON meter_data(device_id);
+	CREATE INDEX IF NOT EXISTS idx_timestamp_device ON meter_data(timestamp, device_id);
+
+	CREATE TABLE IF NOT EXISTS alarm_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		rule_id TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		parameter TEXT NOT NULL,
+		state TEXT NOT NULL,
+		value REAL,
+		threshold REAL,
+		message TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_alarm_events_timestamp ON alarm_events(timestamp);
+	`)
+	if err != nil {
+		return fmt.Errorf("建立資料表失敗: %v", err)
+	}
+
+	return migrateDatabase(s.db)
+}
+
+func (s *sqliteStorage) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// 資料庫檔案大小 (不含 WAL)
+func (s *sqliteStorage) Size() (int64, error) {
+	var pages, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
+// 以 SQLite 線上備份 API 複製資料庫至 destPath (不存在的新檔案)
+// WAL 模式下備份只持有讀取交易，收集器可繼續寫入；一次複製所有頁面，取得一致的快照
+func (s *sqliteStorage) Backup(destPath string) error {
+	ctx := context.Background()
+	src, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	destDB, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	err = dest.Raw(func(destConn interface{}) error {
+		return src.Raw(func(srcConn interface{}) error {
+			backup, err := destConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			// 資料庫忙碌 (SQLITE_BUSY/LOCKED) 時 Step 回傳未完成，稍後再試
+			for attempt := 0; ; attempt++ {
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Close()
+					return err
+				}
+				if done {
+					break
+				}
+				if attempt >= 50 {
+					backup.Close()
+					return fmt.Errorf("資料庫忙碌中")
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			return backup.Finish()
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("線上備份失敗: %v", err)
+	}
+
+	// 備份檔改為一般日誌模式，成為不需要 -wal 檔的單一檔案
+	if _, err := dest.ExecContext(ctx, `PRAGMA journal_mode = DELETE`); err != nil {
+		return fmt.Errorf("設定備份檔日誌模式失敗: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"energy-monitoring/internal/webcore"
+)
+
+// 檢查用的固定時間 (整點，方便計算期間)
+var checkBase = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func expectEqual(what string, got, want interface{}) error {
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("%s: 預期 %v，實際 %v", what, want, got)
+	}
+	return nil
+}
+
+func expectFloat(what string, got, want float64) error {
+	if math.Abs(got-want) > 1e-9 {
+		return fmt.Errorf("%s: 預期 %v，實際 %v", what, want, got)
+	}
+	return nil
+}
+
+// 資料儲存一致性檢查：對 SQLite 與 PostgreSQL 執行相同的檢查項目，確認各實作行為一致
+// 檢查使用獨立的暫存資料庫 (SQLite 暫存檔、PostgreSQL 暫存 schema)，不影響正式資料
+type storageCheck struct {
+	name string
+	run  func(store Storage) error
+}
+
+func checkReadings(values ...float64) []MeterReading {
+	readings := make([]MeterReading, 0, len(values))
+	for i, v := range values {
+		readings = append(readings, MeterReading{Name: fmt.Sprintf("P%d", i+1), Value: v, Unit: "V", Quality: QualityGood})
+	}
+	return readings
+}
+
+func checkQuery(point string, from, to time.Time, filter QualityFilter) SampleQuery {
+	return SampleQuery{DeviceID: "check", Point: point, From: from, To: to, Quality: filter}
+}
+
+func collectSamples(store Storage, q SampleQuery) ([]Sample, error) {
+	var samples []Sample
+	err := forEachSample(store, q, func(sample Sample) error {
+		samples = append(samples, sample)
+		return nil
+	})
+	return samples, err
+}
+
+var storageChecks = []storageCheck{
+	{"重複初始化與結構版本", func(store Storage) error {
+		if err := store.Init(); err != nil {
+			return err
+		}
+		version, err := store.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		return expectEqual("結構版本", version, schemaVersion)
+	}},
+
+	{"連線檢查與資料庫大小", func(store Storage) error {
+		if err := store.Ping(context.Background()); err != nil {
+			return err
+		}
+		size, err := store.Size()
+		if err != nil {
+			return err
+		}
+		if size <= 0 {
+			return fmt.Errorf("資料庫大小應大於 0，實際 %d", size)
+		}
+		return nil
+	}},
+
+	{"寫入取樣與最新快照", func(store Storage) error {
+		for i := 0; i < 10; i++ {
+			readings := checkReadings(float64(i), float64(100+i))
+			if i == 5 {
+				readings[1].Quality = QualityCommError
+			}
+			if i == 6 {
+				readings[1].Quality = QualityOutOfRange
+			}
+			if err := store.WriteSamples([]PollRecord{{"check", checkBase.Add(time.Duration(i) * time.Minute), readings}}); err != nil {
+				return err
+			}
+		}
+
+		snapshot, err := store.LatestSnapshot("check")
+		if err != nil {
+			return err
+		}
+		var readings []MeterReading
+		if err := json.Unmarshal([]byte(snapshot), &readings); err != nil {
+			return err
+		}
+		if len(readings) != 2 {
+			return fmt.Errorf("快照點位數: 預期 2，實際 %d", len(readings))
+		}
+		return expectFloat("快照數值", readings[0].Value, 9)
+	}},
+
+	{"區間查詢 (含邊界、筆數限制、品質篩選)", func(store Storage) error {
+		from, to := checkBase.Add(2*time.Minute), checkBase.Add(7*time.Minute)
+		samples, err := collectSamples(store, checkQuery("P1", from, to, QualityFilterUsable))
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("筆數", len(samples), 6); err != nil {
+			return err
+		}
+		if err := expectEqual("第一筆時間", samples[0].Timestamp.UnixMilli(), from.UnixMilli()); err != nil {
+			return err
+		}
+		if err := expectEqual("單位", samples[0].Unit, "V"); err != nil {
+			return err
+		}
+
+		q := checkQuery("P1", from, to, QualityFilterUsable)
+		q.Limit = 2
+		if samples, err = collectSamples(store, q); err != nil {
+			return err
+		}
+		if err := expectEqual("限制筆數", len(samples), 2); err != nil {
+			return err
+		}
+
+		all, err := collectSamples(store, checkQuery("P2", checkBase, to, QualityFilterAll))
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("全部品質筆數", len(all), 8); err != nil {
+			return err
+		}
+		if all[5].Value != nil || all[5].Quality != QualityCommError {
+			return fmt.Errorf("通訊失敗應沒有數值: %+v", all[5])
+		}
+
+		usable, err := collectSamples(store, checkQuery("P2", checkBase, to, QualityFilterUsable))
+		if err != nil {
+			return err
+		}
+		valued, err := collectSamples(store, checkQuery("P2", checkBase, to, QualityFilterValued))
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("正常品質筆數", len(usable), 6); err != nil {
+			return err
+		}
+		return expectEqual("帶數值筆數", len(valued), 7)
+	}},
+
+	{"批次寫入", func(store Storage) error {
+		records := make([]PollRecord, 0, 3)
+		for i := 0; i < 3; i++ {
+			records = append(records, PollRecord{"batch", checkBase.Add(time.Duration(i) * time.Second), checkReadings(float64(i), 1)})
+		}
+		if err := store.WriteSamples(records); err != nil {
+			return err
+		}
+		total, _, err := store.CountSamples(SampleQuery{DeviceID: "batch", Point: "P1", From: checkBase, To: checkBase.Add(time.Minute), Quality: QualityFilterAll})
+		if err != nil {
+			return err
+		}
+		return expectEqual("批次筆數", total, 3)
+	}},
+
+	{"重複時間覆寫", func(store Storage) error {
+		readings := checkReadings(42, 142)
+		if err := store.WriteSamples([]PollRecord{{"check", checkBase.Add(3 * time.Minute), readings}}); err != nil {
+			return err
+		}
+		at := checkBase.Add(3 * time.Minute)
+		samples, err := collectSamples(store, checkQuery("P1", at, at, QualityFilterAll))
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("筆數", len(samples), 1); err != nil {
+			return err
+		}
+		return expectFloat("覆寫後數值", *samples[0].Value, 42)
+	}},
+
+	{"筆數與單位", func(store Storage) error {
+		total, unit, err := store.CountSamples(checkQuery("P2", checkBase, checkBase.Add(time.Hour), QualityFilterValued))
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("筆數", total, 9); err != nil {
+			return err
+		}
+		if err := expectEqual("單位", unit, "V"); err != nil {
+			return err
+		}
+		latest, err := store.LatestUnit("check", "P2")
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("最新單位", latest, "V"); err != nil {
+			return err
+		}
+		missing, err := store.LatestUnit("check", "不存在")
+		if err != nil {
+			return err
+		}
+		return expectEqual("不存在點位的單位", missing, "")
+	}},
+
+	{"固定區間統計", func(store Storage) error {
+		var buckets []SampleBucket
+		err := store.BucketSamples(checkQuery("P1", checkBase, checkBase.Add(time.Hour), QualityFilterUsable), checkBase, 5*time.Minute,
+			func(bucket SampleBucket) error {
+				buckets = append(buckets, bucket)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("區間數", len(buckets), 2); err != nil {
+			return err
+		}
+		// 0~4 分：0, 1, 2, 42, 4
+		first := buckets[0]
+		if err := expectEqual("區間序號", first.Index, 0); err != nil {
+			return err
+		}
+		if err := expectFloat("平均", first.Avg, 49.0/5); err != nil {
+			return err
+		}
+		if err := expectFloat("最小", first.Min, 0); err != nil {
+			return err
+		}
+		if err := expectFloat("最大", first.Max, 42); err != nil {
+			return err
+		}
+		if err := expectFloat("平均時間", first.MeanMs, 120000); err != nil {
+			return err
+		}
+		return expectEqual("筆數", first.Count, 5)
+	}},
+
+	{"日曆期間統計 (含異常筆數)", func(store Storage) error {
+		periods := []time.Time{checkBase, checkBase.Add(5 * time.Minute), checkBase.Add(10 * time.Minute), checkBase.Add(15 * time.Minute)}
+		rollups, err := store.Rollup("check", "P2", periods, QualityFilterUsable)
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("期間數", len(rollups), 2); err != nil {
+			return err
+		}
+		// 5~9 分：105 (comm_error)、106 (out_of_range)、107、108、109
+		second := rollups[1]
+		if !second.Start.Equal(periods[1]) {
+			return fmt.Errorf("期間起始: 預期 %v，實際 %v", periods[1], second.Start)
+		}
+		if err := expectEqual("筆數", second.Count, 3); err != nil {
+			return err
+		}
+		if err := expectEqual("異常筆數", second.BadCount, 2); err != nil {
+			return err
+		}
+		if err := expectFloat("平均", second.Avg, 108); err != nil {
+			return err
+		}
+
+		rollups, err = store.Rollup("check", "P2", periods, QualityFilterValued)
+		if err != nil {
+			return err
+		}
+		return expectFloat("含異常的最小值", rollups[1].Min, 106)
+	}},
+
+	{"大量寫入 (略過重複、dry run)", func(store Storage) error {
+		at := checkBase.Add(24 * time.Hour)
+		for _, dryRun := range []bool{true, false} {
+			writer, err := store.OpenSampleWriter(dryRun)
+			if err != nil {
+				return err
+			}
+			inserted := 0
+			for i := 0; i < 3; i++ {
+				// 第 3 筆與第 1 筆重複；第 4 筆與既有資料重複
+				ts := at.Add(time.Duration(i%2) * time.Second)
+				ok, err := writer.Insert(Sample{DeviceID: "check", Point: "P1", Timestamp: ts, Value: floatPtr(1), Unit: "V", Quality: QualityGood})
+				if err != nil {
+					writer.Close(false)
+					return err
+				}
+				if ok {
+					inserted++
+				}
+			}
+			ok, err := writer.Insert(Sample{DeviceID: "check", Point: "P1", Timestamp: checkBase, Value: floatPtr(1), Unit: "V", Quality: QualityGood})
+			if err != nil {
+				writer.Close(false)
+				return err
+			}
+			if ok {
+				inserted++
+			}
+			if err := writer.Close(true); err != nil {
+				return err
+			}
+			if err := expectEqual(fmt.Sprintf("新增筆數 (dry run=%v)", dryRun), inserted, 2); err != nil {
+				return err
+			}
+
+			total, _, err := store.CountSamples(checkQuery("P1", at, at.Add(time.Minute), QualityFilterAll))
+			if err != nil {
+				return err
+			}
+			want := 2
+			if dryRun {
+				want = 0
+			}
+			if err := expectEqual(fmt.Sprintf("寫入後筆數 (dry run=%v)", dryRun), total, want); err != nil {
+				return err
+			}
+		}
+
+		writer, err := store.OpenSampleWriter(false)
+		if err != nil {
+			return err
+		}
+		writer.Insert(Sample{DeviceID: "check", Point: "P9", Timestamp: at, Unit: "V", Quality: QualityCommError})
+		if err := writer.Close(false); err != nil {
+			return err
+		}
+		total, _, err := store.CountSamples(checkQuery("P9", at, at, QualityFilterAll))
+		if err != nil {
+			return err
+		}
+		return expectEqual("放棄後筆數", total, 0)
+	}},
+
+	{"缺口與用電量 (含補齊)", func(store Storage) error {
+		for i, value := range map[int]float64{0: 100, 1: 101, 2: 103, 5: 0, 10: 110, 11: 112} {
+			reading := MeterReading{Name: "kWh", Value: value, Unit: "kWh", Quality: QualityGood}
+			if i == 5 {
+				reading.Quality = QualityCommError
+			}
+			if err := store.WriteSamples([]PollRecord{{"gaps", checkBase.Add(time.Duration(i) * time.Minute), []MeterReading{reading}}}); err != nil {
+				return err
+			}
+		}
+
+		minGap := 90 * time.Second
+		gaps, err := store.SampleGaps("gaps", checkBase.Add(3*time.Minute), checkBase.Add(20*time.Minute), minGap)
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("缺口數", len(gaps), 2); err != nil {
+			return err
+		}
+		if !gaps[0].Start.Equal(checkBase.Add(2*time.Minute)) || !gaps[0].End.Equal(checkBase.Add(10*time.Minute)) || gaps[0].Open {
+			return fmt.Errorf("缺口: 預期 2m ~ 10m，實際 %+v", gaps[0])
+		}
+		if !gaps[1].Start.Equal(checkBase.Add(11*time.Minute)) || !gaps[1].Open {
+			return fmt.Errorf("未恢復的缺口: 預期由 11m 開始，實際 %+v", gaps[1])
+		}
+
+		if _, err := store.RecomputeEnergyDeltas("gaps", "kWh", checkBase, checkBase.Add(time.Hour), minGap); err != nil {
+			return err
+		}
+		deltas, err := store.EnergyDeltas("gaps", "kWh", checkBase, checkBase.Add(time.Hour))
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("用電量筆數", len(deltas), 4); err != nil {
+			return err
+		}
+		if err := expectFloat("跨越缺口的用電量", deltas[2].Delta, 7); err != nil {
+			return err
+		}
+		if !deltas[2].Gap || deltas[2].Backfilled {
+			return fmt.Errorf("跨越缺口的標記錯誤: %+v", deltas[2])
+		}
+
+		// 補齊：已存在的量測值不覆寫，通訊失敗的取樣以補齊值取代
+		var backfill []Sample
+		for _, minute := range []int{2, 5, 6} {
+			backfill = append(backfill, Sample{DeviceID: "gaps", Point: "kWh", Timestamp: checkBase.Add(time.Duration(minute) * time.Minute),
+				Value: floatPtr(100 + float64(minute)), Unit: "kWh", Quality: QualityBackfilled})
+		}
+		written, err := store.BackfillSamples(backfill)
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("補齊筆數", written, 2); err != nil {
+			return err
+		}
+		if _, err := store.RecomputeEnergyDeltas("gaps", "kWh", gaps[0].Start, gaps[0].End, minGap); err != nil {
+			return err
+		}
+		if deltas, err = store.EnergyDeltas("gaps", "kWh", checkBase, checkBase.Add(time.Hour)); err != nil {
+			return err
+		}
+		if err := expectEqual("補齊後用電量筆數", len(deltas), 6); err != nil {
+			return err
+		}
+		if err := expectFloat("補齊後的用電量", deltas[2].Delta+deltas[3].Delta+deltas[4].Delta, 7); err != nil {
+			return err
+		}
+		if !deltas[2].Backfilled || deltas[2].Gap || !deltas[2].Start.Equal(checkBase.Add(2*time.Minute)) || !deltas[3].Start.Equal(checkBase.Add(5*time.Minute)) {
+			return fmt.Errorf("補齊的標記錯誤: %+v", deltas[2:4])
+		}
+		return nil
+	}},
+
+	{"告警事件", func(store Storage) error {
+		for i, state := range []string{"active", "cleared"} {
+			err := store.InsertAlarmEvent(AlarmEvent{Timestamp: checkBase.Add(time.Duration(i) * time.Minute), RuleID: "R1",
+				DeviceID: "check", Parameter: "P1", State: state, Value: 250, Threshold: 240, Message: "檢查"})
+			if err != nil {
+				return err
+			}
+		}
+		events, err := store.RecentAlarmEvents(1)
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("事件數", len(events), 1); err != nil {
+			return err
+		}
+		if err := expectEqual("最新事件", events[0].State, "cleared"); err != nil {
+			return err
+		}
+		if !events[0].Timestamp.Equal(checkBase.Add(time.Minute)) {
+			return fmt.Errorf("事件時間: 預期 %v，實際 %v", checkBase.Add(time.Minute), events[0].Timestamp)
+		}
+		if err := expectFloat("門檻", events[0].Threshold, 240); err != nil {
+			return err
+		}
+
+		events, err = store.AlarmEvents(checkBase.Add(30*time.Second), checkBase.Add(time.Hour))
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("區間事件數", len(events), 1); err != nil {
+			return err
+		}
+		return expectEqual("區間事件", events[0].State, "cleared")
+	}},
+
+	{"設定值", func(store Storage) error {
+		if _, ok, err := store.GetSetting("check"); err != nil || ok {
+			return fmt.Errorf("不存在的設定應回傳 false: %v", err)
+		}
+		for _, value := range []string{"a", "b"} {
+			if err := store.PutSetting("check", value); err != nil {
+				return err
+			}
+		}
+		value, ok, err := store.GetSetting("check")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("讀取不到設定值")
+		}
+		return expectEqual("設定值", value, "b")
+	}},
+
+	{"使用者、API 權杖與稽核紀錄", func(store Storage) error {
+		hash, err := webcore.HashPassword("check-password")
+		if err != nil {
+			return err
+		}
+		user := User{Name: "check", PasswordHash: hash, Role: RoleOperator, CreatedAt: checkBase, UpdatedAt: checkBase}
+		if err := store.PutUser(user); err != nil {
+			return err
+		}
+		user.Disabled, user.UpdatedAt = true, checkBase.Add(time.Minute)
+		if err := store.PutUser(user); err != nil {
+			return err
+		}
+		stored, found, err := store.GetUser("check")
+		if err != nil {
+			return err
+		}
+		if !found || !stored.Disabled || !stored.CreatedAt.Equal(checkBase) || !stored.UpdatedAt.Equal(user.UpdatedAt) {
+			return fmt.Errorf("使用者: 預期 %+v，實際 %+v", user, stored)
+		}
+		if !webcore.VerifyPassword(stored.PasswordHash, "check-password") || webcore.VerifyPassword(stored.PasswordHash, "wrong-password") {
+			return fmt.Errorf("密碼比對錯誤")
+		}
+
+		if _, _, err := newAPIToken(stored, "admin", RoleAdmin, 0); err == nil {
+			return fmt.Errorf("權杖角色不可高於使用者")
+		}
+		token, secret, err := createAPIToken(store, "check", "check", RoleViewer, time.Hour)
+		if err != nil {
+			return err
+		}
+		id, rest, ok := splitAPIToken(secret)
+		if !ok || id != token.ID || hashSecret(rest) != token.SecretHash {
+			return fmt.Errorf("權杖格式錯誤: %s", secret)
+		}
+		if err := store.TouchAPIToken(token.ID, checkBase); err != nil {
+			return err
+		}
+		got, found, err := store.GetAPIToken(token.ID)
+		if err != nil {
+			return err
+		}
+		if !found || got.Role != RoleViewer || !got.LastUsedAt.Equal(checkBase) || got.ExpiresAt.IsZero() {
+			return fmt.Errorf("權杖: %+v", got)
+		}
+		tokens, err := store.ListAPITokens("check")
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("權杖數", len(tokens), 1); err != nil {
+			return err
+		}
+		if deleted, err := store.DeleteUser("check"); err != nil || !deleted {
+			return fmt.Errorf("刪除使用者失敗: %v", err)
+		}
+		if _, found, err := store.GetAPIToken(token.ID); err != nil || found {
+			return fmt.Errorf("刪除使用者後權杖應一併刪除: %v", err)
+		}
+
+		for i, name := range []string{"alice", "bob", "alice"} {
+			err := store.InsertAuditEvent(AuditEvent{Timestamp: checkBase.Add(time.Duration(i) * time.Minute), User: name,
+				Role: RoleAdmin, Auth: authSession, Remote: "127.0.0.1", Action: "POST /api/users", Target: "check", Status: 200})
+			if err != nil {
+				return err
+			}
+		}
+		events, err := store.AuditEvents(AuditQuery{From: checkBase, To: checkBase.Add(time.Hour), User: "alice", Limit: 10})
+		if err != nil {
+			return err
+		}
+		if err := expectEqual("稽核紀錄數", len(events), 2); err != nil {
+			return err
+		}
+		if !events[0].Timestamp.Equal(checkBase.Add(2 * time.Minute)) {
+			return fmt.Errorf("稽核紀錄應由新到舊: %+v", events)
+		}
+		return nil
+	}},
+}
+
+// SQLite 使用暫存目錄中的資料庫
+func TestStorageSQLite(t *testing.T) {
+	database := DefaultConfig().Database
+	database.Driver = "sqlite"
+	runStorageChecks(t, database)
+}
+
+// PostgreSQL 需以 ENERGY_TEST_POSTGRES_DSN 指定連線字串 (ENERGY_TEST_TIMESCALE=1 啟用 TimescaleDB)，
+// 檢查使用暫存 schema，結束後刪除
+func TestStoragePostgres(t *testing.T) {
+	dsn := os.Getenv("ENERGY_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未設定 ENERGY_TEST_POSTGRES_DSN")
+	}
+	database := DefaultConfig().Database
+	database.Driver, database.DSN = "postgres", dsn
+	database.Timescale = os.Getenv("ENERGY_TEST_TIMESCALE") == "1"
+	runStorageChecks(t, database)
+}
+
+// 依序執行所有檢查 (後面的檢查沿用前面寫入的資料)
+func runStorageChecks(t *testing.T, database DatabaseConfig) {
+	store, cleanup, err := openScratchStorage(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	if err := store.Init(); err != nil {
+		t.Fatalf("初始化失敗: %v", err)
+	}
+
+	for _, check := range storageChecks {
+		check := check
+		t.Run(check.name, func(t *testing.T) {
+			if err := check.run(store); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
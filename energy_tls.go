@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"energy-monitoring/internal/webcore"
+	"github.com/rs/cors"
+)
+
+// HTTPS 憑證、轉址與安全標頭由 internal/webcore 提供 (與看板 Web 伺服器 main.go 共用)
+
+// 啟動 HTTP 或 HTTPS 服務；啟用 TLS 並設定 redirect_listen 時另外啟動轉址服務
+func serveHTTP(name, listen string, t TLSConfig, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if !t.Enabled {
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("%s錯誤: %v", name, err)
+			}
+		}()
+		return nil
+	}
+
+	tlsConfig, err := webcore.ServerTLSConfig(t)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+	go func() {
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			log.Printf("%s錯誤: %v", name, err)
+		}
+	}()
+
+	if t.RedirectListen != "" {
+		_, port, _ := net.SplitHostPort(listen)
+		redirect := &http.Server{
+			Addr:              t.RedirectListen,
+			Handler:           webcore.HTTPSRedirectHandler(port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		log.Printf("↪️ HTTP 轉址服務啟動於 %s (轉址到 HTTPS 連接埠 %s)", t.RedirectListen, port)
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("%s轉址服務錯誤: %v", name, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// 套用 CORS 允許清單與安全標頭；未設定 CORS 來源時不回應任何 CORS 標頭 (瀏覽器只允許同源)
+func securityHandler(origins []string, headers map[string]string, tlsEnabled bool, next http.Handler) http.Handler {
+	if len(origins) > 0 {
+		next = cors.New(cors.Options{
+			AllowedOrigins: origins,
+			AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodOptions},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Content-Encoding"},
+		}).Handler(next)
+	}
+
+	merged := webcore.SecurityHeaders(headers, tlsEnabled)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		merged.Apply(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
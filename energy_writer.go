@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// 寫入失敗時的重試次數 (例如 busy_timeout 內仍無法取得鎖定)
+const writeQueueRetries = 3
+
+// 寫入佇列統計
+type WriteQueueStats struct {
+	Depth       int           `json:"depth"`        // 目前佇列中的輪詢筆數
+	Capacity    int           `json:"capacity"`     // 佇列容量
+	MaxDepth    int           `json:"max_depth"`    // 佇列曾達到的最大筆數
+	Enqueued    int64         `json:"enqueued"`     // 放入佇列的輪詢筆數
+	Written     int64         `json:"written"`      // 已寫入的輪詢筆數
+	Samples     int64         `json:"samples"`      // 已寫入的點位筆數
+	Batches     int64         `json:"batches"`      // 寫入交易數
+	Blocked     int64         `json:"blocked"`      // 佇列已滿、收集器需等待的次數 (背壓)
+	BlockedTime time.Duration `json:"blocked_time"` // 收集器累計等待時間
+	Dropped     int64         `json:"dropped"`      // 無法寫入暫存區而丟棄的輪詢筆數
+	Failed      int64         `json:"failed"`       // 重試後仍寫入失敗的輪詢筆數
+	Spooled     int64         `json:"spooled"`      // 寫入暫存區的輪詢筆數
+	LastBatch   int           `json:"last_batch"`
+	LastFlush   time.Duration `json:"last_flush"` // 最近一次交易的耗時
+	LastError   string        `json:"last_error,omitempty"`
+	LastErrorAt time.Time     `json:"last_error_at"`
+}
+
+// 寫入佇列：收集器只需放入佇列，不受磁碟同步延遲影響；
+// 背景寫入器將多次輪詢合併為一個交易寫入。佇列已滿時收集器最多等待 enqueue_timeout (背壓)，
+// 逾時或重試後仍無法寫入的資料存入暫存區，資料庫恢復後依序補寫 (未設定暫存區時丟棄)
+type WriteQueue struct {
+	store  Storage
+	config WriteQueueConfig
+	spool  *Spool
+	queue  chan PollRecord
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu    sync.Mutex
+	stats WriteQueueStats
+	full  bool // 目前是否處於佇列已滿狀態 (避免重複記錄警告)
+}
+
+// 建立寫入佇列
+func NewWriteQueue(store Storage, cfg WriteQueueConfig, spool *Spool) *WriteQueue {
+	return &WriteQueue{
+		store:  store,
+		config: cfg,
+		spool:  spool,
+		queue:  make(chan PollRecord, cfg.Size),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		stats:  WriteQueueStats{Capacity: cfg.Size},
+	}
+}
+
+// 啟動背景寫入
+func (wq *WriteQueue) Start() {
+	log.Printf("💾 寫入佇列已啟動 (容量 %d，每批最多 %d 筆)", wq.config.Size, wq.config.BatchSize)
+	go wq.run()
+}
+
+// 停止並寫入佇列中剩餘的資料 (呼叫前需先停止收集器)
+func (wq *WriteQueue) Stop() {
+	close(wq.stop)
+	<-wq.done
+	if wq.spool != nil {
+		wq.spool.Close()
+	}
+}
+
+// 放入一次輪詢的結果
+func (wq *WriteQueue) Enqueue(record PollRecord) error {
+	select {
+	case wq.queue <- record:
+		wq.enqueued(0)
+		return nil
+	case <-wq.stop:
+		return fmt.Errorf("寫入佇列已停止")
+	default:
+	}
+
+	// 佇列已滿：收集器等待寫入器消化 (背壓)
+	wq.mu.Lock()
+	wq.stats.Blocked++
+	if !wq.full {
+		wq.full = true
+		log.Printf("⚠️ 寫入佇列已滿 (%d 筆)，收集器等待寫入", wq.config.Size)
+	}
+	wq.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(wq.config.EnqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case wq.queue <- record:
+		wq.enqueued(time.Since(start))
+		return nil
+	case <-timer.C:
+		wq.mu.Lock()
+		wq.stats.BlockedTime += time.Since(start)
+		wq.mu.Unlock()
+		if err := wq.toSpool([]PollRecord{record}); err != nil {
+			return fmt.Errorf("寫入佇列已滿，等待 %v 後丟棄電表 %s 的資料: %v", wq.config.EnqueueTimeout, record.DeviceID, err)
+		}
+		return nil
+	case <-wq.stop:
+		return fmt.Errorf("寫入佇列已停止")
+	}
+}
+
+func (wq *WriteQueue) enqueued(blocked time.Duration) {
+	depth := len(wq.queue)
+
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+	wq.stats.Enqueued++
+	wq.stats.BlockedTime += blocked
+	if depth > wq.stats.MaxDepth {
+		wq.stats.MaxDepth = depth
+	}
+}
+
+// 目前統計
+func (wq *WriteQueue) Stats() WriteQueueStats {
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+
+	stats := wq.stats
+	stats.Depth = len(wq.queue)
+	return stats
+}
+
+func (wq *WriteQueue) run() {
+	defer close(wq.done)
+
+	ticker := time.NewTicker(wq.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]PollRecord, 0, wq.config.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			wq.write(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case record := <-wq.queue:
+			batch = append(batch, record)
+			if len(batch) >= wq.config.BatchSize {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+			wq.replay()
+
+		case <-wq.stop:
+			// 寫入佇列中剩餘的資料
+		drain:
+			for {
+				select {
+				case record := <-wq.queue:
+					batch = append(batch, record)
+					if len(batch) >= wq.config.BatchSize {
+						flush()
+					}
+				default:
+					break drain
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// 以一個交易寫入一批資料，失敗時重試
+func (wq *WriteQueue) write(batch []PollRecord) {
+	samples := 0
+	for _, record := range batch {
+		samples += len(record.Readings)
+	}
+
+	var err error
+	for attempt := 0; attempt <= writeQueueRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+		}
+
+		start := time.Now()
+		if err = wq.store.WriteSamples(batch); err == nil {
+			wq.mu.Lock()
+			wq.stats.Written += int64(len(batch))
+			wq.stats.Samples += int64(samples)
+			wq.stats.Batches++
+			wq.stats.LastBatch = len(batch)
+			wq.stats.LastFlush = time.Since(start)
+			if wq.full && len(wq.queue) < cap(wq.queue)/2 {
+				wq.full = false
+				log.Printf("✅ 寫入佇列已恢復 (剩餘 %d 筆)", len(wq.queue))
+			}
+			wq.mu.Unlock()
+			return
+		}
+		log.Printf("⚠️ 寫入 %d 筆輪詢資料失敗 (第 %d 次): %v", len(batch), attempt+1, err)
+	}
+
+	wq.mu.Lock()
+	wq.stats.Failed += int64(len(batch))
+	wq.stats.LastError = err.Error()
+	wq.stats.LastErrorAt = time.Now()
+	wq.mu.Unlock()
+
+	if spoolErr := wq.toSpool(batch); spoolErr != nil {
+		log.Printf("❌ 重試後仍無法寫入，已丟棄 %d 筆輪詢資料: %v (%v)", len(batch), err, spoolErr)
+		return
+	}
+	log.Printf("💾 重試後仍無法寫入，%d 筆輪詢資料已存入暫存區: %v", len(batch), err)
+}
+
+// 存入暫存區 (一批一筆紀錄)；未設定暫存區或寫入失敗時計為丟棄
+func (wq *WriteQueue) toSpool(batch []PollRecord) error {
+	err := fmt.Errorf("未設定暫存區")
+	if wq.spool != nil {
+		var payload []byte
+		if payload, err = json.Marshal(batch); err == nil {
+			err = wq.spool.Append(payload)
+		}
+	}
+
+	wq.mu.Lock()
+	defer wq.mu.Unlock()
+	if err != nil {
+		wq.stats.Dropped += int64(len(batch))
+		return err
+	}
+	wq.stats.Spooled += int64(len(batch))
+	return nil
+}
+
+// 資料庫可寫入時依序補寫暫存區的資料，失敗時停止 (下次再試)
+func (wq *WriteQueue) replay() {
+	if wq.spool == nil || wq.spool.Stats().Records == 0 {
+		return
+	}
+
+	samples := 0
+	replayed, err := wq.spool.Replay(func(payload []byte) error {
+		var batch []PollRecord
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			log.Printf("⚠️ 暫存資料格式錯誤，略過: %v", err)
+			return nil
+		}
+		if err := wq.store.WriteSamples(batch); err != nil {
+			return err
+		}
+		for _, record := range batch {
+			samples += len(record.Readings)
+		}
+		return nil
+	})
+	// 補寫失敗 (資料庫仍無法寫入) 時不另外記錄，寫入失敗已有紀錄
+	if replayed > 0 {
+		wq.mu.Lock()
+		wq.stats.Samples += int64(samples)
+		wq.mu.Unlock()
+		log.Printf("📥 已從暫存區補寫 %d 批資料 (%d 個點位)", replayed, samples)
+	}
+	if err != nil && replayed > 0 {
+		log.Printf("⚠️ 暫存區補寫中斷: %v", err)
+	}
+}
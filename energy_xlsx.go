@@ -0,0 +1,236 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Excel 單一工作表的列數上限，超過時自動新增工作表
+const xlsxMaxRows = 1048576
+
+// 串流寫出 XLSX (不需第三方套件)
+// 每一列直接寫入 zip 串流，記憶體用量與列數無關；字串使用 inline string，不需共用字串表
+type xlsxWriter struct {
+	zw       *zip.Writer
+	sheet    *bufio.Writer
+	name     string
+	sheets   []string
+	header   []string
+	location *time.Location
+	row      int
+}
+
+// 建立 XLSX 寫出器，時間欄位以 loc 的當地時間寫入
+func newXLSXWriter(w io.Writer, sheetName string, loc *time.Location) *xlsxWriter {
+	return &xlsxWriter{zw: zip.NewWriter(w), name: sheetName, location: loc}
+}
+
+// 寫入標題列 (新增工作表時會重複標題)
+func (xw *xlsxWriter) WriteHeader(columns []string) error {
+	xw.header = columns
+	return xw.startSheet()
+}
+
+// 寫入一列；支援 string、float64、*float64 (nil 為空白)、int、int64、time.Time
+func (xw *xlsxWriter) WriteRow(values []interface{}) error {
+	if xw.sheet == nil || xw.row >= xlsxMaxRows {
+		if err := xw.endSheet(); err != nil {
+			return err
+		}
+		if err := xw.startSheet(); err != nil {
+			return err
+		}
+	}
+	return xw.writeRow(values, 0)
+}
+
+// 結束目前的工作表並寫入活頁簿結構
+func (xw *xlsxWriter) Close() error {
+	if xw.sheet == nil && len(xw.sheets) == 0 {
+		if err := xw.startSheet(); err != nil {
+			return err
+		}
+	}
+	if err := xw.endSheet(); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xw.contentTypes()},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xw.workbook()},
+		{"xl/_rels/workbook.xml.rels", xw.workbookRels()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, file := range files {
+		w, err := xw.zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, file.content); err != nil {
+			return err
+		}
+	}
+
+	return xw.zw.Close()
+}
+
+// 開始新的工作表
+func (xw *xlsxWriter) startSheet() error {
+	index := len(xw.sheets) + 1
+	name := xw.name
+	if index > 1 {
+		name = fmt.Sprintf("%s (%d)", xw.name, index)
+	}
+	xw.sheets = append(xw.sheets, name)
+
+	w, err := xw.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", index))
+	if err != nil {
+		return err
+	}
+	xw.sheet = bufio.NewWriter(w)
+	xw.row = 0
+
+	xw.sheet.WriteString(xml.Header)
+	xw.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	xw.sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	xw.sheet.WriteString(`<cols><col min="1" max="1" width="22" customWidth="1"/>`)
+	if len(xw.header) > 1 {
+		fmt.Fprintf(xw.sheet, `<col min="2" max="%d" width="16" customWidth="1"/>`, len(xw.header))
+	}
+	xw.sheet.WriteString(`</cols><sheetData>`)
+
+	if len(xw.header) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(xw.header))
+	for i, column := range xw.header {
+		values[i] = column
+	}
+	return xw.writeRow(values, 1)
+}
+
+// 結束目前的工作表
+func (xw *xlsxWriter) endSheet() error {
+	if xw.sheet == nil {
+		return nil
+	}
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	err := xw.sheet.Flush()
+	xw.sheet = nil
+	return err
+}
+
+// 寫入一列 (style 1 為粗體標題)
+func (xw *xlsxWriter) writeRow(values []interface{}, style int) error {
+	xw.row++
+	fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.row)
+
+	for i, value := range values {
+		ref := xlsxColumn(i) + strconv.Itoa(xw.row)
+		switch v := value.(type) {
+		case nil:
+			continue
+		case *float64:
+			if v == nil {
+				continue
+			}
+			fmt.Fprintf(xw.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(*v, 'f', -1, 64))
+		case float64:
+			fmt.Fprintf(xw.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+		case int:
+			fmt.Fprintf(xw.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case int64:
+			fmt.Fprintf(xw.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case time.Time:
+			fmt.Fprintf(xw.sheet, `<c r="%s" s="2"><v>%s</v></c>`, ref, strconv.FormatFloat(xlsxSerial(v.In(xw.location)), 'f', -1, 64))
+		default:
+			fmt.Fprintf(xw.sheet, `<c r="%s" t="inlineStr" s="%d"><is><t>`, ref, style)
+			xml.EscapeText(xw.sheet, []byte(fmt.Sprint(v)))
+			xw.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+// Excel 日期序號 (1899-12-30 起算的天數，以當地時間表示)
+func xlsxSerial(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	return float64(wall.Sub(epoch).Milliseconds()) / float64(24*time.Hour/time.Millisecond)
+}
+
+// 欄位代號 (0 → A，26 → AA)
+func xlsxColumn(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+func (xw *xlsxWriter) contentTypes() string {
+	content := xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`
+	for i := range xw.sheets {
+		content += fmt.Sprintf(`<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	return content + `</Types>`
+}
+
+func (xw *xlsxWriter) workbook() string {
+	content := xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	for i, name := range xw.sheets {
+		content += fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlAttr(name), i+1, i+1)
+	}
+	return content + `</sheets></workbook>`
+}
+
+func (xw *xlsxWriter) workbookRels() string {
+	content := xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	for i := range xw.sheets {
+		content += fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	content += fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(xw.sheets)+1)
+	return content + `</Relationships>`
+}
+
+func xmlAttr(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+const xlsxRootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// 樣式：0 預設、1 粗體標題、2 日期時間
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
@@ -4,10 +4,10 @@ go 1.19
 
 require (
 	github.com/goburrow/modbus v0.1.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.18
 	github.com/rs/cors v1.10.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/goburrow/serial v0.1.0 // indirect
-)
+require github.com/goburrow/serial v0.1.0 // indirect
@@ -0,0 +1,153 @@
+package webcore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 看板網頁的網址前綴
+const DashboardPrefix = "/dashboard/"
+
+// 壓縮傳送的檔案類型
+var compressibleExts = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".json": true, ".svg": true, ".csv": true,
+}
+
+// 編入的網頁檔案 (啟動時計算 ETag 與 gzip 內容)
+type embeddedAsset struct {
+	content []byte
+	gzipped []byte // 壓縮後沒有比較小時為 nil
+	etag    string
+}
+
+// 看板網頁檔案服務
+type AssetServer struct {
+	assets      map[string]*embeddedAsset // 相對路徑，例如 css/energy_dashboard.css
+	overrideDir string                    // 同名檔案優先使用此目錄的版本，空白表示停用
+}
+
+// 載入編入執行檔的網頁檔案 (由各執行檔以 go:embed 提供)
+func NewAssetServer(files fs.FS, overrideDir string) (*AssetServer, error) {
+	s := &AssetServer{assets: make(map[string]*embeddedAsset), overrideDir: overrideDir}
+	err := fs.WalkDir(files, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		asset := &embeddedAsset{content: content, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+		if compressibleExts[strings.ToLower(path.Ext(name))] {
+			var buf bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			zw.Write(content)
+			zw.Close()
+			if buf.Len() < len(content) {
+				asset.gzipped = buf.Bytes()
+			}
+		}
+		s.assets[name] = asset
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("讀取看板網頁檔案失敗: %v", err)
+	}
+	return s, nil
+}
+
+// 網址路徑對應的檔案名稱；隱藏檔與超出目錄的路徑視為不存在
+func AssetName(urlPath string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// 檔案是否存在於覆寫目錄或編入的網頁檔案中
+func (s *AssetServer) Has(name string) bool {
+	if _, ok := s.assets[name]; ok {
+		return true
+	}
+	if s.overrideDir == "" {
+		return false
+	}
+	f, err := http.Dir(s.overrideDir).Open("/" + name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	return err == nil && !info.IsDir()
+}
+
+// 傳送檔案：覆寫目錄優先，編入的檔案以 ETag 驗證快取並依 Accept-Encoding 傳送 gzip 版本
+func (s *AssetServer) Serve(w http.ResponseWriter, r *http.Request, name string) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".css", ".js", ".json":
+		// 檔名沒有版本號，每次都向伺服器確認 (未變更時回應 304)
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+
+	if s.overrideDir != "" {
+		if f, err := http.Dir(s.overrideDir).Open("/" + name); err == nil {
+			defer f.Close()
+			if info, err := f.Stat(); err == nil && !info.IsDir() {
+				http.ServeContent(w, r, name, info.ModTime(), f)
+				return
+			}
+		}
+	}
+
+	asset, ok := s.assets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	content, etag := asset.content, asset.etag
+	if asset.gzipped != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			content, etag = asset.gzipped, strings.TrimSuffix(etag, `"`)+`-gz"`
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+}
+
+// Accept-Encoding 是否接受 gzip (q=0 表示不接受)
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, param := range params[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				weight, err := strconv.ParseFloat(strings.TrimPrefix(q, "q="), 64)
+				return err == nil && weight > 0
+			}
+		}
+		return true
+	}
+	return false
+}
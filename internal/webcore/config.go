@@ -0,0 +1,368 @@
+package webcore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 設定驗證錯誤
+type ConfigError struct {
+	Field   string `json:"field"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("第 %d 行 %s: %s", e.Line, e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// 多筆設定驗證錯誤 (API 以 details 回傳清單)
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// 記錄一筆驗證錯誤
+type AddFunc func(field, format string, args ...interface{})
+
+// LabVIEW 資料來源設定 (看板 Web 伺服器使用)
+type LabVIEWConfig struct {
+	Host         string        `yaml:"host"`
+	Port         int           `yaml:"port"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// 能源看板 Web 伺服器設定
+type WebConfig struct {
+	Listen      string            `yaml:"listen"`
+	Users       []WebUser         `yaml:"users"` // HTTP Basic 帳號，未設定時不需登入
+	TLS         TLSConfig         `yaml:"tls"`
+	CORSOrigins []string          `yaml:"cors_origins"`
+	Headers     map[string]string `yaml:"headers"`
+	AssetsDir   string            `yaml:"assets_dir"`
+}
+
+// 能源看板帳號 (密碼雜湊以 energy_system user hash 子命令產生)
+type WebUser struct {
+	Name         string `yaml:"name"`
+	PasswordHash string `yaml:"password_hash"`
+}
+
+// final.json 快照輸出設定
+type SnapshotOutputConfig struct {
+	Path        string   `yaml:"path"`
+	Formats     []string `yaml:"formats"` // 額外輸出格式 (載入時轉為小寫)，目前支援 csv
+	KeepLast    int      `yaml:"keep_last"`
+	LegacyArray bool     `yaml:"legacy_array"` // final.json 維持舊版純陣列格式，含世代計數器的文件改寫到 final.v2.json
+}
+
+// 格式名稱統一為小寫 (寫入與網頁提供檔案時直接比對)
+func (c *SnapshotOutputConfig) Normalize() {
+	for i, format := range c.Formats {
+		c.Formats[i] = strings.ToLower(strings.TrimSpace(format))
+	}
+}
+
+func DefaultLabVIEWConfig() LabVIEWConfig {
+	return LabVIEWConfig{Host: "localhost", Port: 8888, PollInterval: 5 * time.Second}
+}
+
+func DefaultWebConfig() WebConfig {
+	return WebConfig{
+		Listen: ":5177",
+		TLS:    TLSConfig{CertFile: "./tls/cert.pem", KeyFile: "./tls/key.pem"},
+	}
+}
+
+func DefaultSnapshotOutputConfig() SnapshotOutputConfig {
+	return SnapshotOutputConfig{Path: "final.json"}
+}
+
+func (c LabVIEWConfig) Validate(add AddFunc) {
+	if c.Host == "" {
+		add("labview.host", "不可為空")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		add("labview.port", "必須介於 1 到 65535 (目前 %d)", c.Port)
+	}
+	if c.PollInterval < time.Second {
+		add("labview.poll_interval", "必須至少 1s (目前 %v)", c.PollInterval)
+	}
+}
+
+func (c WebConfig) Validate(add AddFunc) {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		add("web.listen", "無效的監聽位址 %q: %v", c.Listen, err)
+	}
+	ValidateTLS("web.tls", c.TLS, c.Listen, add)
+	ValidateCORSOrigins("web.cors_origins", c.CORSOrigins, add)
+	ValidateHeaders("web.headers", c.Headers, add)
+	ValidateAssetsDir("web.assets_dir", c.AssetsDir, add)
+	for i, user := range c.Users {
+		field := fmt.Sprintf("web.users[%d]", i)
+		if user.Name == "" {
+			add(field+".name", "不可為空白")
+		}
+		if _, _, _, err := ParsePasswordHash(user.PasswordHash); err != nil {
+			add(field+".password_hash", "%v (以 user hash 子命令產生)", err)
+		}
+	}
+}
+
+func (c SnapshotOutputConfig) Validate(add AddFunc) {
+	if c.Path == "" {
+		add("outputs.snapshot.path", "不可為空")
+	}
+	if c.KeepLast < 0 {
+		add("outputs.snapshot.keep_last", "不可為負數 (目前 %d)", c.KeepLast)
+	}
+	for i, format := range c.Formats {
+		if format != "csv" {
+			add(fmt.Sprintf("outputs.snapshot.formats[%d]", i), "不支援的格式 %q", format)
+		}
+	}
+}
+
+// 看板 Web 伺服器的環境變數覆寫 (energy_system 驗證設定時也套用，兩邊看到相同的值)
+func ApplyWebEnv(labview *LabVIEWConfig, web *WebConfig, getenv func(string) string) error {
+	if v := getenv("ENERGY_LABVIEW_HOST"); v != "" {
+		labview.Host = v
+	}
+	if v := getenv("ENERGY_LABVIEW_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("ENERGY_LABVIEW_PORT: 無效的連接埠 %q", v)
+		}
+		labview.Port = port
+	}
+	if v := getenv("ENERGY_LABVIEW_POLL_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("ENERGY_LABVIEW_POLL_INTERVAL: 無效的時間間隔 %q", v)
+		}
+		labview.PollInterval = interval
+	}
+	if v := getenv("ENERGY_WEB_LISTEN"); v != "" {
+		web.Listen = v
+	}
+	if v := getenv("ENERGY_WEB_TLS_CERT"); v != "" {
+		web.TLS.CertFile = v
+		web.TLS.Enabled = true
+	}
+	if v := getenv("ENERGY_WEB_TLS_KEY"); v != "" {
+		web.TLS.KeyFile = v
+		web.TLS.Enabled = true
+	}
+	if v := getenv("ENERGY_WEB_CORS_ORIGINS"); v != "" {
+		web.CORSOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			web.CORSOrigins = append(web.CORSOrigins, strings.TrimSpace(origin))
+		}
+	}
+	return nil
+}
+
+// TLS 設定：一般憑證必須存在；自簽憑證的檔案可以不存在 (啟動時產生)
+func ValidateTLS(field string, t TLSConfig, listen string, add AddFunc) {
+	if !t.Enabled {
+		if t.RedirectListen != "" {
+			add(field+".redirect_listen", "需要啟用 TLS")
+		}
+		return
+	}
+	if t.CertFile == "" {
+		add(field+".cert_file", "啟用 TLS 時必須指定")
+	}
+	if t.KeyFile == "" {
+		add(field+".key_file", "啟用 TLS 時必須指定")
+	}
+	if t.CertFile != "" && t.KeyFile != "" {
+		_, certErr := os.Stat(t.CertFile)
+		_, keyErr := os.Stat(t.KeyFile)
+		switch {
+		case !t.SelfSigned:
+			if certErr != nil {
+				add(field+".cert_file", "無法讀取憑證檔案: %v (或設定 self_signed: true 自動產生)", certErr)
+			}
+			if keyErr != nil {
+				add(field+".key_file", "無法讀取金鑰檔案: %v", keyErr)
+			}
+		case (certErr == nil) != (keyErr == nil):
+			add(field, "憑證與金鑰檔案必須同時存在或同時不存在 (%s / %s)", t.CertFile, t.KeyFile)
+		}
+	}
+	for i, host := range t.Hosts {
+		if strings.TrimSpace(host) == "" {
+			add(fmt.Sprintf("%s.hosts[%d]", field, i), "不可為空白")
+		}
+	}
+	if t.RedirectListen != "" {
+		if _, _, err := net.SplitHostPort(t.RedirectListen); err != nil {
+			add(field+".redirect_listen", "無效的監聽位址 %q: %v", t.RedirectListen, err)
+		} else if t.RedirectListen == listen {
+			add(field+".redirect_listen", "不可與 HTTPS 監聽位址相同")
+		}
+	}
+}
+
+// CORS 來源必須是 * 或 scheme://host[:port]
+func ValidateCORSOrigins(field string, origins []string, add AddFunc) {
+	for i, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			add(fmt.Sprintf("%s[%d]", field, i), "無效的來源 %q (例如 https://grafana.example.com:3000)", origin)
+		}
+	}
+}
+
+func ValidateHeaders(field string, headers map[string]string, add AddFunc) {
+	for name := range headers {
+		if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+			add(field, "無效的標頭名稱 %q", name)
+		}
+	}
+}
+
+func ValidateAssetsDir(field, dir string, add AddFunc) {
+	if dir == "" {
+		return
+	}
+	if info, err := os.Stat(dir); err != nil {
+		add(field, "無法讀取目錄: %v", err)
+	} else if !info.IsDir() {
+		add(field, "%s 不是目錄", dir)
+	}
+}
+
+// 看板 Web 伺服器從共用設定檔讀取的區段；
+// 其餘區段 (meters、database 等) 由 energy_system 讀取與驗證，這裡只保留不解析
+type WebServerConfig struct {
+	LabVIEW LabVIEWConfig `yaml:"labview"`
+	Web     WebConfig     `yaml:"web"`
+	Outputs struct {
+		Snapshot SnapshotOutputConfig `yaml:"snapshot"`
+		Others   map[string]yaml.Node `yaml:",inline"`
+	} `yaml:"outputs"`
+	Others map[string]yaml.Node `yaml:",inline"`
+
+	// 設定檔內容 (標示驗證錯誤的行號)
+	content []byte
+}
+
+// 讀取設定檔並套用環境變數覆寫；檔案不存在且未明確指定時使用預設值。
+// labview、web 與 outputs.snapshot 不允許未知欄位
+func LoadWebServerConfig(path string, required bool) (*WebServerConfig, error) {
+	cfg := &WebServerConfig{LabVIEW: DefaultLabVIEWConfig(), Web: DefaultWebConfig()}
+	cfg.Outputs.Snapshot = DefaultSnapshotOutputConfig()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && (required || !os.IsNotExist(err)) {
+		return nil, fmt.Errorf("無法讀取設定檔 %s: %v", path, err)
+	}
+	if err == nil && len(bytes.TrimSpace(content)) > 0 {
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("設定檔 %s 格式錯誤: %v", path, err)
+		}
+		cfg.content = content
+	}
+	cfg.Outputs.Snapshot.Normalize()
+
+	if err := ApplyWebEnv(&cfg.LabVIEW, &cfg.Web, os.Getenv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 驗證設定內容，回傳所有錯誤 (標示設定檔中的行號)
+func (cfg *WebServerConfig) Validate() []ConfigError {
+	var errs []ConfigError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ConfigError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	cfg.LabVIEW.Validate(add)
+	cfg.Web.Validate(add)
+	cfg.Outputs.Snapshot.Validate(add)
+	return AnnotateConfigErrors(cfg.content, errs)
+}
+
+// 為驗證錯誤補上設定檔中的行號
+func AnnotateConfigErrors(content []byte, errs []ConfigError) []ConfigError {
+	var root yaml.Node
+	if len(errs) == 0 || yaml.Unmarshal(content, &root) != nil {
+		return errs
+	}
+
+	for i := range errs {
+		if node := findConfigNode(&root, errs[i].Field); node != nil {
+			errs[i].Line = node.Line
+		}
+	}
+	return errs
+}
+
+// 依欄位路徑 (例如 meters[1].port) 尋找 YAML 節點，找不到時回傳最接近的上層節點
+func findConfigNode(root *yaml.Node, field string) *yaml.Node {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	var found *yaml.Node
+	for _, part := range strings.Split(field, ".") {
+		key, index := part, -1
+		if open := strings.Index(part, "["); open >= 0 && strings.HasSuffix(part, "]") {
+			key = part[:open]
+			index, _ = strconv.Atoi(part[open+1 : len(part)-1])
+		}
+
+		next := mappingValue(node, key)
+		if next == nil {
+			return found
+		}
+		node, found = next, next
+
+		if index >= 0 {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return found
+			}
+			node = node.Content[index]
+			found = node
+		}
+	}
+
+	return found
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
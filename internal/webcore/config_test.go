@@ -0,0 +1,105 @@
+package webcore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "energy_config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 其他區段 (energy_system 使用) 不解析；看板使用的區段不允許未知欄位
+func TestLoadWebServerConfigSections(t *testing.T) {
+	path := writeConfig(t, `
+meters:
+  - id: m1
+    host: 192.168.1.9
+outputs:
+  influx:
+    url: http://localhost:8086/write?db=energy
+  snapshot:
+    path: out/final.json
+    formats: [CSV]
+web:
+  listen: ":5188"
+`)
+	cfg, err := LoadWebServerConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Web.Listen != ":5188" || cfg.Outputs.Snapshot.Path != "out/final.json" || cfg.LabVIEW.Port != 8888 {
+		t.Fatalf("設定內容錯誤: %+v", cfg)
+	}
+	// 格式名稱載入時轉為小寫
+	if errs := cfg.Validate(); len(errs) > 0 || cfg.Outputs.Snapshot.Formats[0] != "csv" {
+		t.Fatalf("快照格式錯誤: %v %v", cfg.Outputs.Snapshot.Formats, errs)
+	}
+
+	path = writeConfig(t, "web:\n  listen: \":5188\"\n  lisen: \":5189\"\n")
+	if _, err := LoadWebServerConfig(path, true); err == nil || !strings.Contains(err.Error(), "lisen") {
+		t.Fatalf("未知欄位應回傳錯誤，實際 %v", err)
+	}
+}
+
+// 驗證錯誤標示行號
+func TestWebServerConfigValidate(t *testing.T) {
+	path := writeConfig(t, `labview:
+  port: 0
+outputs:
+  snapshot:
+    formats: [xml]
+web:
+  users:
+    - name: admin
+      password_hash: plain-text
+`)
+	cfg, err := LoadWebServerConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[string]int)
+	for _, e := range cfg.Validate() {
+		lines[e.Field] = e.Line
+	}
+	want := map[string]int{
+		"labview.port":                2,
+		"outputs.snapshot.formats[0]": 5,
+		"web.users[0].password_hash":  9,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("預期 %d 個錯誤，實際 %v", len(want), lines)
+	}
+	for field, line := range want {
+		if lines[field] != line {
+			t.Errorf("%s: 預期第 %d 行，實際 %d", field, line, lines[field])
+		}
+	}
+}
+
+func TestApplyWebEnv(t *testing.T) {
+	labview, web := DefaultLabVIEWConfig(), DefaultWebConfig()
+	env := map[string]string{
+		"ENERGY_LABVIEW_PORT":     "9999",
+		"ENERGY_WEB_TLS_CERT":     "cert.pem",
+		"ENERGY_WEB_CORS_ORIGINS": "https://a.example.com, https://b.example.com",
+	}
+	if err := ApplyWebEnv(&labview, &web, func(name string) string { return env[name] }); err != nil {
+		t.Fatal(err)
+	}
+	if labview.Port != 9999 || !web.TLS.Enabled || web.TLS.CertFile != "cert.pem" || len(web.CORSOrigins) != 2 || web.CORSOrigins[1] != "https://b.example.com" {
+		t.Fatalf("環境變數覆寫錯誤: %+v %+v", labview, web)
+	}
+
+	env["ENERGY_LABVIEW_PORT"] = "abc"
+	if err := ApplyWebEnv(&labview, &web, func(name string) string { return env[name] }); err == nil {
+		t.Fatal("無效的連接埠應回傳錯誤")
+	}
+}
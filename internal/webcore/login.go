@@ -0,0 +1,212 @@
+package webcore
+
+import (
+	"crypto/sha256"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// 驗證成功的帳號密碼保留的時間，期間內 HTTP Basic 請求 (Grafana、/write 輪詢、看板自動更新) 不再重新計算 PBKDF2
+	VerifiedLoginTTL = time.Minute
+	// 每個來源 IP 每分鐘最多計算的密碼雜湊次數 (不含快取命中)，超過時不計算直接拒絕
+	PasswordAttemptsPerMinute = 30
+	// 登入失敗、快取與來源 IP 紀錄各自的筆數上限
+	MaxLoginRecords = 10000
+)
+
+var (
+	ErrLoginFailed = errors.New("帳號或密碼錯誤")
+	ErrLoginLocked = errors.New("登入失敗次數過多，請稍後再試")
+	ErrThrottled   = errors.New("登入嘗試過於頻繁，請稍後再試")
+)
+
+// 連續登入失敗達 MaxFailures 次時暫停該帳號登入 Lockout (MaxFailures 為 0 表示不暫停)
+type LoginPolicy struct {
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+// 連續登入失敗紀錄
+type loginFailure struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// 來源 IP 目前一分鐘內計算密碼雜湊的次數
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+// 帳號密碼登入的限制：帳號連續失敗暫停登入、來源 IP 計算密碼雜湊的次數限制，
+// 以及最近驗證成功的帳號密碼快取；mu 只保護紀錄，計算 PBKDF2 時不持有
+type LoginLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailure
+	verified map[string]time.Time      // 驗證成功的帳號密碼 (雜湊) 與到期時間
+	attempts map[string]*attemptWindow // 依來源 IP
+}
+
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		failures: make(map[string]*loginFailure),
+		verified: make(map[string]time.Time),
+		attempts: make(map[string]*attemptWindow),
+	}
+}
+
+// 驗證帳號密碼，remote 為來源 IP
+// 帳號不存在 (passwordHash 空白) 或 enabled 為 false 時仍計算雜湊 (回應時間與密碼錯誤相同) 但視為失敗；
+// 最近驗證成功的帳號密碼直接通過 (密碼變更後雜湊不同即失效)，需要計算 PBKDF2 時先檢查來源 IP 的次數限制
+func (l *LoginLimiter) Verify(policy LoginPolicy, name, password, passwordHash, remote string, enabled bool) error {
+	if l.locked(name) {
+		return ErrLoginLocked
+	}
+	now := time.Now()
+	sum := sha256.Sum256([]byte(name + "\x00" + password + "\x00" + passwordHash))
+	key := string(sum[:])
+	if enabled && passwordHash != "" && l.recentlyVerified(key, now) {
+		return nil
+	}
+	if !l.allowAttempt(remote, now) {
+		return ErrThrottled
+	}
+
+	ok := VerifyPassword(passwordHash, password)
+	if !ok || !enabled {
+		l.recordLogin(name, false, policy)
+		return ErrLoginFailed
+	}
+	l.recordLogin(name, true, policy)
+	l.rememberVerified(key, now)
+	return nil
+}
+
+// 帳號是否暫停登入
+func (l *LoginLimiter) locked(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[name]
+	return ok && time.Now().Before(f.lockedUntil)
+}
+
+// 記錄登入結果
+// 帳號名稱由用戶端提供 (可能不存在)，新增紀錄前清除已解除暫停且超過 lockout 未再失敗的紀錄，並限制筆數
+func (l *LoginLimiter) recordLogin(name string, success bool, policy LoginPolicy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if success || policy.MaxFailures == 0 {
+		delete(l.failures, name)
+		return
+	}
+	now := time.Now()
+	f, ok := l.failures[name]
+	if !ok {
+		if len(l.failures) >= MaxLoginRecords {
+			l.pruneFailures(now, policy.Lockout)
+		}
+		f = &loginFailure{}
+		l.failures[name] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= policy.MaxFailures {
+		f.count = 0
+		f.lockedUntil = now.Add(policy.Lockout)
+		log.Printf("🔒 帳號 %s 連續登入失敗 %d 次，暫停登入 %v", name, policy.MaxFailures, policy.Lockout)
+	}
+}
+
+// 清除過期的登入失敗紀錄；仍超過上限時清除最久沒有失敗且未暫停的紀錄 (呼叫端需持有 mu)
+func (l *LoginLimiter) pruneFailures(now time.Time, lockout time.Duration) {
+	for name, f := range l.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.last) > lockout {
+			delete(l.failures, name)
+		}
+	}
+	for len(l.failures) >= MaxLoginRecords {
+		oldest := ""
+		for name, f := range l.failures {
+			if now.Before(f.lockedUntil) {
+				continue
+			}
+			if oldest == "" || f.last.Before(l.failures[oldest].last) {
+				oldest = name
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(l.failures, oldest)
+	}
+}
+
+// 帳號密碼是否在 VerifiedLoginTTL 內驗證成功過
+func (l *LoginLimiter) recentlyVerified(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expires, ok := l.verified[key]
+	if ok && now.After(expires) {
+		delete(l.verified, key)
+		return false
+	}
+	return ok
+}
+
+// 記錄驗證成功的帳號密碼
+func (l *LoginLimiter) rememberVerified(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.verified) >= MaxLoginRecords {
+		for k, expires := range l.verified {
+			if now.After(expires) {
+				delete(l.verified, k)
+			}
+		}
+		if len(l.verified) >= MaxLoginRecords {
+			return
+		}
+	}
+	l.verified[key] = now.Add(VerifiedLoginTTL)
+}
+
+// 來源 IP 是否還能計算密碼雜湊 (每分鐘 PasswordAttemptsPerMinute 次)
+func (l *LoginLimiter) allowAttempt(host string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.attempts[host]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		if !ok && len(l.attempts) >= MaxLoginRecords {
+			for h, old := range l.attempts {
+				if now.Sub(old.start) >= time.Minute {
+					delete(l.attempts, h)
+				}
+			}
+			if len(l.attempts) >= MaxLoginRecords {
+				return false
+			}
+		}
+		w = &attemptWindow{start: now}
+		l.attempts[host] = w
+	}
+	if w.count >= PasswordAttemptsPerMinute {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// 請求的來源 IP (不含連接埠)
+func RemoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
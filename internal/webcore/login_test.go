@@ -0,0 +1,61 @@
+package webcore
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// 只計算 1 次的密碼雜湊 (測試用，避免每次驗證計算 PBKDF2 20 萬次)
+func testPasswordHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := PBKDF2SHA256([]byte(password), salt, 1, sha256.Size)
+	return fmt.Sprintf("%s$1$%s$%s", PasswordHashScheme,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func expectLogin(t *testing.T, what string, got, want error) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("%s: 預期 %v，實際 %v", what, want, got)
+	}
+}
+
+// 連續失敗達上限後暫停登入 (密碼正確也拒絕)，其他帳號不受影響
+func TestLoginLimiterLockout(t *testing.T) {
+	l := NewLoginLimiter()
+	policy := LoginPolicy{MaxFailures: 3, Lockout: time.Minute}
+	hash := testPasswordHash("correct-horse")
+	for i := 0; i < 3; i++ {
+		expectLogin(t, "密碼錯誤", l.Verify(policy, "admin", "wrong", hash, "10.0.0.1", true), ErrLoginFailed)
+	}
+	expectLogin(t, "暫停期間", l.Verify(policy, "admin", "correct-horse", hash, "10.0.0.2", true), ErrLoginLocked)
+	expectLogin(t, "其他帳號", l.Verify(policy, "viewer", "correct-horse", hash, "10.0.0.1", true), nil)
+}
+
+// 每個來源 IP 每分鐘最多計算 PasswordAttemptsPerMinute 次雜湊
+func TestLoginLimiterThrottle(t *testing.T) {
+	l := NewLoginLimiter()
+	hash := testPasswordHash("correct-horse")
+	for i := 0; i < PasswordAttemptsPerMinute; i++ {
+		expectLogin(t, "密碼錯誤", l.Verify(LoginPolicy{}, "admin", "wrong", hash, "10.0.0.1", true), ErrLoginFailed)
+	}
+	expectLogin(t, "超過次數", l.Verify(LoginPolicy{}, "admin", "correct-horse", hash, "10.0.0.1", true), ErrThrottled)
+	expectLogin(t, "其他來源", l.Verify(LoginPolicy{}, "admin", "correct-horse", hash, "10.0.0.2", true), nil)
+}
+
+// 驗證成功的帳號密碼在快取期間不計入次數限制；停用帳號或密碼雜湊變更時快取失效
+func TestLoginLimiterVerifiedCache(t *testing.T) {
+	l := NewLoginLimiter()
+	hash := testPasswordHash("correct-horse")
+	expectLogin(t, "首次登入", l.Verify(LoginPolicy{}, "admin", "correct-horse", hash, "10.0.0.1", true), nil)
+	for i := 0; i < PasswordAttemptsPerMinute; i++ {
+		l.Verify(LoginPolicy{}, "admin", "wrong", hash, "10.0.0.1", true)
+	}
+	expectLogin(t, "快取命中", l.Verify(LoginPolicy{}, "admin", "correct-horse", hash, "10.0.0.1", true), nil)
+	expectLogin(t, "密碼變更", l.Verify(LoginPolicy{}, "admin", "correct-horse", testPasswordHash("correct-horse!"), "10.0.0.1", true), ErrThrottled)
+	expectLogin(t, "停用帳號", l.Verify(LoginPolicy{}, "admin", "correct-horse", hash, "10.0.0.2", false), ErrLoginFailed)
+	expectLogin(t, "帳號不存在", l.Verify(LoginPolicy{}, "nobody", "correct-horse", "", "10.0.0.2", false), ErrLoginFailed)
+}
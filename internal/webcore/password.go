@@ -0,0 +1,97 @@
+// Package webcore 能源監控系統 (energy_system) 與看板 Web 伺服器 (main.go) 共用的程式：
+// 設定檔的 labview、web 與 outputs.snapshot 區段 (型別、預設值與驗證)、密碼雜湊與登入限制、
+// HTTPS 自簽憑證與轉址、安全標頭，以及編入執行檔的看板網頁服務
+package webcore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	PasswordHashScheme     = "pbkdf2-sha256"
+	PasswordHashIterations = 200000
+	PasswordMinLength      = 8
+)
+
+// 密碼雜湊：pbkdf2-sha256$<次數>$<salt>$<金鑰> (base64)
+func HashPassword(password string) (string, error) {
+	if len(password) < PasswordMinLength {
+		return "", fmt.Errorf("密碼至少需要 %d 個字元", PasswordMinLength)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := PBKDF2SHA256([]byte(password), salt, PasswordHashIterations, sha256.Size)
+	return fmt.Sprintf("%s$%d$%s$%s", PasswordHashScheme, PasswordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// 解析密碼雜湊，回傳次數、salt 與金鑰
+func ParsePasswordHash(encoded string) (int, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != PasswordHashScheme {
+		return 0, nil, nil, fmt.Errorf("密碼雜湊格式錯誤")
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("密碼雜湊的次數錯誤")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("密碼雜湊的 salt 錯誤")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("密碼雜湊的金鑰錯誤")
+	}
+	return iterations, salt, key, nil
+}
+
+// 比對密碼；雜湊為空白時 (帳號不存在) 仍計算一次，避免以回應時間判斷帳號是否存在
+func VerifyPassword(encoded, password string) bool {
+	iterations, salt, key, err := ParsePasswordHash(encoded)
+	if err != nil {
+		iterations, salt, key = PasswordHashIterations, make([]byte, 16), make([]byte, sha256.Size)
+	}
+	derived := PBKDF2SHA256([]byte(password), salt, iterations, len(key))
+	return err == nil && subtle.ConstantTimeCompare(derived, key) == 1
+}
+
+// PBKDF2-HMAC-SHA256 (RFC 8018)，也用於備份檔加密
+func PBKDF2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	key := make([]byte, 0, blocks*hashLen)
+	counter := make([]byte, 4)
+	u := make([]byte, 0, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		prf.Write(counter)
+		u = prf.Sum(u[:0])
+
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
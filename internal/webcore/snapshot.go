@@ -0,0 +1,226 @@
+package webcore
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 電表資料結構
+type MeterData struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Unit  string `json:"unit"`
+}
+
+// 快照文件格式 (final.json；legacy_array 時為 final.v2.json)
+type SnapshotDocument struct {
+	Generation uint64      `json:"generation"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Data       []MeterData `json:"data"`
+}
+
+// 以原子方式寫入電表資料快照
+// 先寫入同目錄的暫存檔再 rename，避免網頁讀到寫到一半的檔案
+type SnapshotWriter struct {
+	Path        string   // 主要快照檔案 (final.json)
+	Formats     []string // 額外輸出格式 (小寫)，目前支援 "csv"
+	KeepLast    int      // 保留最近 N 份歷史快照，0 表示不保留
+	HistoryDir  string   // 歷史快照目錄
+	LegacyArray bool     // Path 維持舊版純陣列格式 (既有的 LabVIEW 讀取程式)，快照文件另寫到 DocumentPath
+
+	generation uint64
+	loaded     bool
+}
+
+// 建立新的快照寫入器
+func NewSnapshotWriter(path string) *SnapshotWriter {
+	return &SnapshotWriter{
+		Path:       path,
+		HistoryDir: "snapshots",
+	}
+}
+
+// 含世代計數器的快照文件路徑：一般為 Path，LegacyArray 時為 <名稱>.v2.json
+func (sw *SnapshotWriter) DocumentPath() string {
+	if sw.LegacyArray {
+		return sw.siblingPath(".v2.json")
+	}
+	return sw.Path
+}
+
+// 額外格式的檔案路徑 (與 Path 同名、副檔名為格式名稱)，未啟用的格式回傳空字串
+func (sw *SnapshotWriter) FormatPath(format string) string {
+	for _, f := range sw.Formats {
+		if f == format {
+			return sw.siblingPath("." + format)
+		}
+	}
+	return ""
+}
+
+func (sw *SnapshotWriter) siblingPath(suffix string) string {
+	return strings.TrimSuffix(sw.Path, filepath.Ext(sw.Path)) + suffix
+}
+
+// 從既有的快照檔案延續世代計數器
+func (sw *SnapshotWriter) loadGeneration() {
+	sw.loaded = true
+
+	// 剛切換 legacy_array 時快照文件還不存在，改讀 Path
+	for _, path := range []string{sw.DocumentPath(), sw.Path} {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// 舊版 final.json 為純陣列格式，解析失敗時從 0 開始
+		var doc SnapshotDocument
+		if err := json.Unmarshal(content, &doc); err == nil && doc.Generation > 0 {
+			sw.generation = doc.Generation
+			return
+		}
+	}
+}
+
+// 寫入新的快照 (JSON 以及額外格式)
+func (sw *SnapshotWriter) Write(data []MeterData) (*SnapshotDocument, error) {
+	if !sw.loaded {
+		sw.loadGeneration()
+	}
+
+	doc := &SnapshotDocument{
+		Generation: sw.generation + 1,
+		Timestamp:  time.Now(),
+		Data:       data,
+	}
+
+	jsonBytes, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("JSON 編碼錯誤: %v", err)
+	}
+
+	if err := writeFileAtomic(sw.DocumentPath(), jsonBytes); err != nil {
+		return nil, err
+	}
+	sw.generation = doc.Generation
+	if sw.LegacyArray {
+		arrayBytes, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			return doc, fmt.Errorf("JSON 編碼錯誤: %v", err)
+		}
+		if err := writeFileAtomic(sw.Path, arrayBytes); err != nil {
+			return doc, err
+		}
+	}
+
+	for _, format := range sw.Formats {
+		switch format {
+		case "csv":
+			if err := writeFileAtomic(sw.FormatPath(format), encodeSnapshotCSV(doc)); err != nil {
+				return doc, err
+			}
+		default:
+			return doc, fmt.Errorf("不支援的快照格式: %s", format)
+		}
+	}
+
+	if sw.KeepLast > 0 {
+		if err := sw.rotateHistory(doc.Generation, jsonBytes); err != nil {
+			return doc, err
+		}
+	}
+
+	return doc, nil
+}
+
+// 保存歷史快照並刪除超過數量上限的舊檔案
+func (sw *SnapshotWriter) rotateHistory(generation uint64, jsonBytes []byte) error {
+	if err := os.MkdirAll(sw.HistoryDir, 0755); err != nil {
+		return fmt.Errorf("建立快照目錄錯誤: %v", err)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(sw.Path), filepath.Ext(sw.Path))
+	name := fmt.Sprintf("%s-%012d.json", base, generation)
+	if err := writeFileAtomic(filepath.Join(sw.HistoryDir, name), jsonBytes); err != nil {
+		return err
+	}
+
+	// 檔名內的世代編號固定寬度，字母排序即為時間排序
+	matches, err := filepath.Glob(filepath.Join(sw.HistoryDir, base+"-*.json"))
+	if err != nil {
+		return fmt.Errorf("列出歷史快照錯誤: %v", err)
+	}
+	sort.Strings(matches)
+
+	for len(matches) > sw.KeepLast {
+		if err := os.Remove(matches[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("刪除舊快照錯誤: %v", err)
+		}
+		matches = matches[1:]
+	}
+
+	return nil
+}
+
+// 將快照轉為 CSV 格式 (供 LabVIEW 操作畫面讀取)
+func encodeSnapshotCSV(doc *SnapshotDocument) []byte {
+	var builder strings.Builder
+	writer := csv.NewWriter(&builder)
+
+	writer.Write([]string{"generation", "timestamp", "index", "name", "value", "unit"})
+	generation := strconv.FormatUint(doc.Generation, 10)
+	timestamp := doc.Timestamp.Format("2006-01-02 15:04:05")
+	for _, item := range doc.Data {
+		writer.Write([]string{generation, timestamp, strconv.Itoa(item.Index), item.Name, item.Value, item.Unit})
+	}
+	writer.Flush()
+
+	return []byte(builder.String())
+}
+
+// 寫入暫存檔後 rename 取代目標檔案
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("建立暫存檔錯誤: %v", err)
+	}
+	tmpName := tmp.Name()
+
+	// 任何錯誤都要清掉暫存檔
+	success := false
+	defer func() {
+		if !success {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("寫入暫存檔錯誤: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("同步暫存檔錯誤: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("關閉暫存檔錯誤: %v", err)
+	}
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return fmt.Errorf("設定檔案權限錯誤: %v", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("取代檔案錯誤: %v", err)
+	}
+
+	success = true
+	return nil
+}
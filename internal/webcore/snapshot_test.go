@@ -0,0 +1,105 @@
+package webcore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSnapshotWriter(t *testing.T) (*SnapshotWriter, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sw := NewSnapshotWriter(filepath.Join(dir, "final.json"))
+	sw.HistoryDir = filepath.Join(dir, "snapshots")
+	return sw, dir
+}
+
+func readSnapshot(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		t.Fatalf("%s 不是完整的 JSON: %v", path, err)
+	}
+}
+
+var testMeterData = []MeterData{{Index: 0, Name: "電壓", Value: "220.5", Unit: "V"}}
+
+// 寫入後目錄中只有目標檔案 (暫存檔已 rename 或刪除)，世代計數器在重新建立寫入器後延續
+func TestSnapshotWriterGeneration(t *testing.T) {
+	sw, dir := newTestSnapshotWriter(t)
+	sw.Formats = []string{"csv"}
+	for i := 0; i < 3; i++ {
+		if _, err := sw.Write(testMeterData); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, file := range files {
+		if name := filepath.Base(file); name != "final.json" && name != "final.csv" {
+			t.Errorf("殘留的檔案: %s", name)
+		}
+	}
+	var doc SnapshotDocument
+	readSnapshot(t, sw.Path, &doc)
+	if doc.Generation != 3 || len(doc.Data) != 1 || doc.Data[0].Value != "220.5" {
+		t.Fatalf("快照內容錯誤: %+v", doc)
+	}
+	csv, _ := ioutil.ReadFile(sw.FormatPath("csv"))
+	if !strings.Contains(string(csv), "3,") || !strings.Contains(string(csv), "電壓") {
+		t.Fatalf("CSV 內容錯誤: %s", csv)
+	}
+
+	restarted := NewSnapshotWriter(sw.Path)
+	written, err := restarted.Write(testMeterData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written.Generation != 4 {
+		t.Fatalf("重新啟動後世代應延續為 4，實際 %d", written.Generation)
+	}
+}
+
+// 只保留最近 KeepLast 份歷史快照
+func TestSnapshotWriterKeepLast(t *testing.T) {
+	sw, _ := newTestSnapshotWriter(t)
+	sw.KeepLast = 2
+	for i := 0; i < 5; i++ {
+		if _, err := sw.Write(testMeterData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(sw.HistoryDir, "*"))
+	if len(files) != 2 || filepath.Base(files[0]) != "final-000000000004.json" || filepath.Base(files[1]) != "final-000000000005.json" {
+		t.Fatalf("歷史快照錯誤: %v", files)
+	}
+}
+
+// legacy_array: final.json 為舊版陣列，世代計數器寫在 final.v2.json
+func TestSnapshotWriterLegacyArray(t *testing.T) {
+	sw, _ := newTestSnapshotWriter(t)
+	if _, err := sw.Write(testMeterData); err != nil {
+		t.Fatal(err)
+	}
+	sw = NewSnapshotWriter(sw.Path)
+	sw.LegacyArray = true
+	if _, err := sw.Write(testMeterData); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []MeterData
+	readSnapshot(t, sw.Path, &data)
+	if len(data) != 1 || data[0].Name != "電壓" {
+		t.Fatalf("final.json 應為陣列: %+v", data)
+	}
+	var doc SnapshotDocument
+	readSnapshot(t, sw.DocumentPath(), &doc)
+	if filepath.Base(sw.DocumentPath()) != "final.v2.json" || doc.Generation != 2 {
+		t.Fatalf("快照文件錯誤: %s %+v", sw.DocumentPath(), doc)
+	}
+}
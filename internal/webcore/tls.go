@@ -0,0 +1,221 @@
+package webcore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 自動產生的自簽憑證名稱，用來判斷過期時可否覆寫
+const selfSignedCommonName = "energy-monitoring self-signed"
+
+// TLS 設定 (energy_system 的 http.tls 與看板 Web 伺服器的 web.tls)
+type TLSConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	CertFile       string   `yaml:"cert_file"`
+	KeyFile        string   `yaml:"key_file"`
+	SelfSigned     bool     `yaml:"self_signed"`     // 憑證檔案不存在 (或自簽憑證過期) 時自動產生自簽憑證
+	Hosts          []string `yaml:"hosts"`           // 自簽憑證的主機名稱與 IP，空白時使用 localhost、主機名稱與本機 IP
+	RedirectListen string   `yaml:"redirect_listen"` // 另外監聽 HTTP 並轉址到 HTTPS (例如 :80)，空白表示停用
+}
+
+// 預設安全標頭 (可用 headers 設定覆寫，值為空白表示移除)；
+// 看板使用 inline 事件處理與 Google Fonts，CSP 需允許
+var DefaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "SAMEORIGIN",
+	"Referrer-Policy":         "same-origin",
+	"Permissions-Policy":      "camera=(), microphone=(), geolocation=()",
+	"Content-Security-Policy": "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; font-src 'self' https://fonts.gstatic.com; img-src 'self' data:; connect-src 'self'; frame-ancestors 'self'",
+}
+
+// 合併後的回應標頭
+type HeaderSet map[string]string
+
+// 預設安全標頭加上設定的覆寫；啟用 TLS 時加上 HSTS
+func SecurityHeaders(overrides map[string]string, tlsEnabled bool) HeaderSet {
+	merged := make(HeaderSet, len(DefaultSecurityHeaders)+len(overrides)+1)
+	for name, value := range DefaultSecurityHeaders {
+		merged[name] = value
+	}
+	if tlsEnabled {
+		merged["Strict-Transport-Security"] = "max-age=31536000"
+	}
+	for name, value := range overrides {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	return merged
+}
+
+// 寫入回應標頭 (略過值為空白的標頭)
+func (headers HeaderSet) Apply(h http.Header) {
+	for name, value := range headers {
+		if value != "" {
+			h.Set(name, value)
+		}
+	}
+}
+
+// 準備 HTTPS 設定：必要時產生自簽憑證並載入憑證
+func ServerTLSConfig(t TLSConfig) (*tls.Config, error) {
+	if err := EnsureCertificate(t); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("載入 TLS 憑證失敗: %v", err)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// 將 HTTP 請求轉址到同一主機的 HTTPS 連接埠
+func HTTPSRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if host == "" {
+			http.Error(w, "缺少 Host 標頭", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		// 非 GET/HEAD 使用 308 保留方法與本文
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target, status)
+	})
+}
+
+// 檢查憑證檔案；設定 self_signed 時於檔案不存在或自簽憑證即將過期時產生新的自簽憑證
+func EnsureCertificate(t TLSConfig) error {
+	if !t.SelfSigned {
+		return nil
+	}
+	if content, err := ioutil.ReadFile(t.CertFile); err == nil {
+		block, _ := pem.Decode(content)
+		if block == nil {
+			return fmt.Errorf("憑證檔案 %s 格式錯誤", t.CertFile)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("憑證檔案 %s 格式錯誤: %v", t.CertFile, err)
+		}
+		// 只更新自己產生的憑證，不覆寫使用者提供的憑證；舊版產生的 CA 憑證 Firefox 無法使用，也重新產生
+		if cert.Subject.CommonName != selfSignedCommonName || (time.Until(cert.NotAfter) > 30*24*time.Hour && !cert.IsCA) {
+			return nil
+		}
+		if cert.IsCA {
+			log.Printf("🔐 自簽憑證為 CA 憑證，重新產生伺服器憑證")
+		} else {
+			log.Printf("🔐 自簽憑證將於 %s 到期，重新產生", cert.NotAfter.Format("2006-01-02"))
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("無法讀取憑證檔案: %v", err)
+	}
+
+	hosts := t.Hosts
+	if len(hosts) == 0 {
+		hosts = defaultCertificateHosts()
+	}
+	if err := generateSelfSignedCertificate(t.CertFile, t.KeyFile, hosts); err != nil {
+		return fmt.Errorf("產生自簽憑證失敗: %v", err)
+	}
+	log.Printf("🔐 已產生自簽憑證 %s (%s)，瀏覽器會顯示不受信任的警告", t.CertFile, strings.Join(hosts, ", "))
+	return nil
+}
+
+// 自簽憑證預設涵蓋 localhost、主機名稱與所有本機 IP
+func defaultCertificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" && name != "localhost" {
+		hosts = append(hosts, name)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		hosts = append(hosts, ipNet.IP.String())
+	}
+	return hosts
+}
+
+// 產生 ECDSA P-256 自簽伺服器憑證 (非 CA，有效 2 年)，金鑰檔案權限 0600
+func generateSelfSignedCertificate(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: selfSignedCommonName, Organization: []string{"Think Power"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(2, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature, // ECDSA 金鑰不需要 KeyEncipherment
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false, // 伺服器憑證 (Firefox 拒絕把 CA 憑證當作伺服器憑證)
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
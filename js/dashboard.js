@@ -55,12 +55,24 @@ class EnergyDashboard {
             try {
                 response = await fetch('/api/latest');
             } catch (error) {
+                response = null;
+            }
+            // 尚未登入 (或工作階段逾時) 時導向登入頁
+            if (response && response.status === 401) {
+                window.location.href = 'login.html?next=' + encodeURIComponent(window.location.pathname);
+                return;
+            }
+            if (!response || !response.ok) {
                 // 回退到final.json
                 response = await fetch('final.json?' + new Date().getTime());
             }
             
             if (response.ok) {
-                const data = await response.json();
+                let data = await response.json();
+                // final.json 快照格式: { generation, timestamp, data: [...] }
+                if (data && !Array.isArray(data) && Array.isArray(data.data)) {
+                    data = data.data;
+                }
                 this.updateDisplayData(data);
             } else {
                 // 使用模擬資料
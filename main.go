@@ -1,9 +1,13 @@
+//go:build ignore
+
 package main
 
 import (
+	"embed"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -15,33 +19,35 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"energy-monitoring/internal/webcore"
 )
 
-// MeterData 電表資料結構
-type MeterData struct {
-	Index int    `json:"index"`
-	Name  string `json:"name"`
-	Value string `json:"value"`
-	Unit  string `json:"unit"`
-}
+// 電表資料與快照寫入由 internal/webcore 提供
+type (
+	MeterData        = webcore.MeterData
+	SnapshotDocument = webcore.SnapshotDocument
+)
 
 // EnergyDataClient 負責與 LabVIEW TCP 伺服器通訊的客戶端
 type EnergyDataClient struct {
-	LabviewHost string
-	LabviewPort int
-	JsonFile    string
-	Running     bool
-	StopChan    chan bool
+	LabviewHost  string
+	LabviewPort  int
+	PollInterval time.Duration
+	Snapshot     *webcore.SnapshotWriter
+	Running      bool
+	StopChan     chan bool
 }
 
 // NewEnergyDataClient 建立新的資料客戶端
 func NewEnergyDataClient() *EnergyDataClient {
 	return &EnergyDataClient{
-		LabviewHost: "localhost",
-		LabviewPort: 8888,
-		JsonFile:    "final.json",
-		Running:     false,
-		StopChan:    make(chan bool),
+		LabviewHost:  "localhost",
+		LabviewPort:  8888,
+		PollInterval: 5 * time.Second,
+		Snapshot:     webcore.NewSnapshotWriter("final.json"),
+		Running:      false,
+		StopChan:     make(chan bool),
 	}
 }
 
@@ -195,25 +201,16 @@ func (client *EnergyDataClient) QueryMeterData() bool {
 
 // UpdateJsonFile 更新 JSON 檔案
 func (client *EnergyDataClient) UpdateJsonFile(data []MeterData) error {
-	jsonBytes, err := json.MarshalIndent(data, "", "    ")
-	if err != nil {
-		return fmt.Errorf("JSON 編碼錯誤: %v", err)
-	}
-
-	err = ioutil.WriteFile(client.JsonFile, jsonBytes, 0644)
-	if err != nil {
-		return fmt.Errorf("寫入檔案錯誤: %v", err)
-	}
-
-	return nil
+	_, err := client.Snapshot.Write(data)
+	return err
 }
 
 // StartPeriodicQuery 開始定期查詢
 func (client *EnergyDataClient) StartPeriodicQuery() {
 	client.Running = true
-	log.Println("開始每 5 秒查詢電表資料...")
+	log.Printf("開始每 %v 查詢電表資料...", client.PollInterval)
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(client.PollInterval)
 	defer ticker.Stop()
 
 	for client.Running {
@@ -234,69 +231,225 @@ func (client *EnergyDataClient) Stop() {
 
 // EnergyWebServer 能源看板 Web 伺服器
 type EnergyWebServer struct {
-	WebPort    int
-	DataClient *EnergyDataClient
-	Server     *http.Server
-	Running    bool
+	WebListen   string
+	Users       map[string]string // 帳號與密碼雜湊，未設定時不需登入
+	TLS         webcore.TLSConfig
+	CORSOrigins []string          // 允許跨來源讀取的網站，空白表示只允許同源
+	Headers     map[string]string // 額外或覆寫的回應標頭，值為空白表示移除預設標頭
+	headers     webcore.HeaderSet // 啟動時合併預設安全標頭與 Headers
+	AssetsDir   string            // 看板網頁覆寫目錄，同名檔案取代內建版本
+	Assets      *webcore.AssetServer
+	DataClient  *EnergyDataClient
+	Server      *http.Server
+	Redirect    *http.Server // HTTP 轉址到 HTTPS (web.tls.redirect_listen)
+	Running     bool
+
+	logins *webcore.LoginLimiter // 登入失敗暫停、來源 IP 次數限制與驗證成功的快取 (避免每個請求重新計算 PBKDF2)
 }
 
+// dashboardLoginPolicy 看板帳號連續登入失敗的暫停設定 (與 energy_system http.auth 的預設值相同)
+var dashboardLoginPolicy = webcore.LoginPolicy{MaxFailures: 5, Lockout: 5 * time.Minute}
+
 // NewEnergyWebServer 建立新的 Web 伺服器
 func NewEnergyWebServer() *EnergyWebServer {
 	return &EnergyWebServer{
-		WebPort:    5177,
+		WebListen:  ":5177",
 		DataClient: NewEnergyDataClient(),
 		Running:    false,
+		logins:     webcore.NewLoginLimiter(),
 	}
 }
 
 // CustomHandler 自定義 HTTP 請求處理器
 func (server *EnergyWebServer) CustomHandler(w http.ResponseWriter, r *http.Request) {
-	// 添加 CORS 標頭
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	server.headers.Apply(w.Header())
+
+	// 只對允許清單中的來源添加 CORS 標頭
+	if origin := r.Header.Get("Origin"); origin != "" && server.allowedOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Add("Vary", "Origin")
+	}
 
 	// 處理 OPTIONS 請求
 	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
+	// 設定帳號時需以 HTTP Basic 登入
+	if len(server.Users) > 0 {
+		if err := server.Authenticate(r); errors.Is(err, webcore.ErrThrottled) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		} else if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="energy dashboard", charset="UTF-8"`)
+			http.Error(w, "需要登入", http.StatusUnauthorized)
+			return
+		}
+	}
+
 	// 記錄請求
 	log.Printf("[%s] %s %s", time.Now().Format("15:04:05"), r.Method, r.URL.Path)
 
-	// 使用標準檔案伺服器處理
-	http.FileServer(http.Dir(".")).ServeHTTP(w, r)
+	// 只提供 /dashboard/ 下的看板網頁與電表資料快照，其餘路徑一律 404
+	switch {
+	case r.URL.Path == "/" || r.URL.Path == webcore.DashboardPrefix || r.URL.Path == "/energy_dashboard.html":
+		http.Redirect(w, r, webcore.DashboardPrefix+"energy_dashboard.html", http.StatusFound)
+		return
+	case !strings.HasPrefix(r.URL.Path, webcore.DashboardPrefix):
+		http.NotFound(w, r)
+		return
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		http.Error(w, "不支援的方法", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := webcore.AssetName(strings.TrimPrefix(r.URL.Path, webcore.DashboardPrefix))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if snapshot := server.snapshotFile(name); snapshot != "" {
+		// 快照每次查詢都會更新，不快取
+		w.Header().Set("Cache-Control", "no-store")
+		f, err := os.Open(snapshot)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, name, info.ModTime(), f)
+		return
+	}
+	server.Assets.Serve(w, r, name)
+}
+
+// snapshotFile 看板讀取的快照 (final.json 與 csv 格式的 final.csv) 對應的檔案路徑
+func (server *EnergyWebServer) snapshotFile(name string) string {
+	snapshot := server.DataClient.Snapshot
+	if snapshot == nil {
+		return ""
+	}
+	switch name {
+	case "final.json":
+		return snapshot.Path
+	case "final.csv":
+		return snapshot.FormatPath("csv")
+	}
+	return ""
+}
+
+// 看板網頁編入執行檔 (與 energy_system 相同)，由 webcore.AssetServer 傳送
+//
+//go:embed energy_dashboard.html login.html thinkpower.ico css js
+var dashboardFiles embed.FS
+
+// allowedOrigin 來源是否在 CORS 允許清單中
+func (server *EnergyWebServer) allowedOrigin(origin string) bool {
+	for _, allowed := range server.CORSOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate 檢查 HTTP Basic 帳號密碼 (webcore.LoginLimiter 限制失敗次數與來源 IP 的嘗試次數)
+func (server *EnergyWebServer) Authenticate(r *http.Request) error {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return webcore.ErrLoginFailed
+	}
+	hash, found := server.Users[name]
+	return server.logins.Verify(dashboardLoginPolicy, name, password, hash, webcore.RemoteHost(r), found)
 }
 
 // StartWebServer 啟動 Web 伺服器
 func (server *EnergyWebServer) StartWebServer() error {
-	// 確保在正確的目錄中
-	if _, err := os.Stat("energy_dashboard.html"); os.IsNotExist(err) {
-		return fmt.Errorf("錯誤: 找不到 energy_dashboard.html 檔案")
+	// 看板網頁已編入執行檔，assets_dir 可覆寫
+	assets, err := webcore.NewAssetServer(dashboardFiles, server.AssetsDir)
+	if err != nil {
+		return err
 	}
+	server.Assets = assets
+	server.headers = webcore.SecurityHeaders(server.Headers, server.TLS.Enabled)
 
 	// 建立 HTTP 伺服器
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", server.CustomHandler)
 
 	server.Server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", server.WebPort),
-		Handler: mux,
+		Addr:              server.WebListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if server.TLS.Enabled {
+		if server.Server.TLSConfig, err = webcore.ServerTLSConfig(server.TLS); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("Web 伺服器啟動於 http://localhost:%d", server.WebPort)
+	log.Printf("Web 伺服器啟動於 %s", server.LocalURL())
+	if len(server.Users) == 0 {
+		log.Printf("⚠️ 未設定 web.users，看板不需登入即可瀏覽")
+	}
 
 	// 在新 goroutine 中啟動伺服器
 	go func() {
-		if err := server.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLS.Enabled {
+			err = server.Server.ListenAndServeTLS("", "")
+		} else {
+			err = server.Server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Printf("Web 伺服器錯誤: %v", err)
 		}
 	}()
 
+	// HTTP 轉址到 HTTPS
+	if server.TLS.Enabled && server.TLS.RedirectListen != "" {
+		_, port, _ := net.SplitHostPort(server.WebListen)
+		server.Redirect = &http.Server{
+			Addr:              server.TLS.RedirectListen,
+			Handler:           webcore.HTTPSRedirectHandler(port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		log.Printf("HTTP 轉址服務啟動於 %s", server.TLS.RedirectListen)
+		go func() {
+			if err := server.Redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("HTTP 轉址服務錯誤: %v", err)
+			}
+		}()
+	}
+
 	return nil
 }
 
+// LocalURL 本機存取用的網址
+func (server *EnergyWebServer) LocalURL() string {
+	host, port, err := net.SplitHostPort(server.WebListen)
+	if err != nil {
+		return "http://localhost:5177"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http://"
+	if server.TLS.Enabled {
+		scheme = "https://"
+	}
+	return scheme + net.JoinHostPort(host, port)
+}
+
 // StartDataClient 啟動資料客戶端
 func (server *EnergyWebServer) StartDataClient() {
 	// 在新 goroutine 中啟動定期查詢
@@ -305,7 +458,7 @@ func (server *EnergyWebServer) StartDataClient() {
 
 // OpenDashboard 開啟能源看板網頁
 func (server *EnergyWebServer) OpenDashboard() {
-	url := fmt.Sprintf("http://localhost:%d/energy_dashboard.html", server.WebPort)
+	url := server.LocalURL() + webcore.DashboardPrefix + "energy_dashboard.html"
 
 	var cmd *exec.Cmd
 	switch runtime.GOOS {
@@ -353,8 +506,8 @@ func (server *EnergyWebServer) Start() error {
 
 	fmt.Println("==================================================")
 	fmt.Println("系統啟動完成！")
-	fmt.Printf("Web 介面: http://localhost:%d/energy_dashboard.html\n", server.WebPort)
-	fmt.Println("每 5 秒自動更新電表資料")
+	fmt.Printf("Web 介面: %s%senergy_dashboard.html\n", server.LocalURL(), webcore.DashboardPrefix)
+	fmt.Printf("每 %v 自動更新電表資料\n", server.DataClient.PollInterval)
 	fmt.Println("按 Ctrl+C 停止系統")
 	fmt.Println("==================================================")
 
@@ -372,19 +525,92 @@ func (server *EnergyWebServer) Stop() {
 	if server.Server != nil {
 		server.Server.Close()
 	}
+	if server.Redirect != nil {
+		server.Redirect.Close()
+	}
 
 	log.Println("系統已停止")
 }
 
 func main() {
+	configPath := flag.String("config", "", "設定檔路徑 (預設 energy_config.yaml，可用 ENERGY_CONFIG 指定)")
+	labviewAddr := flag.String("labview", "", "LabVIEW TCP 伺服器位址 (例如 localhost:8888)")
+	listen := flag.String("listen", "", "Web 伺服器監聽位址 (例如 :5177)")
+	snapshotKeep := flag.Int("snapshot-keep", -1, "保留最近 N 份歷史快照 (0 表示不保留)")
+	snapshotFormats := flag.String("snapshot-formats", "", "額外快照格式，以逗號分隔 (例如: csv)")
+	flag.Parse()
+
+	// 設定來源優先順序: 設定檔 < 環境變數 < 命令列參數
+	path, required := *configPath, *configPath != ""
+	if path == "" {
+		path = os.Getenv("ENERGY_CONFIG")
+		required = path != ""
+	}
+	if path == "" {
+		path = "energy_config.yaml"
+	}
+	config, err := webcore.LoadWebServerConfig(path, required)
+	if err != nil {
+		log.Fatalf("載入設定失敗: %v", err)
+	}
+
+	if *labviewAddr != "" {
+		host, port, err := net.SplitHostPort(*labviewAddr)
+		if err != nil {
+			log.Fatalf("無效的 LabVIEW 位址 %q: %v", *labviewAddr, err)
+		}
+		config.LabVIEW.Host = host
+		config.LabVIEW.Port, err = strconv.Atoi(port)
+		if err != nil {
+			log.Fatalf("無效的 LabVIEW 連接埠 %q", port)
+		}
+	}
+	if *listen != "" {
+		config.Web.Listen = *listen
+	}
+	if *snapshotKeep >= 0 {
+		config.Outputs.Snapshot.KeepLast = *snapshotKeep
+	}
+	if *snapshotFormats != "" {
+		config.Outputs.Snapshot.Formats = nil
+		for _, format := range strings.Split(*snapshotFormats, ",") {
+			config.Outputs.Snapshot.Formats = append(config.Outputs.Snapshot.Formats, format)
+		}
+		config.Outputs.Snapshot.Normalize()
+	}
+
+	// 與 energy_system config validate 相同的檢查 (labview、web、outputs.snapshot)
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, e := range errs {
+			log.Printf("❌ 設定錯誤 %v", e)
+		}
+		log.Fatalf("設定驗證失敗，請執行 energy_system config validate 檢查設定檔")
+	}
+
 	server := NewEnergyWebServer()
+	server.WebListen = config.Web.Listen
+	server.TLS = config.Web.TLS
+	server.CORSOrigins = config.Web.CORSOrigins
+	server.Headers = config.Web.Headers
+	server.AssetsDir = config.Web.AssetsDir
+	server.Users = make(map[string]string)
+	for _, user := range config.Web.Users {
+		server.Users[user.Name] = user.PasswordHash
+	}
+	server.DataClient.LabviewHost = config.LabVIEW.Host
+	server.DataClient.LabviewPort = config.LabVIEW.Port
+	server.DataClient.PollInterval = config.LabVIEW.PollInterval
+	server.DataClient.Snapshot = webcore.NewSnapshotWriter(config.Outputs.Snapshot.Path)
+	server.DataClient.Snapshot.KeepLast = config.Outputs.Snapshot.KeepLast
+	server.DataClient.Snapshot.Formats = config.Outputs.Snapshot.Formats
+	server.DataClient.Snapshot.LegacyArray = config.Outputs.Snapshot.LegacyArray
 
 	// 設定信號處理
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// 啟動系統
-	err := server.Start()
+	err = server.Start()
 	if err != nil {
 		log.Fatalf("系統啟動失敗: %v", err)
 	}
@@ -15,12 +15,6 @@ if not exist "web_server.exe" (
     exit /b 1
 )
 
-if not exist "energy_dashboard.html" (
-    echo 錯誤: 找不到 energy_dashboard.html 檔案
-    pause
-    exit /b 1
-)
-
 echo 正在啟動能源看板系統...
 echo.
 echo 注意事項:
@@ -51,7 +51,7 @@ set CGO_ENABLED=1
 set GOOS=windows
 set GOARCH=amd64
 
-go build -ldflags="-s -w" -o energy_system.exe energy_backend.go
+go build -ldflags="-s -w" -o energy_system.exe .
 if %ERRORLEVEL% neq 0 (
     echo ❌ 編譯失敗
     pause